@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vixac/bullet/model"
+	store_interface "github.com/vixac/bullet/store/store_interface"
+)
+
+// maxDepotRawBytes caps the body of a raw write. The sqlite and postgres drivers bind a
+// BLOB or bytea as one value, so each write holds its body in memory once on its way to
+// the store, and this bounds that; larger bodies are refused with 413.
+const maxDepotRawBytes = 32 << 20
+
+// createRaw stores the request body as a new raw item of the bucket in the bucketId
+// query param, with the request's Content-Type.
+func (h *depotHandler) createRaw(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	bucketID, err := strconv.ParseInt(c.Query("bucketId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucketId"})
+		return
+	}
+	data, ok := readDepotRaw(c)
+	if !ok {
+		return
+	}
+	id, revision, err := h.store.DepotCreateRaw(space, int32(bucketID), data, c.GetHeader("Content-Type"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "depot", "written", 1)
+	c.Header("ETag", depotETag(revision))
+	c.JSON(http.StatusCreated, model.DepotCreateResponse{ID: id, Revision: revision})
+}
+
+// updateRaw replaces an item's value with the request body and Content-Type; with
+// If-Match, only while the item is at that revision, as for update.
+func (h *depotHandler) updateRaw(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	expected, err := ifMatchRevision(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	data, ok := readDepotRaw(c)
+	if !ok {
+		return
+	}
+	var revision int64
+	if expected == nil {
+		revision, err = h.store.DepotUpdateRaw(space, id, data, c.GetHeader("Content-Type"))
+	} else {
+		revision, err = h.store.DepotUpdateRawIfRevision(space, id, data, c.GetHeader("Content-Type"), *expected)
+	}
+	if err != nil {
+		var conflict *store_interface.DepotRevisionConflictError
+		if errors.As(err, &conflict) {
+			c.Header("ETag", depotETag(conflict.Current))
+		}
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "depot", "written", 1)
+	c.Header("ETag", depotETag(revision))
+	c.JSON(http.StatusOK, model.DepotUpdateResponse{Revision: revision})
+}
+
+// getRaw writes an item's bytes as the response body, with its content type.
+func (h *depotHandler) getRaw(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	item, err := h.store.DepotGetRaw(space, id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "depot", "read", 1)
+	c.DataFromReader(http.StatusOK, int64(len(item.Data)), item.ContentType, bytes.NewReader(item.Data),
+		map[string]string{"ETag": depotETag(item.Revision)})
+}
+
+// readDepotRaw copies the request body into a buffer sized from its Content-Length, or
+// responds with 413 when it is over maxDepotRawBytes.
+func readDepotRaw(c *gin.Context) ([]byte, bool) {
+	if c.Request.ContentLength > maxDepotRawBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("body is over %d bytes", maxDepotRawBytes)})
+		return nil, false
+	}
+	var buf bytes.Buffer
+	if c.Request.ContentLength > 0 {
+		buf.Grow(int(c.Request.ContentLength))
+	}
+	_, err := io.Copy(&buf, http.MaxBytesReader(c.Writer, c.Request.Body, maxDepotRawBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("body is over %d bytes", maxDepotRawBytes)})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vixac/bullet/model"
@@ -19,23 +22,32 @@ type depotHandler struct {
 //
 //	POST   {prefix}/items            — create one
 //	POST   {prefix}/items/batch      — create many
-//	PUT    {prefix}/items/:id        — update
-//	GET    {prefix}/items/:id        — get one
+//	POST   {prefix}/items/raw        — create one from the raw body and Content-Type, into ?bucketId=
+//	PUT    {prefix}/items/:id        — update; with If-Match, only at that revision (412 otherwise)
+//	PUT    {prefix}/items/:id/raw    — replace the value with the raw body and Content-Type; If-Match as for PUT items/:id
+//	GET    {prefix}/items/:id        — get one, with its revision as the ETag
+//	GET    {prefix}/items/:id/raw    — get one as its raw bytes, with its content type
 //	POST   {prefix}/items/batch-get  — get many (IDs in body)
 //	DELETE {prefix}/items/:id        — delete one
 //	DELETE {prefix}/bucket/:bucketId — delete by bucket
-//	GET    {prefix}/bucket/:bucketId — get all by bucket
+//	GET    {prefix}/bucket/:bucketId — get all by bucket; with limit, start_after or descending
+//	                                   query params, one page in ID order with next_cursor
+//	POST   {prefix}/query            — page through a bucket by filters on JSON values
 func SetupDepotRouter(store store_interface.DepotStore, prefix string, engine *gin.Engine) *gin.Engine {
 	h := &depotHandler{store: store}
 	g := engine.Group(prefix)
 	g.POST("/items", h.createOne)
 	g.POST("/items/batch", h.createMany)
+	g.POST("/items/raw", h.createRaw)
 	g.PUT("/items/:id", h.update)
+	g.PUT("/items/:id/raw", h.updateRaw)
 	g.GET("/items/:id", h.getOne)
+	g.GET("/items/:id/raw", h.getRaw)
 	g.POST("/items/batch-get", h.getMany)
 	g.DELETE("/items/:id", h.deleteOne)
 	g.DELETE("/bucket/:bucketId", h.deleteByBucket)
 	g.GET("/bucket/:bucketId", h.getAllByBucket)
+	g.POST("/query", h.query)
 	return engine
 }
 
@@ -50,13 +62,14 @@ func (h *depotHandler) createOne(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	id, err := h.store.DepotCreate(space, req.BucketID, req.Value)
+	id, revision, err := h.store.DepotCreate(space, req.BucketID, req.Value)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 	incrementObjects(c, "depot", "written", 1)
-	c.JSON(http.StatusCreated, model.DepotCreateResponse{ID: id})
+	c.Header("ETag", depotETag(revision))
+	c.JSON(http.StatusCreated, model.DepotCreateResponse{ID: id, Revision: revision})
 }
 
 func (h *depotHandler) createMany(c *gin.Context) {
@@ -95,12 +108,33 @@ func (h *depotHandler) update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.store.DepotUpdate(space, id, req.Value); err != nil {
+	revision, err := ifMatchRevision(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if revision == nil {
+		if err := h.store.DepotUpdate(space, id, req.Value); err != nil {
+			respondError(c, err)
+			return
+		}
+		incrementObjects(c, "depot", "written", 1)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	next, err := h.store.DepotUpdateIfRevision(space, id, req.Value, *revision)
+	if err != nil {
+		var conflict *store_interface.DepotRevisionConflictError
+		if errors.As(err, &conflict) {
+			c.Header("ETag", depotETag(conflict.Current))
+		}
 		respondError(c, err)
 		return
 	}
 	incrementObjects(c, "depot", "written", 1)
-	c.Status(http.StatusOK)
+	c.Header("ETag", depotETag(next))
+	c.JSON(http.StatusOK, model.DepotUpdateResponse{Revision: next})
 }
 
 func (h *depotHandler) getOne(c *gin.Context) {
@@ -114,13 +148,14 @@ func (h *depotHandler) getOne(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
-	value, err := h.store.DepotGet(space, id)
+	item, err := h.store.DepotGetItem(space, id)
 	if err != nil {
 		respondError(c, err)
 		return
 	}
 	incrementObjects(c, "depot", "read", 1)
-	c.JSON(http.StatusOK, model.DepotGetResponse{Value: value})
+	c.Header("ETag", depotETag(item.Revision))
+	c.JSON(http.StatusOK, model.DepotGetResponse{Value: item.Value, Revision: item.Revision, ContentType: item.ContentType})
 }
 
 func (h *depotHandler) getMany(c *gin.Context) {
@@ -190,6 +225,10 @@ func (h *depotHandler) getAllByBucket(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucketId"})
 		return
 	}
+	if wantsBucketPage(c) {
+		h.getBucketPage(c, space, int32(bucketID))
+		return
+	}
 	values, err := h.store.DepotGetAllByBucket(space, int32(bucketID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -198,3 +237,105 @@ func (h *depotHandler) getAllByBucket(c *gin.Context) {
 	incrementObjects(c, "depot", "read", len(values))
 	c.JSON(http.StatusOK, model.DepotGetAllByBucketResponse{Values: values})
 }
+
+const maxDepotPageLimit = 10000
+
+// wantsBucketPage reports whether a bucket listing asks for a page rather than the whole bucket.
+func wantsBucketPage(c *gin.Context) bool {
+	for _, param := range []string{"limit", "start_after", "descending"} {
+		if _, ok := c.GetQuery(param); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *depotHandler) getBucketPage(c *gin.Context, space store_interface.TenancySpace, bucketID int32) {
+	opts, err := toDepotPageOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	items, next, err := h.store.DepotGetBucketPage(space, bucketID, opts)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "depot", "read", len(items))
+	c.JSON(http.StatusOK, model.DepotBucketPageResponse{Items: items, NextCursor: next})
+}
+
+// toDepotPageOptions converts and validates the paging query params of a bucket listing.
+func toDepotPageOptions(c *gin.Context) (store_interface.DepotPageOptions, error) {
+	var opts store_interface.DepotPageOptions
+	var err error
+	if raw := c.Query("limit"); raw != "" {
+		if opts.Limit, err = strconv.Atoi(raw); err != nil || opts.Limit < 0 || opts.Limit > maxDepotPageLimit {
+			return opts, fmt.Errorf("%w: limit must be between 0 and %d", store_interface.ErrInvalidQueryOptions, maxDepotPageLimit)
+		}
+	}
+	if raw := c.Query("start_after"); raw != "" {
+		if opts.StartAfter, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return opts, fmt.Errorf("%w: invalid start_after %q", store_interface.ErrInvalidQueryOptions, raw)
+		}
+	}
+	if raw := c.Query("descending"); raw != "" {
+		if opts.Descending, err = strconv.ParseBool(raw); err != nil {
+			return opts, fmt.Errorf("%w: invalid descending %q", store_interface.ErrInvalidQueryOptions, raw)
+		}
+	}
+	return opts, opts.Validate()
+}
+
+func (h *depotHandler) query(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	var req model.DepotQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Limit > maxDepotPageLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 0 and %d", maxDepotPageLimit)})
+		return
+	}
+	filters := make([]store_interface.DepotFieldFilter, len(req.Filters))
+	for i, f := range req.Filters {
+		filters[i] = store_interface.DepotFieldFilter{Path: f.Path, Op: store_interface.DepotFilterOp(f.Op), Value: f.Value, Values: f.Values}
+	}
+	opts := store_interface.DepotPageOptions{Limit: req.Limit, StartAfter: req.StartAfter, Descending: req.Descending}
+	items, next, err := h.store.DepotQuery(space, req.BucketID, filters, opts)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "depot", "read", len(items))
+	c.JSON(http.StatusOK, model.DepotBucketPageResponse{Items: items, NextCursor: next})
+}
+
+// depotETag renders a revision as a strong entity tag.
+func depotETag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// ifMatchRevision reads the revision an update is conditional on from If-Match, which
+// takes one ETag from depotETag. It returns nil when the update is unconditional, as
+// with no If-Match or "*".
+func ifMatchRevision(c *gin.Context) (*int64, error) {
+	raw := strings.TrimSpace(c.GetHeader("If-Match"))
+	if raw == "" || raw == "*" {
+		return nil, nil
+	}
+	unquoted, err := strconv.Unquote(raw)
+	if err != nil || !strings.HasPrefix(raw, `"`) {
+		return nil, fmt.Errorf("invalid If-Match %q: expected one quoted revision", raw)
+	}
+	revision, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid If-Match %q: expected one quoted revision", raw)
+	}
+	return &revision, nil
+}
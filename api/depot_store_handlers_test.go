@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
@@ -45,6 +46,20 @@ func (d *depotClient) do(method, path string, body any) *http.Response {
 	return resp
 }
 
+// doRaw sends body as is, with contentType unless it is empty.
+func (d *depotClient) doRaw(method, path, contentType string, body []byte) *http.Response {
+	d.t.Helper()
+	req, _ := http.NewRequest(method, d.srv.URL+"/depot"+path, bytes.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("X-App-Id", "1")
+	req.Header.Set("X-Tenancy-Id", "2")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(d.t, err)
+	return resp
+}
+
 func TestDepotCreateAndGet(t *testing.T) {
 	srv, _ := newDepotServer(t)
 	c := &depotClient{t: t, srv: srv}
@@ -170,3 +185,255 @@ func TestDepotDeleteByBucketAndGetAll(t *testing.T) {
 	getAllAfter.Body.Close()
 	assert.Len(t, emptyBody.Values, 0)
 }
+
+func TestDepotGetBucketPage(t *testing.T) {
+	srv, _ := newDepotServer(t)
+	c := &depotClient{t: t, srv: srv}
+
+	r := c.do(http.MethodPost, "/items/batch", model.DepotCreateManyRequest{BucketID: 5, Values: []string{"a", "b", "c"}})
+	var created model.DepotCreateManyResponse
+	json.NewDecoder(r.Body).Decode(&created)
+	r.Body.Close()
+	require.Len(t, created.IDs, 3)
+
+	resp := c.do(http.MethodGet, "/bucket/5?limit=2", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var page model.DepotBucketPageResponse
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	require.Len(t, page.Items, 2)
+	assert.Equal(t, model.DepotItem{ID: created.IDs[0], Value: "a", Revision: 1}, page.Items[0])
+	assert.Equal(t, created.IDs[1], page.NextCursor)
+
+	resp = c.do(http.MethodGet, "/bucket/5?limit=2&start_after="+strconv.FormatInt(page.NextCursor, 10), nil)
+	page = model.DepotBucketPageResponse{}
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "c", page.Items[0].Value)
+	assert.Zero(t, page.NextCursor)
+
+	resp = c.do(http.MethodGet, "/bucket/5?descending=true", nil)
+	page = model.DepotBucketPageResponse{}
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	require.Len(t, page.Items, 3)
+	assert.Equal(t, "c", page.Items[0].Value)
+
+	for _, query := range []string{"limit=-1", "limit=x", "start_after=x", "descending=maybe"} {
+		resp = c.do(http.MethodGet, "/bucket/5?"+query, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		resp.Body.Close()
+	}
+}
+
+func TestDepotUpdateIfMatch(t *testing.T) {
+	srv, _ := newDepotServer(t)
+	c := &depotClient{t: t, srv: srv}
+
+	createResp := c.do(http.MethodPost, "/items", model.DepotCreateRequest{BucketID: 1, Value: "draft"})
+	var created model.DepotCreateResponse
+	json.NewDecoder(createResp.Body).Decode(&created)
+	createResp.Body.Close()
+	assert.Equal(t, int64(1), created.Revision)
+	assert.Equal(t, `"1"`, createResp.Header.Get("ETag"))
+	path := "/items/" + strconv.FormatInt(created.ID, 10)
+
+	put := func(ifMatch, value string) *http.Response {
+		t.Helper()
+		b, _ := json.Marshal(model.DepotUpdateRequest{Value: value})
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/depot"+path, bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-App-Id", "1")
+		req.Header.Set("X-Tenancy-Id", "2")
+		req.Header.Set("If-Match", ifMatch)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Two clients read revision 1; the first save wins and the second gets 412
+	getResp := c.do(http.MethodGet, path, nil)
+	etag := getResp.Header.Get("ETag")
+	getResp.Body.Close()
+	assert.Equal(t, `"1"`, etag)
+
+	first := put(etag, "first")
+	assert.Equal(t, http.StatusOK, first.StatusCode)
+	var updated model.DepotUpdateResponse
+	json.NewDecoder(first.Body).Decode(&updated)
+	first.Body.Close()
+	assert.Equal(t, int64(2), updated.Revision)
+	assert.Equal(t, `"2"`, first.Header.Get("ETag"))
+
+	second := put(etag, "second")
+	assert.Equal(t, http.StatusPreconditionFailed, second.StatusCode)
+	assert.Equal(t, `"2"`, second.Header.Get("ETag"))
+	second.Body.Close()
+
+	getResp = c.do(http.MethodGet, path, nil)
+	var got model.DepotGetResponse
+	json.NewDecoder(getResp.Body).Decode(&got)
+	getResp.Body.Close()
+	assert.Equal(t, model.DepotGetResponse{Value: "first", Revision: 2}, got)
+
+	resp := put(`"1"`, "x")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp = put("1", "x")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestDepotQuery(t *testing.T) {
+	srv, _ := newDepotServer(t)
+	c := &depotClient{t: t, srv: srv}
+
+	r := c.do(http.MethodPost, "/items/batch", model.DepotCreateManyRequest{BucketID: 3, Values: []string{
+		`{"status":"open","priority":2}`,
+		`{"status":"closed","priority":5}`,
+		`{"status":"open","priority":7}`,
+		`plain text`,
+	}})
+	var created model.DepotCreateManyResponse
+	json.NewDecoder(r.Body).Decode(&created)
+	r.Body.Close()
+	require.Len(t, created.IDs, 4)
+
+	resp := c.do(http.MethodPost, "/query", model.DepotQueryRequest{
+		BucketID: 3,
+		Filters: []model.DepotFieldFilter{
+			{Path: "status", Op: "in", Values: []any{"open", "pending"}},
+			{Path: "priority", Op: "gt", Value: 1},
+		},
+		Limit: 1,
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var page model.DepotBucketPageResponse
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, model.DepotItem{ID: created.IDs[0], Value: `{"status":"open","priority":2}`, Revision: 1}, page.Items[0])
+	assert.Equal(t, created.IDs[0], page.NextCursor)
+
+	resp = c.do(http.MethodPost, "/query", model.DepotQueryRequest{
+		BucketID:   3,
+		Filters:    []model.DepotFieldFilter{{Path: "status", Op: "eq", Value: "open"}},
+		StartAfter: page.NextCursor,
+	})
+	page = model.DepotBucketPageResponse{}
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, created.IDs[2], page.Items[0].ID)
+	assert.Zero(t, page.NextCursor)
+
+	for _, req := range []model.DepotQueryRequest{
+		{BucketID: 3, Filters: []model.DepotFieldFilter{{Path: "status", Op: "like", Value: "o%"}}},
+		{BucketID: 3, Filters: []model.DepotFieldFilter{{Path: "$.status", Op: "exists"}}},
+		{BucketID: 3, Filters: []model.DepotFieldFilter{{Path: "priority", Op: "lt", Value: false}}},
+		{BucketID: 3, Limit: -1},
+		{BucketID: 3, Limit: 10001},
+	} {
+		resp = c.do(http.MethodPost, "/query", req)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, req)
+		resp.Body.Close()
+	}
+}
+
+func TestDepotRaw(t *testing.T) {
+	srv, _ := newDepotServer(t)
+	c := &depotClient{t: t, srv: srv}
+
+	png := []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0xff}
+	resp := c.doRaw(http.MethodPost, "/items/raw?bucketId=8", "image/png", png)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+	var created model.DepotCreateResponse
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	path := "/items/" + strconv.FormatInt(created.ID, 10)
+
+	resp = c.do(http.MethodGet, path+"/raw", nil)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	assert.Equal(t, strconv.Itoa(len(png)), resp.Header.Get("Content-Length"))
+	assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+	assert.Equal(t, png, body)
+
+	// The JSON read flags the item as raw
+	resp = c.do(http.MethodGet, path, nil)
+	var got model.DepotGetResponse
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	assert.Equal(t, model.DepotGetResponse{Value: "", Revision: 1, ContentType: "image/png"}, got)
+
+	// Replacing the bytes bumps the revision; without a Content-Type they are octet-stream
+	resp = c.doRaw(http.MethodPut, path+"/raw", "", []byte{1, 2, 3})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+	resp.Body.Close()
+	resp = c.do(http.MethodGet, path+"/raw", nil)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, []byte{1, 2, 3}, body)
+
+	// With If-Match, a stale revision is refused with the current ETag
+	putIfMatch := func(ifMatch string, data []byte) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/depot"+path+"/raw", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "image/png")
+		req.Header.Set("X-App-Id", "1")
+		req.Header.Set("X-Tenancy-Id", "2")
+		req.Header.Set("If-Match", ifMatch)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	resp = putIfMatch(`"1"`, png)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+	resp.Body.Close()
+	resp = putIfMatch(`"2"`, png)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"3"`, resp.Header.Get("ETag"))
+	resp.Body.Close()
+	resp = putIfMatch("2", png)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	// A text item reads as text/plain
+	resp = c.do(http.MethodPost, "/items", model.DepotCreateRequest{BucketID: 8, Value: "héllo"})
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	resp = c.do(http.MethodGet, "/items/"+strconv.FormatInt(created.ID, 10)+"/raw", nil)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "héllo", string(body))
+
+	resp = c.doRaw(http.MethodPut, "/items/99999/raw", "image/png", png)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+	resp = c.do(http.MethodGet, "/items/99999/raw", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+	resp = c.doRaw(http.MethodPost, "/items/raw", "image/png", png)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+	resp = c.doRaw(http.MethodPut, path+"/raw", "image/png", make([]byte, maxDepotRawBytes+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	resp.Body.Close()
+
+	// A chunked body has no Content-Length, so is cut off as it is read
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/depot"+path+"/raw", io.MultiReader(bytes.NewReader(make([]byte, maxDepotRawBytes+1))))
+	req.Header.Set("X-App-Id", "1")
+	req.Header.Set("X-Tenancy-Id", "2")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	resp.Body.Close()
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vixac/bullet/model"
@@ -19,6 +21,9 @@ type groveHandler struct {
 //	POST   {prefix}/trees/:treeId/nodes                          — create node
 //	DELETE {prefix}/trees/:treeId/nodes/:nodeId                  — delete node (?soft=true for soft delete)
 //	PATCH  {prefix}/trees/:treeId/nodes/:nodeId                  — move node
+//	PUT    {prefix}/trees/:treeId/nodes/:nodeId/metadata         — replace node metadata
+//	POST   {prefix}/trees/:treeId/nodes/:nodeId/copy             — copy subtree
+//	POST   {prefix}/trees/:treeId/nodes/:nodeId/transfer         — move subtree to another tree
 //	GET    {prefix}/trees/:treeId/nodes/:nodeId                  — get node info
 //	GET    {prefix}/trees/:treeId/nodes/:nodeId/exists           — check existence
 //	GET    {prefix}/trees/:treeId/nodes/:nodeId/children         — get children
@@ -30,12 +35,18 @@ type groveHandler struct {
 //	POST   {prefix}/trees/:treeId/bulk/ancestors                 — bulk ancestors
 //	POST   {prefix}/trees/:treeId/bulk/aggregates                — bulk subtree aggregates
 //	POST   {prefix}/trees/:treeId/bulk/aggregates/local          — bulk local aggregates
+//	GET    {prefix}/trees/:treeId/verify                         — check tree integrity
+//	POST   {prefix}/trees/:treeId/repair                         — rebuild derived tree data
+//	GET    {prefix}/trees/:treeId/changes                        — change feed (?since=&limit=&wait= to long-poll)
 func SetupGroveRouter(store store_interface.GroveStore, prefix string, engine *gin.Engine) *gin.Engine {
 	h := &groveHandler{store: store}
 	g := engine.Group(prefix)
 	g.POST("/trees/:treeId/nodes", h.createNode)
 	g.DELETE("/trees/:treeId/nodes/:nodeId", h.deleteNode)
 	g.PATCH("/trees/:treeId/nodes/:nodeId", h.moveNode)
+	g.PUT("/trees/:treeId/nodes/:nodeId/metadata", h.updateMetadata)
+	g.POST("/trees/:treeId/nodes/:nodeId/copy", h.copySubtree)
+	g.POST("/trees/:treeId/nodes/:nodeId/transfer", h.transferSubtree)
 	g.GET("/trees/:treeId/nodes/:nodeId", h.getNodeInfo)
 	g.GET("/trees/:treeId/nodes/:nodeId/exists", h.exists)
 	g.GET("/trees/:treeId/nodes/:nodeId/children", h.getChildren)
@@ -47,6 +58,9 @@ func SetupGroveRouter(store store_interface.GroveStore, prefix string, engine *g
 	g.POST("/trees/:treeId/bulk/ancestors", h.getAncestorsBulk)
 	g.POST("/trees/:treeId/bulk/aggregates", h.getSubtreeAggregatesBulk)
 	g.POST("/trees/:treeId/bulk/aggregates/local", h.getLocalAggregatesBulk)
+	g.GET("/trees/:treeId/verify", h.verifyTree)
+	g.POST("/trees/:treeId/repair", h.repairTree)
+	g.GET("/trees/:treeId/changes", h.getChanges)
 	return engine
 }
 
@@ -132,6 +146,111 @@ func (h *groveHandler) moveNode(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+func (h *groveHandler) updateMetadata(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	treeID := store_interface.TreeID(c.Param("treeId"))
+	nodeID := store_interface.NodeID(c.Param("nodeId"))
+	var req model.GroveUpdateMetadataRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	metadata := store_interface.NodeMetadata(req.Metadata)
+	if metadata == nil {
+		metadata = store_interface.NodeMetadata{}
+	}
+	if err := h.store.UpdateNodeMetadata(space, treeID, nodeID, metadata); err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "grove", "written", 1)
+	c.Status(http.StatusOK)
+}
+
+func (h *groveHandler) copySubtree(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	treeID := store_interface.TreeID(c.Param("treeId"))
+	nodeID := store_interface.NodeID(c.Param("nodeId"))
+	var req model.GroveCopySubtreeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dstTree := treeID
+	if req.DstTreeID != nil {
+		dstTree = store_interface.TreeID(*req.DstTreeID)
+	}
+	var dstParent *store_interface.NodeID
+	if req.DstParentID != nil {
+		n := store_interface.NodeID(*req.DstParentID)
+		dstParent = &n
+	}
+	opts := store_interface.CopySubtreeOptions{
+		IDStrategy:     store_interface.NodeIDStrategy(req.IDStrategy),
+		Prefix:         req.Prefix,
+		Suffix:         req.Suffix,
+		CopyAggregates: req.CopyAggregates,
+	}
+	if req.Position != nil {
+		p := store_interface.ChildPosition(*req.Position)
+		opts.Position = &p
+	}
+	mapping, err := h.store.CopySubtree(space, treeID, nodeID, dstTree, dstParent, opts)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	out := make(map[string]string, len(mapping))
+	for oldID, newID := range mapping {
+		out[string(oldID)] = string(newID)
+	}
+	incrementObjects(c, "grove", "written", len(out))
+	c.JSON(http.StatusCreated, model.GroveCopySubtreeResponse{Mapping: out})
+}
+
+func (h *groveHandler) transferSubtree(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	treeID := store_interface.TreeID(c.Param("treeId"))
+	nodeID := store_interface.NodeID(c.Param("nodeId"))
+	var req model.GroveTransferSubtreeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DstTreeID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dst_tree_id is required"})
+		return
+	}
+	var dstParent *store_interface.NodeID
+	if req.DstParentID != nil {
+		n := store_interface.NodeID(*req.DstParentID)
+		dstParent = &n
+	}
+	var position *store_interface.ChildPosition
+	if req.Position != nil {
+		p := store_interface.ChildPosition(*req.Position)
+		position = &p
+	}
+	if err := h.store.MoveSubtreeToTree(space, treeID, nodeID, store_interface.TreeID(req.DstTreeID), dstParent, position); err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "grove", "written", 1)
+	c.Status(http.StatusOK)
+}
+
 func (h *groveHandler) getNodeInfo(c *gin.Context) {
 	space, err := extractSpace(c)
 	if err != nil {
@@ -404,6 +523,162 @@ func aggregatesBulkToMap(bulk map[store_interface.NodeID]map[store_interface.Agg
 	return result
 }
 
+func (h *groveHandler) verifyTree(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	treeID := store_interface.TreeID(c.Param("treeId"))
+	report, err := h.store.VerifyTree(space, treeID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "grove", "read", report.NodeCount)
+	c.JSON(http.StatusOK, NewGroveIntegrityResponse(report))
+}
+
+func (h *groveHandler) repairTree(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	treeID := store_interface.TreeID(c.Param("treeId"))
+	report, err := h.store.RepairTree(space, treeID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "grove", "written", report.NodeCount)
+	c.JSON(http.StatusOK, NewGroveIntegrityResponse(report))
+}
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+	maxChangesWait      = 60 * time.Second
+	changesPollInterval = 250 * time.Millisecond
+)
+
+// getChanges returns changes after ?since=. With ?wait=<seconds> it long-polls,
+// holding the request open until a change arrives, the wait runs out or the
+// client goes away, and answers with an empty list on timeout.
+func (h *groveHandler) getChanges(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	treeID := store_interface.TreeID(c.Param("treeId"))
+
+	since, err := strconv.ParseInt(c.DefaultQuery("since", "0"), 10, 64)
+	if err != nil || since < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since must be a non-negative integer"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultChangesLimit)))
+	if err != nil || limit <= 0 || limit > maxChangesLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxChangesLimit)})
+		return
+	}
+	waitSeconds, err := strconv.Atoi(c.DefaultQuery("wait", "0"))
+	if err != nil || waitSeconds < 0 || time.Duration(waitSeconds)*time.Second > maxChangesWait {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "wait must be between 0 and " + strconv.Itoa(int(maxChangesWait/time.Second)) + " seconds"})
+		return
+	}
+
+	deadline := time.Now().Add(time.Duration(waitSeconds) * time.Second)
+	var changes []store_interface.GroveChange
+	for {
+		changes, err = h.store.GetChanges(space, treeID, since, limit)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if len(changes) > 0 || !time.Now().Before(deadline) {
+			break
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-time.After(changesPollInterval):
+		}
+	}
+
+	resp := model.GroveChangesResponse{
+		Changes: make([]model.GroveChange, len(changes)),
+		LastSeq: since,
+	}
+	for i, change := range changes {
+		resp.Changes[i] = newGroveChange(change)
+		resp.LastSeq = change.Seq
+	}
+	incrementObjects(c, "grove", "read", len(changes))
+	c.JSON(http.StatusOK, resp)
+}
+
+func newGroveChange(change store_interface.GroveChange) model.GroveChange {
+	item := model.GroveChange{
+		Seq:    change.Seq,
+		Kind:   string(change.Kind),
+		NodeID: string(change.NodeID),
+		Soft:   change.Soft,
+		At:     change.At,
+	}
+	if change.Parent != nil {
+		p := string(*change.Parent)
+		item.ParentID = &p
+	}
+	if change.Position != nil {
+		p := float64(*change.Position)
+		item.Position = &p
+	}
+	if change.Metadata != nil {
+		item.Metadata = *change.Metadata
+	}
+	if change.MutationID != nil {
+		m := string(*change.MutationID)
+		item.MutationID = &m
+	}
+	if change.Deltas != nil {
+		item.Deltas = make(map[string]int64, len(change.Deltas))
+		for k, v := range change.Deltas {
+			item.Deltas[string(k)] = int64(v)
+		}
+	}
+	return item
+}
+
+// NewGroveIntegrityResponse converts an integrity report to its JSON form.
+func NewGroveIntegrityResponse(report *store_interface.TreeIntegrityReport) model.GroveIntegrityReportResponse {
+	closureRows := func(pairs []store_interface.ClosurePair) []model.GroveClosureRow {
+		rows := make([]model.GroveClosureRow, len(pairs))
+		for i, pair := range pairs {
+			rows[i] = model.GroveClosureRow{
+				AncestorID:   string(pair.Ancestor),
+				DescendantID: string(pair.Descendant),
+				Depth:        pair.Depth,
+			}
+		}
+		return rows
+	}
+	return model.GroveIntegrityReportResponse{
+		TreeID:             string(report.TreeID),
+		NodeCount:          report.NodeCount,
+		Consistent:         report.Consistent(),
+		Repaired:           report.Repaired,
+		OrphanedNodes:      nodeIDsToStrings(report.OrphanedNodes),
+		CycleNodes:         nodeIDsToStrings(report.CycleNodes),
+		MissingClosure:     closureRows(report.MissingClosure),
+		StaleClosure:       closureRows(report.StaleClosure),
+		DepthMismatches:    nodeIDsToStrings(report.DepthMismatches),
+		DanglingAggregates: nodeIDsToStrings(report.DanglingAggregates),
+		DanglingMutations:  nodeIDsToStrings(report.DanglingMutations),
+	}
+}
+
 func toNodeIDs(strs []string) []store_interface.NodeID {
 	ids := make([]store_interface.NodeID, len(strs))
 	for i, s := range strs {
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -284,3 +286,215 @@ func TestGroveConflict(t *testing.T) {
 	assert.Equal(t, http.StatusConflict, dupResp.StatusCode)
 	dupResp.Body.Close()
 }
+
+func TestGroveCopySubtree(t *testing.T) {
+	srv, _ := newGroveServer(t)
+	c := &groveClient{t: t, srv: srv, treeID: "tree8"}
+
+	c.createNode("root", nil)
+	c.createNode("A", ptr("root"))
+	c.createNode("B", ptr("A"))
+	c.createNode("Z", ptr("root"))
+
+	// Copy A (with B) under Z, suffixing the new IDs
+	copyResp := c.do(http.MethodPost, "/nodes/A/copy", model.GroveCopySubtreeRequest{
+		DstParentID: ptr("Z"),
+		IDStrategy:  "affix",
+		Suffix:      "-copy",
+	})
+	require.Equal(t, http.StatusCreated, copyResp.StatusCode)
+	var copyBody model.GroveCopySubtreeResponse
+	json.NewDecoder(copyResp.Body).Decode(&copyBody)
+	copyResp.Body.Close()
+	assert.Equal(t, map[string]string{"A": "A-copy", "B": "B-copy"}, copyBody.Mapping)
+
+	ancestorResp := c.do(http.MethodGet, "/nodes/B-copy/ancestors", nil)
+	var ancestorBody model.GroveAncestorsResponse
+	json.NewDecoder(ancestorResp.Body).Decode(&ancestorBody)
+	ancestorResp.Body.Close()
+	assert.ElementsMatch(t, []string{"A-copy", "Z", "root"}, ancestorBody.Ancestors)
+
+	// Same IDs again → 409
+	dupResp := c.do(http.MethodPost, "/nodes/A/copy", model.GroveCopySubtreeRequest{
+		DstParentID: ptr("Z"),
+		IDStrategy:  "affix",
+		Suffix:      "-copy",
+	})
+	assert.Equal(t, http.StatusConflict, dupResp.StatusCode)
+	dupResp.Body.Close()
+
+	// Unknown ID strategy → 400
+	badResp := c.do(http.MethodPost, "/nodes/A/copy", model.GroveCopySubtreeRequest{IDStrategy: "sequential"})
+	assert.Equal(t, http.StatusBadRequest, badResp.StatusCode)
+	badResp.Body.Close()
+
+	// Copy into another tree with generated IDs
+	otherResp := c.do(http.MethodPost, "/nodes/A/copy", model.GroveCopySubtreeRequest{
+		DstTreeID:  ptr("tree8-other"),
+		IDStrategy: "uuid",
+	})
+	require.Equal(t, http.StatusCreated, otherResp.StatusCode)
+	var otherBody model.GroveCopySubtreeResponse
+	json.NewDecoder(otherResp.Body).Decode(&otherBody)
+	otherResp.Body.Close()
+	require.Len(t, otherBody.Mapping, 2)
+
+	other := &groveClient{t: t, srv: srv, treeID: "tree8-other"}
+	childrenResp := other.do(http.MethodGet, "/nodes/"+otherBody.Mapping["A"]+"/children", nil)
+	var childrenBody model.GroveChildrenResponse
+	json.NewDecoder(childrenResp.Body).Decode(&childrenBody)
+	childrenResp.Body.Close()
+	assert.Equal(t, []string{otherBody.Mapping["B"]}, childrenBody.Children)
+}
+
+func TestGroveTransferSubtree(t *testing.T) {
+	srv, _ := newGroveServer(t)
+	c := &groveClient{t: t, srv: srv, treeID: "tree9"}
+	dst := &groveClient{t: t, srv: srv, treeID: "tree9-dst"}
+
+	c.createNode("root", nil)
+	c.createNode("A", ptr("root"))
+	c.createNode("B", ptr("A"))
+	dst.createNode("droot", nil)
+
+	moveResp := c.do(http.MethodPost, "/nodes/A/transfer", model.GroveTransferSubtreeRequest{
+		DstTreeID:   "tree9-dst",
+		DstParentID: ptr("droot"),
+	})
+	assert.Equal(t, http.StatusOK, moveResp.StatusCode)
+	moveResp.Body.Close()
+
+	goneResp := c.do(http.MethodGet, "/nodes/B/exists", nil)
+	var goneBody model.GroveExistsResponse
+	json.NewDecoder(goneResp.Body).Decode(&goneBody)
+	goneResp.Body.Close()
+	assert.False(t, goneBody.Exists)
+
+	ancestorResp := dst.do(http.MethodGet, "/nodes/B/ancestors", nil)
+	var ancestorBody model.GroveAncestorsResponse
+	json.NewDecoder(ancestorResp.Body).Decode(&ancestorBody)
+	ancestorResp.Body.Close()
+	assert.ElementsMatch(t, []string{"A", "droot"}, ancestorBody.Ancestors)
+
+	// Missing destination tree → 400
+	badResp := c.do(http.MethodPost, "/nodes/root/transfer", model.GroveTransferSubtreeRequest{})
+	assert.Equal(t, http.StatusBadRequest, badResp.StatusCode)
+	badResp.Body.Close()
+
+	// Moving an ID that already exists in the destination → 409
+	c.createNode("droot", ptr("root"))
+	dupResp := c.do(http.MethodPost, "/nodes/droot/transfer", model.GroveTransferSubtreeRequest{DstTreeID: "tree9-dst"})
+	assert.Equal(t, http.StatusConflict, dupResp.StatusCode)
+	dupResp.Body.Close()
+}
+
+func TestGroveVerifyAndRepair(t *testing.T) {
+	srv, _ := newGroveServer(t)
+	c := &groveClient{t: t, srv: srv, treeID: "tree10"}
+
+	c.createNode("root", nil)
+	c.createNode("leaf", ptr("root"))
+	mutResp := c.do(http.MethodPost, "/nodes/leaf/mutations", model.GroveApplyMutationRequest{
+		MutationID: "m1",
+		Deltas:     map[string]int64{"count": 1},
+	})
+	mutResp.Body.Close()
+	delResp := c.do(http.MethodDelete, "/nodes/leaf", nil)
+	delResp.Body.Close()
+
+	verifyResp := c.do(http.MethodGet, "/verify", nil)
+	assert.Equal(t, http.StatusOK, verifyResp.StatusCode)
+	var verifyBody model.GroveIntegrityReportResponse
+	json.NewDecoder(verifyResp.Body).Decode(&verifyBody)
+	verifyResp.Body.Close()
+	assert.False(t, verifyBody.Consistent)
+	assert.Equal(t, 1, verifyBody.NodeCount)
+	assert.Equal(t, []string{"leaf"}, verifyBody.DanglingAggregates)
+
+	repairResp := c.do(http.MethodPost, "/repair", nil)
+	assert.Equal(t, http.StatusOK, repairResp.StatusCode)
+	var repairBody model.GroveIntegrityReportResponse
+	json.NewDecoder(repairResp.Body).Decode(&repairBody)
+	repairResp.Body.Close()
+	assert.True(t, repairBody.Repaired)
+
+	againResp := c.do(http.MethodGet, "/verify", nil)
+	var againBody model.GroveIntegrityReportResponse
+	json.NewDecoder(againResp.Body).Decode(&againBody)
+	againResp.Body.Close()
+	assert.True(t, againBody.Consistent)
+}
+
+func TestGroveChangeFeed(t *testing.T) {
+	srv, _ := newGroveServer(t)
+	c := &groveClient{t: t, srv: srv, treeID: "tree11"}
+
+	getChanges := func(query string) model.GroveChangesResponse {
+		t.Helper()
+		resp := c.do(http.MethodGet, "/changes"+query, nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body model.GroveChangesResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body
+	}
+
+	c.createNode("root", nil)
+	c.createNode("child", ptr("root"))
+	metaResp := c.do(http.MethodPut, "/nodes/child/metadata", model.GroveUpdateMetadataRequest{
+		Metadata: map[string]interface{}{"title": "hello"},
+	})
+	metaResp.Body.Close()
+	assert.Equal(t, http.StatusOK, metaResp.StatusCode)
+
+	missingResp := c.do(http.MethodPut, "/nodes/missing/metadata", model.GroveUpdateMetadataRequest{
+		Metadata: map[string]interface{}{},
+	})
+	missingResp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, missingResp.StatusCode)
+
+	body := getChanges("")
+	require.Len(t, body.Changes, 3)
+	assert.Equal(t, "create", body.Changes[0].Kind)
+	assert.Equal(t, "child", body.Changes[1].NodeID)
+	assert.Equal(t, "root", *body.Changes[1].ParentID)
+	assert.Equal(t, "metadata", body.Changes[2].Kind)
+	assert.Equal(t, "hello", body.Changes[2].Metadata["title"])
+	assert.Equal(t, body.Changes[2].Seq, body.LastSeq)
+
+	paged := getChanges("?since=1&limit=1")
+	require.Len(t, paged.Changes, 1)
+	assert.Equal(t, "child", paged.Changes[0].NodeID)
+	assert.Equal(t, paged.Changes[0].Seq, paged.LastSeq)
+
+	// Nothing new: the cursor is echoed back
+	empty := getChanges("?since=" + strconv.FormatInt(body.LastSeq, 10))
+	assert.Empty(t, empty.Changes)
+	assert.Equal(t, body.LastSeq, empty.LastSeq)
+
+	// A long-poll returns as soon as a change lands
+	done := make(chan model.GroveChangesResponse)
+	go func() {
+		done <- getChanges("?wait=10&since=" + strconv.FormatInt(body.LastSeq, 10))
+	}()
+	time.Sleep(300 * time.Millisecond)
+	mutResp := c.do(http.MethodPost, "/nodes/child/mutations", model.GroveApplyMutationRequest{
+		MutationID: "m1",
+		Deltas:     map[string]int64{"count": 2},
+	})
+	mutResp.Body.Close()
+	select {
+	case polled := <-done:
+		require.Len(t, polled.Changes, 1)
+		assert.Equal(t, "mutation", polled.Changes[0].Kind)
+		assert.Equal(t, int64(2), polled.Changes[0].Deltas["count"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("long-poll did not return after a change")
+	}
+
+	for _, query := range []string{"?since=-1", "?since=abc", "?limit=0", "?limit=5000", "?wait=-1", "?wait=600"} {
+		resp := c.do(http.MethodGet, "/changes"+query, nil)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
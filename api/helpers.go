@@ -36,17 +36,30 @@ func extractSpace(c *gin.Context) (store_interface.TenancySpace, error) {
 	}, nil
 }
 
-// respondError maps well-known grove store errors to appropriate HTTP status codes.
+// respondError maps well-known store errors to appropriate HTTP status codes.
 func respondError(c *gin.Context, err error) {
 	switch {
-	case errors.Is(err, store_interface.ErrNodeNotFound):
+	case errors.Is(err, store_interface.ErrNodeNotFound), errors.Is(err, store_interface.ErrTrackKeyNotFound),
+		errors.Is(err, store_interface.ErrDepotItemNotFound),
+		errors.Is(err, store_interface.ErrTrackBucketNotFound):
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 	case errors.Is(err, store_interface.ErrNodeAlreadyExists):
 		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 	case errors.Is(err, store_interface.ErrCycleDetected):
 		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
-	case errors.Is(err, store_interface.ErrMutationConflict):
+	case errors.Is(err, store_interface.ErrMutationConflict), errors.Is(err, store_interface.ErrTrackValueMismatch),
+		errors.Is(err, store_interface.ErrTrackPutRejected):
 		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, store_interface.ErrInvalidCopyOptions), errors.Is(err, store_interface.ErrInvalidMetricFilter),
+		errors.Is(err, store_interface.ErrInvalidQueryOptions), errors.Is(err, store_interface.ErrInvalidPutCondition),
+		errors.Is(err, store_interface.ErrInvalidHistoryPolicy), errors.Is(err, store_interface.ErrInvalidDepotQuery):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, store_interface.ErrTrackWatchResumeGap):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, store_interface.ErrDepotRevisionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	case errors.Is(err, store_interface.ErrNotImplemented):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
 	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
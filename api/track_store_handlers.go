@@ -1,8 +1,14 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vixac/bullet/model"
@@ -10,30 +16,77 @@ import (
 )
 
 type trackHandler struct {
-	store store_interface.TrackStore
+	store   store_interface.TrackStore
+	watcher store_interface.TrackWatcher
 }
 
+// DefaultTrackWatchBacklog is how many changes SetupTrackRouter retains for resuming watches
+// when it has to wrap a store that does not publish its changes.
+const DefaultTrackWatchBacklog = 10000
+
 // SetupTrackRouter registers all track endpoints under the given prefix.
 //
 // Endpoints:
 //
-//	POST   {prefix}/items          — upsert one
-//	POST   {prefix}/items/batch    — upsert many
-//	POST   {prefix}/items/get      — get one (key in body to support arbitrary key strings)
+//	POST   {prefix}/items          — upsert one (409 when its condition is not met)
+//	POST   {prefix}/items/batch    — upsert many (with a condition, lists the written and rejected keys)
+//	POST   {prefix}/items/get      — get one (key in body to support arbitrary key strings; ?legacy=true for {"value": n})
 //	POST   {prefix}/items/batch-get — get many
+//	POST   {prefix}/items/increment — atomically add to a value
+//	POST   {prefix}/items/cas      — compare-and-swap (409 on mismatch)
 //	DELETE {prefix}/items          — delete many
-//	POST   {prefix}/query          — prefix query
-//	POST   {prefix}/query/multi    — multi-prefix query
+//	POST   {prefix}/items/delete-by-prefix — delete every key under the prefixes, optionally filtered by tag and metric
+//	POST   {prefix}/items/history  — prior values of a key in a bucket that keeps history, newest first
+//	POST   {prefix}/query          — prefix query (limit, start_after and descending page through keys,
+//	                                 1000 a page unless limit is set or all asks for every match;
+//	                                 order_by value or metric returns the top limit items instead;
+//	                                 tags match items holding any of them, or all with tag_match "all")
+//	POST   {prefix}/query/multi    — multi-prefix query (paged the same way)
+//	POST   {prefix}/query/range    — key range query, start inclusive and end exclusive by default (paged the same way)
+//	POST   {prefix}/query/by-value — keys holding any of the values, optionally under a prefix (paged in key order)
+//	POST   {prefix}/query/aggregate — count, sum, min, max and avg over prefixes, optionally grouped by tag
+//	GET    {prefix}/buckets        — bucket IDs holding live keys
+//	GET    {prefix}/buckets/:bucketId/stats — key count, key and value ranges and approximate size of a bucket
+//	GET    {prefix}/buckets/:bucketId/export — stream the live keys of a bucket as NDJSON, in key order
+//	POST   {prefix}/buckets/:bucketId/import — write NDJSON lines into a bucket in batches
+//	GET    {prefix}/buckets/:bucketId/history — the bucket's history policy
+//	PUT    {prefix}/buckets/:bucketId/history — set the history policy; an empty policy turns history off
+//	GET    {prefix}/watch          — server-sent events for puts and deletes under ?bucketId=&prefix=, resuming
+//	                                 after the Last-Event-ID header or ?since= sequence (410 once it is no longer retained);
+//	                                 expired keys arrive as deletes once the sweeper removes them
+//
+// Watches only see the writes made through a store_interface.TrackWatcher, so a store that
+// is not one is wrapped in a WatchedTrackStore; pass the same wrapped store to anything
+// else that writes to it.
 func SetupTrackRouter(store store_interface.TrackStore, prefix string, engine *gin.Engine) *gin.Engine {
-	h := &trackHandler{store: store}
+	watcher, ok := store.(store_interface.TrackWatcher)
+	if !ok {
+		watched := store_interface.NewWatchedTrackStore(store, DefaultTrackWatchBacklog)
+		store, watcher = watched, watched
+	}
+	h := &trackHandler{store: store, watcher: watcher}
 	g := engine.Group(prefix)
 	g.POST("/items", h.upsertOne)
 	g.POST("/items/batch", h.upsertMany)
 	g.POST("/items/get", h.getOne)
 	g.POST("/items/batch-get", h.getMany)
+	g.POST("/items/increment", h.increment)
+	g.POST("/items/cas", h.compareAndSwap)
 	g.DELETE("/items", h.deleteMany)
+	g.POST("/items/delete-by-prefix", h.deleteByPrefix)
+	g.POST("/items/history", h.history)
 	g.POST("/query", h.queryByPrefix)
 	g.POST("/query/multi", h.queryByPrefixes)
+	g.POST("/query/range", h.queryByRange)
+	g.POST("/query/by-value", h.queryByValue)
+	g.POST("/query/aggregate", h.aggregate)
+	g.GET("/buckets", h.listBuckets)
+	g.GET("/buckets/:bucketId/stats", h.bucketStats)
+	g.GET("/buckets/:bucketId/export", h.exportBucket)
+	g.POST("/buckets/:bucketId/import", h.importBucket)
+	g.GET("/buckets/:bucketId/history", h.getHistoryPolicy)
+	g.PUT("/buckets/:bucketId/history", h.setHistoryPolicy)
+	g.GET("/watch", h.watch)
 	return engine
 }
 
@@ -48,7 +101,34 @@ func (h *trackHandler) upsertOne(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.store.TrackPut(space, req.BucketID, req.Key, req.Value, req.Tag, req.Metric); err != nil {
+	expiresAt, err := toExpiry(req.TTLSeconds, req.ExpiresAt, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cond, err := toPutCondition(req.Condition, req.ConditionTag)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// TrackPut takes a single tag, so further tags go through the batch puts
+	item := model.TrackKeyValueItem{Key: req.Key, Value: model.TrackValue{Value: req.Value, Tag: req.Tag, Tags: req.Tags, Metric: req.Metric, ExpiresAt: expiresAt}}
+	if cond != nil {
+		result, err := h.store.TrackPutManyIf(space, map[int32][]model.TrackKeyValueItem{req.BucketID: {item}}, *cond)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if len(result.Written) == 0 {
+			respondError(c, store_interface.ErrTrackPutRejected)
+			return
+		}
+	} else if len(req.Tags) > 0 {
+		if err := h.store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{req.BucketID: {item}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	} else if err := h.store.TrackPut(space, req.BucketID, req.Key, req.Value, req.Tag, req.Metric, expiresAt); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -67,9 +147,31 @@ func (h *trackHandler) upsertMany(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	now := time.Now()
 	items := make(map[int32][]model.TrackKeyValueItem)
 	for _, bucket := range req.Buckets {
-		items[bucket.BucketID] = append(items[bucket.BucketID], bucket.Items...)
+		for _, item := range bucket.Items {
+			if item.Value.ExpiresAt, err = toExpiry(item.TTLSeconds, item.ExpiresAt, now); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("key %q: %s", item.Key, err)})
+				return
+			}
+			items[bucket.BucketID] = append(items[bucket.BucketID], item)
+		}
+	}
+	cond, err := toPutCondition(req.Condition, req.ConditionTag)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if cond != nil {
+		result, err := h.store.TrackPutManyIf(space, items, *cond)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		incrementObjects(c, "track", "written", len(result.Written))
+		c.JSON(http.StatusOK, result)
+		return
 	}
 	if err := h.store.TrackPutMany(space, items); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -94,13 +196,57 @@ func (h *trackHandler) getOne(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	value, err := h.store.TrackGet(space, req.BucketID, req.Key)
+	value, err := h.store.TrackGetValue(space, req.BucketID, req.Key)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 	incrementObjects(c, "track", "read", 1)
-	c.JSON(http.StatusOK, gin.H{"value": value})
+	// Older clients expect only the bare value
+	if c.Query("legacy") == "true" {
+		c.JSON(http.StatusOK, gin.H{"value": value.Value})
+		return
+	}
+	c.JSON(http.StatusOK, model.TrackKeyValueItem{Key: req.Key, Value: value, ExpiresAt: value.ExpiresAt})
+}
+
+func (h *trackHandler) increment(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	var req model.TrackIncrementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	value, err := h.store.TrackIncrement(space, req.BucketID, req.Key, req.Delta)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "track", "written", 1)
+	c.JSON(http.StatusOK, model.TrackIncrementResponse{Key: req.Key, Value: value.Value})
+}
+
+func (h *trackHandler) compareAndSwap(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	var req model.TrackCompareAndSwapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.store.TrackCompareAndSwap(space, req.BucketID, req.Key, req.Expected, req.Value, req.Tag, req.Metric); err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "track", "written", 1)
+	c.Status(http.StatusOK)
 }
 
 func (h *trackHandler) getMany(c *gin.Context) {
@@ -158,6 +304,57 @@ func (h *trackHandler) deleteMany(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+func (h *trackHandler) deleteByPrefix(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	var req model.TrackDeleteByPrefixRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	metric, err := toMetricFilter(req.Metric)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	deleted, err := h.store.TrackDeleteByPrefix(space, req.BucketID, req.Prefixes, req.Tags, metric)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, model.TrackDeleteByPrefixResponse{Deleted: deleted})
+}
+
+func (h *trackHandler) history(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	var req model.TrackHistoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must not be negative"})
+		return
+	}
+	entries, err := h.store.TrackGetHistory(space, req.BucketID, req.Key, req.Limit)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if entries == nil {
+		entries = []model.TrackHistoryEntry{}
+	}
+	incrementObjects(c, "track", "read", len(entries))
+	c.JSON(http.StatusOK, model.TrackHistoryResponse{Key: req.Key, Entries: entries})
+}
+
 func (h *trackHandler) queryByPrefix(c *gin.Context) {
 	space, err := extractSpace(c)
 	if err != nil {
@@ -169,19 +366,23 @@ func (h *trackHandler) queryByPrefix(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	var metricValue *float64
-	var isGt bool
-	if req.Metric != nil {
-		metricValue = &req.Metric.Value
-		isGt = req.Metric.Operator == "gt"
+	metric, err := toMetricFilter(req.Metric)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	items, err := h.store.GetItemsByKeyPrefix(space, req.BucketID, req.Prefix, req.Tags, metricValue, isGt)
+	opts, err := toQueryOptions(req.TrackPageRequest)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	items, next, err := h.store.GetItemsByKeyPrefixesPage(space, req.BucketID, []string{req.Prefix}, req.Tags, metric, opts)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 	incrementObjects(c, "track", "read", len(items))
-	c.JSON(http.StatusOK, gin.H{"items": items})
+	c.JSON(http.StatusOK, model.TrackQueryResponse{Items: withExpiry(items), NextCursor: next})
 }
 
 func (h *trackHandler) queryByPrefixes(c *gin.Context) {
@@ -195,17 +396,416 @@ func (h *trackHandler) queryByPrefixes(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	var metricValue *float64
-	var isGt bool
-	if req.Metric != nil {
-		metricValue = &req.Metric.Value
-		isGt = req.Metric.Operator == "gt"
+	metric, err := toMetricFilter(req.Metric)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	items, err := h.store.GetItemsByKeyPrefixes(space, req.BucketID, req.Prefixes, req.Tags, metricValue, isGt)
+	opts, err := toQueryOptions(req.TrackPageRequest)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	items, next, err := h.store.GetItemsByKeyPrefixesPage(space, req.BucketID, req.Prefixes, req.Tags, metric, opts)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 	incrementObjects(c, "track", "read", len(items))
-	c.JSON(http.StatusOK, gin.H{"items": items})
+	c.JSON(http.StatusOK, model.TrackQueryResponse{Items: withExpiry(items), NextCursor: next})
+}
+
+func (h *trackHandler) queryByRange(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	var req model.TrackGetItemsByRangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	metric, err := toMetricFilter(req.Metric)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts, err := toQueryOptions(req.TrackPageRequest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	keyRange := store_interface.TrackKeyRange{
+		Start:          req.Start,
+		End:            req.End,
+		StartInclusive: req.StartInclusive == nil || *req.StartInclusive,
+		EndInclusive:   req.EndInclusive,
+	}
+	items, next, err := h.store.GetItemsByKeyRange(space, req.BucketID, keyRange, req.Tags, metric, opts)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "track", "read", len(items))
+	c.JSON(http.StatusOK, model.TrackQueryResponse{Items: withExpiry(items), NextCursor: next})
+}
+
+func (h *trackHandler) queryByValue(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	var req model.TrackGetKeysByValueRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	values := make([]int64, len(req.Values))
+	for i, v := range req.Values {
+		if values[i], err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid value %q", v)})
+			return
+		}
+	}
+	opts, err := toQueryOptions(req.TrackPageRequest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	items, next, err := h.store.GetKeysByValue(space, req.BucketID, values, req.Prefix, opts)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	incrementObjects(c, "track", "read", len(items))
+	c.JSON(http.StatusOK, model.TrackQueryResponse{Items: withExpiry(items), NextCursor: next})
+}
+
+func (h *trackHandler) aggregate(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	var req model.TrackAggregateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	metric, err := toMetricFilter(req.Metric)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	groups, err := h.store.TrackAggregate(space, req.BucketID, req.Prefixes, req.Tags, metric, req.GroupByTag)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, model.TrackAggregateResponse{Groups: groups})
+}
+
+func (h *trackHandler) listBuckets(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	buckets, err := h.store.ListTrackBuckets(space)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if buckets == nil {
+		buckets = []int32{}
+	}
+	c.JSON(http.StatusOK, model.TrackBucketsResponse{Buckets: buckets})
+}
+
+func (h *trackHandler) bucketStats(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	bucketID, err := strconv.ParseInt(c.Param("bucketId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucketId"})
+		return
+	}
+	stats, err := h.store.TrackBucketStats(space, int32(bucketID))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+func (h *trackHandler) getHistoryPolicy(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	bucketID, err := strconv.ParseInt(c.Param("bucketId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucketId"})
+		return
+	}
+	policy, err := h.store.TrackGetHistoryPolicy(space, int32(bucketID))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, model.TrackHistoryPolicy{
+		MaxVersions:   policy.MaxVersions,
+		MaxAgeSeconds: int64(policy.MaxAge / time.Second),
+	})
+}
+
+func (h *trackHandler) setHistoryPolicy(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	bucketID, err := strconv.ParseInt(c.Param("bucketId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucketId"})
+		return
+	}
+	var req model.TrackHistoryPolicy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	policy := store_interface.TrackHistoryPolicy{
+		MaxVersions: req.MaxVersions,
+		MaxAge:      time.Duration(req.MaxAgeSeconds) * time.Second,
+	}
+	if err := h.store.TrackSetHistoryPolicy(space, int32(bucketID), policy); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+const (
+	trackExportFlushEvery  = 1000
+	trackImportBatchSize   = 1000
+	trackImportMaxLineSize = 1 << 20
+)
+
+func (h *trackHandler) exportBucket(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	bucketID, err := strconv.ParseInt(c.Param("bucketId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucketId"})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	count := 0
+	err = h.store.TrackScanBucket(space, int32(bucketID), func(item model.TrackKeyValueItem) error {
+		if err := enc.Encode(model.TrackExportItem{
+			Key:       item.Key,
+			Value:     item.Value.Value,
+			Tag:       item.Value.Tag,
+			Tags:      item.Value.Tags,
+			Metric:    item.Value.Metric,
+			ExpiresAt: item.Value.ExpiresAt,
+		}); err != nil {
+			return err
+		}
+		count++
+		if count%trackExportFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	incrementObjects(c, "track", "read", count)
+	if err != nil {
+		// Once lines have gone out the status is sent; the client sees a truncated stream
+		if !c.Writer.Written() {
+			respondError(c, err)
+			return
+		}
+		log.Printf("track export of bucket %d stopped after %d items: %v", bucketID, count, err)
+		c.Abort()
+	}
+}
+
+func (h *trackHandler) importBucket(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	bucketID, err := strconv.ParseInt(c.Param("bucketId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucketId"})
+		return
+	}
+
+	var summary model.TrackImportResponse
+	batch := make([]model.TrackKeyValueItem, 0, trackImportBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := h.store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{int32(bucketID): batch}); err != nil {
+			return err
+		}
+		summary.Imported += len(batch)
+		summary.Batches++
+		batch = make([]model.TrackKeyValueItem, 0, trackImportBatchSize)
+		return nil
+	}
+	fail := func(status int, err error) {
+		incrementObjects(c, "track", "written", summary.Imported)
+		summary.Error = err.Error()
+		c.JSON(status, summary)
+	}
+
+	now := time.Now()
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), trackImportMaxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var item model.TrackExportItem
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			fail(http.StatusBadRequest, fmt.Errorf("line %d: %w", line, err))
+			return
+		}
+		if item.ExpiresAt != nil && !now.Before(*item.ExpiresAt) {
+			summary.Expired++
+			continue
+		}
+		batch = append(batch, model.TrackKeyValueItem{
+			Key:   item.Key,
+			Value: model.TrackValue{Value: item.Value, Tag: item.Tag, Tags: item.Tags, Metric: item.Metric, ExpiresAt: item.ExpiresAt},
+		})
+		if len(batch) == trackImportBatchSize {
+			if err := flush(); err != nil {
+				fail(http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	if err := flush(); err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	incrementObjects(c, "track", "written", summary.Imported)
+	c.JSON(http.StatusOK, summary)
+}
+
+// toPutCondition converts the optional condition of a write; nil means write unconditionally.
+func toPutCondition(condition string, tag *int64) (*store_interface.TrackPutCondition, error) {
+	if condition == "" {
+		if tag != nil {
+			return nil, fmt.Errorf("%w: condition_tag needs condition %s", store_interface.ErrInvalidPutCondition, store_interface.TrackPutIfTag)
+		}
+		return nil, nil
+	}
+	cond := store_interface.TrackPutCondition{Mode: store_interface.TrackPutMode(condition), Tag: tag}
+	if err := cond.Validate(); err != nil {
+		return nil, err
+	}
+	return &cond, nil
+}
+
+// toExpiry resolves the optional ttl_seconds or expires_at of a write into an expiry time.
+func toExpiry(ttlSeconds *int64, expiresAt *time.Time, now time.Time) (*time.Time, error) {
+	switch {
+	case ttlSeconds != nil && expiresAt != nil:
+		return nil, fmt.Errorf("set either ttl_seconds or expires_at, not both")
+	case ttlSeconds != nil:
+		if *ttlSeconds <= 0 {
+			return nil, fmt.Errorf("ttl_seconds must be positive")
+		}
+		t := now.Add(time.Duration(*ttlSeconds) * time.Second)
+		return &t, nil
+	default:
+		return expiresAt, nil
+	}
+}
+
+// withExpiry lifts each item's stored expiry to the top level of the response.
+func withExpiry(items []model.TrackKeyValueItem) []model.TrackKeyValueItem {
+	for i := range items {
+		items[i].ExpiresAt = items[i].Value.ExpiresAt
+	}
+	return items
+}
+
+// toMetricFilter converts and validates the optional metric filter of a query request.
+func toMetricFilter(f *model.MetricFilter) (*store_interface.MetricFilter, error) {
+	if f == nil {
+		return nil, nil
+	}
+	filter := store_interface.MetricFilter{
+		Operator: store_interface.MetricOperator(f.Operator),
+		Value:    f.Value,
+	}
+	if filter.Operator == store_interface.MetricBetween {
+		if f.Upper == nil {
+			return nil, fmt.Errorf("%w: between needs an upper bound", store_interface.ErrInvalidMetricFilter)
+		}
+		filter.Upper = *f.Upper
+	} else if f.Upper != nil {
+		return nil, fmt.Errorf("%w: upper is only used by between", store_interface.ErrInvalidMetricFilter)
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	return &filter, nil
+}
+
+const (
+	defaultTrackQueryLimit = 1000
+	maxTrackQueryLimit     = 10000
+)
+
+// toQueryOptions converts and validates the paging fields of a query request. A request
+// without a limit gets defaultTrackQueryLimit, so a large prefix is never read whole
+// unless the client asks for it with all.
+func toQueryOptions(req model.TrackPageRequest) (store_interface.TrackQueryOptions, error) {
+	if req.Limit < 0 || req.Limit > maxTrackQueryLimit {
+		return store_interface.TrackQueryOptions{}, fmt.Errorf("%w: limit must be between 0 and %d", store_interface.ErrInvalidQueryOptions, maxTrackQueryLimit)
+	}
+	limit := req.Limit
+	switch {
+	case req.All && limit != 0:
+		return store_interface.TrackQueryOptions{}, fmt.Errorf("%w: all cannot be combined with a limit", store_interface.ErrInvalidQueryOptions)
+	case req.All:
+		limit = 0
+	case limit == 0:
+		limit = defaultTrackQueryLimit
+	}
+	opts := store_interface.TrackQueryOptions{
+		Limit:      limit,
+		StartAfter: req.StartAfter,
+		Descending: req.Descending,
+		OrderBy:    store_interface.TrackOrderBy(req.OrderBy),
+		TagMatch:   store_interface.TrackTagMatch(req.TagMatch),
+	}
+	if err := opts.Validate(); err != nil {
+		return store_interface.TrackQueryOptions{}, err
+	}
+	return opts, nil
 }
@@ -1,17 +1,25 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vixac/bullet/metrics"
 	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/ram"
+	store_interface "github.com/vixac/bullet/store/store_interface"
 )
 
 func newTrackServer(t *testing.T) (*httptest.Server, string) {
@@ -48,6 +56,39 @@ func trackDelete(t *testing.T, srv *httptest.Server, path string, body any) *htt
 	return resp
 }
 
+func trackPut(t *testing.T, srv *httptest.Server, path string, body any) *http.Response {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/track"+path, bytes.NewBuffer(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-App-Id", "1")
+	req.Header.Set("X-Tenancy-Id", "2")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func trackPostRaw(t *testing.T, srv *httptest.Server, path string, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/track"+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("X-App-Id", "1")
+	req.Header.Set("X-Tenancy-Id", "2")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func trackGet(t *testing.T, srv *httptest.Server, path string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/track"+path, nil)
+	req.Header.Set("X-App-Id", "1")
+	req.Header.Set("X-Tenancy-Id", "2")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
 func TestTrackUpsertAndGetOne(t *testing.T) {
 	srv, _ := newTrackServer(t)
 
@@ -61,10 +102,26 @@ func TestTrackUpsertAndGetOne(t *testing.T) {
 
 	getResp := trackPost(t, srv, "/items/get", model.TrackRequest{BucketID: 10, Key: "hello"})
 	assert.Equal(t, http.StatusOK, getResp.StatusCode)
-	var body map[string]int64
+	var body model.TrackKeyValueItem
 	json.NewDecoder(getResp.Body).Decode(&body)
 	getResp.Body.Close()
-	assert.Equal(t, int64(42), body["value"])
+	assert.Equal(t, "hello", body.Key)
+	assert.Equal(t, int64(42), body.Value.Value)
+	require.NotNil(t, body.Value.Tag)
+	assert.Equal(t, tag, *body.Value.Tag)
+	require.NotNil(t, body.Value.Metric)
+	assert.Equal(t, metric, *body.Value.Metric)
+
+	legacyResp := trackPost(t, srv, "/items/get?legacy=true", model.TrackRequest{BucketID: 10, Key: "hello"})
+	assert.Equal(t, http.StatusOK, legacyResp.StatusCode)
+	var legacyBody map[string]any
+	json.NewDecoder(legacyResp.Body).Decode(&legacyBody)
+	legacyResp.Body.Close()
+	assert.Equal(t, map[string]any{"value": float64(42)}, legacyBody)
+
+	missingResp := trackPost(t, srv, "/items/get", model.TrackRequest{BucketID: 10, Key: "missing"})
+	missingResp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, missingResp.StatusCode)
 }
 
 func TestTrackUpsertMany(t *testing.T) {
@@ -178,3 +235,712 @@ func TestTrackMissingHeaders(t *testing.T) {
 	resp.Body.Close()
 	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 }
+
+func TestTrackIncrementAndCompareAndSwap(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	incResp := trackPost(t, srv, "/items/increment", model.TrackIncrementRequest{BucketID: 3, Key: "hits", Delta: 4})
+	assert.Equal(t, http.StatusOK, incResp.StatusCode)
+	incResp.Body.Close()
+
+	incResp = trackPost(t, srv, "/items/increment", model.TrackIncrementRequest{BucketID: 3, Key: "hits", Delta: 2})
+	assert.Equal(t, http.StatusOK, incResp.StatusCode)
+	var incBody model.TrackIncrementResponse
+	json.NewDecoder(incResp.Body).Decode(&incBody)
+	incResp.Body.Close()
+	assert.Equal(t, "hits", incBody.Key)
+	assert.Equal(t, int64(6), incBody.Value)
+
+	casResp := trackPost(t, srv, "/items/cas", model.TrackCompareAndSwapRequest{BucketID: 3, Key: "lock", Value: 1})
+	casResp.Body.Close()
+	assert.Equal(t, http.StatusOK, casResp.StatusCode)
+
+	casResp = trackPost(t, srv, "/items/cas", model.TrackCompareAndSwapRequest{BucketID: 3, Key: "lock", Value: 2})
+	casResp.Body.Close()
+	assert.Equal(t, http.StatusConflict, casResp.StatusCode)
+
+	expected := int64(1)
+	casResp = trackPost(t, srv, "/items/cas", model.TrackCompareAndSwapRequest{BucketID: 3, Key: "lock", Expected: &expected, Value: 0})
+	casResp.Body.Close()
+	assert.Equal(t, http.StatusOK, casResp.StatusCode)
+
+	casResp = trackPost(t, srv, "/items/cas", model.TrackCompareAndSwapRequest{BucketID: 3, Key: "lock", Expected: &expected, Value: 5})
+	casResp.Body.Close()
+	assert.Equal(t, http.StatusConflict, casResp.StatusCode)
+
+	getResp := trackPost(t, srv, "/items/get", model.TrackRequest{BucketID: 3, Key: "lock"})
+	var body model.TrackKeyValueItem
+	json.NewDecoder(getResp.Body).Decode(&body)
+	getResp.Body.Close()
+	assert.Equal(t, int64(0), body.Value.Value)
+}
+
+func TestTrackQueryMetricFilters(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	for i, metric := range []float64{10, 20, 30} {
+		m := metric
+		r := trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: "m:" + strconv.Itoa(i), Value: 5, Metric: &m})
+		r.Body.Close()
+	}
+
+	upper := 25.0
+	queryResp := trackPost(t, srv, "/query", model.TrackGetItemsByPrefixRequest{
+		BucketID: 1, Prefix: "m:", Metric: &model.MetricFilter{Operator: "between", Value: 15, Upper: &upper},
+	})
+	assert.Equal(t, http.StatusOK, queryResp.StatusCode)
+	var body map[string][]model.TrackKeyValueItem
+	json.NewDecoder(queryResp.Body).Decode(&body)
+	queryResp.Body.Close()
+	require.Len(t, body["items"], 1)
+	assert.Equal(t, "m:1", body["items"][0].Key)
+
+	multiResp := trackPost(t, srv, "/query/multi", model.TrackGetItemsByPrefixesRequest{
+		BucketID: 1, Prefixes: []string{"m:"}, Metric: &model.MetricFilter{Operator: "gte", Value: 20},
+	})
+	assert.Equal(t, http.StatusOK, multiResp.StatusCode)
+	json.NewDecoder(multiResp.Body).Decode(&body)
+	multiResp.Body.Close()
+	assert.Len(t, body["items"], 2)
+
+	for _, filter := range []model.MetricFilter{
+		{Operator: "approx", Value: 1},
+		{Operator: ""},
+		{Operator: "between", Value: 1},
+		{Operator: "gt", Value: 1, Upper: &upper},
+	} {
+		f := filter
+		resp := trackPost(t, srv, "/query", model.TrackGetItemsByPrefixRequest{BucketID: 1, Prefix: "m:", Metric: &f})
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, f.Operator)
+
+		resp = trackPost(t, srv, "/query/multi", model.TrackGetItemsByPrefixesRequest{BucketID: 1, Prefixes: []string{"m:"}, Metric: &f})
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, f.Operator)
+	}
+}
+
+func TestTrackQueryPagination(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	for _, k := range []string{"p:1", "p:2", "p:3", "q:1"} {
+		r := trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: k, Value: 5})
+		r.Body.Close()
+	}
+
+	query := func(req model.TrackGetItemsByPrefixRequest) model.TrackQueryResponse {
+		t.Helper()
+		resp := trackPost(t, srv, "/query", req)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body model.TrackQueryResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body
+	}
+
+	first := query(model.TrackGetItemsByPrefixRequest{BucketID: 1, Prefix: "p:", TrackPageRequest: model.TrackPageRequest{Limit: 2}})
+	require.Len(t, first.Items, 2)
+	assert.Equal(t, "p:1", first.Items[0].Key)
+	assert.Equal(t, "p:2", first.NextCursor)
+
+	second := query(model.TrackGetItemsByPrefixRequest{BucketID: 1, Prefix: "p:", TrackPageRequest: model.TrackPageRequest{Limit: 2, StartAfter: first.NextCursor}})
+	require.Len(t, second.Items, 1)
+	assert.Equal(t, "p:3", second.Items[0].Key)
+	assert.Empty(t, second.NextCursor)
+
+	desc := query(model.TrackGetItemsByPrefixRequest{BucketID: 1, Prefix: "p:", TrackPageRequest: model.TrackPageRequest{Limit: 1, Descending: true}})
+	require.Len(t, desc.Items, 1)
+	assert.Equal(t, "p:3", desc.Items[0].Key)
+	assert.Equal(t, "p:3", desc.NextCursor)
+
+	multiResp := trackPost(t, srv, "/query/multi", model.TrackGetItemsByPrefixesRequest{
+		BucketID: 1, Prefixes: []string{"p:", "q:"}, TrackPageRequest: model.TrackPageRequest{Limit: 3},
+	})
+	var multi model.TrackQueryResponse
+	json.NewDecoder(multiResp.Body).Decode(&multi)
+	multiResp.Body.Close()
+	assert.Len(t, multi.Items, 3)
+	assert.Equal(t, "p:3", multi.NextCursor)
+
+	for _, page := range []model.TrackPageRequest{{Limit: -1}, {Limit: 10001}, {Limit: 2, All: true}} {
+		resp := trackPost(t, srv, "/query", model.TrackGetItemsByPrefixRequest{BucketID: 1, Prefix: "p:", TrackPageRequest: page})
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestTrackQueryDefaultLimit(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	items := make([]model.TrackKeyValueItem, defaultTrackQueryLimit+1)
+	for i := range items {
+		items[i] = model.TrackKeyValueItem{Key: fmt.Sprintf("k:%05d", i), Value: model.TrackValue{Value: int64(i)}}
+	}
+	resp := trackPost(t, srv, "/items/batch", model.TrackPutManyRequest{Buckets: []model.TrackPutItems{{BucketID: 1, Items: items}}})
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	query := func(page model.TrackPageRequest) model.TrackQueryResponse {
+		t.Helper()
+		resp := trackPost(t, srv, "/query", model.TrackGetItemsByPrefixRequest{BucketID: 1, Prefix: "k:", TrackPageRequest: page})
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body model.TrackQueryResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body
+	}
+
+	// Without a limit the first page stops at the default size and says where to go on
+	first := query(model.TrackPageRequest{})
+	require.Len(t, first.Items, defaultTrackQueryLimit)
+	assert.Equal(t, items[defaultTrackQueryLimit-1].Key, first.NextCursor)
+
+	rest := query(model.TrackPageRequest{StartAfter: first.NextCursor})
+	require.Len(t, rest.Items, 1)
+	assert.Equal(t, items[defaultTrackQueryLimit].Key, rest.Items[0].Key)
+	assert.Empty(t, rest.NextCursor)
+
+	all := query(model.TrackPageRequest{All: true})
+	assert.Len(t, all.Items, defaultTrackQueryLimit+1)
+	assert.Empty(t, all.NextCursor)
+}
+
+func TestTrackQueryRange(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	for _, k := range []string{"d:01", "d:02", "d:03", "d:04"} {
+		r := trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: k, Value: 5})
+		r.Body.Close()
+	}
+
+	query := func(req model.TrackGetItemsByRangeRequest) model.TrackQueryResponse {
+		t.Helper()
+		resp := trackPost(t, srv, "/query/range", req)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body model.TrackQueryResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body
+	}
+	keys := func(resp model.TrackQueryResponse) []string {
+		var out []string
+		for _, item := range resp.Items {
+			out = append(out, item.Key)
+		}
+		return out
+	}
+
+	// Start inclusive, end exclusive by default
+	assert.Equal(t, []string{"d:02", "d:03"}, keys(query(model.TrackGetItemsByRangeRequest{BucketID: 1, Start: "d:02", End: "d:04"})))
+
+	exclusive := false
+	assert.Equal(t, []string{"d:03", "d:04"}, keys(query(model.TrackGetItemsByRangeRequest{
+		BucketID: 1, Start: "d:02", End: "d:04", StartInclusive: &exclusive, EndInclusive: true,
+	})))
+
+	first := query(model.TrackGetItemsByRangeRequest{BucketID: 1, Start: "d:01", TrackPageRequest: model.TrackPageRequest{Limit: 3, Descending: true}})
+	assert.Equal(t, []string{"d:04", "d:03", "d:02"}, keys(first))
+	assert.Equal(t, "d:02", first.NextCursor)
+
+	resp := trackPost(t, srv, "/query/range", model.TrackGetItemsByRangeRequest{BucketID: 1, Start: "d:04", End: "d:01"})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTrackQueryOrderBy(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	for k, m := range map[string]float64{"s:a": 10, "s:b": 30, "s:c": 20} {
+		metric := m
+		r := trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: k, Value: 1, Metric: &metric})
+		r.Body.Close()
+	}
+
+	resp := trackPost(t, srv, "/query", model.TrackGetItemsByPrefixRequest{
+		BucketID: 1, Prefix: "s:", TrackPageRequest: model.TrackPageRequest{Limit: 2, OrderBy: "metric", Descending: true},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body model.TrackQueryResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	resp.Body.Close()
+	require.Len(t, body.Items, 2)
+	assert.Equal(t, "s:b", body.Items[0].Key)
+	assert.Equal(t, "s:c", body.Items[1].Key)
+	assert.Empty(t, body.NextCursor)
+
+	for _, page := range []model.TrackPageRequest{{OrderBy: "size"}, {OrderBy: "value", StartAfter: "s:a"}} {
+		resp := trackPost(t, srv, "/query/multi", model.TrackGetItemsByPrefixesRequest{BucketID: 1, Prefixes: []string{"s:"}, TrackPageRequest: page})
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestTrackQueryAggregate(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	tag := int64(3)
+	for i, k := range []string{"a:1", "a:2", "a:3"} {
+		req := model.TrackRequest{BucketID: 1, Key: k, Value: int64(i + 1)}
+		if i > 0 {
+			req.Tag = &tag
+		}
+		r := trackPost(t, srv, "/items", req)
+		r.Body.Close()
+	}
+
+	resp := trackPost(t, srv, "/query/aggregate", model.TrackAggregateRequest{BucketID: 1, Prefixes: []string{"a:"}, GroupByTag: true})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body model.TrackAggregateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	resp.Body.Close()
+
+	require.Len(t, body.Groups, 2)
+	assert.Nil(t, body.Groups[0].Tag)
+	assert.Equal(t, int64(1), body.Groups[0].Count)
+	require.NotNil(t, body.Groups[1].Tag)
+	assert.Equal(t, tag, *body.Groups[1].Tag)
+	assert.Equal(t, int64(5), body.Groups[1].Value.Sum)
+	require.NotNil(t, body.Groups[1].Value.Avg)
+	assert.Equal(t, 2.5, *body.Groups[1].Value.Avg)
+	assert.Nil(t, body.Groups[1].Metric.Avg)
+
+	resp = trackPost(t, srv, "/query/aggregate", model.TrackAggregateRequest{BucketID: 1})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTrackTTL(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	ttl := int64(3600)
+	resp := trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: "session", Value: 1, TTLSeconds: &ttl})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	past := time.Now().Add(-time.Second)
+	resp = trackPost(t, srv, "/items/batch", model.TrackPutManyRequest{
+		Buckets: []model.TrackPutItems{{BucketID: 1, Items: []model.TrackKeyValueItem{
+			{Key: "stale", Value: model.TrackValue{Value: 2}, ExpiresAt: &past},
+		}}},
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	getResp := trackPost(t, srv, "/items/get", model.TrackRequest{BucketID: 1, Key: "session"})
+	require.Equal(t, http.StatusOK, getResp.StatusCode)
+	var item model.TrackKeyValueItem
+	require.NoError(t, json.NewDecoder(getResp.Body).Decode(&item))
+	require.NotNil(t, item.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *item.ExpiresAt, time.Minute)
+
+	getResp = trackPost(t, srv, "/items/get", model.TrackRequest{BucketID: 1, Key: "stale"})
+	assert.Equal(t, http.StatusNotFound, getResp.StatusCode)
+
+	queryResp := trackPost(t, srv, "/query", model.TrackGetItemsByPrefixRequest{BucketID: 1, Prefix: ""})
+	require.Equal(t, http.StatusOK, queryResp.StatusCode)
+	var query model.TrackQueryResponse
+	require.NoError(t, json.NewDecoder(queryResp.Body).Decode(&query))
+	require.Len(t, query.Items, 1)
+	assert.Equal(t, "session", query.Items[0].Key)
+	assert.NotNil(t, query.Items[0].ExpiresAt)
+
+	zero := int64(0)
+	resp = trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: "bad", Value: 1, TTLSeconds: &zero})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: "bad", Value: 1, TTLSeconds: &ttl, ExpiresAt: &past})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTrackSweeper(t *testing.T) {
+	store := ram.NewRamStore()
+	space := store_interface.TenancySpace{AppId: 1, TenancyId: 2}
+	past := time.Now().Add(-time.Second)
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, store.TrackPut(space, 1, k, 1, nil, nil, &past))
+	}
+	require.NoError(t, store.TrackPut(space, 1, "kept", 1, nil, nil, nil))
+
+	m := metrics.NewMetrics()
+	deleted, err := sweepExpiredTracks(store, m, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+	bullet := m.Snapshot().Namespaces["bullet"]
+	assert.Equal(t, uint64(3), bullet.Counters["track.objects_expired"])
+	assert.Equal(t, uint64(1), bullet.Counters["track.sweeps"])
+
+	_, err = store.TrackGetValue(space, 1, "kept")
+	assert.NoError(t, err)
+
+	require.NoError(t, store.TrackSetHistoryPolicy(space, 1, store_interface.TrackHistoryPolicy{MaxVersions: 1}))
+	for v := int64(2); v <= 4; v++ {
+		require.NoError(t, store.TrackPut(space, 1, "kept", v, nil, nil, nil))
+	}
+	pruned, err := pruneTrackHistory(store, m, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, pruned)
+	assert.Equal(t, uint64(2), m.Snapshot().Namespaces["bullet"].Counters["track.history_pruned"])
+}
+
+func TestTrackDeleteByPrefix(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	for _, k := range []string{"user:1:a", "user:1:b", "user:2:a"} {
+		r := trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: k, Value: 5})
+		require.Equal(t, http.StatusOK, r.StatusCode)
+	}
+
+	resp := trackPost(t, srv, "/items/delete-by-prefix", model.TrackDeleteByPrefixRequest{BucketID: 1, Prefixes: []string{"user:1:"}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var result model.TrackDeleteByPrefixResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, 2, result.Deleted)
+
+	queryResp := trackPost(t, srv, "/query", model.TrackGetItemsByPrefixRequest{BucketID: 1, Prefix: "user:"})
+	require.Equal(t, http.StatusOK, queryResp.StatusCode)
+	var query model.TrackQueryResponse
+	require.NoError(t, json.NewDecoder(queryResp.Body).Decode(&query))
+	require.Len(t, query.Items, 1)
+	assert.Equal(t, "user:2:a", query.Items[0].Key)
+
+	resp = trackPost(t, srv, "/items/delete-by-prefix", model.TrackDeleteByPrefixRequest{BucketID: 1})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTrackBuckets(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	listResp := trackGet(t, srv, "/buckets")
+	require.Equal(t, http.StatusOK, listResp.StatusCode)
+	var list model.TrackBucketsResponse
+	require.NoError(t, json.NewDecoder(listResp.Body).Decode(&list))
+	assert.Empty(t, list.Buckets)
+
+	metric := 2.5
+	for _, r := range []model.TrackRequest{
+		{BucketID: 10, Key: "b", Value: 3, Metric: &metric},
+		{BucketID: 10, Key: "a", Value: -1},
+		{BucketID: 2, Key: "x", Value: 1},
+	} {
+		resp := trackPost(t, srv, "/items", r)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	listResp = trackGet(t, srv, "/buckets")
+	require.Equal(t, http.StatusOK, listResp.StatusCode)
+	require.NoError(t, json.NewDecoder(listResp.Body).Decode(&list))
+	assert.Equal(t, []int32{2, 10}, list.Buckets)
+
+	statsResp := trackGet(t, srv, "/buckets/10/stats")
+	require.Equal(t, http.StatusOK, statsResp.StatusCode)
+	var stats model.TrackBucketStats
+	require.NoError(t, json.NewDecoder(statsResp.Body).Decode(&stats))
+	assert.Equal(t, int32(10), stats.BucketID)
+	assert.Equal(t, int64(2), stats.KeyCount)
+	assert.Equal(t, "a", stats.MinKey)
+	assert.Equal(t, "b", stats.MaxKey)
+	require.NotNil(t, stats.Value.Min)
+	assert.Equal(t, int64(-1), *stats.Value.Min)
+	assert.Equal(t, int64(1), stats.Metric.Count)
+	assert.Equal(t, int64(26), stats.ApproxBytes)
+
+	assert.Equal(t, http.StatusNotFound, trackGet(t, srv, "/buckets/3/stats").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, trackGet(t, srv, "/buckets/abc/stats").StatusCode)
+}
+
+func TestTrackExportImport(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	tag := int64(3)
+	ttl := int64(3600)
+	for _, r := range []model.TrackRequest{
+		{BucketID: 1, Key: "b", Value: 2},
+		{BucketID: 1, Key: "a", Value: 1, Tag: &tag, TTLSeconds: &ttl},
+	} {
+		resp := trackPost(t, srv, "/items", r)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	exportResp := trackGet(t, srv, "/buckets/1/export")
+	require.Equal(t, http.StatusOK, exportResp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", exportResp.Header.Get("Content-Type"))
+	exported, err := io.ReadAll(exportResp.Body)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(exported)), "\n")
+	require.Len(t, lines, 2)
+	var first model.TrackExportItem
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, "a", first.Key)
+	assert.Equal(t, int64(1), first.Value)
+	assert.NotNil(t, first.ExpiresAt)
+
+	// An expired line is skipped, and blank lines are ignored
+	body := string(exported) + "\n" + `{"key":"old","value":"9","expires_at":"2000-01-01T00:00:00Z"}` + "\n"
+	importResp := trackPostRaw(t, srv, "/buckets/2/import", body)
+	require.Equal(t, http.StatusOK, importResp.StatusCode)
+	var summary model.TrackImportResponse
+	require.NoError(t, json.NewDecoder(importResp.Body).Decode(&summary))
+	assert.Equal(t, model.TrackImportResponse{Imported: 2, Expired: 1, Batches: 1}, summary)
+
+	getResp := trackPost(t, srv, "/items/get", model.TrackRequest{BucketID: 2, Key: "a"})
+	require.Equal(t, http.StatusOK, getResp.StatusCode)
+	var item model.TrackKeyValueItem
+	require.NoError(t, json.NewDecoder(getResp.Body).Decode(&item))
+	require.NotNil(t, item.Value.Tag)
+	assert.Equal(t, tag, *item.Value.Tag)
+	assert.NotNil(t, item.ExpiresAt)
+
+	badResp := trackPostRaw(t, srv, "/buckets/3/import", `{"key":"x","value":"1"}`+"\nnot json\n")
+	require.Equal(t, http.StatusBadRequest, badResp.StatusCode)
+	require.NoError(t, json.NewDecoder(badResp.Body).Decode(&summary))
+	assert.Contains(t, summary.Error, "line 2")
+	assert.Equal(t, 0, summary.Imported)
+}
+
+func TestTrackConditionalPut(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	owner := int64(1)
+	claim := model.TrackRequest{BucketID: 1, Key: "job", Value: 1, Tag: &owner, Condition: "if_absent"}
+	assert.Equal(t, http.StatusOK, trackPost(t, srv, "/items", claim).StatusCode)
+	assert.Equal(t, http.StatusConflict, trackPost(t, srv, "/items", claim).StatusCode)
+
+	other := int64(2)
+	release := model.TrackRequest{BucketID: 1, Key: "job", Value: 0, Tag: &other, Condition: "if_tag", ConditionTag: &other}
+	assert.Equal(t, http.StatusConflict, trackPost(t, srv, "/items", release).StatusCode)
+	release.ConditionTag = &owner
+	assert.Equal(t, http.StatusOK, trackPost(t, srv, "/items", release).StatusCode)
+
+	resp := trackPost(t, srv, "/items/batch", model.TrackPutManyRequest{
+		Buckets: []model.TrackPutItems{{BucketID: 1, Items: []model.TrackKeyValueItem{
+			{Key: "job", Value: model.TrackValue{Value: 3}},
+			{Key: "other", Value: model.TrackValue{Value: 3}},
+		}}},
+		Condition: "if_present",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var result model.TrackPutManyResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, []model.TrackBucketKeyPair{{BucketID: 1, Key: "job"}}, result.Written)
+	assert.Equal(t, []model.TrackBucketKeyPair{{BucketID: 1, Key: "other"}}, result.Rejected)
+
+	bad := model.TrackRequest{BucketID: 1, Key: "job", Value: 1, ConditionTag: &owner}
+	assert.Equal(t, http.StatusBadRequest, trackPost(t, srv, "/items", bad).StatusCode)
+	bad = model.TrackRequest{BucketID: 1, Key: "job", Value: 1, Condition: "whenever"}
+	assert.Equal(t, http.StatusBadRequest, trackPost(t, srv, "/items", bad).StatusCode)
+}
+
+func TestTrackHistory(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	resp := trackGet(t, srv, "/buckets/1/history")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var policy model.TrackHistoryPolicy
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&policy))
+	assert.Equal(t, model.TrackHistoryPolicy{}, policy)
+
+	resp = trackPut(t, srv, "/buckets/1/history", model.TrackHistoryPolicy{MaxVersions: 2, MaxAgeSeconds: 3600})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = trackGet(t, srv, "/buckets/1/history")
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&policy))
+	assert.Equal(t, model.TrackHistoryPolicy{MaxVersions: 2, MaxAgeSeconds: 3600}, policy)
+
+	for v := int64(1); v <= 4; v++ {
+		r := trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: "k", Value: v})
+		require.Equal(t, http.StatusOK, r.StatusCode)
+	}
+
+	resp = trackPost(t, srv, "/items/history", model.TrackHistoryRequest{BucketID: 1, Key: "k"})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var history model.TrackHistoryResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	assert.Equal(t, "k", history.Key)
+	require.Len(t, history.Entries, 2)
+	assert.Equal(t, int64(3), history.Entries[0].Value)
+	assert.Equal(t, int64(2), history.Entries[1].Value)
+	assert.False(t, history.Entries[0].ReplacedAt.IsZero())
+
+	resp = trackPost(t, srv, "/items/history", model.TrackHistoryRequest{BucketID: 1, Key: "k", Limit: 1})
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	assert.Len(t, history.Entries, 1)
+
+	resp = trackPost(t, srv, "/items/history", model.TrackHistoryRequest{BucketID: 1, Key: "missing"})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.JSONEq(t, `{"key": "missing", "entries": []}`, string(body))
+
+	// An empty policy turns history off and drops it
+	resp = trackPut(t, srv, "/buckets/1/history", model.TrackHistoryPolicy{})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = trackPost(t, srv, "/items/history", model.TrackHistoryRequest{BucketID: 1, Key: "k"})
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	assert.Empty(t, history.Entries)
+
+	resp = trackPut(t, srv, "/buckets/1/history", model.TrackHistoryPolicy{MaxVersions: -1})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = trackPost(t, srv, "/items/history", model.TrackHistoryRequest{BucketID: 1, Key: "k", Limit: -1})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTrackQueryByValue(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	for k, v := range map[string]int64{"a": 4242, "b": 1, "c": 4242} {
+		r := trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: k, Value: v})
+		require.Equal(t, http.StatusOK, r.StatusCode)
+	}
+
+	req := model.TrackGetKeysByValueRequest{BucketID: 1, Values: []string{"4242"}, TrackPageRequest: model.TrackPageRequest{Limit: 1}}
+	resp := trackPost(t, srv, "/query/by-value", req)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var page model.TrackQueryResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "a", page.Items[0].Key)
+	assert.Equal(t, "a", page.NextCursor)
+
+	req.StartAfter = page.NextCursor
+	resp = trackPost(t, srv, "/query/by-value", req)
+	page = model.TrackQueryResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "c", page.Items[0].Key)
+	assert.Equal(t, "", page.NextCursor)
+
+	resp = trackPost(t, srv, "/query/by-value", model.TrackGetKeysByValueRequest{BucketID: 1, Values: []string{"x"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = trackPost(t, srv, "/query/by-value", model.TrackGetKeysByValueRequest{BucketID: 1})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTrackTags(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	one := int64(1)
+	for _, r := range []model.TrackRequest{
+		{BucketID: 1, Key: "a", Value: 1, Tag: &one, Tags: []int64{2, 3}},
+		{BucketID: 1, Key: "b", Value: 2, Tags: []int64{3}},
+		{BucketID: 1, Key: "c", Value: 3, Tag: &one},
+	} {
+		resp := trackPost(t, srv, "/items", r)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	getResp := trackPost(t, srv, "/items/get", model.TrackRequest{BucketID: 1, Key: "a"})
+	require.Equal(t, http.StatusOK, getResp.StatusCode)
+	var item model.TrackKeyValueItem
+	require.NoError(t, json.NewDecoder(getResp.Body).Decode(&item))
+	assert.Equal(t, []int64{2, 3}, item.Value.Tags)
+
+	keys := func(req model.TrackGetItemsByPrefixRequest) []string {
+		t.Helper()
+		resp := trackPost(t, srv, "/query", req)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var page model.TrackQueryResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+		var out []string
+		for _, item := range page.Items {
+			out = append(out, item.Key)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"a", "b"}, keys(model.TrackGetItemsByPrefixRequest{BucketID: 1, Tags: []int64{3}}))
+	assert.Equal(t, []string{"a", "c"}, keys(model.TrackGetItemsByPrefixRequest{BucketID: 1, Tags: []int64{1}}))
+	assert.Equal(t, []string{"a"}, keys(model.TrackGetItemsByPrefixRequest{
+		BucketID: 1, Tags: []int64{1, 3}, TrackPageRequest: model.TrackPageRequest{TagMatch: "all"},
+	}))
+
+	resp := trackPost(t, srv, "/query", model.TrackGetItemsByPrefixRequest{
+		BucketID: 1, Tags: []int64{1}, TrackPageRequest: model.TrackPageRequest{TagMatch: "some"},
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Export carries the further tags, and import restores them
+	exportResp := trackGet(t, srv, "/buckets/1/export")
+	require.Equal(t, http.StatusOK, exportResp.StatusCode)
+	exported, err := io.ReadAll(exportResp.Body)
+	require.NoError(t, err)
+	var first model.TrackExportItem
+	require.NoError(t, json.Unmarshal([]byte(strings.Split(string(exported), "\n")[0]), &first))
+	assert.Equal(t, []int64{2, 3}, first.Tags)
+
+	importResp := trackPostRaw(t, srv, "/buckets/2/import", string(exported))
+	require.Equal(t, http.StatusOK, importResp.StatusCode)
+	getResp = trackPost(t, srv, "/items/get", model.TrackRequest{BucketID: 2, Key: "a"})
+	require.Equal(t, http.StatusOK, getResp.StatusCode)
+	item = model.TrackKeyValueItem{}
+	require.NoError(t, json.NewDecoder(getResp.Body).Decode(&item))
+	assert.Equal(t, []int64{2, 3}, item.Value.Tags)
+}
+
+// readTrackWatchEvent reads the next event of a watch stream, skipping heartbeats.
+func readTrackWatchEvent(t *testing.T, r *bufio.Reader) (string, model.TrackWatchEvent) {
+	t.Helper()
+	var id string
+	var e model.TrackWatchEvent
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e))
+		case line == "" && id != "":
+			return id, e
+		}
+	}
+}
+
+func trackWatch(t *testing.T, srv *httptest.Server, query string, lastEventID string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/track/watch?"+query, nil)
+	req.Header.Set("X-App-Id", "1")
+	req.Header.Set("X-Tenancy-Id", "2")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestTrackWatch(t *testing.T) {
+	srv, _ := newTrackServer(t)
+
+	resp := trackWatch(t, srv, "bucketId=1&prefix=flag:", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	stream := bufio.NewReader(resp.Body)
+
+	trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: "other", Value: 1}).Body.Close()
+	trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: "flag:a", Value: 7}).Body.Close()
+	trackDelete(t, srv, "/items", model.TrackDeleteManyRequest{Items: []model.TrackBucketKeyPair{{BucketID: 1, Key: "flag:a"}}}).Body.Close()
+
+	id, e := readTrackWatchEvent(t, stream)
+	assert.Equal(t, "2", id)
+	assert.Equal(t, "put", e.Type)
+	assert.Equal(t, "flag:a", e.Key)
+	require.NotNil(t, e.Value)
+	assert.Equal(t, int64(7), *e.Value)
+	id, e = readTrackWatchEvent(t, stream)
+	assert.Equal(t, "3", id)
+	assert.Equal(t, "delete", e.Type)
+	resp.Body.Close()
+
+	// Reconnecting with the last event id replays what came after it
+	trackPost(t, srv, "/items", model.TrackRequest{BucketID: 1, Key: "flag:b", Value: 8}).Body.Close()
+	resp = trackWatch(t, srv, "bucketId=1&prefix=flag:", "2")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	stream = bufio.NewReader(resp.Body)
+	id, _ = readTrackWatchEvent(t, stream)
+	assert.Equal(t, "3", id)
+	id, e = readTrackWatchEvent(t, stream)
+	assert.Equal(t, "4", id)
+	assert.Equal(t, "flag:b", e.Key)
+	resp.Body.Close()
+
+	resp = trackWatch(t, srv, "bucketId=1&since=99", "")
+	assert.Equal(t, http.StatusGone, resp.StatusCode)
+	resp.Body.Close()
+	resp = trackWatch(t, srv, "prefix=flag:", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/vixac/bullet/metrics"
+	store_interface "github.com/vixac/bullet/store/store_interface"
+)
+
+// StartTrackSweeper deletes expired track keys, then the history entries their bucket's
+// policy no longer retains, every interval, batch at a time, until ctx is done. Reads
+// already hide both; the sweeper reclaims their space, and through a WatchedTrackStore
+// tells watches of the expired keys.
+func StartTrackSweeper(ctx context.Context, store store_interface.TrackStore, m *metrics.Metrics, interval time.Duration, batch int) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := sweepExpiredTracks(store, m, batch); err != nil {
+					log.Printf("track sweep failed: %v", err)
+				}
+				if _, err := pruneTrackHistory(store, m, batch); err != nil {
+					log.Printf("track history prune failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// sweepExpiredTracks deletes batches of expired keys until a batch comes back short, and
+// returns how many were deleted.
+func sweepExpiredTracks(store store_interface.TrackStore, m *metrics.Metrics, batch int) (int, error) {
+	now := time.Now()
+	total := 0
+	for {
+		deleted, err := store.TrackDeleteExpired(now, batch)
+		n := len(deleted)
+		total += n
+		if n > 0 {
+			m.AddCounter("track.objects_expired", uint64(n))
+		}
+		if err != nil || n < batch {
+			m.IncrementCounter("track.sweeps")
+			m.SetGauge("track.last_sweep_expired", float64(total))
+			return total, err
+		}
+	}
+}
+
+// pruneTrackHistory deletes batches of stale history entries until a batch comes back
+// short, and returns how many were deleted.
+func pruneTrackHistory(store store_interface.TrackStore, m *metrics.Metrics, batch int) (int, error) {
+	now := time.Now()
+	total := 0
+	for {
+		n, err := store.TrackPruneHistory(now, batch)
+		total += n
+		if n > 0 {
+			m.AddCounter("track.history_pruned", uint64(n))
+		}
+		if err != nil || n < batch {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vixac/bullet/model"
+	store_interface "github.com/vixac/bullet/store/store_interface"
+)
+
+// trackWatchHeartbeat is how often an idle watch sends a comment line, so that proxies
+// keep the connection open and a gone client is noticed.
+var trackWatchHeartbeat = 15 * time.Second
+
+// watch streams the puts and deletes under a prefix of a bucket as server-sent events,
+// each with its sequence as the event id, until the client goes away. A client that
+// falls too far behind is disconnected and can resume from its last event id.
+func (h *trackHandler) watch(c *gin.Context) {
+	space, err := extractSpace(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	bucketID, err := strconv.ParseInt(c.Query("bucketId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucketId"})
+		return
+	}
+	since, err := watchSince(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := store_interface.TrackWatchFilter{Space: space, BucketID: int32(bucketID), Prefix: c.Query("prefix")}
+	sub, missed, err := h.watcher.TrackWatch(filter, since)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	count := 0
+	defer func() { incrementObjects(c, "track", "read", count) }()
+	for _, e := range missed {
+		if err := writeTrackWatchEvent(c.Writer, e); err != nil {
+			return
+		}
+		count++
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(trackWatchHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.Events():
+			if !ok {
+				if sub.Lagged() {
+					log.Printf("track watch of bucket %d fell behind and was disconnected", bucketID)
+				}
+				return
+			}
+			if err := writeTrackWatchEvent(c.Writer, e); err != nil {
+				return
+			}
+			count++
+		}
+		c.Writer.Flush()
+	}
+}
+
+// watchSince reads the sequence to resume after from the Last-Event-ID header, which
+// EventSource clients send on reconnecting, or else the since query parameter.
+func watchSince(c *gin.Context) (*uint64, error) {
+	raw := c.GetHeader("Last-Event-ID")
+	if raw == "" {
+		raw = c.Query("since")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+	since, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resume sequence %q", raw)
+	}
+	return &since, nil
+}
+
+func writeTrackWatchEvent(w gin.ResponseWriter, e model.TrackWatchEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Sequence, e.Type, data)
+	return err
+}
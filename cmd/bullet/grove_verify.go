@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/vixac/bullet/api"
+	"github.com/vixac/bullet/config"
+	store_interface "github.com/vixac/bullet/store/store_interface"
+)
+
+// runGroveVerify checks a single grove tree, repairing it when -repair is given,
+// and prints the report as JSON. It returns 1 if problems remain.
+//
+//	bullet grove-verify -db-type sqlite -sqlite bullet.db -app-id 1 -tenancy-id 1 -tree board [-repair]
+func runGroveVerify(args []string) int {
+	cfg := config.LoadGroveVerify(args)
+	kvStore, err := openStore(&cfg.Config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer kvStore.TrackClose()
+
+	space := store_interface.TenancySpace{AppId: cfg.AppId, TenancyId: cfg.TenancyId}
+	treeID := store_interface.TreeID(cfg.TreeID)
+
+	var report *store_interface.TreeIntegrityReport
+	if cfg.Repair {
+		report, err = kvStore.RepairTree(space, treeID)
+	} else {
+		report, err = kvStore.VerifyTree(space, treeID)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out, err := json.MarshalIndent(api.NewGroveIntegrityResponse(report), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Consistent() && !report.Repaired {
+		return 1
+	}
+	return 0
+}
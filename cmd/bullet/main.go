@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vixac/bullet/api"
@@ -16,23 +19,12 @@ import (
 )
 
 func main() {
-	cfg := config.Load()
-	var kvStore store_interface.Store
-	var err error
-
-	switch cfg.DBType {
-	case config.Mongo:
-		kvStore, err = mongodb.NewMongoStore(cfg.MongoURI)
-	case config.Boltdb:
-		kvStore, err = boltdb.NewBoltStore(cfg.BoltPath)
-	case config.Sqlite:
-		kvStore, err = sqlite_store.NewSQLiteStore(cfg.SqlPath)
-	case config.Postgresql:
-		kvStore, err = postgresql.NewPostgreSQLStore(cfg.PostgresDSN)
-	default:
-		log.Fatal("unsupported store type")
+	if len(os.Args) > 1 && os.Args[1] == "grove-verify" {
+		os.Exit(runGroveVerify(os.Args[2:]))
 	}
 
+	cfg := config.Load()
+	kvStore, err := openStore(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -40,10 +32,31 @@ func main() {
 
 	println("Creating gin routers.. on port: ", cfg.Port)
 	engine := gin.Default()
-	api.SetupObservationsRouter(metrics.NewMetrics(), engine)
-	engine = api.SetupTrackRouter(kvStore, "/track", engine)
+	m := metrics.NewMetrics()
+	api.SetupObservationsRouter(m, engine)
+	// Every track write goes through trackStore, so that watches see it
+	trackStore := store_interface.NewWatchedTrackStore(kvStore, cfg.TrackWatchBacklog)
+	engine = api.SetupTrackRouter(trackStore, "/track", engine)
 	engine = api.SetupDepotRouter(kvStore, "/depot", engine)
 	engine = api.SetupGroveRouter(kvStore, "/grove", engine)
+	if cfg.TrackSweepInterval > 0 {
+		api.StartTrackSweeper(context.Background(), trackStore, m, cfg.TrackSweepInterval, cfg.TrackSweepBatch)
+	}
 	fmt.Println("Bullet is Healthy, on port " + cfg.Port)
 	log.Fatal(engine.Run(":" + cfg.Port))
 }
+
+func openStore(cfg *config.Config) (store_interface.Store, error) {
+	switch cfg.DBType {
+	case config.Mongo:
+		return mongodb.NewMongoStore(cfg.MongoURI)
+	case config.Boltdb:
+		return boltdb.NewBoltStoreWithOptions(cfg.BoltPath, boltdb.BoltOptions{TrackFilterIndexes: cfg.BoltTrackIndexes})
+	case config.Sqlite:
+		return sqlite_store.NewSQLiteStore(cfg.SqlPath)
+	case config.Postgresql:
+		return postgresql.NewPostgreSQLStore(cfg.PostgresDSN)
+	default:
+		return nil, errors.New("unsupported store type")
+	}
+}
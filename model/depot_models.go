@@ -6,7 +6,8 @@ type DepotCreateRequest struct {
 }
 
 type DepotCreateResponse struct {
-	ID int64 `json:"id"`
+	ID       int64 `json:"id"`
+	Revision int64 `json:"revision"`
 }
 
 type DepotCreateManyRequest struct {
@@ -28,7 +29,14 @@ type DepotGetRequest struct {
 }
 
 type DepotGetResponse struct {
-	Value string `json:"value"`
+	Value       string `json:"value"`
+	Revision    int64  `json:"revision"`
+	ContentType string `json:"content_type,omitempty"` // set for raw items, whose bytes are read from /raw
+}
+
+// DepotUpdateResponse carries the revision an update moved the item to.
+type DepotUpdateResponse struct {
+	Revision int64 `json:"revision"`
 }
 
 type DepotGetManyRequest struct {
@@ -51,3 +59,42 @@ type DepotBucketRequest struct {
 type DepotGetAllByBucketResponse struct {
 	Values map[int64]string `json:"values"`
 }
+
+type DepotItem struct {
+	ID          int64  `json:"id"`
+	Value       string `json:"value"`
+	Revision    int64  `json:"revision"`
+	ContentType string `json:"content_type,omitempty"` // set for raw items, whose Value is empty
+}
+
+// DepotRawItem is an item's value as bytes of a content type.
+type DepotRawItem struct {
+	ID          int64
+	Data        []byte
+	ContentType string
+	Revision    int64
+}
+
+// DepotBucketPageResponse is one page of a bucket listing, in ID order.
+type DepotBucketPageResponse struct {
+	Items      []DepotItem `json:"items"`
+	NextCursor int64       `json:"next_cursor,omitempty"` // set when more items follow
+}
+
+// DepotFieldFilter matches depot values by the JSON field at Path, a dot-separated list
+// of object keys.
+type DepotFieldFilter struct {
+	Path   string `json:"path"`
+	Op     string `json:"op"`               // eq, in, gt, gte, lt, lte or exists
+	Value  any    `json:"value,omitempty"`  // a string, number or boolean, for eq and the range operators
+	Values []any  `json:"values,omitempty"` // for in
+}
+
+// DepotQueryRequest asks for a page of the items in a bucket whose values match every filter.
+type DepotQueryRequest struct {
+	BucketID   int32              `json:"bucket_id"`
+	Filters    []DepotFieldFilter `json:"filters"`
+	Limit      int                `json:"limit,omitempty"`
+	StartAfter int64              `json:"start_after,omitempty"`
+	Descending bool               `json:"descending,omitempty"`
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // ===== REQUESTS =====
 
 type GroveCreateNodeRequest struct {
@@ -14,6 +16,12 @@ type GroveMoveNodeRequest struct {
 	NewPosition *float64 `json:"new_position,omitempty"`
 }
 
+type GroveTransferSubtreeRequest struct {
+	DstTreeID   string   `json:"dst_tree_id"`
+	DstParentID *string  `json:"dst_parent_id,omitempty"`
+	Position    *float64 `json:"position,omitempty"`
+}
+
 type GroveApplyMutationRequest struct {
 	MutationID string           `json:"mutation_id"`
 	Deltas     map[string]int64 `json:"deltas"`
@@ -23,8 +31,26 @@ type GroveBulkNodesRequest struct {
 	NodeIDs []string `json:"node_ids"`
 }
 
+type GroveCopySubtreeRequest struct {
+	DstTreeID      *string  `json:"dst_tree_id,omitempty"` // defaults to the source tree
+	DstParentID    *string  `json:"dst_parent_id,omitempty"`
+	Position       *float64 `json:"position,omitempty"`
+	IDStrategy     string   `json:"id_strategy"` // "affix" or "uuid"
+	Prefix         string   `json:"prefix,omitempty"`
+	Suffix         string   `json:"suffix,omitempty"`
+	CopyAggregates bool     `json:"copy_aggregates,omitempty"`
+}
+
+type GroveUpdateMetadataRequest struct {
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
 // ===== RESPONSES =====
 
+type GroveCopySubtreeResponse struct {
+	Mapping map[string]string `json:"mapping"`
+}
+
 type GroveExistsResponse struct {
 	Exists bool `json:"exists"`
 }
@@ -67,3 +93,41 @@ type GroveAggregatesBulkResponse struct {
 	Aggregates map[string]map[string]int64 `json:"aggregates"`
 	Missing    []string                    `json:"missing"`
 }
+
+type GroveClosureRow struct {
+	AncestorID   string `json:"ancestor_id"`
+	DescendantID string `json:"descendant_id"`
+	Depth        int    `json:"depth"`
+}
+
+type GroveIntegrityReportResponse struct {
+	TreeID             string            `json:"tree_id"`
+	NodeCount          int               `json:"node_count"`
+	Consistent         bool              `json:"consistent"`
+	Repaired           bool              `json:"repaired"`
+	OrphanedNodes      []string          `json:"orphaned_nodes"`
+	CycleNodes         []string          `json:"cycle_nodes"`
+	MissingClosure     []GroveClosureRow `json:"missing_closure"`
+	StaleClosure       []GroveClosureRow `json:"stale_closure"`
+	DepthMismatches    []string          `json:"depth_mismatches"`
+	DanglingAggregates []string          `json:"dangling_aggregates"`
+	DanglingMutations  []string          `json:"dangling_mutations"`
+}
+
+type GroveChange struct {
+	Seq        int64                  `json:"seq"`
+	Kind       string                 `json:"kind"`
+	NodeID     string                 `json:"node_id"`
+	ParentID   *string                `json:"parent_id,omitempty"`
+	Position   *float64               `json:"position,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	MutationID *string                `json:"mutation_id,omitempty"`
+	Deltas     map[string]int64       `json:"deltas,omitempty"`
+	Soft       bool                   `json:"soft,omitempty"`
+	At         time.Time              `json:"at"`
+}
+
+type GroveChangesResponse struct {
+	Changes []GroveChange `json:"changes"`
+	LastSeq int64         `json:"last_seq"`
+}
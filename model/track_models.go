@@ -1,18 +1,68 @@
 package model
 
+import "time"
+
+// TrackRequest writes one key. Either ttl_seconds or expires_at makes the key expire;
+// without them it is kept until deleted. Condition (if_absent, if_present or if_tag, which
+// compares the current tag with condition_tag) makes the write conditional.
 type TrackRequest struct {
+	BucketID     int32      `json:"bucketId"`
+	Key          string     `json:"key"`
+	Value        int64      `json:"value,string"`
+	Tag          *int64     `json:"tag,omitempty"`
+	Tags         []int64    `json:"tags,omitempty"`
+	Metric       *float64   `json:"metric,omitempty"`
+	TTLSeconds   *int64     `json:"ttl_seconds,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	Condition    string     `json:"condition,omitempty"`
+	ConditionTag *int64     `json:"condition_tag,omitempty"`
+}
+
+type TrackIncrementRequest struct {
+	BucketID int32  `json:"bucketId"`
+	Key      string `json:"key"`
+	Delta    int64  `json:"delta,string"`
+}
+
+// Expected is the value the key must currently hold; omit it to require that the key is absent.
+type TrackCompareAndSwapRequest struct {
 	BucketID int32    `json:"bucketId"`
 	Key      string   `json:"key"`
+	Expected *int64   `json:"expected,string,omitempty"`
 	Value    int64    `json:"value,string"`
 	Tag      *int64   `json:"tag,omitempty"`
 	Metric   *float64 `json:"metric,omitempty"`
 }
+
 type TrackDeleteManyRequest struct {
 	Items []TrackBucketKeyPair `json:"items"`
 }
 
+// TrackDeleteByPrefixRequest deletes the keys under the prefixes that pass the filters.
+// An empty prefix clears the whole bucket.
+type TrackDeleteByPrefixRequest struct {
+	BucketID int32         `json:"bucketId"`
+	Prefixes []string      `json:"prefixes"`
+	Tags     []int64       `json:"tags,omitempty"`
+	Metric   *MetricFilter `json:"metric,omitempty"`
+}
+
+type TrackDeleteByPrefixResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// TrackPutManyRequest writes many keys. A condition, as in TrackRequest, applies to every
+// key and makes the response a TrackPutManyResult.
 type TrackPutManyRequest struct {
-	Buckets []TrackPutItems `json:"buckets"`
+	Buckets      []TrackPutItems `json:"buckets"`
+	Condition    string          `json:"condition,omitempty"`
+	ConditionTag *int64          `json:"condition_tag,omitempty"`
+}
+
+// TrackPutManyResult lists the keys a conditional put wrote and those its condition rejected.
+type TrackPutManyResult struct {
+	Written  []TrackBucketKeyPair `json:"written"`
+	Rejected []TrackBucketKeyPair `json:"rejected"`
 }
 
 type TrackGetManyRequest struct {
@@ -20,14 +70,29 @@ type TrackGetManyRequest struct {
 }
 
 type MetricFilter struct {
-	Operator string  `json:"operator"` // "gt", "lt", etc.
-	Value    float64 `json:"value"`
+	Operator string   `json:"operator"`        // "gt", "gte", "lt", "lte", "eq", "ne" or "between"
+	Value    float64  `json:"value"`           // lower bound for "between"
+	Upper    *float64 `json:"upper,omitempty"` // upper bound, only for "between"
+}
+
+// TrackPageRequest pages query results in key order, or with order_by "value" or "metric"
+// returns the top limit items in that order (without a next page). Without a limit a page
+// holds the server's default page size; all returns every match instead.
+type TrackPageRequest struct {
+	Limit      int    `json:"limit,omitempty"`
+	All        bool   `json:"all,omitempty"`         // no limit, for clients that read small prefixes whole
+	StartAfter string `json:"start_after,omitempty"` // next_cursor of the previous page, key order only
+	Descending bool   `json:"descending,omitempty"`
+	OrderBy    string `json:"order_by,omitempty"`  // "key" (default), "value" or "metric"
+	TagMatch   string `json:"tag_match,omitempty"` // "any" (default) or "all" of the request's tags
 }
+
 type TrackGetItemsByPrefixRequest struct {
 	BucketID int32         `json:"bucketId"`
 	Prefix   string        `json:"prefix"`
 	Tags     []int64       `json:"tags,omitempty"`
 	Metric   *MetricFilter `json:"metric,omitempty"`
+	TrackPageRequest
 }
 
 type TrackGetItemsByPrefixesRequest struct {
@@ -35,15 +100,54 @@ type TrackGetItemsByPrefixesRequest struct {
 	Prefixes []string      `json:"prefixes"`
 	Tags     []int64       `json:"tags,omitempty"`
 	Metric   *MetricFilter `json:"metric,omitempty"`
+	TrackPageRequest
+}
+
+// TrackGetItemsByRangeRequest selects keys from start to end; an empty bound is open.
+// The range is half-open by default: start is included and end is not.
+type TrackGetItemsByRangeRequest struct {
+	BucketID       int32         `json:"bucketId"`
+	Start          string        `json:"start,omitempty"`
+	End            string        `json:"end,omitempty"`
+	StartInclusive *bool         `json:"start_inclusive,omitempty"` // defaults to true
+	EndInclusive   bool          `json:"end_inclusive,omitempty"`
+	Tags           []int64       `json:"tags,omitempty"`
+	Metric         *MetricFilter `json:"metric,omitempty"`
+	TrackPageRequest
+}
+
+// TrackGetKeysByValueRequest finds the keys holding any of the values, optionally only
+// under a prefix. Values are strings, like track values elsewhere, so they survive JSON
+// parsers without 64-bit integers. Pages are in key order only.
+type TrackGetKeysByValueRequest struct {
+	BucketID int32    `json:"bucketId"`
+	Values   []string `json:"values"`
+	Prefix   string   `json:"prefix,omitempty"`
+	TrackPageRequest
+}
+
+// TrackAggregateRequest summarises the items matching the prefixes and filters. An empty
+// prefix matches the whole bucket.
+type TrackAggregateRequest struct {
+	BucketID   int32         `json:"bucketId"`
+	Prefixes   []string      `json:"prefixes"`
+	Tags       []int64       `json:"tags,omitempty"`
+	Metric     *MetricFilter `json:"metric,omitempty"`
+	GroupByTag bool          `json:"group_by_tag,omitempty"`
 }
 
 type TrackBucketKeyPair struct {
 	BucketID int32  `json:"bucketId"`
 	Key      string `json:"key"`
 }
+
+// TrackKeyValueItem carries expiry at the top level over the API; ttl_seconds is only
+// read on writes. Stores keep it in Value.ExpiresAt.
 type TrackKeyValueItem struct {
-	Key   string     `json:"key"`
-	Value TrackValue `json:"value"`
+	Key        string     `json:"key"`
+	Value      TrackValue `json:"value"`
+	TTLSeconds *int64     `json:"ttl_seconds,omitempty" bson:"-"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" bson:"-"`
 }
 
 type TrackPutItems struct {
@@ -56,13 +160,134 @@ type TrackGetKeys struct {
 	Keys     []string `json:"keys"`
 }
 
+type TrackIncrementResponse struct {
+	Key   string `json:"key"`
+	Value int64  `json:"value"`
+}
+
+type TrackQueryResponse struct {
+	Items      []TrackKeyValueItem `json:"items"`
+	NextCursor string              `json:"next_cursor,omitempty"` // set when more items follow
+}
+
+type TrackAggregateResponse struct {
+	Groups []TrackAggregateGroup `json:"groups"`
+}
+
+// TrackAggregateGroup summarises one tag's items when grouping by tag, or all matching
+// items otherwise.
+type TrackAggregateGroup struct {
+	Tag    *int64           `json:"tag,omitempty"` // nil for untagged items, or when not grouping
+	Count  int64            `json:"count"`
+	Value  TrackValueStats  `json:"value"`
+	Metric TrackMetricStats `json:"metric"` // over the items that have a metric
+}
+
+type TrackValueStats struct {
+	Sum int64    `json:"sum"`
+	Min *int64   `json:"min,omitempty"`
+	Max *int64   `json:"max,omitempty"`
+	Avg *float64 `json:"avg,omitempty"`
+}
+
+type TrackMetricStats struct {
+	Count int64    `json:"count"`
+	Sum   float64  `json:"sum"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	Avg   *float64 `json:"avg,omitempty"`
+}
+
+type TrackBucketsResponse struct {
+	Buckets []int32 `json:"buckets"`
+}
+
+// TrackBucketStats describes the live keys of one bucket. ApproxBytes estimates the
+// stored keys and values and leaves out backend overhead such as indexes.
+type TrackBucketStats struct {
+	BucketID    int32            `json:"bucketId"`
+	KeyCount    int64            `json:"key_count"`
+	MinKey      string           `json:"min_key"`
+	MaxKey      string           `json:"max_key"`
+	Value       TrackValueStats  `json:"value"`
+	Metric      TrackMetricStats `json:"metric"` // over the keys that have a metric
+	ApproxBytes int64            `json:"approx_bytes"`
+}
+
+// TrackExportItem is one NDJSON line of a bucket export, and of an import.
+type TrackExportItem struct {
+	Key       string     `json:"key"`
+	Value     int64      `json:"value,string"`
+	Tag       *int64     `json:"tag,omitempty"`
+	Tags      []int64    `json:"tags,omitempty"`
+	Metric    *float64   `json:"metric,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// TrackImportResponse summarises an import. Batches are committed as they fill, so after
+// an error Imported still counts the lines that were written.
+type TrackImportResponse struct {
+	Imported int    `json:"imported"`
+	Expired  int    `json:"expired"` // lines skipped because their expiry had passed
+	Batches  int    `json:"batches"`
+	Error    string `json:"error,omitempty"`
+}
+
+// TrackHistoryPolicy turns on history for a bucket; leaving both fields zero turns it off
+// and drops the bucket's history.
+type TrackHistoryPolicy struct {
+	MaxVersions   int   `json:"max_versions,omitempty"`
+	MaxAgeSeconds int64 `json:"max_age_seconds,omitempty"`
+}
+
+type TrackHistoryRequest struct {
+	BucketID int32  `json:"bucketId"`
+	Key      string `json:"key"`
+	Limit    int    `json:"limit,omitempty"`
+}
+
+// TrackHistoryEntry is a value a key held before a write or delete replaced it.
+type TrackHistoryEntry struct {
+	Value      int64      `json:"value,string"`
+	Tag        *int64     `json:"tag,omitempty"`
+	Tags       []int64    `json:"tags,omitempty"`
+	Metric     *float64   `json:"metric,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	ReplacedAt time.Time  `json:"replaced_at"`
+}
+
+type TrackHistoryResponse struct {
+	Key     string              `json:"key"`
+	Entries []TrackHistoryEntry `json:"entries"` // newest first
+}
+
 type TrackGetManyResponse struct {
 	Values  map[string]map[string]TrackValue `json:"values"`  // bucketId -> (key -> value)
 	Missing map[string][]string              `json:"missing"` // bucketId -> list of missing keys
 }
 
+// TrackValue carries an optional primary Tag and any number of further Tags. Tag
+// filters match against both; conditional puts and grouping by tag use Tag alone.
 type TrackValue struct {
-	Value  int64    `bson:"value"`
-	Tag    *int64   `bson:"tag,omitempty"`
-	Metric *float64 `bson:"metric,omitempty"`
+	Value     int64      `bson:"value"`
+	Tag       *int64     `bson:"tag,omitempty"`
+	Tags      []int64    `bson:"tags,omitempty" json:",omitempty"`
+	Metric    *float64   `bson:"metric,omitempty"`
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty" json:"-"` // nil for keys that never expire
+}
+
+// TrackWatchEvent is the data of one /track/watch server-sent event. A put carries the
+// written entry; a delete by prefix carries Prefix instead of Key, as the keys it removed
+// are not known.
+type TrackWatchEvent struct {
+	Sequence  uint64     `json:"sequence"`
+	Type      string     `json:"type"` // "put" or "delete"
+	BucketID  int32      `json:"bucketId"`
+	Key       string     `json:"key,omitempty"`
+	Prefix    *string    `json:"prefix,omitempty"`
+	Value     *int64     `json:"value,string,omitempty"`
+	Tag       *int64     `json:"tag,omitempty"`
+	Tags      []int64    `json:"tags,omitempty"`
+	Metric    *float64   `json:"metric,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
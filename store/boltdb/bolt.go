@@ -8,10 +8,38 @@ type BoltStore struct {
 	db *bbolt.DB
 }
 
+// BoltOptions configures a BoltStore.
+type BoltOptions struct {
+	// TrackFilterIndexes builds tag and metric indexes of the track buckets, so that
+	// filtered track reads walk an index rather than every key under their prefixes, at
+	// the cost of more work per write. Once built, the file keeps them up to date however
+	// it is opened, until DropTrackFilterIndexes.
+	TrackFilterIndexes bool
+}
+
 func NewBoltStore(path string) (*BoltStore, error) {
+	return NewBoltStoreWithOptions(path, BoltOptions{})
+}
+
+func NewBoltStoreWithOptions(path string, opts BoltOptions) (*BoltStore, error) {
 	db, err := bbolt.Open(path, 0666, nil)
 	if err != nil {
 		return nil, err
 	}
-	return &BoltStore{db: db}, nil
+	store := &BoltStore{db: db}
+	if err := store.buildTrackValueIndexes(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := store.buildTrackExpiryIndex(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if opts.TrackFilterIndexes {
+		if err := store.setTrackFilterIndexes(true); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return store, nil
 }
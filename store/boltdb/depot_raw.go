@@ -0,0 +1,140 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/vixac/bullet/model"
+	"github.com/vixac/bullet/store/store_interface"
+	"go.etcd.io/bbolt"
+)
+
+// Raw data up to depotInlineRawBytes is stored inline in the item's entry. Anything
+// larger goes into the space's data bucket in chunks of depotRawChunkBytes, keyed by the
+// item ID and chunk number, so that item buckets stay small to page through and large
+// values do not each need one long run of free pages.
+const (
+	depotInlineRawBytes = 16 << 10
+	depotRawChunkBytes  = 64 << 10
+)
+
+func getDepotDataBucketName(space store_interface.TenancySpace) []byte {
+	return []byte(fmt.Sprintf("depot:v2:%d:tenant:%d:data", space.AppId, space.TenancyId))
+}
+
+func depotChunkKey(id int64, n int) []byte {
+	return binary.BigEndian.AppendUint32(depotIDKey(id), uint32(n))
+}
+
+// putDepotEntry writes e as the entry of id, storing its raw data in chunks when it is
+// too large to inline, and drops any chunks of the entry it replaces.
+func putDepotEntry(tx *bbolt.Tx, space store_interface.TenancySpace, items *bbolt.Bucket, id int64, e depotEntry) error {
+	if err := deleteDepotChunks(tx, space, id); err != nil {
+		return err
+	}
+	if e.contentType != "" && len(e.data) > depotInlineRawBytes {
+		chunks, err := tx.CreateBucketIfNotExists(getDepotDataBucketName(space))
+		if err != nil {
+			return err
+		}
+		for n, off := 0, 0; off < len(e.data); n, off = n+1, off+depotRawChunkBytes {
+			chunk := e.data[off:min(off+depotRawChunkBytes, len(e.data))]
+			if err := chunks.Put(depotChunkKey(id, n), chunk); err != nil {
+				return err
+			}
+		}
+		e.chunked, e.size, e.data = true, len(e.data), nil
+	}
+	return items.Put(depotIDKey(id), encodeDepotEntry(e))
+}
+
+// deleteDepotChunks drops the chunks of id, if it has any.
+func deleteDepotChunks(tx *bbolt.Tx, space store_interface.TenancySpace, id int64) error {
+	chunks := tx.Bucket(getDepotDataBucketName(space))
+	if chunks == nil {
+		return nil
+	}
+	prefix := depotIDKey(id)
+	var keys [][]byte
+	c := chunks.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	// Deleting while a cursor is walking the same bucket skips keys, so delete afterwards
+	for _, k := range keys {
+		if err := chunks.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// depotRawData copies out the raw data of e, joining its chunks when it has them.
+func depotRawData(tx *bbolt.Tx, space store_interface.TenancySpace, id int64, e depotEntry) ([]byte, error) {
+	if !e.chunked {
+		return bytes.Clone(e.data), nil
+	}
+	data := make([]byte, 0, e.size)
+	if chunks := tx.Bucket(getDepotDataBucketName(space)); chunks != nil {
+		prefix := depotIDKey(id)
+		c := chunks.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			data = append(data, v...)
+		}
+	}
+	if len(data) != e.size {
+		return nil, fmt.Errorf("depot item %d has %d of its %d bytes", id, len(data), e.size)
+	}
+	return data, nil
+}
+
+func (m *BoltStore) DepotCreateRaw(space store_interface.TenancySpace, bucketID int32, data []byte, contentType string) (int64, int64, error) {
+	var id int64
+	err := m.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		id, err = createDepotEntry(tx, space, bucketID, depotEntry{
+			revision:    store_interface.DepotInitialRevision,
+			contentType: store_interface.DepotRawContentType(contentType),
+			data:        data,
+		})
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, store_interface.DepotInitialRevision, nil
+}
+
+func (m *BoltStore) DepotUpdateRaw(space store_interface.TenancySpace, id int64, data []byte, contentType string) (int64, error) {
+	return m.updateDepotEntry(space, id, 0, func(e *depotEntry) { e.setRaw(data, contentType) })
+}
+
+func (m *BoltStore) DepotUpdateRawIfRevision(space store_interface.TenancySpace, id int64, data []byte, contentType string, revision int64) (int64, error) {
+	return m.updateDepotEntry(space, id, revision, func(e *depotEntry) { e.setRaw(data, contentType) })
+}
+
+func (m *BoltStore) DepotGetRaw(space store_interface.TenancySpace, id int64) (model.DepotRawItem, error) {
+	var raw model.DepotRawItem
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		e, err := getDepotEntry(tx, space, id)
+		if err != nil {
+			return err
+		}
+		data, err := depotRawData(tx, space, id, e)
+		if err != nil {
+			return err
+		}
+		raw = store_interface.DepotRawFromItem(e.item(id), data)
+		return nil
+	})
+	if err != nil {
+		return model.DepotRawItem{}, err
+	}
+	return raw, nil
+}
+
+// setRaw turns e into a raw entry holding data.
+func (e *depotEntry) setRaw(data []byte, contentType string) {
+	*e = depotEntry{revision: e.revision, contentType: store_interface.DepotRawContentType(contentType), data: data}
+}
@@ -1,11 +1,18 @@
 package boltdb
 
 import (
+	"encoding/binary"
 	"fmt"
 
+	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/store_interface"
+	"go.etcd.io/bbolt"
 )
 
+// Each space has an ID bucket, mapping every item ID to its depot bucket and handing out
+// IDs from its sequence, and an item bucket per depot bucket, holding the items keyed by
+// ID. Items are read by ID through the ID bucket, and buckets are paged in ID order
+// straight from their item bucket.
 func newDepotBucket(appID int32, tenantId int64) []byte {
 	return []byte(fmt.Sprintf("depot:v2:%d:tenant:%d", appID, tenantId))
 }
@@ -13,33 +20,364 @@ func newDepotBucket(appID int32, tenantId int64) []byte {
 func getBucketName(space store_interface.TenancySpace) []byte {
 	return newDepotBucket(space.AppId, space.TenancyId)
 }
-func (m *BoltStore) DepotCreate(space store_interface.TenancySpace, bucketID int32, value string) (int64, error) {
-	return 0, nil
+
+func getDepotItemBucketName(space store_interface.TenancySpace, bucketID int32) []byte {
+	return []byte(fmt.Sprintf("depot:v2:%d:tenant:%d:bucket:%d", space.AppId, space.TenancyId, bucketID))
+}
+
+func depotIDKey(id int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+// depotEntry is a stored item. Entries are a kind byte and the big-endian revision,
+// followed for text items by the value, and for raw items by the length-prefixed content
+// type and then either the data or, for data stored in chunks, its size. See depot_raw.go.
+type depotEntry struct {
+	revision    int64
+	value       string
+	contentType string // set for raw items
+	data        []byte // inline raw data; it points into the transaction's memory when decoded
+	chunked     bool   // the raw data is in the space's data bucket instead
+	size        int    // of the chunked data
+}
+
+const (
+	depotKindText byte = iota
+	depotKindRaw
+	depotKindRawChunked
+)
+
+func encodeDepotEntry(e depotEntry) []byte {
+	if e.contentType == "" {
+		out := make([]byte, 0, 9+len(e.value))
+		out = append(out, depotKindText)
+		out = binary.BigEndian.AppendUint64(out, uint64(e.revision))
+		return append(out, e.value...)
+	}
+	kind := depotKindRaw
+	if e.chunked {
+		kind = depotKindRawChunked
+	}
+	out := make([]byte, 0, 19+len(e.contentType)+len(e.data))
+	out = append(out, kind)
+	out = binary.BigEndian.AppendUint64(out, uint64(e.revision))
+	out = binary.BigEndian.AppendUint16(out, uint16(len(e.contentType)))
+	out = append(out, e.contentType...)
+	if e.chunked {
+		return binary.BigEndian.AppendUint64(out, uint64(e.size))
+	}
+	return append(out, e.data...)
+}
+
+func decodeDepotEntry(b []byte) (depotEntry, error) {
+	if len(b) < 9 {
+		return depotEntry{}, fmt.Errorf("malformed depot entry")
+	}
+	e := depotEntry{revision: int64(binary.BigEndian.Uint64(b[1:9]))}
+	switch b[0] {
+	case depotKindText:
+		e.value = string(b[9:])
+		return e, nil
+	case depotKindRaw, depotKindRawChunked:
+		if len(b) < 11 || len(b) < 11+int(binary.BigEndian.Uint16(b[9:11])) {
+			return depotEntry{}, fmt.Errorf("malformed depot entry")
+		}
+		n := 11 + int(binary.BigEndian.Uint16(b[9:11]))
+		e.contentType = string(b[11:n])
+		if b[0] == depotKindRaw {
+			e.data = b[n:]
+			return e, nil
+		}
+		if len(b) != n+8 {
+			return depotEntry{}, fmt.Errorf("malformed depot entry")
+		}
+		e.chunked, e.size = true, int(binary.BigEndian.Uint64(b[n:]))
+		return e, nil
+	}
+	return depotEntry{}, fmt.Errorf("malformed depot entry")
+}
+
+func (e depotEntry) item(id int64) model.DepotItem {
+	return model.DepotItem{ID: id, Value: e.value, Revision: e.revision, ContentType: e.contentType}
+}
+
+// setText turns e into a text entry holding value.
+func (e *depotEntry) setText(value string) {
+	*e = depotEntry{revision: e.revision, value: value}
+}
+
+// depotItemBucket returns the item bucket holding id, or nil when the space has no such item.
+func depotItemBucket(tx *bbolt.Tx, space store_interface.TenancySpace, id int64) *bbolt.Bucket {
+	ids := tx.Bucket(getBucketName(space))
+	if ids == nil {
+		return nil
+	}
+	bucketID := ids.Get(depotIDKey(id))
+	if bucketID == nil {
+		return nil
+	}
+	return tx.Bucket(getDepotItemBucketName(space, int32(binary.BigEndian.Uint32(bucketID))))
+}
+
+// getDepotEntry reads the entry of id, or returns ErrDepotItemNotFound.
+func getDepotEntry(tx *bbolt.Tx, space store_interface.TenancySpace, id int64) (depotEntry, error) {
+	bkt := depotItemBucket(tx, space, id)
+	if bkt == nil {
+		return depotEntry{}, store_interface.ErrDepotItemNotFound
+	}
+	v := bkt.Get(depotIDKey(id))
+	if v == nil {
+		return depotEntry{}, store_interface.ErrDepotItemNotFound
+	}
+	return decodeDepotEntry(v)
+}
+
+// createDepotEntry stores e under the next ID of the space and returns the ID.
+func createDepotEntry(tx *bbolt.Tx, space store_interface.TenancySpace, bucketID int32, e depotEntry) (int64, error) {
+	ids, err := tx.CreateBucketIfNotExists(getBucketName(space))
+	if err != nil {
+		return 0, err
+	}
+	items, err := tx.CreateBucketIfNotExists(getDepotItemBucketName(space, bucketID))
+	if err != nil {
+		return 0, err
+	}
+	seq, err := ids.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	id := int64(seq)
+	if err := ids.Put(depotIDKey(id), binary.BigEndian.AppendUint32(nil, uint32(bucketID))); err != nil {
+		return 0, err
+	}
+	return id, putDepotEntry(tx, space, items, id, e)
+}
+
+func (m *BoltStore) DepotCreate(space store_interface.TenancySpace, bucketID int32, value string) (int64, int64, error) {
+	var id int64
+	err := m.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		id, err = createDepotEntry(tx, space, bucketID, depotEntry{revision: store_interface.DepotInitialRevision, value: value})
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, store_interface.DepotInitialRevision, nil
 }
+
 func (m *BoltStore) DepotCreateMany(space store_interface.TenancySpace, bucketID int32, values []string) ([]int64, error) {
-	return []int64{}, nil
+	ids := make([]int64, 0, len(values))
+	err := m.db.Update(func(tx *bbolt.Tx) error {
+		for _, v := range values {
+			id, err := createDepotEntry(tx, space, bucketID, depotEntry{revision: store_interface.DepotInitialRevision, value: v})
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
 }
 
 func (m *BoltStore) DepotUpdate(space store_interface.TenancySpace, id int64, value string) error {
-	return nil
+	_, err := m.updateDepotEntry(space, id, 0, func(e *depotEntry) { e.setText(value) })
+	return err
+}
+
+func (m *BoltStore) DepotUpdateIfRevision(space store_interface.TenancySpace, id int64, value string, revision int64) (int64, error) {
+	return m.updateDepotEntry(space, id, revision, func(e *depotEntry) { e.setText(value) })
+}
 
+// updateDepotEntry applies update to the entry of id and bumps its revision, which it
+// returns. A non-zero revision has to match the current one.
+func (m *BoltStore) updateDepotEntry(space store_interface.TenancySpace, id int64, revision int64, update func(*depotEntry)) (int64, error) {
+	var e depotEntry
+	err := m.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		e, err = getDepotEntry(tx, space, id)
+		if err != nil {
+			return err
+		}
+		if revision != 0 && e.revision != revision {
+			return &store_interface.DepotRevisionConflictError{ID: id, Expected: revision, Current: e.revision}
+		}
+		update(&e)
+		e.revision++
+		return putDepotEntry(tx, space, depotItemBucket(tx, space, id), id, e)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return e.revision, nil
 }
 
 func (m *BoltStore) DepotGet(space store_interface.TenancySpace, id int64) (string, error) {
-	return "", nil
+	var value string
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		e, err := getDepotEntry(tx, space, id)
+		value = e.value
+		return err
+	})
+	return value, err
+}
+func (m *BoltStore) DepotGetItem(space store_interface.TenancySpace, id int64) (model.DepotItem, error) {
+	var item model.DepotItem
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		e, err := getDepotEntry(tx, space, id)
+		item = e.item(id)
+		return err
+	})
+	if err != nil {
+		return model.DepotItem{}, err
+	}
+	return item, nil
 }
 func (m *BoltStore) DepotGetMany(space store_interface.TenancySpace, ids []int64) (map[int64]string, []int64, error) {
-	return map[int64]string{}, []int64{}, nil
+	found := make(map[int64]string)
+	var missing []int64
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		for _, id := range ids {
+			e, err := getDepotEntry(tx, space, id)
+			if err == store_interface.ErrDepotItemNotFound {
+				missing = append(missing, id)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			found[id] = e.value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return found, missing, nil
 }
 
 func (m *BoltStore) DepotDelete(space store_interface.TenancySpace, id int64) error {
-	return nil
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		bkt := depotItemBucket(tx, space, id)
+		if bkt == nil {
+			return nil
+		}
+		if err := bkt.Delete(depotIDKey(id)); err != nil {
+			return err
+		}
+		if err := deleteDepotChunks(tx, space, id); err != nil {
+			return err
+		}
+		return tx.Bucket(getBucketName(space)).Delete(depotIDKey(id))
+	})
 }
 func (m *BoltStore) DepotDeleteByBucket(space store_interface.TenancySpace, bucketID int32) error {
-	return nil
-
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		name := getDepotItemBucketName(space, bucketID)
+		items := tx.Bucket(name)
+		if items == nil {
+			return nil
+		}
+		ids := tx.Bucket(getBucketName(space))
+		err := items.ForEach(func(k, _ []byte) error {
+			if err := deleteDepotChunks(tx, space, int64(binary.BigEndian.Uint64(k))); err != nil {
+				return err
+			}
+			return ids.Delete(k)
+		})
+		if err != nil {
+			return err
+		}
+		return tx.DeleteBucket(name)
+	})
 }
 func (m *BoltStore) DepotGetAllByBucket(space store_interface.TenancySpace, bucketID int32) (map[int64]string, error) {
+	result := make(map[int64]string)
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		items := tx.Bucket(getDepotItemBucketName(space, bucketID))
+		if items == nil {
+			return nil
+		}
+		return items.ForEach(func(k, v []byte) error {
+			e, err := decodeDepotEntry(v)
+			if err != nil {
+				return err
+			}
+			result[int64(binary.BigEndian.Uint64(k))] = e.value
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (m *BoltStore) DepotGetBucketPage(space store_interface.TenancySpace, bucketID int32, opts store_interface.DepotPageOptions) ([]model.DepotItem, int64, error) {
+	return m.DepotQuery(space, bucketID, nil, opts)
+}
+
+// depotPageStart positions c at the first item after the page cursor in the page's direction.
+func depotPageStart(c *bbolt.Cursor, opts store_interface.DepotPageOptions) ([]byte, []byte) {
+	if opts.StartAfter == 0 {
+		if opts.Descending {
+			return c.Last()
+		}
+		return c.First()
+	}
+	k, v := c.Seek(depotIDKey(opts.StartAfter))
+	if opts.Descending {
+		if k == nil {
+			return c.Last()
+		}
+		return c.Prev()
+	}
+	if k != nil && int64(binary.BigEndian.Uint64(k)) == opts.StartAfter {
+		return c.Next()
+	}
+	return k, v
+}
+
+func depotPageStep(c *bbolt.Cursor, opts store_interface.DepotPageOptions) ([]byte, []byte) {
+	if opts.Descending {
+		return c.Prev()
+	}
+	return c.Next()
+}
 
-	return map[int64]string{}, nil
+// DepotQuery walks the bucket's items with a cursor from the page cursor and applies the
+// filters in Go, stopping one match past the page to know whether another follows.
+func (m *BoltStore) DepotQuery(space store_interface.TenancySpace, bucketID int32, filters []store_interface.DepotFieldFilter, opts store_interface.DepotPageOptions) ([]model.DepotItem, int64, error) {
+	if err := store_interface.ValidateDepotQuery(filters, opts); err != nil {
+		return nil, 0, err
+	}
+	var items []model.DepotItem
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(getDepotItemBucketName(space, bucketID))
+		if bkt == nil {
+			return nil
+		}
+		fetch := opts.FetchLimit()
+		c := bkt.Cursor()
+		k, v := depotPageStart(c, opts)
+		for ; k != nil && (fetch == 0 || len(items) < fetch); k, v = depotPageStep(c, opts) {
+			e, err := decodeDepotEntry(v)
+			if err != nil {
+				return err
+			}
+			if store_interface.DepotValueMatches(filters, e.value) {
+				items = append(items, e.item(int64(binary.BigEndian.Uint64(k))))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	page, next := opts.PageItems(items)
+	return page, next, nil
 }
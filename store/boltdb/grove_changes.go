@@ -0,0 +1,143 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/vixac/bullet/store/store_interface"
+	"go.etcd.io/bbolt"
+)
+
+func groveChangesBucket(space store_interface.TenancySpace, treeID store_interface.TreeID) []byte {
+	return []byte(fmt.Sprintf("grove:changes:%d:%d:%s", space.AppId, space.TenancyId, treeID))
+}
+
+// Change log entry structure, keyed by big-endian sequence number
+type changeData struct {
+	Kind       string                          `json:"kind"`
+	NodeID     string                          `json:"node_id"`
+	Parent     *string                         `json:"parent,omitempty"`
+	Position   *float64                        `json:"position,omitempty"`
+	Metadata   *store_interface.NodeMetadata   `json:"metadata,omitempty"`
+	MutationID *string                         `json:"mutation_id,omitempty"`
+	Deltas     store_interface.AggregateDeltas `json:"deltas,omitempty"`
+	Soft       bool                            `json:"soft,omitempty"`
+	At         int64                           `json:"at"`
+}
+
+func changeKey(seq int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(seq))
+	return key
+}
+
+// GetChanges returns a tree's changes after the given sequence number
+func (b *BoltStore) GetChanges(space store_interface.TenancySpace, treeID store_interface.TreeID, since int64, limit int) ([]store_interface.GroveChange, error) {
+	changes := []store_interface.GroveChange{}
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		changesBkt := tx.Bucket(groveChangesBucket(space, treeID))
+		if changesBkt == nil {
+			return nil
+		}
+		if since < 0 {
+			since = 0
+		}
+
+		c := changesBkt.Cursor()
+		for k, v := c.Seek(changeKey(since + 1)); k != nil; k, v = c.Next() {
+			if limit > 0 && len(changes) >= limit {
+				break
+			}
+			var entry changeData
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			change := store_interface.GroveChange{
+				Seq:      int64(binary.BigEndian.Uint64(k)),
+				Kind:     store_interface.GroveChangeKind(entry.Kind),
+				NodeID:   store_interface.NodeID(entry.NodeID),
+				Metadata: entry.Metadata,
+				Deltas:   entry.Deltas,
+				Soft:     entry.Soft,
+				At:       time.UnixMilli(entry.At).UTC(),
+			}
+			if entry.Parent != nil {
+				p := store_interface.NodeID(*entry.Parent)
+				change.Parent = &p
+			}
+			if entry.Position != nil {
+				p := store_interface.ChildPosition(*entry.Position)
+				change.Position = &p
+			}
+			if entry.MutationID != nil {
+				m := store_interface.MutationID(*entry.MutationID)
+				change.MutationID = &m
+			}
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// recordChange appends a change to the tree's log inside the writing transaction
+func recordChange(tx *bbolt.Tx, space store_interface.TenancySpace, treeID store_interface.TreeID, change store_interface.GroveChange) error {
+	changesBkt, err := tx.CreateBucketIfNotExists(groveChangesBucket(space, treeID))
+	if err != nil {
+		return err
+	}
+	seq, err := changesBkt.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	entry := changeData{
+		Kind:     string(change.Kind),
+		NodeID:   string(change.NodeID),
+		Metadata: change.Metadata,
+		Deltas:   change.Deltas,
+		Soft:     change.Soft,
+		At:       time.Now().UnixMilli(),
+	}
+	if change.Parent != nil {
+		p := string(*change.Parent)
+		entry.Parent = &p
+	}
+	if change.Position != nil {
+		p := float64(*change.Position)
+		entry.Position = &p
+	}
+	if change.MutationID != nil {
+		m := string(*change.MutationID)
+		entry.MutationID = &m
+	}
+
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return changesBkt.Put(changeKey(int64(seq)), entryBytes)
+}
+
+// nodeChange builds a change from a stored node.
+func nodeChange(kind store_interface.GroveChangeKind, n nodeData) store_interface.GroveChange {
+	change := store_interface.GroveChange{
+		Kind:     kind,
+		NodeID:   store_interface.NodeID(n.ID),
+		Metadata: n.Metadata,
+	}
+	if n.Parent != nil {
+		p := store_interface.NodeID(*n.Parent)
+		change.Parent = &p
+	}
+	if n.Position != nil {
+		p := store_interface.ChildPosition(*n.Position)
+		change.Position = &p
+	}
+	return change
+}
@@ -0,0 +1,238 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/vixac/bullet/store/store_interface"
+	"go.etcd.io/bbolt"
+)
+
+// treeInspection is everything inspectTree learns about a tree, including the raw
+// keys of dangling aggregate and mutation entries so RepairTree can delete them.
+type treeInspection struct {
+	shape           *store_interface.TreeShape
+	report          *store_interface.TreeIntegrityReport
+	nodes           map[store_interface.NodeID]nodeData
+	danglingAggKeys [][]byte
+	danglingMutKeys [][]byte
+}
+
+// VerifyTree reports inconsistencies between a tree's parent pointers and its derived data
+func (b *BoltStore) VerifyTree(space store_interface.TenancySpace, treeID store_interface.TreeID) (*store_interface.TreeIntegrityReport, error) {
+	var report *store_interface.TreeIntegrityReport
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		inspection, err := inspectTree(tx, space, treeID)
+		if err != nil {
+			return err
+		}
+		report = inspection.report
+		return nil
+	})
+	return report, err
+}
+
+// RepairTree rebuilds a tree's closure entries and depths from its parent pointers
+func (b *BoltStore) RepairTree(space store_interface.TenancySpace, treeID store_interface.TreeID) (*store_interface.TreeIntegrityReport, error) {
+	var report *store_interface.TreeIntegrityReport
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		inspection, err := inspectTree(tx, space, treeID)
+		if err != nil {
+			return err
+		}
+		report = inspection.report
+		if len(inspection.nodes) == 0 && report.Consistent() {
+			return nil
+		}
+
+		nodesBkt, err := tx.CreateBucketIfNotExists(groveNodesBucket(space, treeID))
+		if err != nil {
+			return err
+		}
+		closureBkt, err := tx.CreateBucketIfNotExists(groveClosureBucket(space, treeID))
+		if err != nil {
+			return err
+		}
+
+		for id, n := range inspection.nodes {
+			parent := inspection.shape.Parents[id]
+			depth := inspection.shape.Depths[id]
+			if n.Depth == depth && (n.Parent == nil) == (parent == nil) {
+				continue
+			}
+			n.Depth = depth
+			if parent == nil {
+				n.Parent = nil
+			}
+			nodeBytes, err := json.Marshal(n)
+			if err != nil {
+				return err
+			}
+			if err := nodesBkt.Put([]byte(id), nodeBytes); err != nil {
+				return err
+			}
+		}
+
+		// Stale entries go first so entries at the wrong depth can be rewritten
+		for _, pair := range report.StaleClosure {
+			if err := closureBkt.Delete([]byte(fmt.Sprintf("%s:%s", pair.Ancestor, pair.Descendant))); err != nil {
+				return err
+			}
+		}
+		for _, pair := range report.MissingClosure {
+			entryBytes, err := json.Marshal(closureEntry{
+				AncestorID:   string(pair.Ancestor),
+				DescendantID: string(pair.Descendant),
+				Depth:        pair.Depth,
+			})
+			if err != nil {
+				return err
+			}
+			if err := closureBkt.Put([]byte(fmt.Sprintf("%s:%s", pair.Ancestor, pair.Descendant)), entryBytes); err != nil {
+				return err
+			}
+		}
+
+		for _, id := range inspection.shape.Detached {
+			change := store_interface.GroveChange{Kind: store_interface.GroveChangeMove, NodeID: id}
+			if position := inspection.nodes[id].Position; position != nil {
+				p := store_interface.ChildPosition(*position)
+				change.Position = &p
+			}
+			if err := recordChange(tx, space, treeID, change); err != nil {
+				return err
+			}
+		}
+
+		if bkt := tx.Bucket(groveAggregatesBucket(space, treeID)); bkt != nil {
+			for _, k := range inspection.danglingAggKeys {
+				if err := bkt.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		if bkt := tx.Bucket(groveMutationsBucket(space, treeID)); bkt != nil {
+			for _, k := range inspection.danglingMutKeys {
+				if err := bkt.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Repaired = true
+	return report, nil
+}
+
+// inspectTree compares a tree's closure entries, depths and side buckets with its parent pointers
+func inspectTree(tx *bbolt.Tx, space store_interface.TenancySpace, treeID store_interface.TreeID) (*treeInspection, error) {
+	inspection := &treeInspection{nodes: make(map[store_interface.NodeID]nodeData)}
+	parents := make(map[store_interface.NodeID]*store_interface.NodeID)
+
+	if nodesBkt := tx.Bucket(groveNodesBucket(space, treeID)); nodesBkt != nil {
+		c := nodesBkt.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var n nodeData
+			if err := json.Unmarshal(v, &n); err != nil {
+				return nil, err
+			}
+			id := store_interface.NodeID(k)
+			inspection.nodes[id] = n
+			var parent *store_interface.NodeID
+			if n.Parent != nil {
+				p := store_interface.NodeID(*n.Parent)
+				parent = &p
+			}
+			parents[id] = parent
+		}
+	}
+
+	var stored []store_interface.ClosurePair
+	if closureBkt := tx.Bucket(groveClosureBucket(space, treeID)); closureBkt != nil {
+		c := closureBkt.Cursor()
+		for _, v := c.First(); v != nil; _, v = c.Next() {
+			var entry closureEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return nil, err
+			}
+			stored = append(stored, store_interface.ClosurePair{
+				Ancestor:   store_interface.NodeID(entry.AncestorID),
+				Descendant: store_interface.NodeID(entry.DescendantID),
+				Depth:      entry.Depth,
+			})
+		}
+	}
+
+	inspection.shape = store_interface.BuildTreeShape(parents)
+	inspection.report = inspection.shape.NewIntegrityReport(treeID, stored)
+
+	for id, n := range inspection.nodes {
+		if n.Depth != inspection.shape.Depths[id] {
+			inspection.report.DepthMismatches = append(inspection.report.DepthMismatches, id)
+		}
+	}
+	sortNodeIDs(inspection.report.DepthMismatches)
+
+	// Soft-deleted nodes can be restored, so their aggregates and markers are kept
+	deletedBkt := tx.Bucket(groveDeletedBucket(space, treeID))
+	known := func(id string) bool {
+		if _, exists := inspection.nodes[store_interface.NodeID(id)]; exists {
+			return true
+		}
+		return deletedBkt != nil && deletedBkt.Get([]byte(id)) != nil
+	}
+
+	inspection.report.DanglingAggregates, inspection.danglingAggKeys = danglingNodeEntries(tx.Bucket(groveAggregatesBucket(space, treeID)), known)
+	inspection.report.DanglingMutations, inspection.danglingMutKeys = danglingNodeEntries(tx.Bucket(groveMutationsBucket(space, treeID)), known)
+
+	return inspection, nil
+}
+
+// danglingNodeEntries finds "node:..." keys whose node is not known. Node IDs may
+// themselves contain ':', so a key is kept if any of its colon-separated prefixes is known.
+func danglingNodeEntries(bkt *bbolt.Bucket, known func(id string) bool) ([]store_interface.NodeID, [][]byte) {
+	if bkt == nil {
+		return nil, nil
+	}
+	var keys [][]byte
+	seen := make(map[store_interface.NodeID]bool)
+	var ids []store_interface.NodeID
+
+	c := bkt.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		firstColon := -1
+		found := false
+		for i, ch := range k {
+			if ch != ':' {
+				continue
+			}
+			if firstColon < 0 {
+				firstColon = i
+			}
+			if known(string(k[:i])) {
+				found = true
+				break
+			}
+		}
+		if found || firstColon < 0 {
+			continue
+		}
+		keys = append(keys, append([]byte(nil), k...))
+		id := store_interface.NodeID(k[:firstColon])
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sortNodeIDs(ids)
+	return ids, keys
+}
+
+func sortNodeIDs(ids []store_interface.NodeID) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
@@ -157,7 +157,7 @@ func (b *BoltStore) CreateNode(
 			}
 		}
 
-		return nil
+		return recordChange(tx, space, treeID, nodeChange(store_interface.GroveChangeCreate, nodeObj))
 	})
 }
 
@@ -224,7 +224,11 @@ func (b *BoltStore) DeleteNode(space store_interface.TenancySpace, treeID store_
 			}
 		}
 
-		return nil
+		return recordChange(tx, space, treeID, store_interface.GroveChange{
+			Kind:   store_interface.GroveChangeDelete,
+			NodeID: node,
+			Soft:   soft,
+		})
 	})
 }
 
@@ -409,8 +413,502 @@ func (b *BoltStore) MoveNode(
 			}
 		}
 
+		return recordChange(tx, space, treeID, store_interface.GroveChange{
+			Kind:     store_interface.GroveChangeMove,
+			NodeID:   node,
+			Parent:   newParent,
+			Position: newPosition,
+		})
+	})
+}
+
+// UpdateNodeMetadata replaces a node's metadata
+func (b *BoltStore) UpdateNodeMetadata(
+	space store_interface.TenancySpace,
+	treeID store_interface.TreeID,
+	node store_interface.NodeID,
+	metadata store_interface.NodeMetadata,
+) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		nodesBkt := tx.Bucket(groveNodesBucket(space, treeID))
+		if nodesBkt == nil {
+			return store_interface.ErrNodeNotFound
+		}
+		nodeBytes := nodesBkt.Get([]byte(node))
+		if nodeBytes == nil {
+			return store_interface.ErrNodeNotFound
+		}
+
+		var nodeObj nodeData
+		if err := json.Unmarshal(nodeBytes, &nodeObj); err != nil {
+			return err
+		}
+		nodeObj.Metadata = &metadata
+
+		updatedBytes, err := json.Marshal(nodeObj)
+		if err != nil {
+			return err
+		}
+		if err := nodesBkt.Put([]byte(node), updatedBytes); err != nil {
+			return err
+		}
+
+		return recordChange(tx, space, treeID, store_interface.GroveChange{
+			Kind:     store_interface.GroveChangeMetadata,
+			NodeID:   node,
+			Metadata: &metadata,
+		})
+	})
+}
+
+// MoveSubtreeToTree moves a node and its descendants, with their aggregates and mutation history, to another tree
+func (b *BoltStore) MoveSubtreeToTree(
+	space store_interface.TenancySpace,
+	srcTree store_interface.TreeID,
+	node store_interface.NodeID,
+	dstTree store_interface.TreeID,
+	dstParent *store_interface.NodeID,
+	position *store_interface.ChildPosition,
+) error {
+	if srcTree == dstTree {
+		return b.MoveNode(space, srcTree, node, dstParent, position)
+	}
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		srcNodesBkt := tx.Bucket(groveNodesBucket(space, srcTree))
+		srcClosureBkt := tx.Bucket(groveClosureBucket(space, srcTree))
+		if srcNodesBkt == nil || srcClosureBkt == nil || srcNodesBkt.Get([]byte(node)) == nil {
+			return store_interface.ErrNodeNotFound
+		}
+
+		// Collect the subtree and its relative depths
+		relDepth := make(map[string]int)
+		c := srcClosureBkt.Cursor()
+		prefix := []byte(fmt.Sprintf("%s:", node))
+		for k, v := c.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, v = c.Next() {
+			var entry closureEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if entry.AncestorID == string(node) {
+				relDepth[entry.DescendantID] = entry.Depth
+			}
+		}
+
+		nodesBkt, err := tx.CreateBucketIfNotExists(groveNodesBucket(space, dstTree))
+		if err != nil {
+			return err
+		}
+		closureBkt, err := tx.CreateBucketIfNotExists(groveClosureBucket(space, dstTree))
+		if err != nil {
+			return err
+		}
+
+		// Ancestors of the destination parent, which become ancestors of every moved node
+		newDepth := 0
+		var parentAncestors []closureEntry
+		if dstParent != nil {
+			parentBytes := nodesBkt.Get([]byte(*dstParent))
+			if parentBytes == nil {
+				return store_interface.ErrNodeNotFound
+			}
+			var parentNode nodeData
+			if err := json.Unmarshal(parentBytes, &parentNode); err != nil {
+				return err
+			}
+			newDepth = parentNode.Depth + 1
+
+			c := closureBkt.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				var entry closureEntry
+				if err := json.Unmarshal(v, &entry); err != nil {
+					continue
+				}
+				if entry.DescendantID == string(*dstParent) {
+					parentAncestors = append(parentAncestors, entry)
+				}
+			}
+		}
+
+		deletedBkt := tx.Bucket(groveDeletedBucket(space, dstTree))
+		for id := range relDepth {
+			if nodesBkt.Get([]byte(id)) != nil {
+				return store_interface.ErrNodeAlreadyExists
+			}
+			if deletedBkt != nil && deletedBkt.Get([]byte(id)) != nil {
+				return store_interface.ErrNodeAlreadyExists
+			}
+		}
+
+		// Split the source closure rows touching the subtree into internal ones, which
+		// move with it, and external ones, which are dropped
+		var closureKeys [][]byte
+		var internal []closureEntry
+		c = srcClosureBkt.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var entry closureEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				continue
+			}
+			if _, moving := relDepth[entry.DescendantID]; !moving {
+				continue
+			}
+			closureKeys = append(closureKeys, append([]byte(nil), k...))
+			if _, internalAncestor := relDepth[entry.AncestorID]; internalAncestor {
+				internal = append(internal, entry)
+			}
+		}
+		for _, k := range closureKeys {
+			if err := srcClosureBkt.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		putClosure := func(entry closureEntry) error {
+			entryBytes, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			return closureBkt.Put([]byte(fmt.Sprintf("%s:%s", entry.AncestorID, entry.DescendantID)), entryBytes)
+		}
+		for _, entry := range internal {
+			if err := putClosure(entry); err != nil {
+				return err
+			}
+		}
+
+		aggregatesBkt, err := tx.CreateBucketIfNotExists(groveAggregatesBucket(space, dstTree))
+		if err != nil {
+			return err
+		}
+		mutationsBkt, err := tx.CreateBucketIfNotExists(groveMutationsBucket(space, dstTree))
+		if err != nil {
+			return err
+		}
+		srcAggregatesBkt := tx.Bucket(groveAggregatesBucket(space, srcTree))
+		srcMutationsBkt := tx.Bucket(groveMutationsBucket(space, srcTree))
+
+		moved := make([]nodeData, 0, len(relDepth))
+		for id, depth := range relDepth {
+			var n nodeData
+			if err := json.Unmarshal(srcNodesBkt.Get([]byte(id)), &n); err != nil {
+				return err
+			}
+			n.Depth = newDepth + depth
+			if id == string(node) {
+				n.Parent = nil
+				if dstParent != nil {
+					p := string(*dstParent)
+					n.Parent = &p
+				}
+				n.Position = nil
+				if position != nil {
+					p := float64(*position)
+					n.Position = &p
+				}
+			}
+			nodeBytes, err := json.Marshal(n)
+			if err != nil {
+				return err
+			}
+			if err := nodesBkt.Put([]byte(id), nodeBytes); err != nil {
+				return err
+			}
+			if err := srcNodesBkt.Delete([]byte(id)); err != nil {
+				return err
+			}
+
+			for _, ancestor := range parentAncestors {
+				if err := putClosure(closureEntry{
+					AncestorID:   ancestor.AncestorID,
+					DescendantID: id,
+					Depth:        ancestor.Depth + depth + 1,
+				}); err != nil {
+					return err
+				}
+			}
+
+			if err := moveGroveNodeEntries(srcAggregatesBkt, aggregatesBkt, id); err != nil {
+				return err
+			}
+			if err := moveGroveNodeEntries(srcMutationsBkt, mutationsBkt, id); err != nil {
+				return err
+			}
+			moved = append(moved, n)
+		}
+
+		// The source tree sees the subtree disappear and the destination sees it appear, parents first
+		sort.Slice(moved, func(i, j int) bool {
+			if moved[i].Depth != moved[j].Depth {
+				return moved[i].Depth < moved[j].Depth
+			}
+			return moved[i].ID < moved[j].ID
+		})
+		for _, n := range moved {
+			if err := recordChange(tx, space, srcTree, store_interface.GroveChange{
+				Kind:   store_interface.GroveChangeDelete,
+				NodeID: store_interface.NodeID(n.ID),
+			}); err != nil {
+				return err
+			}
+		}
+		for _, n := range moved {
+			if err := recordChange(tx, space, dstTree, nodeChange(store_interface.GroveChangeCreate, n)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// moveGroveNodeEntries moves every "node:..." key from src to dst, replacing any
+// entries dst already holds for that node. A nil src has nothing to move.
+func moveGroveNodeEntries(src, dst *bbolt.Bucket, node string) error {
+	prefix := []byte(fmt.Sprintf("%s:", node))
+	collect := func(bkt *bbolt.Bucket) (keys, values [][]byte) {
+		c := bkt.Cursor()
+		for k, v := c.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, v = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+			values = append(values, append([]byte(nil), v...))
+		}
+		return keys, values
+	}
+
+	staleKeys, _ := collect(dst)
+	for _, k := range staleKeys {
+		if err := dst.Delete(k); err != nil {
+			return err
+		}
+	}
+	if src == nil {
+		return nil
+	}
+
+	keys, values := collect(src)
+	for i, k := range keys {
+		if err := dst.Put(k, values[i]); err != nil {
+			return err
+		}
+		if err := src.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopySubtree clones a node and its descendants under a new parent, possibly in another tree
+func (b *BoltStore) CopySubtree(
+	space store_interface.TenancySpace,
+	srcTree store_interface.TreeID,
+	srcNode store_interface.NodeID,
+	dstTree store_interface.TreeID,
+	dstParent *store_interface.NodeID,
+	opts store_interface.CopySubtreeOptions,
+) (map[store_interface.NodeID]store_interface.NodeID, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var mapping map[store_interface.NodeID]store_interface.NodeID
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		srcNodesBkt := tx.Bucket(groveNodesBucket(space, srcTree))
+		if srcNodesBkt == nil || srcNodesBkt.Get([]byte(srcNode)) == nil {
+			return store_interface.ErrNodeNotFound
+		}
+
+		// Collect everything to copy before writing, as source and destination may share buckets
+		var nodes []nodeData
+		relDepth := make(map[string]int)
+		if srcClosureBkt := tx.Bucket(groveClosureBucket(space, srcTree)); srcClosureBkt != nil {
+			c := srcClosureBkt.Cursor()
+			prefix := []byte(fmt.Sprintf("%s:", srcNode))
+			for k, v := c.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, v = c.Next() {
+				var entry closureEntry
+				if err := json.Unmarshal(v, &entry); err != nil {
+					return err
+				}
+				if entry.AncestorID != string(srcNode) {
+					continue
+				}
+				nodeBytes := srcNodesBkt.Get([]byte(entry.DescendantID))
+				if nodeBytes == nil {
+					continue
+				}
+				var n nodeData
+				if err := json.Unmarshal(nodeBytes, &n); err != nil {
+					return err
+				}
+				nodes = append(nodes, n)
+				relDepth[n.ID] = entry.Depth
+			}
+		}
+		if _, ok := relDepth[string(srcNode)]; !ok {
+			return store_interface.ErrNodeNotFound
+		}
+		sort.Slice(nodes, func(i, j int) bool {
+			if relDepth[nodes[i].ID] != relDepth[nodes[j].ID] {
+				return relDepth[nodes[i].ID] < relDepth[nodes[j].ID]
+			}
+			return nodes[i].ID < nodes[j].ID
+		})
+
+		aggregates := make(map[string]map[string][]byte)
+		if opts.CopyAggregates {
+			if srcAggBkt := tx.Bucket(groveAggregatesBucket(space, srcTree)); srcAggBkt != nil {
+				for _, n := range nodes {
+					c := srcAggBkt.Cursor()
+					prefix := []byte(fmt.Sprintf("%s:", n.ID))
+					for k, v := c.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, v = c.Next() {
+						if aggregates[n.ID] == nil {
+							aggregates[n.ID] = make(map[string][]byte)
+						}
+						aggregates[n.ID][string(k[len(prefix):])] = append([]byte(nil), v...)
+					}
+				}
+			}
+		}
+
+		nodesBkt, err := tx.CreateBucketIfNotExists(groveNodesBucket(space, dstTree))
+		if err != nil {
+			return err
+		}
+		closureBkt, err := tx.CreateBucketIfNotExists(groveClosureBucket(space, dstTree))
+		if err != nil {
+			return err
+		}
+
+		// Ancestors of the destination parent, which become ancestors of every copied node
+		rootDepth := 0
+		var parentAncestors []closureEntry
+		if dstParent != nil {
+			parentBytes := nodesBkt.Get([]byte(*dstParent))
+			if parentBytes == nil {
+				return store_interface.ErrNodeNotFound
+			}
+			var parentNode nodeData
+			if err := json.Unmarshal(parentBytes, &parentNode); err != nil {
+				return err
+			}
+			rootDepth = parentNode.Depth + 1
+
+			c := closureBkt.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				var entry closureEntry
+				if err := json.Unmarshal(v, &entry); err != nil {
+					continue
+				}
+				if entry.DescendantID == string(*dstParent) {
+					parentAncestors = append(parentAncestors, entry)
+				}
+			}
+		}
+
+		ids := make([]store_interface.NodeID, len(nodes))
+		for i, n := range nodes {
+			ids[i] = store_interface.NodeID(n.ID)
+		}
+		mapping, err = opts.MapSubtreeIDs(ids)
+		if err != nil {
+			return err
+		}
+
+		deletedBkt := tx.Bucket(groveDeletedBucket(space, dstTree))
+		for _, newID := range mapping {
+			if nodesBkt.Get([]byte(newID)) != nil {
+				return store_interface.ErrNodeAlreadyExists
+			}
+			if deletedBkt != nil && deletedBkt.Get([]byte(newID)) != nil {
+				return store_interface.ErrNodeAlreadyExists
+			}
+		}
+
+		putClosure := func(entry closureEntry) error {
+			entryBytes, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			return closureBkt.Put([]byte(fmt.Sprintf("%s:%s", entry.AncestorID, entry.DescendantID)), entryBytes)
+		}
+
+		var aggregatesBkt *bbolt.Bucket
+		if len(aggregates) > 0 {
+			aggregatesBkt, err = tx.CreateBucketIfNotExists(groveAggregatesBucket(space, dstTree))
+			if err != nil {
+				return err
+			}
+		}
+
+		parents := make(map[store_interface.NodeID]store_interface.NodeID, len(nodes))
+		for _, n := range nodes {
+			newID := string(mapping[store_interface.NodeID(n.ID)])
+			copied := nodeData{
+				ID:       newID,
+				Position: n.Position,
+				Depth:    rootDepth + relDepth[n.ID],
+				Metadata: n.Metadata,
+			}
+			if n.ID == string(srcNode) {
+				if dstParent != nil {
+					p := string(*dstParent)
+					copied.Parent = &p
+				}
+				if opts.Position != nil {
+					p := float64(*opts.Position)
+					copied.Position = &p
+				}
+			} else {
+				p := mapping[store_interface.NodeID(*n.Parent)]
+				parents[store_interface.NodeID(newID)] = p
+				ps := string(p)
+				copied.Parent = &ps
+			}
+
+			nodeBytes, err := json.Marshal(copied)
+			if err != nil {
+				return err
+			}
+			if err := nodesBkt.Put([]byte(newID), nodeBytes); err != nil {
+				return err
+			}
+
+			for _, ancestor := range parentAncestors {
+				if err := putClosure(closureEntry{
+					AncestorID:   ancestor.AncestorID,
+					DescendantID: newID,
+					Depth:        ancestor.Depth + relDepth[n.ID] + 1,
+				}); err != nil {
+					return err
+				}
+			}
+
+			for aggKey, value := range aggregates[n.ID] {
+				if err := aggregatesBkt.Put([]byte(fmt.Sprintf("%s:%s", newID, aggKey)), value); err != nil {
+					return err
+				}
+			}
+
+			if err := recordChange(tx, space, dstTree, nodeChange(store_interface.GroveChangeCreate, copied)); err != nil {
+				return err
+			}
+		}
+
+		for _, pair := range store_interface.SubtreeClosure(mapping[srcNode], parents) {
+			if err := putClosure(closureEntry{
+				AncestorID:   string(pair.Ancestor),
+				DescendantID: string(pair.Descendant),
+				Depth:        pair.Depth,
+			}); err != nil {
+				return err
+			}
+		}
+
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return mapping, nil
 }
 
 // Exists checks if a node exists
@@ -763,7 +1261,12 @@ func (b *BoltStore) ApplyAggregateMutation(
 			return err
 		}
 
-		return nil
+		return recordChange(tx, space, treeID, store_interface.GroveChange{
+			Kind:       store_interface.GroveChangeMutation,
+			NodeID:     node,
+			MutationID: &mutation,
+			Deltas:     deltas,
+		})
 	})
 }
 
@@ -37,16 +37,19 @@ func (s *BoltStore) MigrateToTenantBuckets(space store_interface.TenancySpace, b
 		}
 
 		fmt.Printf("VX: new bucket \n")
-		newBkt, err := tx.CreateBucketIfNotExists(
-			newTrackBucketName(space, bucketId),
-		)
+		newName := newTrackBucketName(space, bucketId)
+		newBkt, err := tx.CreateBucketIfNotExists(newName)
 		if err != nil {
 			return err
 		}
 
-		// copy all kv pairs
+		// copy all kv pairs, indexing their values as they land
 		err = oldBkt.ForEach(func(k, v []byte) error {
-			return newBkt.Put(k, v)
+			value, err := decodeTrackValue(v)
+			if err != nil {
+				return err
+			}
+			return putTrackValue(tx, newName, newBkt, k, value)
 		})
 		if err != nil {
 			return err
@@ -0,0 +1,154 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/vixac/bullet/model"
+	"github.com/vixac/bullet/store/store_interface"
+	"go.etcd.io/bbolt"
+)
+
+// One expiry index bucket covers every track bucket in the file, holding an empty entry
+// per expiring key, keyed by its expiry then the track bucket name and the key. The
+// sweeper reads the front of it rather than scanning every track bucket. putTrackValue and
+// deleteTrackValue keep it in step with the track buckets.
+var (
+	trackExpiryIndexBucket   = []byte("track:expiry-index:v1")
+	trackExpiryIndexBuiltKey = []byte("track_expiry_index")
+)
+
+// trackExpiryIndexKey orders entries by expiry in unix milliseconds, with the sign bit
+// flipped like trackValueIndexValue, then by track bucket and key. The bucket name is
+// length-prefixed so that the key can be split off again.
+func trackExpiryIndexKey(expiresAt time.Time, trackBucket, key []byte) []byte {
+	k := trackValueIndexValue(expiresAt.UnixMilli())
+	k = binary.BigEndian.AppendUint16(k, uint16(len(trackBucket)))
+	k = append(k, trackBucket...)
+	return append(k, key...)
+}
+
+// splitTrackExpiryIndexKey returns the expiry, track bucket name and key of an entry.
+func splitTrackExpiryIndexKey(k []byte) (time.Time, []byte, []byte) {
+	expiresAt := time.UnixMilli(int64(binary.BigEndian.Uint64(k) ^ (1 << 63)))
+	n := int(binary.BigEndian.Uint16(k[8:]))
+	return expiresAt, k[10 : 10+n], k[10+n:]
+}
+
+// updateTrackExpiryIndex moves the expiry index entry of key from its prior value to
+// value; either may be nil, for a new or a deleted key.
+func updateTrackExpiryIndex(tx *bbolt.Tx, trackBucket, key []byte, prior, value *model.TrackValue) error {
+	priorExpiry := prior != nil && prior.ExpiresAt != nil
+	expiry := value != nil && value.ExpiresAt != nil
+	if !priorExpiry && !expiry {
+		return nil
+	}
+	index, err := tx.CreateBucketIfNotExists(trackExpiryIndexBucket)
+	if err != nil {
+		return err
+	}
+	if priorExpiry {
+		if err := index.Delete(trackExpiryIndexKey(*prior.ExpiresAt, trackBucket, key)); err != nil {
+			return err
+		}
+	}
+	if expiry {
+		return index.Put(trackExpiryIndexKey(*value.ExpiresAt, trackBucket, key), nil)
+	}
+	return nil
+}
+
+// buildTrackExpiryIndex indexes the expiring keys of a file written before the expiry
+// index existed. It runs once, and records that it has in the schema bucket.
+func (b *BoltStore) buildTrackExpiryIndex() error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		sb, err := tx.CreateBucketIfNotExists(schemaBucket)
+		if err != nil {
+			return err
+		}
+		if sb.Get(trackExpiryIndexBuiltKey) != nil {
+			return nil
+		}
+		index, err := tx.CreateBucketIfNotExists(trackExpiryIndexBucket)
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(func(name []byte, bkt *bbolt.Bucket) error {
+			if !bytes.HasPrefix(name, []byte(trackBucketPrefix)) {
+				return nil
+			}
+			return bkt.ForEach(func(k, v []byte) error {
+				value, err := decodeTrackValue(v)
+				if err != nil {
+					return err
+				}
+				if value.ExpiresAt == nil {
+					return nil
+				}
+				return index.Put(trackExpiryIndexKey(*value.ExpiresAt, name, k), nil)
+			})
+		})
+		if err != nil {
+			return err
+		}
+		return sb.Put(trackExpiryIndexBuiltKey, []byte{1})
+	})
+}
+
+// TrackDeleteExpired reads the first limit entries of the expiry index that are due and
+// deletes their keys, so a sweep touches only the keys it removes and holds bolt's writer
+// lock briefly.
+func (b *BoltStore) TrackDeleteExpired(now time.Time, limit int) ([]store_interface.TrackExpiredKey, error) {
+	var deleted []store_interface.TrackExpiredKey
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		index := tx.Bucket(trackExpiryIndexBucket)
+		if index == nil {
+			return nil
+		}
+		var due [][]byte
+		c := index.Cursor()
+		for k, _ := c.First(); k != nil && len(due) < limit; k, _ = c.Next() {
+			if expiresAt, _, _ := splitTrackExpiryIndexKey(k); now.Before(expiresAt) {
+				break
+			}
+			due = append(due, append([]byte(nil), k...))
+		}
+
+		// Deleting while a cursor is walking the same bucket skips keys, so delete afterwards
+		for _, k := range due {
+			_, name, key := splitTrackExpiryIndexKey(k)
+			bkt := tx.Bucket(name)
+			if bkt == nil || bkt.Get(key) == nil {
+				// The key went without its entry; drop the entry so it is not read again
+				if err := index.Delete(k); err != nil {
+					return err
+				}
+				continue
+			}
+			value, err := decodeTrackValue(bkt.Get(key))
+			if err != nil {
+				return err
+			}
+			if !store_interface.TrackExpired(value, now) {
+				if err := index.Delete(k); err != nil {
+					return err
+				}
+				continue
+			}
+			space, bucketID, err := parseTrackBucketName(name)
+			if err != nil {
+				return err
+			}
+			if err := deleteTrackValue(tx, name, bkt, key); err != nil {
+				return err
+			}
+			deleted = append(deleted, store_interface.TrackExpiredKey{Space: space, BucketID: bucketID, Key: string(key)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
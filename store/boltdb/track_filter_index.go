@@ -0,0 +1,260 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vixac/bullet/model"
+	"github.com/vixac/bullet/store/store_interface"
+	"go.etcd.io/bbolt"
+)
+
+// With BoltOptions.TrackFilterIndexes, each track bucket also has a tag index and a
+// metric index bucket alongside it. The tag index holds one empty entry per tag in a
+// key's tag set, keyed by the tag then the key; the metric index one per key with a
+// metric, keyed by the metric then the key. Like the value index they change in the
+// transaction of the write, in putTrackValue and deleteTrackValue. Entries of expired
+// keys stay until the key is deleted, so reads check what they find against the track bucket.
+const (
+	trackTagIndexPrefix    = "track:tag-index:v1:"
+	trackMetricIndexPrefix = "track:metric-index:v1:"
+)
+
+// trackFilterIndexesKey marks, in the schema bucket, a file whose filter indexes are built.
+var trackFilterIndexesKey = []byte("track_filter_indexes")
+
+func getTrackTagIndexBucketName(trackBucket []byte) []byte {
+	return append([]byte(trackTagIndexPrefix), trackBucket[len(trackBucketPrefix):]...)
+}
+
+func getTrackMetricIndexBucketName(trackBucket []byte) []byte {
+	return append([]byte(trackMetricIndexPrefix), trackBucket[len(trackBucketPrefix):]...)
+}
+
+func trackTagIndexKey(tag int64, key []byte) []byte {
+	return append(trackValueIndexValue(tag), key...)
+}
+
+// trackMetricIndexValue orders the bytes like the floats: positive floats get the sign
+// bit set, and negative ones all their bits flipped. Negative zero is stored as zero,
+// which it equals.
+func trackMetricIndexValue(metric float64) []byte {
+	if metric == 0 {
+		metric = 0
+	}
+	bits := math.Float64bits(metric)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
+
+func trackMetricIndexKey(metric float64, key []byte) []byte {
+	return append(trackMetricIndexValue(metric), key...)
+}
+
+func trackFilterIndexesOn(tx *bbolt.Tx) bool {
+	sb := tx.Bucket(schemaBucket)
+	return sb != nil && sb.Get(trackFilterIndexesKey) != nil
+}
+
+// updateTrackFilterIndexes moves key's filter index entries from prior to value, either
+// of which is nil when the key is being created or deleted.
+func updateTrackFilterIndexes(tx *bbolt.Tx, trackBucket []byte, key []byte, prior, value *model.TrackValue) error {
+	if !trackFilterIndexesOn(tx) {
+		return nil
+	}
+	tagIndex, err := tx.CreateBucketIfNotExists(getTrackTagIndexBucketName(trackBucket))
+	if err != nil {
+		return err
+	}
+	metricIndex, err := tx.CreateBucketIfNotExists(getTrackMetricIndexBucketName(trackBucket))
+	if err != nil {
+		return err
+	}
+	if prior != nil {
+		for _, t := range store_interface.TrackTagSet(*prior) {
+			if err := tagIndex.Delete(trackTagIndexKey(t, key)); err != nil {
+				return err
+			}
+		}
+		if prior.Metric != nil {
+			if err := metricIndex.Delete(trackMetricIndexKey(*prior.Metric, key)); err != nil {
+				return err
+			}
+		}
+	}
+	if value != nil {
+		for _, t := range store_interface.TrackTagSet(*value) {
+			if err := tagIndex.Put(trackTagIndexKey(t, key), nil); err != nil {
+				return err
+			}
+		}
+		if value.Metric != nil {
+			if err := metricIndex.Put(trackMetricIndexKey(*value.Metric, key), nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DropTrackFilterIndexes drops the tag and metric indexes, and stops keeping them.
+func (b *BoltStore) DropTrackFilterIndexes() error {
+	return b.setTrackFilterIndexes(false)
+}
+
+// setTrackFilterIndexes builds the filter indexes of every track bucket and records that
+// writes keep them, or drops them all, in one transaction.
+func (b *BoltStore) setTrackFilterIndexes(on bool) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		sb, err := tx.CreateBucketIfNotExists(schemaBucket)
+		if err != nil {
+			return err
+		}
+		if trackFilterIndexesOn(tx) == on {
+			return nil
+		}
+
+		var names [][]byte
+		err = tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if bytes.HasPrefix(name, []byte(trackTagIndexPrefix)) || bytes.HasPrefix(name, []byte(trackMetricIndexPrefix)) ||
+				on && bytes.HasPrefix(name, []byte(trackBucketPrefix)) {
+				names = append(names, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !on {
+			for _, name := range names {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+			return sb.Delete(trackFilterIndexesKey)
+		}
+
+		if err := sb.Put(trackFilterIndexesKey, []byte{1}); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !bytes.HasPrefix(name, []byte(trackBucketPrefix)) {
+				// Left over from a crash mid-drop; rebuilt from the track bucket below
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+		}
+		for _, name := range names {
+			if !bytes.HasPrefix(name, []byte(trackBucketPrefix)) {
+				continue
+			}
+			err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+				value, err := decodeTrackValue(v)
+				if err != nil {
+					return err
+				}
+				return updateTrackFilterIndexes(tx, name, k, nil, &value)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// trackIndexedKeys plans a filtered read of the keys under prefixes. With tags it walks
+// the tag index under each prefix, for each tag or, when all of them must match, just
+// the first; with only a metric filter it walks the metric index across the filter's
+// range. It returns the candidate keys in ascending order without duplicates, or false
+// when the indexes are off or cannot serve the filters, as for a "ne" metric filter, and
+// the caller scans the track bucket instead. Candidates still have to pass the filters.
+func trackIndexedKeys(tx *bbolt.Tx, trackBucket []byte,
+	prefixes []string,
+	tags []int64, match store_interface.TrackTagMatch,
+	metric *store_interface.MetricFilter,
+) ([]string, bool) {
+
+	if !trackFilterIndexesOn(tx) {
+		return nil, false
+	}
+	var keys []string
+	switch {
+	case len(tags) > 0:
+		index := tx.Bucket(getTrackTagIndexBucketName(trackBucket))
+		if index == nil {
+			return nil, false
+		}
+		if match == store_interface.TrackTagMatchAll {
+			tags = tags[:1]
+		}
+		c := index.Cursor()
+		for _, t := range tags {
+			head := trackValueIndexValue(t)
+			for _, p := range prefixes {
+				start := append(head, p...)
+				for k, _ := c.Seek(start); k != nil && bytes.HasPrefix(k, start); k, _ = c.Next() {
+					keys = append(keys, string(k[len(head):]))
+				}
+			}
+		}
+
+	case metric != nil && metric.Operator != store_interface.MetricNe:
+		index := tx.Bucket(getTrackMetricIndexBucketName(trackBucket))
+		if index == nil {
+			return nil, false
+		}
+		// Walk between inclusive bounds; the filter itself drops the ends of exclusive ones
+		var lower, upper []byte
+		switch metric.Operator {
+		case store_interface.MetricGt, store_interface.MetricGte:
+			lower = trackMetricIndexValue(metric.Value)
+		case store_interface.MetricLt, store_interface.MetricLte:
+			upper = trackMetricIndexValue(metric.Value)
+		case store_interface.MetricEq:
+			lower, upper = trackMetricIndexValue(metric.Value), trackMetricIndexValue(metric.Value)
+		case store_interface.MetricBetween:
+			lower, upper = trackMetricIndexValue(metric.Value), trackMetricIndexValue(metric.Upper)
+		}
+		c := index.Cursor()
+		var k []byte
+		if lower == nil {
+			k, _ = c.First()
+		} else {
+			k, _ = c.Seek(lower)
+		}
+		for ; k != nil && (upper == nil || bytes.Compare(k[:8], upper) <= 0); k, _ = c.Next() {
+			key := string(k[8:])
+			if slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(key, p) }) {
+				keys = append(keys, key)
+			}
+		}
+
+	default:
+		return nil, false
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys), true
+}
+
+// matchTrackValue decodes v and reports whether it is live and passes the filters.
+func matchTrackValue(v []byte, now time.Time, tagFilter store_interface.TrackTagFilter, metric *store_interface.MetricFilter) (model.TrackValue, bool, error) {
+	value, err := decodeTrackValue(v)
+	if err != nil {
+		return model.TrackValue{}, false, err
+	}
+	if store_interface.TrackExpired(value, now) || !tagFilter.Matches(value) ||
+		metric != nil && !metric.Matches(value.Metric) {
+		return model.TrackValue{}, false, nil
+	}
+	return value, true, nil
+}
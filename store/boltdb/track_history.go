@@ -0,0 +1,215 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/vixac/bullet/model"
+	"github.com/vixac/bullet/store/store_interface"
+	"go.etcd.io/bbolt"
+)
+
+// History policies live in one bucket, keyed by the name of the history bucket they govern.
+var trackHistoryPolicyBucket = []byte("track:history-policy")
+
+const trackHistoryBucketPrefix = "track:history:v1:"
+
+func getTrackHistoryBucketName(space store_interface.TenancySpace, bucketID int32) []byte {
+	return []byte(fmt.Sprintf("%s%d:%d_bucket_%d", trackHistoryBucketPrefix, space.AppId, space.TenancyId, bucketID))
+}
+
+// History keys are the length-prefixed track key followed by a big-endian sequence
+// number, so one key's entries are contiguous and sorted oldest first.
+func trackHistoryKeyPrefix(key string) []byte {
+	out := make([]byte, 4, 4+len(key)+8)
+	binary.BigEndian.PutUint32(out, uint32(len(key)))
+	return append(out, key...)
+}
+
+// History values are the replaced-at time in unix milliseconds followed by the encoded track value.
+func encodeTrackHistoryEntry(v model.TrackValue, replacedAt time.Time) []byte {
+	out := binary.BigEndian.AppendUint64(nil, uint64(replacedAt.UnixMilli()))
+	return append(out, encodeTrackValue(v)...)
+}
+
+func decodeTrackHistoryEntry(b []byte) (model.TrackHistoryEntry, error) {
+	if len(b) < 8 {
+		return model.TrackHistoryEntry{}, fmt.Errorf("track history entry too short: %d bytes", len(b))
+	}
+	value, err := decodeTrackValue(b[8:])
+	if err != nil {
+		return model.TrackHistoryEntry{}, err
+	}
+	replacedAt := time.UnixMilli(int64(binary.BigEndian.Uint64(b))).UTC()
+	return store_interface.TrackHistoryEntry(value, replacedAt), nil
+}
+
+func encodeTrackHistoryPolicy(p store_interface.TrackHistoryPolicy) []byte {
+	out := binary.BigEndian.AppendUint64(nil, uint64(p.MaxVersions))
+	return binary.BigEndian.AppendUint64(out, uint64(p.MaxAge.Milliseconds()))
+}
+
+func decodeTrackHistoryPolicy(b []byte) store_interface.TrackHistoryPolicy {
+	if len(b) < 16 {
+		return store_interface.TrackHistoryPolicy{}
+	}
+	return store_interface.TrackHistoryPolicy{
+		MaxVersions: int(binary.BigEndian.Uint64(b)),
+		MaxAge:      time.Duration(binary.BigEndian.Uint64(b[8:])) * time.Millisecond,
+	}
+}
+
+func getTrackHistoryPolicy(tx *bbolt.Tx, historyBucket []byte) store_interface.TrackHistoryPolicy {
+	policies := tx.Bucket(trackHistoryPolicyBucket)
+	if policies == nil {
+		return store_interface.TrackHistoryPolicy{}
+	}
+	return decodeTrackHistoryPolicy(policies.Get(historyBucket))
+}
+
+// recordTrackHistory copies the live value under key into the bucket's history, when the
+// bucket keeps one. Writers call it inside their transaction before overwriting or deleting key.
+func recordTrackHistory(tx *bbolt.Tx, space store_interface.TenancySpace, bucketID int32, bkt *bbolt.Bucket, key string, now time.Time) error {
+	name := getTrackHistoryBucketName(space, bucketID)
+	if !getTrackHistoryPolicy(tx, name).Enabled() {
+		return nil
+	}
+	prior, live, err := getLiveTrackValue(bkt, key, now)
+	if err != nil || !live {
+		return err
+	}
+	hist, err := tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return err
+	}
+	seq, err := hist.NextSequence()
+	if err != nil {
+		return err
+	}
+	return hist.Put(binary.BigEndian.AppendUint64(trackHistoryKeyPrefix(key), seq), encodeTrackHistoryEntry(prior, now))
+}
+
+func (b *BoltStore) TrackSetHistoryPolicy(space store_interface.TenancySpace, bucketID int32, policy store_interface.TrackHistoryPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	name := getTrackHistoryBucketName(space, bucketID)
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		policies, err := tx.CreateBucketIfNotExists(trackHistoryPolicyBucket)
+		if err != nil {
+			return err
+		}
+		if policy.Enabled() {
+			return policies.Put(name, encodeTrackHistoryPolicy(policy))
+		}
+		if err := policies.Delete(name); err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket(name); err != nil && err != bbolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) TrackGetHistoryPolicy(space store_interface.TenancySpace, bucketID int32) (store_interface.TrackHistoryPolicy, error) {
+	var policy store_interface.TrackHistoryPolicy
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		policy = getTrackHistoryPolicy(tx, getTrackHistoryBucketName(space, bucketID))
+		return nil
+	})
+	return policy, err
+}
+
+func (b *BoltStore) TrackGetHistory(space store_interface.TenancySpace, bucketID int32, key string, limit int) ([]model.TrackHistoryEntry, error) {
+	name := getTrackHistoryBucketName(space, bucketID)
+	var policy store_interface.TrackHistoryPolicy
+	var entries []model.TrackHistoryEntry
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		policy = getTrackHistoryPolicy(tx, name)
+		hist := tx.Bucket(name)
+		if hist == nil {
+			return nil
+		}
+		prefix := trackHistoryKeyPrefix(key)
+		c := hist.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			entry, err := decodeTrackHistoryEntry(v)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The cursor walks oldest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return policy.Retained(entries, time.Now(), limit), nil
+}
+
+// TrackPruneHistory walks every history bucket one key at a time, and deletes up to limit
+// entries the bucket's policy no longer retains in one transaction.
+func (b *BoltStore) TrackPruneHistory(now time.Time, limit int) (int, error) {
+	pruned := 0
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		type staleEntry struct {
+			bucket []byte
+			key    []byte
+		}
+		var stale []staleEntry
+		err := tx.ForEach(func(name []byte, hist *bbolt.Bucket) error {
+			if !bytes.HasPrefix(name, []byte(trackHistoryBucketPrefix)) {
+				return nil
+			}
+			policy := getTrackHistoryPolicy(tx, name)
+
+			// Collect one track key's entries at a time, as retention counts from its newest
+			var keys [][]byte
+			var replaced []time.Time
+			flush := func() {
+				for i := 0; i < len(keys) && len(stale) < limit; i++ {
+					if !policy.Retains(len(keys)-1-i, replaced[i], now) {
+						stale = append(stale, staleEntry{bucket: append([]byte(nil), name...), key: keys[i]})
+					}
+				}
+				keys, replaced = keys[:0], replaced[:0]
+			}
+			var current []byte
+			c := hist.Cursor()
+			for k, v := c.First(); k != nil && len(stale) < limit; k, v = c.Next() {
+				if len(k) < 12 || len(v) < 8 {
+					continue
+				}
+				trackKey := k[:len(k)-8]
+				if !bytes.Equal(trackKey, current) {
+					flush()
+					current = append(current[:0], trackKey...)
+				}
+				keys = append(keys, append([]byte(nil), k...))
+				replaced = append(replaced, time.UnixMilli(int64(binary.BigEndian.Uint64(v))))
+			}
+			flush()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Deleting while a cursor is walking the same bucket skips keys, so delete afterwards
+		for _, e := range stale {
+			if err := tx.Bucket(e.bucket).Delete(e.key); err != nil {
+				return err
+			}
+		}
+		pruned = len(stale)
+		return nil
+	})
+	return pruned, err
+}
@@ -5,6 +5,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/store_interface"
@@ -21,39 +24,70 @@ func newTrackBucketName(space store_interface.TenancySpace, bucketID int32) []by
 func getTrackBucketName(space store_interface.TenancySpace, bucketID int32) []byte {
 	return newTrackBucketName(space, bucketID)
 }
-func encodeTrackValue(value int64, tag *int64, metric *float64) []byte {
+
+// parseTrackBucketName is the inverse of getTrackBucketName.
+func parseTrackBucketName(name []byte) (store_interface.TenancySpace, int32, error) {
+	var space store_interface.TenancySpace
+	var bucketID int32
+	if _, err := fmt.Sscanf(string(name), "track:v2:%d:%d_bucket_%d", &space.AppId, &space.TenancyId, &bucketID); err != nil {
+		return store_interface.TenancySpace{}, 0, fmt.Errorf("malformed track bucket name %q: %w", name, err)
+	}
+	return space, bucketID, nil
+}
+
+// Track values are the big-endian value, then flagged tag and metric, then an optional
+// flagged expiry in unix milliseconds, then an optional uvarint count of further tags and
+// the tags themselves. Values written before expiry existed end after the metric, and
+// values without further tags end after the expiry.
+func encodeTrackValue(v model.TrackValue) []byte {
 	buf := &bytes.Buffer{}
 
 	// Value
-	binary.Write(buf, binary.BigEndian, uint64(value))
+	binary.Write(buf, binary.BigEndian, uint64(v.Value))
 
 	// Tag
-	if tag != nil {
+	if v.Tag != nil {
 		buf.WriteByte(1)
-		binary.Write(buf, binary.BigEndian, uint64(*tag))
+		binary.Write(buf, binary.BigEndian, uint64(*v.Tag))
 	} else {
 		buf.WriteByte(0)
 	}
 
 	// Metric
-	if metric != nil {
+	if v.Metric != nil {
 		buf.WriteByte(1)
-		binary.Write(buf, binary.BigEndian, math.Float64bits(*metric))
+		binary.Write(buf, binary.BigEndian, math.Float64bits(*v.Metric))
 	} else {
 		buf.WriteByte(0)
 	}
 
+	// Expiry, whose flag must be written when tags follow
+	if v.ExpiresAt != nil {
+		buf.WriteByte(1)
+		binary.Write(buf, binary.BigEndian, uint64(v.ExpiresAt.UnixMilli()))
+	} else if len(v.Tags) > 0 {
+		buf.WriteByte(0)
+	}
+
+	// Further tags
+	if len(v.Tags) > 0 {
+		buf.Write(binary.AppendUvarint(nil, uint64(len(v.Tags))))
+		for _, t := range v.Tags {
+			binary.Write(buf, binary.BigEndian, uint64(t))
+		}
+	}
+
 	return buf.Bytes()
 }
 
-func decodeTrackValue(b []byte) (value int64, tag *int64, metric *float64, err error) {
+func decodeTrackValue(b []byte) (value model.TrackValue, err error) {
 	buf := bytes.NewReader(b)
 
 	var v uint64
 	if err = binary.Read(buf, binary.BigEndian, &v); err != nil {
 		return
 	}
-	value = int64(v)
+	value.Value = int64(v)
 
 	// Tag
 	flag, err := buf.ReadByte()
@@ -66,7 +100,7 @@ func decodeTrackValue(b []byte) (value int64, tag *int64, metric *float64, err e
 			return
 		}
 		tv2 := int64(tv)
-		tag = &tv2
+		value.Tag = &tv2
 	}
 
 	// Metric
@@ -80,20 +114,76 @@ func decodeTrackValue(b []byte) (value int64, tag *int64, metric *float64, err e
 			return
 		}
 		mv2 := math.Float64frombits(mv)
-		metric = &mv2
+		value.Metric = &mv2
+	}
+
+	// Expiry, absent from older values
+	if buf.Len() == 0 {
+		return
+	}
+	if flag, err = buf.ReadByte(); err != nil {
+		return
+	}
+	if flag == 1 {
+		var ev uint64
+		if err = binary.Read(buf, binary.BigEndian, &ev); err != nil {
+			return
+		}
+		expiresAt := time.UnixMilli(int64(ev)).UTC()
+		value.ExpiresAt = &expiresAt
+	}
+
+	// Further tags, absent from older values
+	if buf.Len() == 0 {
+		return
+	}
+	count, err := binary.ReadUvarint(buf)
+	if err != nil {
+		return
+	}
+	if count > uint64(buf.Len()/8) {
+		err = fmt.Errorf("track value claims %d tags in %d bytes", count, buf.Len())
+		return
+	}
+	value.Tags = make([]int64, count)
+	for i := range value.Tags {
+		var tv uint64
+		if err = binary.Read(buf, binary.BigEndian, &tv); err != nil {
+			return
+		}
+		value.Tags[i] = int64(tv)
 	}
 
 	return
 }
 
-func (b *BoltStore) TrackPut(space store_interface.TenancySpace, bucketID int32, key string, value int64, tag *int64, metric *float64) error {
+// getLiveTrackValue decodes the value under key, reporting expired or missing keys as not found.
+func getLiveTrackValue(bkt *bbolt.Bucket, key string, now time.Time) (model.TrackValue, bool, error) {
+	val := bkt.Get([]byte(key))
+	if val == nil {
+		return model.TrackValue{}, false, nil
+	}
+	value, err := decodeTrackValue(val)
+	if err != nil {
+		return model.TrackValue{}, false, err
+	}
+	if store_interface.TrackExpired(value, now) {
+		return model.TrackValue{}, false, nil
+	}
+	return value, true, nil
+}
+
+func (b *BoltStore) TrackPut(space store_interface.TenancySpace, bucketID int32, key string, value int64, tag *int64, metric *float64, expiresAt *time.Time) error {
+	name := getTrackBucketName(space, bucketID)
 	return b.db.Update(func(tx *bbolt.Tx) error {
-		bkt, err := tx.CreateBucketIfNotExists(getTrackBucketName(space, bucketID))
+		bkt, err := tx.CreateBucketIfNotExists(name)
 		if err != nil {
 			return err
 		}
-		val := encodeTrackValue(value, tag, metric)
-		return bkt.Put([]byte(key), val)
+		if err := recordTrackHistory(tx, space, bucketID, bkt, key, time.Now()); err != nil {
+			return err
+		}
+		return putTrackValue(tx, name, bkt, []byte(key), model.TrackValue{Value: value, Tag: tag, Metric: metric, ExpiresAt: expiresAt})
 	})
 }
 
@@ -106,17 +196,87 @@ func (b *BoltStore) TrackGet(space store_interface.TenancySpace, bucketID int32,
 		if bkt == nil {
 			return bbolt.ErrBucketNotFound
 		}
-		val := bkt.Get([]byte(key))
-		if val == nil {
+		val, found, err := getLiveTrackValue(bkt, key, time.Now())
+		if err != nil {
+			return err
+		}
+		if !found {
 			return fmt.Errorf("Track get boltstore key not found")
 		}
-		value = int64(binary.BigEndian.Uint64(val))
+		value = val.Value
+		return nil
+	})
+	return value, err
+}
+
+func (b *BoltStore) TrackGetValue(space store_interface.TenancySpace, bucketID int32, key string) (model.TrackValue, error) {
+	var value model.TrackValue
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(getTrackBucketName(space, bucketID))
+		if bkt == nil {
+			return store_interface.ErrTrackKeyNotFound
+		}
+		val, found, err := getLiveTrackValue(bkt, key, time.Now())
+		if err != nil {
+			return err
+		}
+		if !found {
+			return store_interface.ErrTrackKeyNotFound
+		}
+		value = val
 		return nil
 	})
 	return value, err
 }
 
+func (b *BoltStore) TrackIncrement(space store_interface.TenancySpace, bucketID int32, key string, delta int64) (model.TrackValue, error) {
+	var value model.TrackValue
+	name := getTrackBucketName(space, bucketID)
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(name)
+		if err != nil {
+			return err
+		}
+		// An expired entry starts again from zero, without its tag, metric or expiry
+		now := time.Now()
+		current, _, err := getLiveTrackValue(bkt, key, now)
+		if err != nil {
+			return err
+		}
+		if err := recordTrackHistory(tx, space, bucketID, bkt, key, now); err != nil {
+			return err
+		}
+		current.Value += delta
+		value = current
+		return putTrackValue(tx, name, bkt, []byte(key), current)
+	})
+	return value, err
+}
+
+func (b *BoltStore) TrackCompareAndSwap(space store_interface.TenancySpace, bucketID int32, key string, expected *int64, value int64, tag *int64, metric *float64) error {
+	name := getTrackBucketName(space, bucketID)
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(name)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		current, exists, err := getLiveTrackValue(bkt, key, now)
+		if err != nil {
+			return err
+		}
+		if expected == nil && exists || expected != nil && (!exists || current.Value != *expected) {
+			return store_interface.ErrTrackValueMismatch
+		}
+		if err := recordTrackHistory(tx, space, bucketID, bkt, key, now); err != nil {
+			return err
+		}
+		return putTrackValue(tx, name, bkt, []byte(key), model.TrackValue{Value: value, Tag: tag, Metric: metric})
+	})
+}
+
 func (b *BoltStore) TrackDeleteMany(space store_interface.TenancySpace, items []model.TrackBucketKeyPair) error {
+	now := time.Now()
 	return b.db.Update(func(tx *bbolt.Tx) error {
 		// Group deletions by bucket to avoid repeated lookups
 		buckets := make(map[int32]*bbolt.Bucket)
@@ -131,7 +291,10 @@ func (b *BoltStore) TrackDeleteMany(space store_interface.TenancySpace, items []
 				buckets[item.BucketID] = bkt
 			}
 
-			if err := bkt.Delete([]byte(item.Key)); err != nil {
+			if err := recordTrackHistory(tx, space, item.BucketID, bkt, item.Key, now); err != nil {
+				return err
+			}
+			if err := deleteTrackValue(tx, getTrackBucketName(space, item.BucketID), bkt, []byte(item.Key)); err != nil {
 				return err
 			}
 		}
@@ -140,21 +303,109 @@ func (b *BoltStore) TrackDeleteMany(space store_interface.TenancySpace, items []
 	})
 }
 
+// TrackDeleteByPrefix walks each prefix with a cursor, deleting matches as it goes.
+func (b *BoltStore) TrackDeleteByPrefix(
+	space store_interface.TenancySpace, bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+) (int, error) {
+
+	prefixes, err := store_interface.DisjointPrefixes(prefixes)
+	if err != nil {
+		return 0, err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return 0, err
+		}
+	}
+
+	tagFilter := store_interface.NewTrackTagFilter(tags, store_interface.TrackTagMatchAny)
+
+	deleted := 0
+	now := time.Now()
+	name := getTrackBucketName(space, bucketID)
+	err = b.db.Update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(name)
+		if bkt == nil {
+			return nil
+		}
+		// The candidates are read up front, so deleting does not disturb a cursor
+		if keys, ok := trackIndexedKeys(tx, name, prefixes, tags, store_interface.TrackTagMatchAny, metric); ok {
+			for _, k := range keys {
+				key := []byte(k)
+				v := bkt.Get(key)
+				if v == nil {
+					continue
+				}
+				value, err := decodeTrackValue(v)
+				if err != nil {
+					return err
+				}
+				if !tagFilter.Matches(value) ||
+					(metric != nil && !metric.Matches(value.Metric)) {
+					continue
+				}
+				if err := recordTrackHistory(tx, space, bucketID, bkt, k, now); err != nil {
+					return err
+				}
+				if err := deleteTrackValue(tx, name, bkt, key); err != nil {
+					return err
+				}
+				deleted++
+			}
+			return nil
+		}
+		c := bkt.Cursor()
+		for _, p := range prefixes {
+			prefix := []byte(p)
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); {
+				value, err := decodeTrackValue(v)
+				if err != nil {
+					return err
+				}
+				if !tagFilter.Matches(value) ||
+					(metric != nil && !metric.Matches(value.Metric)) {
+					k, v = c.Next()
+					continue
+				}
+				// Next after Delete skips a key, so seek back to where the deleted key was
+				key := append([]byte(nil), k...)
+				if err := recordTrackHistory(tx, space, bucketID, bkt, string(key), now); err != nil {
+					return err
+				}
+				if err := deleteTrackValue(tx, name, bkt, key); err != nil {
+					return err
+				}
+				deleted++
+				k, v = c.Seek(key)
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
 func (b *BoltStore) TrackClose() error {
 	return b.db.Close()
 }
 
 func (b *BoltStore) TrackPutMany(space store_interface.TenancySpace, items map[int32][]model.TrackKeyValueItem) error {
+	now := time.Now()
 	return b.db.Update(func(tx *bbolt.Tx) error {
 		for bucketID, arr := range items {
-			bkt, err := tx.CreateBucketIfNotExists([]byte(getTrackBucketName(space, bucketID)))
+			name := getTrackBucketName(space, bucketID)
+			bkt, err := tx.CreateBucketIfNotExists(name)
 			if err != nil {
 				return err
 			}
 
 			for _, it := range arr {
-				val := encodeTrackValue(it.Value.Value, it.Value.Tag, it.Value.Metric)
-				if err := bkt.Put([]byte(it.Key), val); err != nil {
+				if err := recordTrackHistory(tx, space, bucketID, bkt, it.Key, now); err != nil {
+					return err
+				}
+				if err := putTrackValue(tx, name, bkt, []byte(it.Key), it.Value); err != nil {
 					return err
 				}
 			}
@@ -163,6 +414,48 @@ func (b *BoltStore) TrackPutMany(space store_interface.TenancySpace, items map[i
 	})
 }
 
+func (b *BoltStore) TrackPutManyIf(space store_interface.TenancySpace, items map[int32][]model.TrackKeyValueItem, cond store_interface.TrackPutCondition) (model.TrackPutManyResult, error) {
+	if err := cond.Validate(); err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+
+	var result model.TrackPutManyResult
+	now := time.Now()
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		result = model.TrackPutManyResult{}
+		for _, bucketID := range store_interface.SortedTrackBuckets(items) {
+			name := getTrackBucketName(space, bucketID)
+			bkt, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+			for _, it := range items[bucketID] {
+				pair := model.TrackBucketKeyPair{BucketID: bucketID, Key: it.Key}
+				current, live, err := getLiveTrackValue(bkt, it.Key, now)
+				if err != nil {
+					return err
+				}
+				if !cond.Allows(current, live) {
+					result.Rejected = append(result.Rejected, pair)
+					continue
+				}
+				if err := recordTrackHistory(tx, space, bucketID, bkt, it.Key, now); err != nil {
+					return err
+				}
+				if err := putTrackValue(tx, name, bkt, []byte(it.Key), it.Value); err != nil {
+					return err
+				}
+				result.Written = append(result.Written, pair)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+	return result, nil
+}
+
 func (b *BoltStore) TrackGetMany(space store_interface.TenancySpace, keys map[int32][]string) (
 	map[int32]map[string]model.TrackValue,
 	map[int32][]string,
@@ -173,6 +466,7 @@ func (b *BoltStore) TrackGetMany(space store_interface.TenancySpace, keys map[in
 
 	found := make(map[int32]map[string]model.TrackValue)
 	missing := make(map[int32][]string)
+	now := time.Now()
 
 	err := b.db.View(func(tx *bbolt.Tx) error {
 		for bucketID, keyList := range keys {
@@ -184,27 +478,21 @@ func (b *BoltStore) TrackGetMany(space store_interface.TenancySpace, keys map[in
 			}
 
 			for _, key := range keyList {
-				val := bkt.Get([]byte(key))
-				if val == nil {
-					missing[bucketID] = append(missing[bucketID], key)
-					continue
-				}
-
-				v, tag, metric, err := decodeTrackValue(val)
+				val, ok, err := getLiveTrackValue(bkt, key, now)
 				if err != nil {
 					fmt.Printf("VX: err in boltstore: %s\n", err.Error())
 					return err
 				}
+				if !ok {
+					missing[bucketID] = append(missing[bucketID], key)
+					continue
+				}
 
 				if found[bucketID] == nil {
 					found[bucketID] = make(map[string]model.TrackValue)
 				}
 
-				found[bucketID][key] = model.TrackValue{
-					Value:  v,
-					Tag:    tag,
-					Metric: metric,
-				}
+				found[bucketID][key] = val
 			}
 		}
 		return nil
@@ -217,22 +505,25 @@ func (b *BoltStore) GetItemsByKeyPrefix(
 	space store_interface.TenancySpace, bucketID int32,
 	prefix string,
 	tags []int64,
-	metricValue *float64,
-	metricIsGt bool,
+	metric *store_interface.MetricFilter,
 ) ([]model.TrackKeyValueItem, error) {
-	return b.GetItemsByKeyPrefixes(space, bucketID, []string{prefix}, tags, metricValue, metricIsGt)
+	return b.GetItemsByKeyPrefixes(space, bucketID, []string{prefix}, tags, metric)
 }
 func (b *BoltStore) GetItemsByKeyPrefixes(
 	space store_interface.TenancySpace, bucketID int32,
 	prefixes []string,
 	tags []int64,
-	metricValue *float64,
-	metricIsGt bool,
+	metric *store_interface.MetricFilter,
 ) ([]model.TrackKeyValueItem, error) {
 
 	if len(prefixes) == 0 {
 		return nil, fmt.Errorf("must provide at least one prefix")
 	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, err
+		}
+	}
 
 	// Process prefixes - empty prefix means "match all" (useful for migration and bulk queries)
 	cleanPrefixes := make([][]byte, 0, len(prefixes))
@@ -250,66 +541,70 @@ func (b *BoltStore) GetItemsByKeyPrefixes(
 
 	var result []model.TrackKeyValueItem
 
-	tagFilter := func(tag *int64) bool {
-		if len(tags) == 0 {
-			return true
-		}
-		if tag == nil {
-			return false
-		}
-		for _, t := range tags {
-			if *tag == t {
-				return true
-			}
-		}
-		return false
-	}
+	tagFilter := store_interface.NewTrackTagFilter(tags, store_interface.TrackTagMatchAny)
 
-	metricFilter := func(metric *float64) bool {
-		if metricValue == nil {
-			return true
-		}
-		if metric == nil {
-			return false
-		}
-		if metricIsGt {
-			return *metric > *metricValue
-		}
-		return *metric < *metricValue
+	metricFilter := func(m *float64) bool {
+		return metric == nil || metric.Matches(m)
 	}
 
+	now := time.Now()
+	name := getTrackBucketName(space, bucketID)
 	err := b.db.View(func(tx *bbolt.Tx) error {
 
-		bkt := tx.Bucket(getTrackBucketName(space, bucketID))
+		bkt := tx.Bucket(name)
 		if bkt == nil {
 			return nil
 		}
 
+		indexPrefixes := []string{""}
+		if !matchAll {
+			indexPrefixes = indexPrefixes[:0]
+			for _, p := range cleanPrefixes {
+				indexPrefixes = append(indexPrefixes, string(p))
+			}
+		}
+		if keys, ok := trackIndexedKeys(tx, name, indexPrefixes, tags, store_interface.TrackTagMatchAny, metric); ok {
+			for _, k := range keys {
+				v := bkt.Get([]byte(k))
+				if v == nil {
+					continue
+				}
+				value, ok, err := matchTrackValue(v, now, tagFilter, metric)
+				if err != nil {
+					return err
+				}
+				if ok {
+					result = append(result, model.TrackKeyValueItem{Key: k, Value: value})
+				}
+			}
+			return nil
+		}
+
 		c := bkt.Cursor()
 
 		if matchAll {
 			// Scan entire bucket when empty prefix is provided
 			for k, v := c.First(); k != nil; k, v = c.Next() {
-				value, tag, metric, err := decodeTrackValue(v)
+				value, err := decodeTrackValue(v)
 				if err != nil {
 					return err
 				}
 
-				if !tagFilter(tag) {
+				if store_interface.TrackExpired(value, now) {
 					continue
 				}
 
-				if !metricFilter(metric) {
+				if !tagFilter.Matches(value) {
+					continue
+				}
+
+				if !metricFilter(value.Metric) {
 					continue
 				}
 
 				result = append(result, model.TrackKeyValueItem{
-					Key: string(k),
-					Value: model.TrackValue{
-						Value:  value,
-						Tag:    tag,
-						Metric: metric,
-					},
+					Key:   string(k),
+					Value: value,
 				})
 			}
 		} else {
@@ -317,26 +612,26 @@ func (b *BoltStore) GetItemsByKeyPrefixes(
 			for _, p := range cleanPrefixes {
 				for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
 
-					value, tag, metric, err := decodeTrackValue(v)
+					value, err := decodeTrackValue(v)
 					if err != nil {
 						return err
 					}
 
-					if !tagFilter(tag) {
+					if store_interface.TrackExpired(value, now) {
+						continue
+					}
+
+					if !tagFilter.Matches(value) {
 						continue
 					}
 
-					if !metricFilter(metric) {
+					if !metricFilter(value.Metric) {
 						continue
 					}
 
 					result = append(result, model.TrackKeyValueItem{
-						Key: string(k),
-						Value: model.TrackValue{
-							Value:  value,
-							Tag:    tag,
-							Metric: metric,
-						},
+						Key:   string(k),
+						Value: value,
 					})
 				}
 			}
@@ -347,3 +642,475 @@ func (b *BoltStore) GetItemsByKeyPrefixes(
 
 	return result, err
 }
+
+// GetItemsByKeyPrefixesPage walks each prefix range with a cursor in the requested
+// direction, reading at most one page (plus one) per prefix before merging.
+func (b *BoltStore) GetItemsByKeyPrefixesPage(
+	space store_interface.TenancySpace, bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+
+	if len(prefixes) == 0 {
+		return nil, "", fmt.Errorf("must provide at least one prefix")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// As in GetItemsByKeyPrefixes, an empty prefix only means "match all" on its own
+	var ranges [][]byte
+	for _, p := range prefixes {
+		if p != "" {
+			ranges = append(ranges, []byte(p))
+		}
+	}
+	if len(ranges) == 0 {
+		ranges = [][]byte{{}}
+	}
+
+	tagFilter := store_interface.NewTrackTagFilter(tags, opts.TagMatch)
+
+	// Key order reads a page per range; other orders have to see every match, keeping the best in a heap
+	fetch := opts.FetchLimit()
+	var top *store_interface.TrackTopK
+	if !opts.ByKey() {
+		fetch = 0
+		top = store_interface.NewTrackTopK(opts)
+	}
+	var candidates []model.TrackKeyValueItem
+	now := time.Now()
+	name := getTrackBucketName(space, bucketID)
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(name)
+		if bkt == nil {
+			return nil
+		}
+
+		indexPrefixes := make([]string, len(ranges))
+		for i, p := range ranges {
+			indexPrefixes[i] = string(p)
+		}
+		if keys, ok := trackIndexedKeys(tx, name, indexPrefixes, tags, opts.TagMatch, metric); ok {
+			var err error
+			candidates, err = indexedTrackItems(bkt, keys, now, tagFilter, metric, opts, top, fetch, nil)
+			return err
+		}
+
+		for _, p := range ranges {
+			c := bkt.Cursor()
+			var k, v []byte
+			var step func() ([]byte, []byte)
+			if opts.Descending {
+				// Position on the last key below both the end of the prefix range and the cursor
+				bound := prefixUpperBound(p)
+				if opts.StartAfter != "" && (bound == nil || bytes.Compare([]byte(opts.StartAfter), bound) < 0) {
+					bound = []byte(opts.StartAfter)
+				}
+				if bound == nil {
+					k, v = c.Last()
+				} else if k, _ = c.Seek(bound); k == nil {
+					k, v = c.Last()
+				} else {
+					k, v = c.Prev()
+				}
+				step = c.Prev
+			} else {
+				start := p
+				if opts.StartAfter != "" && bytes.Compare([]byte(opts.StartAfter), p) > 0 {
+					start = []byte(opts.StartAfter)
+				}
+				k, v = c.Seek(start)
+				step = c.Next
+			}
+
+			found := 0
+			for ; k != nil && bytes.HasPrefix(k, p); k, v = step() {
+				if !opts.After(string(k)) {
+					continue
+				}
+				value, err := decodeTrackValue(v)
+				if err != nil {
+					return err
+				}
+				if store_interface.TrackExpired(value, now) {
+					continue
+				}
+				if !tagFilter.Matches(value) {
+					continue
+				}
+				if metric != nil && !metric.Matches(value.Metric) {
+					continue
+				}
+				item := model.TrackKeyValueItem{Key: string(k), Value: value}
+				if top != nil {
+					top.Push(item)
+					continue
+				}
+				candidates = append(candidates, item)
+				found++
+				if fetch > 0 && found >= fetch {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if top != nil {
+		return top.Items(), "", nil
+	}
+	items, next := opts.PageItems(candidates)
+	return items, next, nil
+}
+
+// prefixUpperBound returns the smallest key greater than every key starting with
+// prefix, or nil if there is none.
+func prefixUpperBound(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xFF {
+			bound := append([]byte(nil), prefix[:i+1]...)
+			bound[i]++
+			return bound
+		}
+	}
+	return nil
+}
+
+// indexedTrackItems reads the candidates the filter indexes planned, in the query's
+// direction, keeping those after the cursor that are within inRange, when given, and
+// pass the filters. Like a cursor walk it pushes them to top when ordering by value or
+// metric, and otherwise stops at fetch.
+func indexedTrackItems(bkt *bbolt.Bucket, keys []string, now time.Time,
+	tagFilter store_interface.TrackTagFilter, metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions, top *store_interface.TrackTopK, fetch int,
+	inRange func(key string) bool,
+) ([]model.TrackKeyValueItem, error) {
+
+	if opts.Descending {
+		slices.Reverse(keys)
+	}
+	var items []model.TrackKeyValueItem
+	for _, k := range keys {
+		if !opts.After(k) || inRange != nil && !inRange(k) {
+			continue
+		}
+		v := bkt.Get([]byte(k))
+		if v == nil {
+			continue
+		}
+		value, ok, err := matchTrackValue(v, now, tagFilter, metric)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		item := model.TrackKeyValueItem{Key: k, Value: value}
+		if top != nil {
+			top.Push(item)
+			continue
+		}
+		items = append(items, item)
+		if fetch > 0 && len(items) >= fetch {
+			break
+		}
+	}
+	return items, nil
+}
+
+// GetItemsByKeyRange walks the range with a single cursor in the requested direction,
+// stopping once it leaves the range or has filled a page (plus one).
+func (b *BoltStore) GetItemsByKeyRange(
+	space store_interface.TenancySpace, bucketID int32,
+	keyRange store_interface.TrackKeyRange,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	if err := keyRange.Validate(); err != nil {
+		return nil, "", err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	tagFilter := store_interface.NewTrackTagFilter(tags, opts.TagMatch)
+
+	// Key order reads a page per range; other orders have to see every match, keeping the best in a heap
+	fetch := opts.FetchLimit()
+	var top *store_interface.TrackTopK
+	if !opts.ByKey() {
+		fetch = 0
+		top = store_interface.NewTrackTopK(opts)
+	}
+	var candidates []model.TrackKeyValueItem
+	now := time.Now()
+	name := getTrackBucketName(space, bucketID)
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(name)
+		if bkt == nil {
+			return nil
+		}
+
+		if keys, ok := trackIndexedKeys(tx, name, []string{""}, tags, opts.TagMatch, metric); ok {
+			var err error
+			candidates, err = indexedTrackItems(bkt, keys, now, tagFilter, metric, opts, top, fetch, keyRange.Contains)
+			return err
+		}
+
+		c := bkt.Cursor()
+		var k, v []byte
+		var step func() ([]byte, []byte)
+		var beyond func(key string) bool
+		if opts.Descending {
+			// Position on the last key at or below both the range end and the cursor
+			bound := keyRange.End
+			if opts.StartAfter != "" && (bound == "" || opts.StartAfter < bound) {
+				bound = opts.StartAfter
+			}
+			if bound == "" {
+				k, v = c.Last()
+			} else if k, v = c.Seek([]byte(bound)); k == nil {
+				k, v = c.Last()
+			} else if string(k) != bound {
+				k, v = c.Prev()
+			}
+			step = c.Prev
+			beyond = func(key string) bool { return keyRange.Start != "" && key < keyRange.Start }
+		} else {
+			start := keyRange.Start
+			if opts.StartAfter > start {
+				start = opts.StartAfter
+			}
+			k, v = c.Seek([]byte(start))
+			step = c.Next
+			beyond = func(key string) bool { return keyRange.End != "" && key > keyRange.End }
+		}
+
+		for ; k != nil && !beyond(string(k)); k, v = step() {
+			key := string(k)
+			if !keyRange.Contains(key) || !opts.After(key) {
+				continue
+			}
+			value, err := decodeTrackValue(v)
+			if err != nil {
+				return err
+			}
+			if store_interface.TrackExpired(value, now) {
+				continue
+			}
+			if !tagFilter.Matches(value) {
+				continue
+			}
+			if metric != nil && !metric.Matches(value.Metric) {
+				continue
+			}
+			item := model.TrackKeyValueItem{Key: key, Value: value}
+			if top != nil {
+				top.Push(item)
+				continue
+			}
+			candidates = append(candidates, item)
+			if fetch > 0 && len(candidates) >= fetch {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if top != nil {
+		return top.Items(), "", nil
+	}
+	items, next := opts.PageItems(candidates)
+	return items, next, nil
+}
+
+// ListTrackBuckets walks the bolt buckets named for space, so it costs one seek per
+// bucket plus however many expired keys precede the first live one.
+func (b *BoltStore) ListTrackBuckets(space store_interface.TenancySpace) ([]int32, error) {
+	prefix := []byte(fmt.Sprintf("track:v2:%d:%d_bucket_", space.AppId, space.TenancyId))
+	var out []int32
+	now := time.Now()
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Cursor()
+		for name, _ := c.Seek(prefix); name != nil && bytes.HasPrefix(name, prefix); name, _ = c.Next() {
+			bucketID, err := strconv.ParseInt(string(name[len(prefix):]), 10, 32)
+			if err != nil {
+				continue
+			}
+			live, err := hasLiveTrackKey(tx.Bucket(name), now)
+			if err != nil {
+				return err
+			}
+			if live {
+				out = append(out, int32(bucketID))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Bucket names sort as strings, so bucket 10 comes before bucket 2
+	slices.Sort(out)
+	return out, nil
+}
+
+func hasLiveTrackKey(bkt *bbolt.Bucket, now time.Time) (bool, error) {
+	c := bkt.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		value, err := decodeTrackValue(v)
+		if err != nil {
+			return false, err
+		}
+		if !store_interface.TrackExpired(value, now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b *BoltStore) TrackBucketStats(space store_interface.TenancySpace, bucketID int32) (model.TrackBucketStats, error) {
+	stats := store_interface.NewTrackBucketStatsBuilder(bucketID)
+	now := time.Now()
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(getTrackBucketName(space, bucketID))
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			value, err := decodeTrackValue(v)
+			if err != nil {
+				return err
+			}
+			if !store_interface.TrackExpired(value, now) {
+				stats.Add(string(k), value)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return model.TrackBucketStats{}, err
+	}
+	return stats.Stats()
+}
+
+// TrackScanBucket calls fn inside one read transaction, so the scan sees a consistent
+// snapshot however long fn takes.
+func (b *BoltStore) TrackScanBucket(space store_interface.TenancySpace, bucketID int32, fn func(model.TrackKeyValueItem) error) error {
+	now := time.Now()
+	return b.db.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(getTrackBucketName(space, bucketID))
+		if bkt == nil {
+			return nil
+		}
+		c := bkt.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			value, err := decodeTrackValue(v)
+			if err != nil {
+				return err
+			}
+			if store_interface.TrackExpired(value, now) {
+				continue
+			}
+			if err := fn(model.TrackKeyValueItem{Key: string(k), Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// TrackAggregate folds every matching item in one read transaction. Prefixes are made
+// disjoint first so overlapping ones are not counted twice.
+func (b *BoltStore) TrackAggregate(
+	space store_interface.TenancySpace, bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	groupByTag bool,
+) ([]model.TrackAggregateGroup, error) {
+
+	prefixes, err := store_interface.DisjointPrefixes(prefixes)
+	if err != nil {
+		return nil, err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	tagFilter := store_interface.NewTrackTagFilter(tags, store_interface.TrackTagMatchAny)
+
+	agg := store_interface.NewTrackAggregator(groupByTag)
+	now := time.Now()
+	name := getTrackBucketName(space, bucketID)
+	err = b.db.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(name)
+		if bkt == nil {
+			return nil
+		}
+		if keys, ok := trackIndexedKeys(tx, name, prefixes, tags, store_interface.TrackTagMatchAny, metric); ok {
+			for _, k := range keys {
+				v := bkt.Get([]byte(k))
+				if v == nil {
+					continue
+				}
+				value, ok, err := matchTrackValue(v, now, tagFilter, metric)
+				if err != nil {
+					return err
+				}
+				if ok {
+					agg.Add(value)
+				}
+			}
+			return nil
+		}
+		c := bkt.Cursor()
+		for _, p := range prefixes {
+			prefix := []byte(p)
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				value, err := decodeTrackValue(v)
+				if err != nil {
+					return err
+				}
+				if store_interface.TrackExpired(value, now) {
+					continue
+				}
+				if !tagFilter.Matches(value) {
+					continue
+				}
+				if metric != nil && !metric.Matches(value.Metric) {
+					continue
+				}
+				agg.Add(value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return agg.Groups(), nil
+}
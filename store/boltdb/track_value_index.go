@@ -0,0 +1,218 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/vixac/bullet/model"
+	"github.com/vixac/bullet/store/store_interface"
+	"go.etcd.io/bbolt"
+)
+
+// Each track bucket has a value index bucket alongside it, holding one empty entry per
+// key, keyed by the value then the key. Every write to a track bucket goes through
+// putTrackValue or deleteTrackValue, so the index changes in the same transaction.
+const (
+	trackBucketPrefix     = "track:v2:"
+	trackValueIndexPrefix = "track:value-index:v1:"
+)
+
+var trackValueIndexBuiltKey = []byte("track_value_index")
+
+func getTrackValueIndexBucketName(trackBucket []byte) []byte {
+	return append([]byte(trackValueIndexPrefix), trackBucket[len(trackBucketPrefix):]...)
+}
+
+// trackValueIndexValue flips the sign bit so the big-endian bytes sort like the signed values.
+func trackValueIndexValue(value int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(value)^(1<<63))
+}
+
+func trackValueIndexKey(value int64, key []byte) []byte {
+	return append(trackValueIndexValue(value), key...)
+}
+
+// putTrackValue writes value under key in the track bucket named trackBucket, moving its
+// value index entry, and any filter and expiry index entries, to match.
+func putTrackValue(tx *bbolt.Tx, trackBucket []byte, bkt *bbolt.Bucket, key []byte, value model.TrackValue) error {
+	index, err := tx.CreateBucketIfNotExists(getTrackValueIndexBucketName(trackBucket))
+	if err != nil {
+		return err
+	}
+	var prior *model.TrackValue
+	if old := bkt.Get(key); old != nil {
+		decoded, err := decodeTrackValue(old)
+		if err != nil {
+			return err
+		}
+		if err := index.Delete(trackValueIndexKey(decoded.Value, key)); err != nil {
+			return err
+		}
+		prior = &decoded
+	}
+	if err := updateTrackFilterIndexes(tx, trackBucket, key, prior, &value); err != nil {
+		return err
+	}
+	if err := updateTrackExpiryIndex(tx, trackBucket, key, prior, &value); err != nil {
+		return err
+	}
+	if err := bkt.Put(key, encodeTrackValue(value)); err != nil {
+		return err
+	}
+	return index.Put(trackValueIndexKey(value.Value, key), nil)
+}
+
+// deleteTrackValue deletes key from the track bucket named trackBucket, and its value,
+// filter and expiry index entries.
+func deleteTrackValue(tx *bbolt.Tx, trackBucket []byte, bkt *bbolt.Bucket, key []byte) error {
+	old := bkt.Get(key)
+	if old == nil {
+		return nil
+	}
+	prior, err := decodeTrackValue(old)
+	if err != nil {
+		return err
+	}
+	if index := tx.Bucket(getTrackValueIndexBucketName(trackBucket)); index != nil {
+		if err := index.Delete(trackValueIndexKey(prior.Value, key)); err != nil {
+			return err
+		}
+	}
+	if err := updateTrackFilterIndexes(tx, trackBucket, key, &prior, nil); err != nil {
+		return err
+	}
+	if err := updateTrackExpiryIndex(tx, trackBucket, key, &prior, nil); err != nil {
+		return err
+	}
+	return bkt.Delete(key)
+}
+
+// buildTrackValueIndexes indexes the track buckets of a file written before the value
+// index existed. It runs once, and records that it has in the schema bucket.
+func (b *BoltStore) buildTrackValueIndexes() error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		sb, err := tx.CreateBucketIfNotExists(schemaBucket)
+		if err != nil {
+			return err
+		}
+		if sb.Get(trackValueIndexBuiltKey) != nil {
+			return nil
+		}
+
+		var names [][]byte
+		err = tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if bytes.HasPrefix(name, []byte(trackBucketPrefix)) {
+				names = append(names, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			index, err := tx.CreateBucketIfNotExists(getTrackValueIndexBucketName(name))
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket(name).ForEach(func(k, v []byte) error {
+				value, err := decodeTrackValue(v)
+				if err != nil {
+					return err
+				}
+				return index.Put(trackValueIndexKey(value.Value, k), nil)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return sb.Put(trackValueIndexBuiltKey, []byte{1})
+	})
+}
+
+// GetKeysByValue walks the value index once per value, reading at most one page (plus
+// one) of index entries under the prefix before merging. Index entries of expired keys
+// are skipped against the track bucket.
+func (b *BoltStore) GetKeysByValue(
+	space store_interface.TenancySpace, bucketID int32,
+	values []int64,
+	prefix string,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+
+	if err := store_interface.ValidateKeysByValue(values, opts); err != nil {
+		return nil, "", err
+	}
+
+	name := getTrackBucketName(space, bucketID)
+	fetch := opts.FetchLimit()
+	var candidates []model.TrackKeyValueItem
+	now := time.Now()
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket(name)
+		index := tx.Bucket(getTrackValueIndexBucketName(name))
+		if bkt == nil || index == nil {
+			return nil
+		}
+
+		for _, value := range values {
+			head := trackValueIndexValue(value)
+			p := append(head, prefix...)
+			c := index.Cursor()
+			var k []byte
+			var step func() ([]byte, []byte)
+			if opts.Descending {
+				bound := prefixUpperBound(p)
+				if opts.StartAfter != "" {
+					if after := append(trackValueIndexValue(value), opts.StartAfter...); bound == nil || bytes.Compare(after, bound) < 0 {
+						bound = after
+					}
+				}
+				if bound == nil {
+					k, _ = c.Last()
+				} else if k, _ = c.Seek(bound); k == nil {
+					k, _ = c.Last()
+				} else {
+					k, _ = c.Prev()
+				}
+				step = c.Prev
+			} else {
+				start := p
+				if opts.StartAfter != "" {
+					if after := append(trackValueIndexValue(value), opts.StartAfter...); bytes.Compare(after, p) > 0 {
+						start = after
+					}
+				}
+				k, _ = c.Seek(start)
+				step = c.Next
+			}
+
+			found := 0
+			for ; k != nil && bytes.HasPrefix(k, p); k, _ = step() {
+				key := k[len(head):]
+				if !opts.After(string(key)) {
+					continue
+				}
+				current, live, err := getLiveTrackValue(bkt, string(key), now)
+				if err != nil {
+					return err
+				}
+				if !live || current.Value != value {
+					continue
+				}
+				candidates = append(candidates, model.TrackKeyValueItem{Key: string(key), Value: current})
+				found++
+				if fetch > 0 && found >= fetch {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	items, next := opts.PageItems(candidates)
+	return items, next, nil
+}
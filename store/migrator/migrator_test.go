@@ -24,15 +24,15 @@ func TestTrackMigrator(t *testing.T) {
 	metric2 := float64(2.5)
 
 	// Add some test items
-	err := source.TrackPut(testTenancy, bucketID, "key1", 100, &tag1, &metric1)
+	err := source.TrackPut(testTenancy, bucketID, "key1", 100, &tag1, &metric1, nil)
 	if err != nil {
 		t.Fatalf("Failed to put test data: %v", err)
 	}
-	err = source.TrackPut(testTenancy, bucketID, "key2", 200, &tag2, &metric2)
+	err = source.TrackPut(testTenancy, bucketID, "key2", 200, &tag2, &metric2, nil)
 	if err != nil {
 		t.Fatalf("Failed to put test data: %v", err)
 	}
-	err = source.TrackPut(testTenancy, bucketID, "key3", 300, nil, nil)
+	err = source.TrackPut(testTenancy, bucketID, "key3", 300, nil, nil, nil)
 	if err != nil {
 		t.Fatalf("Failed to put test data: %v", err)
 	}
@@ -76,7 +76,7 @@ func TestTrackMigrator(t *testing.T) {
 	}
 
 	// Verify with GetItemsByKeyPrefix to check tags and metrics
-	items, err := target.GetItemsByKeyPrefix(testTenancy, bucketID, "", []int64{}, nil, true)
+	items, err := target.GetItemsByKeyPrefix(testTenancy, bucketID, "", []int64{}, nil)
 	if err != nil {
 		t.Fatalf("Failed to get items by prefix: %v", err)
 	}
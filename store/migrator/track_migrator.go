@@ -17,7 +17,7 @@ type TrackMigrator struct {
 
 func (t *TrackMigrator) Migrate(bucketId int32) error {
 	// Fetch all items from the source bucket (empty prefix gets everything)
-	items, err := t.SourceTrack.GetItemsByKeyPrefix(t.Tenancy, bucketId, "", []int64{}, nil, true)
+	items, err := t.SourceTrack.GetItemsByKeyPrefix(t.Tenancy, bucketId, "", []int64{}, nil)
 	if err != nil {
 		return fmt.Errorf("failed to fetch items from source bucket %d: %w", bucketId, err)
 	}
@@ -0,0 +1,167 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vixac/bullet/model"
+	"github.com/vixac/bullet/store/store_interface"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Raw data up to depotInlineRawBytes is kept in the item's document. Anything larger,
+// which could pass mongo's 16MB document limit, is written first as a blob of
+// depotRawChunkBytes chunks in the depot chunk collection, which the item then points
+// at. Blobs are never changed: an update writes a new one and drops the old one once the
+// item has moved on, so a reader that finds a blob short has raced an update and reads
+// the item again. A failure between the two writes can leave a blob no item points at.
+const (
+	depotInlineRawBytes = 1 << 20
+	depotRawChunkBytes  = 1 << 20
+)
+
+// depotRawReadAttempts bounds how often DepotGetRaw reads an item again whose blob was
+// replaced while it was being read.
+const depotRawReadAttempts = 3
+
+type depotChunk struct {
+	BlobId primitive.ObjectID `bson:"blobId"`
+	N      int                `bson:"n"`
+	Data   []byte             `bson:"data"`
+}
+
+// writeDepotBlob stores data in chunks under a new blob ID.
+func (m *MongoStore) writeDepotBlob(data []byte) (primitive.ObjectID, error) {
+	blobId := primitive.NewObjectID()
+	var chunks []any
+	for n, off := 0, 0; off < len(data); n, off = n+1, off+depotRawChunkBytes {
+		chunks = append(chunks, depotChunk{BlobId: blobId, N: n, Data: data[off:min(off+depotRawChunkBytes, len(data))]})
+	}
+	if _, err := m.depotChunkCollection.InsertMany(context.TODO(), chunks); err != nil {
+		m.deleteDepotBlobs(&blobId)
+		return primitive.ObjectID{}, err
+	}
+	return blobId, nil
+}
+
+// readDepotBlob joins the chunks of a blob.
+func (m *MongoStore) readDepotBlob(blobId primitive.ObjectID) ([]byte, error) {
+	cur, err := m.depotChunkCollection.Find(context.TODO(), bson.M{"blobId": blobId}, options.Find().SetSort(bson.D{{Key: "n", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+
+	var data []byte
+	for cur.Next(context.TODO()) {
+		var chunk depotChunk
+		if err := cur.Decode(&chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk.Data...)
+	}
+	return data, cur.Err()
+}
+
+// deleteDepotBlobs drops the chunks of the blobs that are set. Callers that have already
+// moved an item off a blob ignore its error, since the blob is then only left behind.
+func (m *MongoStore) deleteDepotBlobs(blobIds ...*primitive.ObjectID) error {
+	ids := make([]primitive.ObjectID, 0, len(blobIds))
+	for _, id := range blobIds {
+		if id != nil {
+			ids = append(ids, *id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := m.depotChunkCollection.DeleteMany(context.TODO(), bson.M{"blobId": bson.M{"$in": ids}})
+	return err
+}
+
+// depotRawDocument is the document of a raw item holding data, writing a blob for the
+// data when it is too large to inline.
+func (m *MongoStore) depotRawDocument(data []byte, contentType string) (depotDocument, error) {
+	doc := depotDocument{ContentType: store_interface.DepotRawContentType(contentType)}
+	if len(data) <= depotInlineRawBytes {
+		doc.Data = data
+		return doc, nil
+	}
+	blobId, err := m.writeDepotBlob(data)
+	if err != nil {
+		return depotDocument{}, err
+	}
+	doc.BlobId, doc.Size = &blobId, int64(len(data))
+	return doc, nil
+}
+
+func (m *MongoStore) DepotCreateRaw(space store_interface.TenancySpace, bucketID int32, data []byte, contentType string) (int64, int64, error) {
+	doc, err := m.depotRawDocument(data, contentType)
+	if err != nil {
+		return 0, 0, err
+	}
+	ids, err := m.insertDepotDocuments(space, bucketID, []depotDocument{doc})
+	if err != nil {
+		m.deleteDepotBlobs(doc.BlobId)
+		return 0, 0, err
+	}
+	return ids[0], store_interface.DepotInitialRevision, nil
+}
+
+func (m *MongoStore) DepotUpdateRaw(space store_interface.TenancySpace, id int64, data []byte, contentType string) (int64, error) {
+	return m.updateDepotRaw(space, id, data, contentType, 0)
+}
+
+func (m *MongoStore) DepotUpdateRawIfRevision(space store_interface.TenancySpace, id int64, data []byte, contentType string, revision int64) (int64, error) {
+	return m.updateDepotRaw(space, id, data, contentType, revision)
+}
+
+func (m *MongoStore) updateDepotRaw(space store_interface.TenancySpace, id int64, data []byte, contentType string, revision int64) (int64, error) {
+	doc, err := m.depotRawDocument(data, contentType)
+	if err != nil {
+		return 0, err
+	}
+	set := bson.M{"value": "", "contentType": doc.ContentType}
+	unset := bson.M{"doc": ""}
+	if doc.BlobId != nil {
+		set["blobId"], set["size"] = doc.BlobId, doc.Size
+		unset["data"] = ""
+	} else {
+		set["data"] = doc.Data
+		unset["blobId"], unset["size"] = "", ""
+	}
+	updated, err := m.updateDepotDocument(space, id, revision, bson.M{"$set": set, "$unset": unset})
+	if err != nil {
+		m.deleteDepotBlobs(doc.BlobId)
+		return 0, err
+	}
+	return updated, nil
+}
+
+func (m *MongoStore) DepotGetRaw(space store_interface.TenancySpace, id int64) (model.DepotRawItem, error) {
+	for attempt := 0; attempt < depotRawReadAttempts; attempt++ {
+		var doc depotDocument
+		err := m.depotCollection.FindOne(context.TODO(), depotFilter(space, id), options.FindOne().SetProjection(bson.M{"doc": 0})).Decode(&doc)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return model.DepotRawItem{}, store_interface.ErrDepotItemNotFound
+		}
+		if err != nil {
+			return model.DepotRawItem{}, err
+		}
+		if doc.BlobId == nil {
+			return store_interface.DepotRawFromItem(doc.item(), doc.Data), nil
+		}
+		data, err := m.readDepotBlob(*doc.BlobId)
+		if err != nil {
+			return model.DepotRawItem{}, err
+		}
+		if int64(len(data)) == doc.Size {
+			return store_interface.DepotRawFromItem(doc.item(), data), nil
+		}
+	}
+	return model.DepotRawItem{}, fmt.Errorf("depot item %d kept changing while its data was read", id)
+}
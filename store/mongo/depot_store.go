@@ -1,126 +1,381 @@
 package mongodb
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+
+	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/store_interface"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func (m *MongoStore) DepotCreate(space store_interface.TenancySpace, bucketID int32, value string) (int64, error) {
-	return 0, nil
-}
-func (m *MongoStore) DepotCreateMany(space store_interface.TenancySpace, bucketID int32, values []string) ([]int64, error) {
-	return []int64{}, nil
+// depotDocument is an item of the depot collection. IDs are handed out per space from
+// the depot counter collection. Values that are JSON objects are also stored decoded in
+// Doc, which queries filter on. Raw items have a content type and keep their data
+// inline or, past depotInlineRawBytes, in the chunks of a blob; see depot_raw.go.
+type depotDocument struct {
+	AppId       int32               `bson:"appId"`
+	TenancyId   int64               `bson:"tenancyId"`
+	BucketId    int32               `bson:"bucketId"`
+	ID          int64               `bson:"id"`
+	Value       string              `bson:"value"`
+	Doc         map[string]any      `bson:"doc,omitempty"`
+	Revision    int64               `bson:"revision"`
+	ContentType string              `bson:"contentType,omitempty"`
+	Data        []byte              `bson:"data,omitempty"`
+	BlobId      *primitive.ObjectID `bson:"blobId,omitempty"`
+	Size        int64               `bson:"size,omitempty"`
 }
 
-func (m *MongoStore) DepotUpdate(space store_interface.TenancySpace, id int64, value string) error {
-	return nil
+// depotItemProjection leaves out what items are not read with.
+var depotItemProjection = bson.M{"doc": 0, "data": 0}
 
+// depotValueDoc decodes value for the doc field, or returns nil when it is not a JSON object.
+func depotValueDoc(value string) map[string]any {
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(value), &doc); err != nil {
+		return nil
+	}
+	return doc
 }
 
-func (m *MongoStore) DepotGet(space store_interface.TenancySpace, id int64) (string, error) {
-	return "", nil
-}
-func (m *MongoStore) DepotGetMany(space store_interface.TenancySpace, ids []int64) (map[int64]string, []int64, error) {
-	return map[int64]string{}, []int64{}, nil
+// depotValueUpdate is the update that stores value, with its doc, in place of any raw data.
+func depotValueUpdate(value string) bson.M {
+	set := bson.M{"value": value}
+	unset := bson.M{"contentType": "", "data": "", "blobId": "", "size": ""}
+	if doc := depotValueDoc(value); doc != nil {
+		set["doc"] = doc
+	} else {
+		unset["doc"] = ""
+	}
+	return bson.M{"$set": set, "$unset": unset}
 }
 
-func (m *MongoStore) DepotDelete(space store_interface.TenancySpace, id int64) error {
-	return nil
+func (d depotDocument) item() model.DepotItem {
+	return model.DepotItem{ID: d.ID, Value: d.Value, Revision: d.Revision, ContentType: d.ContentType}
 }
-func (m *MongoStore) DepotDeleteByBucket(space store_interface.TenancySpace, bucketID int32) error {
-	return nil
 
+func depotFilter(space store_interface.TenancySpace, id int64) bson.M {
+	return bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "id": id}
 }
-func (m *MongoStore) DepotGetAllByBucket(space store_interface.TenancySpace, bucketID int32) (map[int64]string, error) {
 
-	return map[int64]string{}, nil
+// nextDepotIDs reserves n IDs in the space and returns the first of them.
+func (m *MongoStore) nextDepotIDs(space store_interface.TenancySpace, n int) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := m.depotCounterCollection.FindOneAndUpdate(context.TODO(),
+		bson.M{"appId": space.AppId, "tenancyId": space.TenancyId},
+		bson.M{"$inc": bson.M{"seq": int64(n)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Seq - int64(n) + 1, nil
 }
 
-/*
-func (m *MongoStore) DepotPut(space store_interface.TenancySpace, key int64, value string) error {
-	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "key": key}
-	update := bson.M{"$set": bson.M{"value": value}}
-	opts := options.Update().SetUpsert(true)
-
-	_, err := m.depotCollection.UpdateOne(context.TODO(), filter, update, opts)
-	return err
+func (m *MongoStore) DepotCreate(space store_interface.TenancySpace, bucketID int32, value string) (int64, int64, error) {
+	ids, err := m.DepotCreateMany(space, bucketID, []string{value})
+	if err != nil {
+		return 0, 0, err
+	}
+	return ids[0], store_interface.DepotInitialRevision, nil
 }
-func (b *MongoStore) DepotGetAll(space store_interface.TenancySpace) (map[int64]string, error) {
-	x := make(map[int64]string)
-	return x, errors.New("Not implmented")
+func (m *MongoStore) DepotCreateMany(space store_interface.TenancySpace, bucketID int32, values []string) ([]int64, error) {
+	docs := make([]depotDocument, len(values))
+	for i, v := range values {
+		docs[i] = depotDocument{Value: v, Doc: depotValueDoc(v)}
+	}
+	return m.insertDepotDocuments(space, bucketID, docs)
 }
 
-func (m *MongoStore) DepotGet(space store_interface.TenancySpace, key int64) (string, error) {
-	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "key": key}
-
-	var result struct {
-		Value string `bson:"value"`
+// insertDepotDocuments gives docs the next IDs of the space and inserts them into the
+// bucket at the initial revision.
+func (m *MongoStore) insertDepotDocuments(space store_interface.TenancySpace, bucketID int32, docs []depotDocument) ([]int64, error) {
+	if len(docs) == 0 {
+		return []int64{}, nil
 	}
-
-	err := m.depotCollection.FindOne(context.TODO(), filter).Decode(&result)
-	if err == mongo.ErrNoDocuments {
-		return "", fmt.Errorf("not found")
+	first, err := m.nextDepotIDs(space, len(docs))
+	if err != nil {
+		return nil, err
 	}
-	return result.Value, err
+	ids := make([]int64, len(docs))
+	inserts := make([]any, len(docs))
+	for i, doc := range docs {
+		ids[i] = first + int64(i)
+		doc.AppId, doc.TenancyId, doc.BucketId = space.AppId, space.TenancyId, bucketID
+		doc.ID, doc.Revision = ids[i], store_interface.DepotInitialRevision
+		inserts[i] = doc
+	}
+	if _, err := m.depotCollection.InsertMany(context.TODO(), inserts); err != nil {
+		return nil, err
+	}
+	return ids, nil
 }
 
-func (m *MongoStore) DepotDelete(space store_interface.TenancySpace, key int64) error {
-	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "key": key}
-	_, err := m.depotCollection.DeleteOne(context.TODO(), filter)
+func (m *MongoStore) DepotUpdate(space store_interface.TenancySpace, id int64, value string) error {
+	_, err := m.updateDepotDocument(space, id, 0, depotValueUpdate(value))
 	return err
 }
 
-func (m *MongoStore) DepotPutMany(space store_interface.TenancySpace, items []model.DepotKeyValueItem) error {
-	var ops []mongo.WriteModel
+func (m *MongoStore) DepotUpdateIfRevision(space store_interface.TenancySpace, id int64, value string, revision int64) (int64, error) {
+	return m.updateDepotDocument(space, id, revision, depotValueUpdate(value))
+}
 
-	for _, item := range items {
-		filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "key": item.Key}
-		update := bson.M{"$set": bson.M{"value": item.Value}}
-		ops = append(ops, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+// updateDepotDocument applies update to the item and bumps its revision, which it
+// returns, then drops the blob the item had. A non-zero revision is part of the filter;
+// when nothing matches, the item is read again to tell a missing item from a conflict.
+func (m *MongoStore) updateDepotDocument(space store_interface.TenancySpace, id int64, revision int64, update bson.M) (int64, error) {
+	filter := depotFilter(space, id)
+	if revision != 0 {
+		filter["revision"] = revision
+	}
+	update["$inc"] = bson.M{"revision": int64(1)}
+	var prior depotDocument
+	err := m.depotCollection.FindOneAndUpdate(context.TODO(), filter, update, options.FindOneAndUpdate().SetProjection(bson.M{"revision": 1, "blobId": 1})).Decode(&prior)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if revision == 0 {
+			return 0, store_interface.ErrDepotItemNotFound
+		}
+		current, err := m.DepotGetItem(space, id)
+		if err != nil {
+			return 0, err
+		}
+		return 0, &store_interface.DepotRevisionConflictError{ID: id, Expected: revision, Current: current.Revision}
 	}
+	if err != nil {
+		return 0, err
+	}
+	// The update stands either way; a blob that fails to go is only left behind
+	m.deleteDepotBlobs(prior.BlobId)
+	return prior.Revision + 1, nil
+}
 
-	if len(ops) == 0 {
+func (m *MongoStore) DepotGet(space store_interface.TenancySpace, id int64) (string, error) {
+	var doc depotDocument
+	err := m.depotCollection.FindOne(context.TODO(), depotFilter(space, id), options.FindOne().SetProjection(depotItemProjection)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", store_interface.ErrDepotItemNotFound
+	}
+	return doc.Value, err
+}
+func (m *MongoStore) DepotGetItem(space store_interface.TenancySpace, id int64) (model.DepotItem, error) {
+	var doc depotDocument
+	err := m.depotCollection.FindOne(context.TODO(), depotFilter(space, id), options.FindOne().SetProjection(depotItemProjection)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.DepotItem{}, store_interface.ErrDepotItemNotFound
+	}
+	if err != nil {
+		return model.DepotItem{}, err
+	}
+	return doc.item(), nil
+}
+func (m *MongoStore) DepotGetMany(space store_interface.TenancySpace, ids []int64) (map[int64]string, []int64, error) {
+	found := make(map[int64]string)
+	var missing []int64
+	if len(ids) == 0 {
+		return found, missing, nil
+	}
+	docs, err := m.findDepotDocuments(bson.M{
+		"appId":     space.AppId,
+		"tenancyId": space.TenancyId,
+		"id":        bson.M{"$in": ids},
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, doc := range docs {
+		found[doc.ID] = doc.Value
+	}
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	return found, missing, nil
+}
+
+func (m *MongoStore) DepotDelete(space store_interface.TenancySpace, id int64) error {
+	var prior depotDocument
+	err := m.depotCollection.FindOneAndDelete(context.TODO(), depotFilter(space, id), options.FindOneAndDelete().SetProjection(bson.M{"blobId": 1})).Decode(&prior)
+	if errors.Is(err, mongo.ErrNoDocuments) {
 		return nil
 	}
+	if err != nil {
+		return err
+	}
+	m.deleteDepotBlobs(prior.BlobId)
+	return nil
+}
 
-	_, err := m.depotCollection.BulkWrite(context.TODO(), ops, options.BulkWrite().SetOrdered(false))
-	return err
+// DepotDeleteByBucket reads the blobs of the bucket's items before deleting them, then
+// drops those blobs. Like every blob drop, that is best effort once the items are gone.
+func (m *MongoStore) DepotDeleteByBucket(space store_interface.TenancySpace, bucketID int32) error {
+	filter := bson.M{
+		"appId":     space.AppId,
+		"tenancyId": space.TenancyId,
+		"bucketId":  bucketID,
+	}
+	blobFilter := maps.Clone(filter)
+	blobFilter["blobId"] = bson.M{"$exists": true}
+	docs, err := m.findDepotDocuments(blobFilter, options.Find().SetProjection(bson.M{"blobId": 1}))
+	if err != nil {
+		return err
+	}
+	if _, err := m.depotCollection.DeleteMany(context.TODO(), filter); err != nil {
+		return err
+	}
+	blobs := make([]*primitive.ObjectID, 0, len(docs))
+	for _, doc := range docs {
+		blobs = append(blobs, doc.BlobId)
+	}
+	m.deleteDepotBlobs(blobs...)
+	return nil
+}
+func (m *MongoStore) DepotGetAllByBucket(space store_interface.TenancySpace, bucketID int32) (map[int64]string, error) {
+	docs, err := m.findDepotDocuments(bson.M{
+		"appId":     space.AppId,
+		"tenancyId": space.TenancyId,
+		"bucketId":  bucketID,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int64]string, len(docs))
+	for _, doc := range docs {
+		result[doc.ID] = doc.Value
+	}
+	return result, nil
 }
 
-func (m *MongoStore) DepotGetMany(space store_interface.TenancySpace, keys []int64) (map[int64]string, []int64, error) {
+// DepotGetBucketPage reads the page in ID order from the bucket index, one item past the
+// page to know whether another follows.
+func (m *MongoStore) DepotGetBucketPage(space store_interface.TenancySpace, bucketID int32, opts store_interface.DepotPageOptions) ([]model.DepotItem, int64, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, 0, err
+	}
 	filter := bson.M{
 		"appId":     space.AppId,
 		"tenancyId": space.TenancyId,
-		"key":       bson.M{"$in": keys},
+		"bucketId":  bucketID,
 	}
+	return m.findDepotPage(filter, opts)
+}
 
-	cur, err := m.depotCollection.Find(context.TODO(), filter)
+// findDepotPage narrows filter to the IDs after the page cursor and reads the page.
+func (m *MongoStore) findDepotPage(filter bson.M, opts store_interface.DepotPageOptions) ([]model.DepotItem, int64, error) {
+	order := 1
+	if opts.Descending {
+		order = -1
+	}
+	if opts.StartAfter != 0 {
+		if opts.Descending {
+			filter["id"] = bson.M{"$lt": opts.StartAfter}
+		} else {
+			filter["id"] = bson.M{"$gt": opts.StartAfter}
+		}
+	}
+	findOpts := options.Find().SetSort(bson.D{{Key: "id", Value: order}})
+	if fetch := opts.FetchLimit(); fetch > 0 {
+		findOpts.SetLimit(int64(fetch))
+	}
+	docs, err := m.findDepotDocuments(filter, findOpts)
 	if err != nil {
-		return nil, nil, err
+		return nil, 0, err
+	}
+	items := make([]model.DepotItem, 0, len(docs))
+	for _, doc := range docs {
+		items = append(items, doc.item())
+	}
+	page, next := opts.PageItems(items)
+	return page, next, nil
+}
+
+// findDepotDocuments reads the documents matching filter, by default without their doc
+// and data.
+func (m *MongoStore) findDepotDocuments(filter bson.M, opts *options.FindOptions) ([]depotDocument, error) {
+	if opts == nil {
+		opts = options.Find()
+	}
+	if opts.Projection == nil {
+		opts.SetProjection(depotItemProjection)
+	}
+	cur, err := m.depotCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
 	}
 	defer cur.Close(context.TODO())
 
-	results := make(map[int64]string)
-	foundKeys := make(map[int64]bool)
+	var docs []depotDocument
+	if err := cur.All(context.TODO(), &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
 
-	for cur.Next(context.TODO()) {
-		var doc struct {
-			Key   int64  `bson:"key"`
-			Value string `bson:"value"`
-		}
-		if err := cur.Decode(&doc); err != nil {
-			return nil, nil, err
+// DepotQuery filters on the doc field natively. Mongo looks into arrays along a path,
+// where the other backends do not match, so every filter also requires that the fields
+// along its path are not arrays.
+func (m *MongoStore) DepotQuery(space store_interface.TenancySpace, bucketID int32, filters []store_interface.DepotFieldFilter, opts store_interface.DepotPageOptions) ([]model.DepotItem, int64, error) {
+	if err := store_interface.ValidateDepotQuery(filters, opts); err != nil {
+		return nil, 0, err
+	}
+	filter := bson.M{
+		"appId":     space.AppId,
+		"tenancyId": space.TenancyId,
+		"bucketId":  bucketID,
+	}
+	var conditions []bson.M
+	for _, f := range filters {
+		c, err := depotFilterConditions(f)
+		if err != nil {
+			return nil, 0, err
 		}
-		results[doc.Key] = doc.Value
-		foundKeys[doc.Key] = true
+		conditions = append(conditions, c...)
 	}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+	return m.findDepotPage(filter, opts)
+}
 
-	var missing []int64
-	for _, k := range keys {
-		if !foundKeys[k] {
-			missing = append(missing, k)
+func depotFilterConditions(f store_interface.DepotFieldFilter) ([]bson.M, error) {
+	segments := f.PathSegments()
+	var conditions []bson.M
+	path := "doc"
+	for i, segment := range segments {
+		path += "." + segment
+		if i < len(segments)-1 || f.Op != store_interface.DepotFilterExists {
+			conditions = append(conditions, bson.M{path: bson.M{"$not": bson.M{"$type": "array"}}})
 		}
 	}
 
-	return results, missing, nil
+	var cond bson.M
+	switch f.Op {
+	case store_interface.DepotFilterExists:
+		cond = bson.M{"$exists": true}
+	case store_interface.DepotFilterIn:
+		values := make([]any, 0, len(f.Values))
+		for _, v := range f.Values {
+			operand, _, err := store_interface.DepotOperand(v)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, operand)
+		}
+		cond = bson.M{"$in": values}
+	default:
+		operand, _, err := store_interface.DepotOperand(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		cond = bson.M{"$" + string(f.Op): operand}
+	}
+	return append(conditions, bson.M{path: cond}), nil
 }
-*/
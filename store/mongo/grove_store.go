@@ -63,3 +63,27 @@ func (m *MongoStore) GetNodeLocalAggregatesBulk(space store_interface.TenancySpa
 func (m *MongoStore) GetNodeWithDescendantsAggregatesBulk(space store_interface.TenancySpace, treeID store_interface.TreeID, nodes []store_interface.NodeID) (map[store_interface.NodeID]map[store_interface.AggregateKey]store_interface.AggregateValue, []store_interface.NodeID, error) {
 	return nil, nil, ErrGroveNotImplemented
 }
+
+func (m *MongoStore) CopySubtree(space store_interface.TenancySpace, srcTree store_interface.TreeID, srcNode store_interface.NodeID, dstTree store_interface.TreeID, dstParent *store_interface.NodeID, opts store_interface.CopySubtreeOptions) (map[store_interface.NodeID]store_interface.NodeID, error) {
+	return nil, ErrGroveNotImplemented
+}
+
+func (m *MongoStore) MoveSubtreeToTree(space store_interface.TenancySpace, srcTree store_interface.TreeID, node store_interface.NodeID, dstTree store_interface.TreeID, dstParent *store_interface.NodeID, position *store_interface.ChildPosition) error {
+	return ErrGroveNotImplemented
+}
+
+func (m *MongoStore) VerifyTree(space store_interface.TenancySpace, treeID store_interface.TreeID) (*store_interface.TreeIntegrityReport, error) {
+	return nil, ErrGroveNotImplemented
+}
+
+func (m *MongoStore) RepairTree(space store_interface.TenancySpace, treeID store_interface.TreeID) (*store_interface.TreeIntegrityReport, error) {
+	return nil, ErrGroveNotImplemented
+}
+
+func (m *MongoStore) UpdateNodeMetadata(space store_interface.TenancySpace, treeID store_interface.TreeID, node store_interface.NodeID, metadata store_interface.NodeMetadata) error {
+	return ErrGroveNotImplemented
+}
+
+func (m *MongoStore) GetChanges(space store_interface.TenancySpace, treeID store_interface.TreeID, since int64, limit int) ([]store_interface.GroveChange, error) {
+	return nil, ErrGroveNotImplemented
+}
@@ -2,6 +2,7 @@ package mongodb
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
@@ -10,10 +11,28 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// trackExpiryBackstop is how long after expiry mongo's TTL monitor removes a track document.
+const trackExpiryBackstop = time.Hour
+
+// indexOptionsConflict is the server's error code for an index that exists with other options.
+const indexOptionsConflict = 85
+
+// Server error codes for dropping an index of a missing collection, or a missing index.
+const (
+	namespaceNotFound = 26
+	indexNotFound     = 27
+)
+
 type MongoStore struct {
 	client          *mongo.Client
 	trackCollection *mongo.Collection
 	depotCollection *mongo.Collection
+
+	depotCounterCollection *mongo.Collection
+	depotChunkCollection   *mongo.Collection
+
+	trackHistoryPolicyCollection *mongo.Collection
+	trackHistoryCollection       *mongo.Collection
 }
 
 func NewMongoStore(uri string) (*MongoStore, error) {
@@ -38,6 +57,12 @@ func NewMongoStore(uri string) (*MongoStore, error) {
 		client:          client,
 		trackCollection: database.Collection("bucket"),
 		depotCollection: database.Collection("depot"),
+
+		depotCounterCollection: database.Collection("depot_counter"),
+		depotChunkCollection:   database.Collection("depot_chunk"),
+
+		trackHistoryPolicyCollection: database.Collection("track_history_policy"),
+		trackHistoryCollection:       database.Collection("track_history"),
 	}
 
 	//bucket index
@@ -74,12 +99,107 @@ func NewMongoStore(uri string) (*MongoStore, error) {
 		log.Fatalf("Failed to create unique index: %v", err)
 	}
 
-	depotModel := mongo.IndexModel{
+	// Reverse lookups of keys by value, in key order
+	valueIndex := mongo.IndexModel{
 		Keys: bson.D{
 			{Key: "appId", Value: 1},
 			{Key: "tenancyId", Value: 1},
+			{Key: "bucketId", Value: 1},
+			{Key: "value", Value: 1},
 			{Key: "key", Value: 1},
 		},
+	}
+	_, err = store.trackCollection.Indexes().CreateOne(context.TODO(), valueIndex, opts)
+	if err != nil {
+		println("Creating track value index failed.")
+		return nil, err
+	}
+
+	// Tag filters on further tags, through a multikey index on the tags array
+	tagsIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "appId", Value: 1},
+			{Key: "tenancyId", Value: 1},
+			{Key: "bucketId", Value: 1},
+			{Key: "tags", Value: 1},
+		},
+	}
+	_, err = store.trackCollection.Indexes().CreateOne(context.TODO(), tagsIndex, opts)
+	if err != nil {
+		println("Creating track tags index failed.")
+		return nil, err
+	}
+
+	// TTL index: mongo removes track documents trackExpiryBackstop after expiresAt has
+	// passed. The sweeper normally deletes them first, and so publishes their deletes to
+	// watches; the TTL monitor only catches what it misses, such as with the sweeper off.
+	expiryIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(trackExpiryBackstop.Seconds())),
+	}
+	_, err = store.trackCollection.Indexes().CreateOne(context.TODO(), expiryIndex, opts)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(indexOptionsConflict) {
+		// Collections indexed before the backstop existed expire documents immediately
+		err = database.RunCommand(context.TODO(), bson.D{
+			{Key: "collMod", Value: store.trackCollection.Name()},
+			{Key: "index", Value: bson.D{
+				{Key: "keyPattern", Value: bson.D{{Key: "expiresAt", Value: 1}}},
+				{Key: "expireAfterSeconds", Value: int32(trackExpiryBackstop.Seconds())},
+			}},
+		}).Err()
+	}
+	if err != nil {
+		println("Creating track expiry index failed.")
+		return nil, err
+	}
+
+	historyPolicyIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "appId", Value: 1},
+			{Key: "tenancyId", Value: 1},
+			{Key: "bucketId", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err = store.trackHistoryPolicyCollection.Indexes().CreateOne(context.TODO(), historyPolicyIndex, opts)
+	if err != nil {
+		println("Creating track history policy index failed.")
+		return nil, err
+	}
+
+	historyIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "appId", Value: 1},
+			{Key: "tenancyId", Value: 1},
+			{Key: "bucketId", Value: 1},
+			{Key: "key", Value: 1},
+			{Key: "_id", Value: -1},
+		},
+	}
+	_, err = store.trackHistoryCollection.Indexes().CreateOne(context.TODO(), historyIndex, opts)
+	if err != nil {
+		println("Creating track history index failed.")
+		return nil, err
+	}
+
+	// The depot was keyed by "key" before it had IDs. Items without a key would all
+	// collide on that unique index, so drop it if it is still there.
+	_, err = store.depotCollection.Indexes().DropOne(context.TODO(), "appId_1_tenancyId_1_key_1")
+	if errors.As(err, &serverErr) && (serverErr.HasErrorCode(namespaceNotFound) || serverErr.HasErrorCode(indexNotFound)) {
+		err = nil
+	}
+	if err != nil {
+		println("Dropping the old depot index failed.")
+		return nil, err
+	}
+
+	depotModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "appId", Value: 1},
+			{Key: "tenancyId", Value: 1},
+			{Key: "id", Value: 1},
+		},
 		Options: options.Index().SetUnique(true),
 	}
 	_, err = store.depotCollection.Indexes().CreateOne(context.TODO(), depotModel, opts)
@@ -88,6 +208,47 @@ func NewMongoStore(uri string) (*MongoStore, error) {
 		return nil, err
 	}
 
+	// Bucket listings and pages, in ID order
+	depotBucketIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "appId", Value: 1},
+			{Key: "tenancyId", Value: 1},
+			{Key: "bucketId", Value: 1},
+			{Key: "id", Value: 1},
+		},
+	}
+	_, err = store.depotCollection.Indexes().CreateOne(context.TODO(), depotBucketIndex, opts)
+	if err != nil {
+		println("Creating depot bucket index failed.")
+		return nil, err
+	}
+
+	depotCounterIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "appId", Value: 1},
+			{Key: "tenancyId", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err = store.depotCounterCollection.Indexes().CreateOne(context.TODO(), depotCounterIndex, opts)
+	if err != nil {
+		println("Creating depot counter index failed.")
+		return nil, err
+	}
+
+	depotChunkIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "blobId", Value: 1},
+			{Key: "n", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err = store.depotChunkCollection.Indexes().CreateOne(context.TODO(), depotChunkIndex, opts)
+	if err != nil {
+		println("Creating depot chunk index failed.")
+		return nil, err
+	}
+
 	println("Mongo connection complete.")
 	return &store, nil
 }
@@ -0,0 +1,202 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vixac/bullet/model"
+	"github.com/vixac/bullet/store/store_interface"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type trackHistoryPolicyDoc struct {
+	AppId       int32 `bson:"appId"`
+	TenancyId   int64 `bson:"tenancyId"`
+	BucketId    int32 `bson:"bucketId"`
+	MaxVersions int   `bson:"maxVersions"`
+	MaxAgeMs    int64 `bson:"maxAgeMs"`
+}
+
+func (d trackHistoryPolicyDoc) policy() store_interface.TrackHistoryPolicy {
+	return store_interface.TrackHistoryPolicy{
+		MaxVersions: d.MaxVersions,
+		MaxAge:      time.Duration(d.MaxAgeMs) * time.Millisecond,
+	}
+}
+
+type trackHistoryDoc struct {
+	ID         any        `bson:"_id,omitempty"`
+	AppId      int32      `bson:"appId"`
+	TenancyId  int64      `bson:"tenancyId"`
+	BucketId   int32      `bson:"bucketId"`
+	Key        string     `bson:"key"`
+	Value      int64      `bson:"value"`
+	Tag        *int64     `bson:"tag,omitempty"`
+	Tags       []int64    `bson:"tags,omitempty"`
+	Metric     *float64   `bson:"metric,omitempty"`
+	ExpiresAt  *time.Time `bson:"expiresAt,omitempty"`
+	ReplacedAt time.Time  `bson:"replacedAt"`
+}
+
+// snapshotTrackHistory reads the live documents matching filter that sit in buckets
+// keeping history, as history documents replaced now. Mongo has no triggers, so writers
+// snapshot before writing and pass the result to recordTrackHistory once the write
+// succeeds; a concurrent write between the two can go unrecorded.
+func (m *MongoStore) snapshotTrackHistory(space store_interface.TenancySpace, filter bson.M) ([]any, error) {
+	buckets, err := m.trackHistoryPolicyCollection.Distinct(context.TODO(), "bucketId",
+		bson.M{"appId": space.AppId, "tenancyId": space.TenancyId})
+	if err != nil || len(buckets) == 0 {
+		return nil, err
+	}
+
+	// withLive adds to the filter it is given, and the caller still needs filter for its write
+	live := withLive(bson.M{"bucketId": bson.M{"$in": buckets}})
+	cursor, err := m.trackCollection.Find(context.TODO(), bson.M{"$and": []bson.M{filter, live}})
+	if err != nil {
+		return nil, err
+	}
+	var docs []trackHistoryDoc
+	if err := cursor.All(context.TODO(), &docs); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	out := make([]any, 0, len(docs))
+	for _, d := range docs {
+		d.ID = nil
+		d.ReplacedAt = now
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+func (m *MongoStore) recordTrackHistory(docs []any) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	_, err := m.trackHistoryCollection.InsertMany(context.TODO(), docs)
+	return err
+}
+
+func (m *MongoStore) TrackSetHistoryPolicy(space store_interface.TenancySpace, bucketID int32, policy store_interface.TrackHistoryPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID}
+
+	if policy.Enabled() {
+		update := bson.M{"$set": bson.M{"maxVersions": policy.MaxVersions, "maxAgeMs": policy.MaxAge.Milliseconds()}}
+		_, err := m.trackHistoryPolicyCollection.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+		return err
+	}
+
+	if _, err := m.trackHistoryPolicyCollection.DeleteOne(context.TODO(), filter); err != nil {
+		return err
+	}
+	_, err := m.trackHistoryCollection.DeleteMany(context.TODO(), filter)
+	return err
+}
+
+func (m *MongoStore) TrackGetHistoryPolicy(space store_interface.TenancySpace, bucketID int32) (store_interface.TrackHistoryPolicy, error) {
+	var doc trackHistoryPolicyDoc
+	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID}
+	err := m.trackHistoryPolicyCollection.FindOne(context.TODO(), filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return store_interface.TrackHistoryPolicy{}, nil
+	}
+	if err != nil {
+		return store_interface.TrackHistoryPolicy{}, err
+	}
+	return doc.policy(), nil
+}
+
+func (m *MongoStore) TrackGetHistory(space store_interface.TenancySpace, bucketID int32, key string, limit int) ([]model.TrackHistoryEntry, error) {
+	policy, err := m.TrackGetHistoryPolicy(space, bucketID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retained entries are always the newest, so the limit can go in the query
+	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID, "key": key}
+	findOpts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	if limit > 0 {
+		findOpts.SetLimit(int64(limit))
+	}
+	cursor, err := m.trackHistoryCollection.Find(context.TODO(), filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []trackHistoryDoc
+	if err := cursor.All(context.TODO(), &docs); err != nil {
+		return nil, err
+	}
+
+	entries := make([]model.TrackHistoryEntry, 0, len(docs))
+	for _, d := range docs {
+		value := model.TrackValue{Value: d.Value, Tag: d.Tag, Tags: d.Tags, Metric: d.Metric, ExpiresAt: d.ExpiresAt}
+		entries = append(entries, store_interface.TrackHistoryEntry(value, d.ReplacedAt))
+	}
+	return policy.Retained(entries, time.Now(), limit), nil
+}
+
+// TrackPruneHistory walks each policy's history one key at a time, newest first, and
+// deletes up to limit entries the policy no longer retains.
+func (m *MongoStore) TrackPruneHistory(now time.Time, limit int) (int, error) {
+	cursor, err := m.trackHistoryPolicyCollection.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	var policies []trackHistoryPolicyDoc
+	if err := cursor.All(context.TODO(), &policies); err != nil {
+		return 0, err
+	}
+
+	var stale bson.A
+	for _, p := range policies {
+		if len(stale) >= limit {
+			break
+		}
+		filter := bson.M{"appId": p.AppId, "tenancyId": p.TenancyId, "bucketId": p.BucketId}
+		findOpts := options.Find().
+			SetSort(bson.D{{Key: "key", Value: 1}, {Key: "_id", Value: -1}}).
+			SetProjection(bson.M{"key": 1, "replacedAt": 1})
+		cursor, err := m.trackHistoryCollection.Find(context.TODO(), filter, findOpts)
+		if err != nil {
+			return 0, err
+		}
+
+		policy := p.policy()
+		currentKey, position := "", 0
+		for len(stale) < limit && cursor.Next(context.TODO()) {
+			var doc trackHistoryDoc
+			if err := cursor.Decode(&doc); err != nil {
+				cursor.Close(context.TODO())
+				return 0, err
+			}
+			if doc.Key != currentKey {
+				currentKey, position = doc.Key, 0
+			}
+			if !policy.Retains(position, doc.ReplacedAt, now) {
+				stale = append(stale, doc.ID)
+			}
+			position++
+		}
+		err = cursor.Err()
+		cursor.Close(context.TODO())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(stale) == 0 {
+		return 0, nil
+	}
+	res, err := m.trackHistoryCollection.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": stale}})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
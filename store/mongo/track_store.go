@@ -2,11 +2,15 @@ package mongodb
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/store_interface"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -32,11 +36,17 @@ func (m *MongoStore) TrackDeleteMany(space store_interface.TenancySpace, items [
 		"$or": orFilters,
 	}
 
-	_, err := m.trackCollection.DeleteMany(context.TODO(), filter)
-	return err
+	history, err := m.snapshotTrackHistory(space, filter)
+	if err != nil {
+		return err
+	}
+	if _, err := m.trackCollection.DeleteMany(context.TODO(), filter); err != nil {
+		return err
+	}
+	return m.recordTrackHistory(history)
 }
 
-func (m *MongoStore) TrackPut(space store_interface.TenancySpace, bucketID int32, key string, value int64, tag *int64, metric *float64) error {
+func (m *MongoStore) TrackPut(space store_interface.TenancySpace, bucketID int32, key string, value int64, tag *int64, metric *float64, expiresAt *time.Time) error {
 	filter := bson.M{
 		"appId":     space.AppId,
 		"tenancyId": space.TenancyId,
@@ -57,25 +67,246 @@ func (m *MongoStore) TrackPut(space store_interface.TenancySpace, bucketID int32
 		updateFields["metric"] = *metric
 	}
 
+	// A single tag replaces any further tags
+	unset := bson.M{"tags": ""}
 	update := bson.M{
-		"$set": updateFields,
+		"$set":   updateFields,
+		"$unset": unset,
 	}
 
-	_, err := m.trackCollection.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
-	return err
+	if expiresAt != nil {
+		updateFields["expiresAt"] = *expiresAt
+	} else {
+		unset["expiresAt"] = ""
+	}
+
+	history, err := m.snapshotTrackHistory(space, filter)
+	if err != nil {
+		return err
+	}
+	if _, err := m.trackCollection.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true)); err != nil {
+		return err
+	}
+	return m.recordTrackHistory(history)
+}
+
+// mongoLiveFilter matches documents that have not expired; a missing expiresAt matches null.
+// Mongo's TTL monitor removes expired documents only periodically, so reads still need it.
+func mongoLiveFilter(now time.Time) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"expiresAt": nil},
+		bson.M{"expiresAt": bson.M{"$gt": now}},
+	}}
+}
+
+// withLive ANDs mongoLiveFilter into filter, alongside any $and clauses already there.
+func withLive(filter bson.M) bson.M {
+	clauses, _ := filter["$and"].([]bson.M)
+	filter["$and"] = append(clauses, mongoLiveFilter(time.Now()))
+	return filter
 }
 
 func (m *MongoStore) TrackGet(space store_interface.TenancySpace, bucketID int32, key string) (int64, error) {
 	var result struct{ Value int64 }
 	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID, "key": key}
-	err := m.trackCollection.FindOne(context.TODO(), filter).Decode(&result)
+	err := m.trackCollection.FindOne(context.TODO(), withLive(filter)).Decode(&result)
 	return result.Value, err
 }
 
+func (m *MongoStore) TrackGetValue(space store_interface.TenancySpace, bucketID int32, key string) (model.TrackValue, error) {
+	var result model.TrackValue
+	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID, "key": key}
+	err := m.trackCollection.FindOne(context.TODO(), withLive(filter)).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.TrackValue{}, store_interface.ErrTrackKeyNotFound
+	}
+	return result, err
+}
+
+func (m *MongoStore) TrackIncrement(space store_interface.TenancySpace, bucketID int32, key string, delta int64) (model.TrackValue, error) {
+	var result model.TrackValue
+	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID, "key": key}
+	// A pipeline update, so an expired document can start again from zero without its
+	// tag, metric or expiry; fields set to $$REMOVE are dropped
+	live := bson.M{"$or": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$expiresAt", nil}}, nil}},
+		bson.M{"$gt": bson.A{"$expiresAt", time.Now()}},
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"value":     bson.M{"$cond": bson.A{live, bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$value", 0}}, delta}}, delta}},
+		"tag":       bson.M{"$cond": bson.A{live, "$tag", "$$REMOVE"}},
+		"tags":      bson.M{"$cond": bson.A{live, "$tags", "$$REMOVE"}},
+		"metric":    bson.M{"$cond": bson.A{live, "$metric", "$$REMOVE"}},
+		"expiresAt": bson.M{"$cond": bson.A{live, "$expiresAt", "$$REMOVE"}},
+	}}}}
+	history, err := m.snapshotTrackHistory(space, filter)
+	if err != nil {
+		return model.TrackValue{}, err
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := m.trackCollection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&result); err != nil {
+		return model.TrackValue{}, err
+	}
+	return result, m.recordTrackHistory(history)
+}
+
+func (m *MongoStore) TrackCompareAndSwap(space store_interface.TenancySpace, bucketID int32, key string, expected *int64, value int64, tag *int64, metric *float64) error {
+	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID, "key": key}
+
+	fields := bson.M{"value": value}
+	unset := bson.M{"tags": ""}
+	if tag != nil {
+		fields["tag"] = *tag
+	} else {
+		unset["tag"] = ""
+	}
+	if metric != nil {
+		fields["metric"] = *metric
+	} else {
+		unset["metric"] = ""
+	}
+
+	// The new entry never expires
+	unset["expiresAt"] = ""
+	update := bson.M{"$set": fields, "$unset": unset}
+
+	if expected == nil {
+		// An expired document counts as absent and is replaced
+		expiredFilter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID, "key": key,
+			"expiresAt": bson.M{"$lte": time.Now()}}
+		res, err := m.trackCollection.UpdateOne(context.TODO(), expiredFilter, update)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount > 0 {
+			return nil
+		}
+
+		// Otherwise only an upsert can succeed: an existing document is left as it is
+		res, err = m.trackCollection.UpdateOne(context.TODO(), filter, bson.M{"$setOnInsert": fields}, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+		if res.UpsertedCount == 0 {
+			return store_interface.ErrTrackValueMismatch
+		}
+		return nil
+	}
+
+	// Only a swap of a live value replaces anything worth keeping in history
+	filter["value"] = *expected
+	history, err := m.snapshotTrackHistory(space, filter)
+	if err != nil {
+		return err
+	}
+	res, err := m.trackCollection.UpdateOne(context.TODO(), withLive(filter), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return store_interface.ErrTrackValueMismatch
+	}
+	return m.recordTrackHistory(history)
+}
+
+// TrackPutManyIf checks and writes each document atomically with a filtered update,
+// or an upsert for if_absent. Mongo runs no transaction across the items.
+func (m *MongoStore) TrackPutManyIf(space store_interface.TenancySpace, items map[int32][]model.TrackKeyValueItem, cond store_interface.TrackPutCondition) (model.TrackPutManyResult, error) {
+	if err := cond.Validate(); err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+
+	var result model.TrackPutManyResult
+	for _, bucketID := range store_interface.SortedTrackBuckets(items) {
+		for _, kv := range items[bucketID] {
+			written, err := m.trackPutIf(space, bucketID, kv, cond)
+			if err != nil {
+				return result, err
+			}
+			pair := model.TrackBucketKeyPair{BucketID: bucketID, Key: kv.Key}
+			if written {
+				result.Written = append(result.Written, pair)
+			} else {
+				result.Rejected = append(result.Rejected, pair)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (m *MongoStore) trackPutIf(space store_interface.TenancySpace, bucketID int32, kv model.TrackKeyValueItem, cond store_interface.TrackPutCondition) (bool, error) {
+	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID, "key": kv.Key}
+
+	fields := bson.M{"value": kv.Value.Value}
+	unset := bson.M{}
+	if kv.Value.Tag != nil {
+		fields["tag"] = *kv.Value.Tag
+	} else {
+		unset["tag"] = ""
+	}
+	if len(kv.Value.Tags) > 0 {
+		fields["tags"] = kv.Value.Tags
+	} else {
+		unset["tags"] = ""
+	}
+	if kv.Value.Metric != nil {
+		fields["metric"] = *kv.Value.Metric
+	} else {
+		unset["metric"] = ""
+	}
+	if kv.Value.ExpiresAt != nil {
+		fields["expiresAt"] = *kv.Value.ExpiresAt
+	} else {
+		unset["expiresAt"] = ""
+	}
+	update := bson.M{"$set": fields, "$unset": unset}
+
+	if cond.Mode != store_interface.TrackPutIfAbsent {
+		if cond.Mode == store_interface.TrackPutIfTag {
+			filter["tag"] = *cond.Tag
+		}
+		history, err := m.snapshotTrackHistory(space, filter)
+		if err != nil {
+			return false, err
+		}
+		res, err := m.trackCollection.UpdateOne(context.TODO(), withLive(filter), update)
+		if err != nil || res.MatchedCount == 0 {
+			return false, err
+		}
+		return true, m.recordTrackHistory(history)
+	}
+
+	// An expired document counts as absent and is replaced
+	expiredFilter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID, "key": kv.Key,
+		"expiresAt": bson.M{"$lte": time.Now()}}
+	res, err := m.trackCollection.UpdateOne(context.TODO(), expiredFilter, update)
+	if err != nil {
+		return false, err
+	}
+	if res.MatchedCount > 0 {
+		return true, nil
+	}
+	res, err = m.trackCollection.UpdateOne(context.TODO(), filter, bson.M{"$setOnInsert": fields}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// Lost a race with a concurrent insert of the same key
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return res.UpsertedCount > 0, nil
+}
+
 func (m *MongoStore) TrackDelete(space store_interface.TenancySpace, bucketID int32, key string) error {
 	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID, "key": key}
-	_, err := m.trackCollection.DeleteOne(context.TODO(), filter)
-	return err
+	history, err := m.snapshotTrackHistory(space, filter)
+	if err != nil {
+		return err
+	}
+	if _, err := m.trackCollection.DeleteOne(context.TODO(), filter); err != nil {
+		return err
+	}
+	return m.recordTrackHistory(history)
 }
 
 func (m *MongoStore) TrackClose() error {
@@ -87,12 +318,25 @@ func (m *MongoStore) TrackPutMany(space store_interface.TenancySpace, items map[
 
 	for bucketID, kvItems := range items {
 		for _, kv := range kvItems {
+			// Flat, like TrackPut, so that filters and expiry see the fields
 			doc := bson.M{
 				"appId":     space.AppId,
 				"tenancyId": space.TenancyId,
 				"bucketId":  bucketID,
 				"key":       kv.Key,
-				"value":     kv.Value,
+				"value":     kv.Value.Value,
+			}
+			if kv.Value.Tag != nil {
+				doc["tag"] = *kv.Value.Tag
+			}
+			if len(kv.Value.Tags) > 0 {
+				doc["tags"] = kv.Value.Tags
+			}
+			if kv.Value.Metric != nil {
+				doc["metric"] = *kv.Value.Metric
+			}
+			if kv.Value.ExpiresAt != nil {
+				doc["expiresAt"] = *kv.Value.ExpiresAt
 			}
 			docs = append(docs, doc)
 		}
@@ -110,10 +354,9 @@ func (b *MongoStore) GetItemsByKeyPrefix(
 	space store_interface.TenancySpace, bucketID int32,
 	prefix string,
 	tags []int64,
-	metricValue *float64,
-	metricIsGt bool,
+	metric *store_interface.MetricFilter,
 ) ([]model.TrackKeyValueItem, error) {
-	return b.GetItemsByKeyPrefixes(space, bucketID, []string{prefix}, tags, metricValue, metricIsGt)
+	return b.GetItemsByKeyPrefixes(space, bucketID, []string{prefix}, tags, metric)
 }
 
 func (m *MongoStore) TrackGetMany(space store_interface.TenancySpace, keys map[int32][]string) (map[int32]map[string]model.TrackValue, map[int32][]string, error) {
@@ -136,7 +379,7 @@ func (m *MongoStore) TrackGetMany(space store_interface.TenancySpace, keys map[i
 		return values, missing, nil
 	}
 
-	cur, err := m.trackCollection.Find(context.TODO(), bson.M{"$or": orFilters})
+	cur, err := m.trackCollection.Find(context.TODO(), withLive(bson.M{"$or": orFilters}))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -146,11 +389,13 @@ func (m *MongoStore) TrackGetMany(space store_interface.TenancySpace, keys map[i
 
 	for cur.Next(context.TODO()) {
 		var result struct {
-			BucketID int32    `bson:"bucketId"`
-			Key      string   `bson:"key"`
-			Value    int64    `bson:"value"`
-			Tag      *int64   `bson:"tag,omitempty"`
-			Metric   *float64 `bson:"metric,omitempty"`
+			BucketID  int32      `bson:"bucketId"`
+			Key       string     `bson:"key"`
+			Value     int64      `bson:"value"`
+			Tag       *int64     `bson:"tag,omitempty"`
+			Tags      []int64    `bson:"tags,omitempty"`
+			Metric    *float64   `bson:"metric,omitempty"`
+			ExpiresAt *time.Time `bson:"expiresAt,omitempty"`
 		}
 		if err := cur.Decode(&result); err != nil {
 			return nil, nil, err
@@ -162,9 +407,11 @@ func (m *MongoStore) TrackGetMany(space store_interface.TenancySpace, keys map[i
 		}
 
 		values[result.BucketID][result.Key] = model.TrackValue{
-			Value:  result.Value,
-			Tag:    result.Tag,
-			Metric: result.Metric,
+			Value:     result.Value,
+			Tag:       result.Tag,
+			Tags:      result.Tags,
+			Metric:    result.Metric,
+			ExpiresAt: result.ExpiresAt,
 		}
 		foundKeys[result.BucketID][result.Key] = true
 	}
@@ -206,12 +453,30 @@ func (m *MongoStore) GetItemsByKeyPrefixes(
 	space store_interface.TenancySpace, bucketID int32,
 	prefixes []string, // multiple prefixes allowed
 	tags []int64, // optional
-	metricValue *float64, // optional
-	metricIsGt bool, // if metricValue != nil
+	metric *store_interface.MetricFilter,
 ) ([]model.TrackKeyValueItem, error) {
+	items, _, err := m.GetItemsByKeyPrefixesPage(space, bucketID, prefixes, tags, metric, store_interface.TrackQueryOptions{})
+	return items, err
+}
+
+func (m *MongoStore) GetItemsByKeyPrefixesPage(
+	space store_interface.TenancySpace, bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
 
 	if len(prefixes) == 0 {
-		return nil, fmt.Errorf("must provide at least one prefix")
+		return nil, "", fmt.Errorf("must provide at least one prefix")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, "", err
+		}
 	}
 
 	// Base filter for app and bucket
@@ -239,36 +504,544 @@ func (m *MongoStore) GetItemsByKeyPrefixes(
 	}
 
 	if len(orClauses) == 0 {
-		return nil, fmt.Errorf("all prefixes were empty")
+		return nil, "", fmt.Errorf("all prefixes were empty")
 	}
 
 	// Attach OR conditions
 	filter["$or"] = orClauses
 
 	// Attach tags filter if provided
-	if len(tags) > 0 {
-		filter["tag"] = bson.M{"$in": tags}
-	}
+	withTags(filter, tags, opts.TagMatch)
 
 	// Attach metric filter if provided
-	if metricValue != nil {
+	if metric != nil {
+		filter["metric"] = mongoMetricFilter(*metric)
+	}
+
+	if metric == nil && opts.OrderBy == store_interface.TrackOrderByMetric {
+		filter["metric"] = bson.M{"$exists": true}
+	}
+
+	// Resume after the cursor key; this is ANDed with the prefix ranges
+	if opts.StartAfter != "" {
+		if opts.Descending {
+			filter["key"] = bson.M{"$lt": opts.StartAfter}
+		} else {
+			filter["key"] = bson.M{"$gt": opts.StartAfter}
+		}
+	}
+	findOpts := options.Find().SetSort(mongoTrackSort(opts))
+	if fetch := opts.FetchLimit(); fetch > 0 {
+		findOpts.SetLimit(int64(fetch))
+	}
+
+	cursor, err := m.trackCollection.Find(context.TODO(), withLive(filter), findOpts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(context.TODO())
+
+	var results []model.TrackKeyValueItem
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, "", err
+	}
+
+	page, next := opts.PageItems(results)
+	return page, next, nil
+}
+
+func (m *MongoStore) GetItemsByKeyRange(
+	space store_interface.TenancySpace, bucketID int32,
+	keyRange store_interface.TrackKeyRange,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	if err := keyRange.Validate(); err != nil {
+		return nil, "", err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	filter := bson.M{
+		"appId":     space.AppId,
+		"tenancyId": space.TenancyId,
+		"bucketId":  bucketID,
+	}
+
+	// Range bounds and the cursor may use the same operator, so each gets its own clause
+	var keyClauses []bson.M
+	if keyRange.Start != "" {
+		op := "$gt"
+		if keyRange.StartInclusive {
+			op = "$gte"
+		}
+		keyClauses = append(keyClauses, bson.M{"key": bson.M{op: keyRange.Start}})
+	}
+	if keyRange.End != "" {
 		op := "$lt"
-		if metricIsGt {
-			op = "$gt"
+		if keyRange.EndInclusive {
+			op = "$lte"
+		}
+		keyClauses = append(keyClauses, bson.M{"key": bson.M{op: keyRange.End}})
+	}
+	if opts.StartAfter != "" {
+		op := "$gt"
+		if opts.Descending {
+			op = "$lt"
 		}
-		filter["metric"] = bson.M{op: *metricValue}
+		keyClauses = append(keyClauses, bson.M{"key": bson.M{op: opts.StartAfter}})
+	}
+	if len(keyClauses) > 0 {
+		filter["$and"] = keyClauses
+	}
+
+	withTags(filter, tags, opts.TagMatch)
+	if metric != nil {
+		filter["metric"] = mongoMetricFilter(*metric)
+	} else if opts.OrderBy == store_interface.TrackOrderByMetric {
+		filter["metric"] = bson.M{"$exists": true}
 	}
 
-	cursor, err := m.trackCollection.Find(context.TODO(), filter)
+	findOpts := options.Find().SetSort(mongoTrackSort(opts))
+	if fetch := opts.FetchLimit(); fetch > 0 {
+		findOpts.SetLimit(int64(fetch))
+	}
+
+	cursor, err := m.trackCollection.Find(context.TODO(), withLive(filter), findOpts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer cursor.Close(context.TODO())
 
 	var results []model.TrackKeyValueItem
 	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, "", err
+	}
+
+	page, next := opts.PageItems(results)
+	return page, next, nil
+}
+
+// GetKeysByValue runs one find over the value index, sorted by key.
+func (m *MongoStore) GetKeysByValue(
+	space store_interface.TenancySpace, bucketID int32,
+	values []int64,
+	prefix string,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+
+	if err := store_interface.ValidateKeysByValue(values, opts); err != nil {
+		return nil, "", err
+	}
+
+	filter := bson.M{
+		"appId":     space.AppId,
+		"tenancyId": space.TenancyId,
+		"bucketId":  bucketID,
+		"value":     bson.M{"$in": values},
+	}
+
+	// The prefix and the cursor may use the same operator, so each gets its own clause
+	var keyClauses []bson.M
+	if prefix != "" {
+		keyClauses = append(keyClauses, bson.M{"key": bson.M{"$gte": prefix, "$lt": nextLexicographicString(prefix)}})
+	}
+	if opts.StartAfter != "" {
+		op := "$gt"
+		if opts.Descending {
+			op = "$lt"
+		}
+		keyClauses = append(keyClauses, bson.M{"key": bson.M{op: opts.StartAfter}})
+	}
+	if len(keyClauses) > 0 {
+		filter["$and"] = keyClauses
+	}
+
+	findOpts := options.Find().SetSort(mongoTrackSort(opts))
+	if fetch := opts.FetchLimit(); fetch > 0 {
+		findOpts.SetLimit(int64(fetch))
+	}
+
+	cursor, err := m.trackCollection.Find(context.TODO(), withLive(filter), findOpts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(context.TODO())
+
+	var results []model.TrackKeyValueItem
+	for cursor.Next(context.TODO()) {
+		var doc struct {
+			Key       string     `bson:"key"`
+			Value     int64      `bson:"value"`
+			Tag       *int64     `bson:"tag,omitempty"`
+			Tags      []int64    `bson:"tags,omitempty"`
+			Metric    *float64   `bson:"metric,omitempty"`
+			ExpiresAt *time.Time `bson:"expiresAt,omitempty"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, "", err
+		}
+		results = append(results, model.TrackKeyValueItem{
+			Key:   doc.Key,
+			Value: model.TrackValue{Value: doc.Value, Tag: doc.Tag, Tags: doc.Tags, Metric: doc.Metric, ExpiresAt: doc.ExpiresAt},
+		})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, "", err
+	}
+
+	page, next := opts.PageItems(results)
+	return page, next, nil
+}
+
+// TrackAggregate runs a $match/$group pipeline. Prefixes are ORed in one $match, so
+// overlapping ones count each document once; an empty prefix matches the whole bucket.
+func (m *MongoStore) TrackAggregate(
+	space store_interface.TenancySpace, bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	groupByTag bool,
+) ([]model.TrackAggregateGroup, error) {
+
+	prefixes, err := store_interface.DisjointPrefixes(prefixes)
+	if err != nil {
+		return nil, err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	filter := mongoPrefixFilter(space, bucketID, prefixes, tags, metric)
+
+	var groupID any
+	if groupByTag {
+		groupID = "$tag"
+	}
+	// $sum, $min and $max skip documents without a metric
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: withLive(filter)}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         groupID,
+			"count":       bson.M{"$sum": 1},
+			"valueSum":    bson.M{"$sum": "$value"},
+			"valueMin":    bson.M{"$min": "$value"},
+			"valueMax":    bson.M{"$max": "$value"},
+			"metricCount": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$isNumber": "$metric"}, 1, 0}}},
+			"metricSum":   bson.M{"$sum": "$metric"},
+			"metricMin":   bson.M{"$min": "$metric"},
+			"metricMax":   bson.M{"$max": "$metric"},
+		}}},
+	}
+
+	cursor, err := m.trackCollection.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	var results []struct {
+		Tag         *int64   `bson:"_id"`
+		Count       int64    `bson:"count"`
+		ValueSum    int64    `bson:"valueSum"`
+		ValueMin    *int64   `bson:"valueMin"`
+		ValueMax    *int64   `bson:"valueMax"`
+		MetricCount int64    `bson:"metricCount"`
+		MetricSum   float64  `bson:"metricSum"`
+		MetricMin   *float64 `bson:"metricMin"`
+		MetricMax   *float64 `bson:"metricMax"`
+	}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	agg := store_interface.NewTrackAggregator(groupByTag)
+	for _, r := range results {
+		agg.Merge(model.TrackAggregateGroup{
+			Tag:    r.Tag,
+			Count:  r.Count,
+			Value:  model.TrackValueStats{Sum: r.ValueSum, Min: r.ValueMin, Max: r.ValueMax},
+			Metric: model.TrackMetricStats{Count: r.MetricCount, Sum: r.MetricSum, Min: r.MetricMin, Max: r.MetricMax},
+		})
+	}
+	return agg.Groups(), nil
+}
+
+// mongoTrackSort sorts on the query's order field, breaking ties by key.
+func (m *MongoStore) ListTrackBuckets(space store_interface.TenancySpace) ([]int32, error) {
+	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId}
+	values, err := m.trackCollection.Distinct(context.TODO(), "bucketId", withLive(filter))
+	if err != nil {
+		return nil, err
+	}
+	bucketIDs := make([]int32, 0, len(values))
+	for _, v := range values {
+		switch id := v.(type) {
+		case int32:
+			bucketIDs = append(bucketIDs, id)
+		case int64:
+			bucketIDs = append(bucketIDs, int32(id))
+		default:
+			return nil, fmt.Errorf("unexpected bucketId type %T", v)
+		}
+	}
+	slices.Sort(bucketIDs)
+	return bucketIDs, nil
+}
+
+// TrackBucketStats computes the stats in one $group; the byte estimate matches
+// store_interface.TrackEntryBytes.
+func (m *MongoStore) TrackBucketStats(space store_interface.TenancySpace, bucketID int32) (model.TrackBucketStats, error) {
+	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID}
+	ifSet := func(field string) bson.M {
+		return bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{field, nil}}, nil}}, 0, 8}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: withLive(filter)}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         nil,
+			"count":       bson.M{"$sum": 1},
+			"minKey":      bson.M{"$min": "$key"},
+			"maxKey":      bson.M{"$max": "$key"},
+			"valueSum":    bson.M{"$sum": "$value"},
+			"valueMin":    bson.M{"$min": "$value"},
+			"valueMax":    bson.M{"$max": "$value"},
+			"metricCount": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$isNumber": "$metric"}, 1, 0}}},
+			"metricSum":   bson.M{"$sum": "$metric"},
+			"metricMin":   bson.M{"$min": "$metric"},
+			"metricMax":   bson.M{"$max": "$metric"},
+			"bytes": bson.M{"$sum": bson.M{"$add": bson.A{
+				bson.M{"$strLenBytes": "$key"}, 8, ifSet("$tag"), ifSet("$metric"), ifSet("$expiresAt"),
+				bson.M{"$multiply": bson.A{8, bson.M{"$size": bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}}}},
+			}}},
+		}}},
+	}
+
+	cursor, err := m.trackCollection.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return model.TrackBucketStats{}, err
+	}
+	defer cursor.Close(context.TODO())
+
+	var results []struct {
+		Count       int64    `bson:"count"`
+		MinKey      string   `bson:"minKey"`
+		MaxKey      string   `bson:"maxKey"`
+		ValueSum    int64    `bson:"valueSum"`
+		ValueMin    *int64   `bson:"valueMin"`
+		ValueMax    *int64   `bson:"valueMax"`
+		MetricCount int64    `bson:"metricCount"`
+		MetricSum   float64  `bson:"metricSum"`
+		MetricMin   *float64 `bson:"metricMin"`
+		MetricMax   *float64 `bson:"metricMax"`
+		Bytes       int64    `bson:"bytes"`
+	}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return model.TrackBucketStats{}, err
+	}
+
+	stats := store_interface.NewTrackBucketStatsBuilder(bucketID)
+	for _, r := range results {
+		stats.Merge(model.TrackBucketStats{
+			KeyCount:    r.Count,
+			MinKey:      r.MinKey,
+			MaxKey:      r.MaxKey,
+			Value:       model.TrackValueStats{Sum: r.ValueSum, Min: r.ValueMin, Max: r.ValueMax},
+			Metric:      model.TrackMetricStats{Count: r.MetricCount, Sum: r.MetricSum, Min: r.MetricMin, Max: r.MetricMax},
+			ApproxBytes: r.Bytes,
+		})
+	}
+	return stats.Stats()
+}
+
+func (m *MongoStore) TrackScanBucket(space store_interface.TenancySpace, bucketID int32, fn func(model.TrackKeyValueItem) error) error {
+	filter := bson.M{"appId": space.AppId, "tenancyId": space.TenancyId, "bucketId": bucketID}
+	findOpts := options.Find().SetSort(bson.D{{Key: "key", Value: 1}})
+	cursor, err := m.trackCollection.Find(context.TODO(), withLive(filter), findOpts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.TODO())
+
+	for cursor.Next(context.TODO()) {
+		var doc struct {
+			Key       string     `bson:"key"`
+			Value     int64      `bson:"value"`
+			Tag       *int64     `bson:"tag,omitempty"`
+			Tags      []int64    `bson:"tags,omitempty"`
+			Metric    *float64   `bson:"metric,omitempty"`
+			ExpiresAt *time.Time `bson:"expiresAt,omitempty"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		item := model.TrackKeyValueItem{
+			Key:   doc.Key,
+			Value: model.TrackValue{Value: doc.Value, Tag: doc.Tag, Tags: doc.Tags, Metric: doc.Metric, ExpiresAt: doc.ExpiresAt},
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// mongoPrefixFilter matches the keys under disjoint prefixes that pass the tag and
+// metric filters.
+func mongoPrefixFilter(space store_interface.TenancySpace, bucketID int32, prefixes []string, tags []int64, metric *store_interface.MetricFilter) bson.M {
+	filter := bson.M{
+		"appId":     space.AppId,
+		"tenancyId": space.TenancyId,
+		"bucketId":  bucketID,
+	}
+	// Disjoint prefixes start with "" only when it is the sole prefix
+	if prefixes[0] != "" {
+		orClauses := make([]bson.M, 0, len(prefixes))
+		for _, prefix := range prefixes {
+			orClauses = append(orClauses, bson.M{
+				"key": bson.M{
+					"$gte": prefix,
+					"$lt":  nextLexicographicString(prefix),
+				},
+			})
+		}
+		filter["$or"] = orClauses
+	}
+	withTags(filter, tags, store_interface.TrackTagMatchAny)
+	if metric != nil {
+		filter["metric"] = mongoMetricFilter(*metric)
+	}
+	return filter
+}
+
+// withTags ANDs into filter a match on the tag set, tag and tags, holding any of the
+// tags, or all of them for store_interface.TrackTagMatchAll. No tags leaves it as it is.
+func withTags(filter bson.M, tags []int64, match store_interface.TrackTagMatch) bson.M {
+	if len(tags) == 0 {
+		return filter
+	}
+	clauses, _ := filter["$and"].([]bson.M)
+	if match == store_interface.TrackTagMatchAll {
+		for _, t := range tags {
+			clauses = append(clauses, bson.M{"$or": bson.A{bson.M{"tag": t}, bson.M{"tags": t}}})
+		}
+	} else {
+		clauses = append(clauses, bson.M{"$or": bson.A{
+			bson.M{"tag": bson.M{"$in": tags}},
+			bson.M{"tags": bson.M{"$in": tags}},
+		}})
+	}
+	filter["$and"] = clauses
+	return filter
+}
+
+func (m *MongoStore) TrackDeleteByPrefix(
+	space store_interface.TenancySpace, bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+) (int, error) {
+
+	prefixes, err := store_interface.DisjointPrefixes(prefixes)
+	if err != nil {
+		return 0, err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return 0, err
+		}
+	}
+
+	filter := mongoPrefixFilter(space, bucketID, prefixes, tags, metric)
+	history, err := m.snapshotTrackHistory(space, filter)
+	if err != nil {
+		return 0, err
+	}
+	res, err := m.trackCollection.DeleteMany(context.TODO(), filter)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), m.recordTrackHistory(history)
+}
+
+func mongoTrackSort(opts store_interface.TrackQueryOptions) bson.D {
+	direction := 1
+	if opts.Descending {
+		direction = -1
+	}
+	if opts.ByKey() {
+		return bson.D{{Key: "key", Value: direction}}
+	}
+	return bson.D{{Key: string(opts.OrderBy), Value: direction}, {Key: "key", Value: direction}}
+}
+
+// mongoMetricFilter translates a metric filter into a condition on the metric field.
+// $ne alone would also match documents without a metric, so it is paired with $exists.
+func mongoMetricFilter(f store_interface.MetricFilter) bson.M {
+	switch f.Operator {
+	case store_interface.MetricGt:
+		return bson.M{"$gt": f.Value}
+	case store_interface.MetricGte:
+		return bson.M{"$gte": f.Value}
+	case store_interface.MetricLt:
+		return bson.M{"$lt": f.Value}
+	case store_interface.MetricLte:
+		return bson.M{"$lte": f.Value}
+	case store_interface.MetricEq:
+		return bson.M{"$eq": f.Value}
+	case store_interface.MetricNe:
+		return bson.M{"$exists": true, "$ne": f.Value}
+	default: // store_interface.MetricBetween
+		return bson.M{"$gte": f.Value, "$lte": f.Upper}
+	}
+}
+
+// TrackDeleteExpired removes expired documents ahead of the TTL monitor, in batches of
+// at most limit.
+func (m *MongoStore) TrackDeleteExpired(now time.Time, limit int) ([]store_interface.TrackExpiredKey, error) {
+	findOpts := options.Find().SetLimit(int64(limit)).
+		SetProjection(bson.M{"_id": 1, "appId": 1, "tenancyId": 1, "bucketId": 1, "key": 1})
+	cursor, err := m.trackCollection.Find(context.TODO(), bson.M{"expiresAt": bson.M{"$lte": now}}, findOpts)
+	if err != nil {
 		return nil, err
 	}
+	var docs []struct {
+		ID        any    `bson:"_id"`
+		AppID     int32  `bson:"appId"`
+		TenancyID int64  `bson:"tenancyId"`
+		BucketID  int32  `bson:"bucketId"`
+		Key       string `bson:"key"`
+	}
+	if err := cursor.All(context.TODO(), &docs); err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
 
-	return results, nil
+	// Documents the TTL monitor removed in the meantime are reported too, as they are gone
+	ids := make(bson.A, 0, len(docs))
+	deleted := make([]store_interface.TrackExpiredKey, 0, len(docs))
+	for _, d := range docs {
+		ids = append(ids, d.ID)
+		deleted = append(deleted, store_interface.TrackExpiredKey{
+			Space:    store_interface.TenancySpace{AppId: d.AppID, TenancyId: d.TenancyID},
+			BucketID: d.BucketID,
+			Key:      d.Key,
+		})
+	}
+	if _, err := m.trackCollection.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return nil, err
+	}
+	return deleted, nil
 }
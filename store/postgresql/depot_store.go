@@ -1,6 +1,13 @@
 package postgresql
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/store_interface"
 )
 
@@ -8,15 +15,31 @@ func (s *PostgreSQLStore) DepotCreate(
 	space store_interface.TenancySpace,
 	bucketID int32,
 	value string,
-) (int64, error) {
+) (int64, int64, error) {
 
-	var id int64
+	var id, revision int64
 	err := s.db.QueryRow(`
 		INSERT INTO depot (app_id, tenancy_id, bucket_id, value)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id
-	`, space.AppId, space.TenancyId, bucketID, value).Scan(&id)
-	return id, err
+		RETURNING id, revision
+	`, space.AppId, space.TenancyId, bucketID, value).Scan(&id, &revision)
+	return id, revision, err
+}
+
+func (s *PostgreSQLStore) DepotCreateRaw(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	data []byte,
+	contentType string,
+) (int64, int64, error) {
+
+	var id, revision int64
+	err := s.db.QueryRow(`
+		INSERT INTO depot (app_id, tenancy_id, bucket_id, value, content_type, data)
+		VALUES ($1, $2, $3, '', $4, $5)
+		RETURNING id, revision
+	`, space.AppId, space.TenancyId, bucketID, store_interface.DepotRawContentType(contentType), data).Scan(&id, &revision)
+	return id, revision, err
 }
 
 func (s *PostgreSQLStore) DepotDeleteByBucket(
@@ -76,12 +99,85 @@ func (s *PostgreSQLStore) DepotUpdate(
 
 	_, err := s.db.Exec(`
 		UPDATE depot
-		SET value=$1
+		SET value=$1, content_type=NULL, data=NULL, revision=revision+1
 		WHERE id=$2 AND app_id=$3 AND tenancy_id=$4
 	`, value, id, space.AppId, space.TenancyId)
 	return err
 }
 
+func (s *PostgreSQLStore) DepotUpdateIfRevision(
+	space store_interface.TenancySpace,
+	id int64,
+	value string,
+	revision int64,
+) (int64, error) {
+
+	var next int64
+	err := s.db.QueryRow(`
+		UPDATE depot
+		SET value=$1, content_type=NULL, data=NULL, revision=revision+1
+		WHERE id=$2 AND app_id=$3 AND tenancy_id=$4 AND revision=$5
+		RETURNING revision
+	`, value, id, space.AppId, space.TenancyId, revision).Scan(&next)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return next, err
+	}
+
+	// Nothing was updated, so report why
+	item, err := s.DepotGetItem(space, id)
+	if err != nil {
+		return 0, err
+	}
+	return 0, &store_interface.DepotRevisionConflictError{ID: id, Expected: revision, Current: item.Revision}
+}
+
+func (s *PostgreSQLStore) DepotUpdateRaw(
+	space store_interface.TenancySpace,
+	id int64,
+	data []byte,
+	contentType string,
+) (int64, error) {
+
+	var next int64
+	err := s.db.QueryRow(`
+		UPDATE depot
+		SET value='', content_type=$1, data=$2, revision=revision+1
+		WHERE id=$3 AND app_id=$4 AND tenancy_id=$5
+		RETURNING revision
+	`, store_interface.DepotRawContentType(contentType), data, id, space.AppId, space.TenancyId).Scan(&next)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, store_interface.ErrDepotItemNotFound
+	}
+	return next, err
+}
+
+func (s *PostgreSQLStore) DepotUpdateRawIfRevision(
+	space store_interface.TenancySpace,
+	id int64,
+	data []byte,
+	contentType string,
+	revision int64,
+) (int64, error) {
+
+	var next int64
+	err := s.db.QueryRow(`
+		UPDATE depot
+		SET value='', content_type=$1, data=$2, revision=revision+1
+		WHERE id=$3 AND app_id=$4 AND tenancy_id=$5 AND revision=$6
+		RETURNING revision
+	`, store_interface.DepotRawContentType(contentType), data, id, space.AppId, space.TenancyId, revision).Scan(&next)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return next, err
+	}
+
+	// Nothing was updated, so report why
+	item, err := s.DepotGetItem(space, id)
+	if err != nil {
+		return 0, err
+	}
+	return 0, &store_interface.DepotRevisionConflictError{ID: id, Expected: revision, Current: item.Revision}
+}
+
 func (s *PostgreSQLStore) DepotDelete(
 	space store_interface.TenancySpace,
 	id int64,
@@ -107,6 +203,42 @@ func (s *PostgreSQLStore) DepotGet(
 	return value, err
 }
 
+func (s *PostgreSQLStore) DepotGetItem(
+	space store_interface.TenancySpace,
+	id int64,
+) (model.DepotItem, error) {
+
+	item := model.DepotItem{ID: id}
+	err := s.db.QueryRow(`
+		SELECT value, revision, COALESCE(content_type, '') FROM depot
+		WHERE id=$1 AND app_id=$2 AND tenancy_id=$3
+	`, id, space.AppId, space.TenancyId).Scan(&item.Value, &item.Revision, &item.ContentType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.DepotItem{}, store_interface.ErrDepotItemNotFound
+	}
+	return item, err
+}
+
+func (s *PostgreSQLStore) DepotGetRaw(
+	space store_interface.TenancySpace,
+	id int64,
+) (model.DepotRawItem, error) {
+
+	item := model.DepotItem{ID: id}
+	var data []byte
+	err := s.db.QueryRow(`
+		SELECT value, revision, COALESCE(content_type, ''), data FROM depot
+		WHERE id=$1 AND app_id=$2 AND tenancy_id=$3
+	`, id, space.AppId, space.TenancyId).Scan(&item.Value, &item.Revision, &item.ContentType, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.DepotRawItem{}, store_interface.ErrDepotItemNotFound
+	}
+	if err != nil {
+		return model.DepotRawItem{}, err
+	}
+	return store_interface.DepotRawFromItem(item, data), nil
+}
+
 func (s *PostgreSQLStore) DepotGetMany(
 	space store_interface.TenancySpace,
 	ids []int64,
@@ -178,3 +310,107 @@ func (s *PostgreSQLStore) DepotGetAllByBucket(
 	}
 	return result, nil
 }
+
+func (s *PostgreSQLStore) DepotGetBucketPage(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	opts store_interface.DepotPageOptions,
+) ([]model.DepotItem, int64, error) {
+	return s.DepotQuery(space, bucketID, nil, opts)
+}
+
+// DepotQuery reads the page, plus one row to detect a next page, in ID order through
+// depot_space_bucket_id_idx. Filters use jsonb operators on depot_jsonb(value), which
+// is NULL for values that are not JSON.
+func (s *PostgreSQLStore) DepotQuery(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	filters []store_interface.DepotFieldFilter,
+	opts store_interface.DepotPageOptions,
+) ([]model.DepotItem, int64, error) {
+
+	if err := store_interface.ValidateDepotQuery(filters, opts); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT id, value, revision, COALESCE(content_type, '') FROM depot
+		WHERE app_id=$1 AND tenancy_id=$2 AND bucket_id=$3`
+	args := []any{space.AppId, space.TenancyId, bucketID}
+	placeholder := func() string { return fmt.Sprintf("$%d", len(args)+1) }
+	for _, f := range filters {
+		query += ` AND ` + depotFilterSQL(f, &args, placeholder)
+	}
+	order := "ASC"
+	if opts.Descending {
+		order = "DESC"
+	}
+	if opts.StartAfter != 0 {
+		args = append(args, opts.StartAfter)
+		if opts.Descending {
+			query += fmt.Sprintf(` AND id < $%d`, len(args))
+		} else {
+			query += fmt.Sprintf(` AND id > $%d`, len(args))
+		}
+	}
+	query += ` ORDER BY id ` + order
+	if fetch := opts.FetchLimit(); fetch > 0 {
+		args = append(args, fetch)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var items []model.DepotItem
+	for rows.Next() {
+		var item model.DepotItem
+		if err := rows.Scan(&item.ID, &item.Value, &item.Revision, &item.ContentType); err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	page, next := opts.PageItems(items)
+	return page, next, nil
+}
+
+// depotFilterSQL renders a filter as a condition on the JSON in value, appending its
+// arguments to args. The field is compared as jsonb, so eq and in only match fields of
+// the operand's type; the range operators check the type first, as jsonb orders across types.
+func depotFilterSQL(f store_interface.DepotFieldFilter, args *[]any, placeholder func() string) string {
+	arg := func(v any) string {
+		p := placeholder()
+		*args = append(*args, v)
+		return p
+	}
+	field := `(depot_jsonb(value) #> ` + arg(f.PathSegments()) + `::text[])`
+	switch f.Op {
+	case store_interface.DepotFilterExists:
+		// #> gives a jsonb null for a JSON null and NULL for a missing path
+		return field + ` IS NOT NULL`
+	case store_interface.DepotFilterIn:
+		operands := make([]string, len(f.Values))
+		for i, v := range f.Values {
+			operands[i] = arg(depotOperandJSON(v)) + `::jsonb`
+		}
+		return field + ` IN (` + strings.Join(operands, ", ") + `)`
+	case store_interface.DepotFilterEq:
+		return field + ` = ` + arg(depotOperandJSON(f.Value)) + `::jsonb`
+	}
+	_, kind, _ := store_interface.DepotOperand(f.Value)
+	return fmt.Sprintf(`(jsonb_typeof%s = '%s' AND %s %s %s::jsonb)`,
+		field, kind, field, f.SQLOperator(), arg(depotOperandJSON(f.Value)))
+}
+
+func depotOperandJSON(operand any) string {
+	v, _, _ := store_interface.DepotOperand(operand)
+	b, _ := json.Marshal(v)
+	return string(b)
+}
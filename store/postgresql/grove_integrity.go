@@ -0,0 +1,198 @@
+package postgresql
+
+import (
+	"database/sql"
+
+	"github.com/vixac/bullet/store/store_interface"
+)
+
+// VerifyTree reports inconsistencies between a tree's parent pointers and its derived data
+func (s *PostgreSQLStore) VerifyTree(space store_interface.TenancySpace, treeID store_interface.TreeID) (*store_interface.TreeIntegrityReport, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, report, err := inspectTree(tx, space, treeID)
+	return report, err
+}
+
+// RepairTree rebuilds a tree's derived data from its parent pointers
+func (s *PostgreSQLStore) RepairTree(space store_interface.TenancySpace, treeID store_interface.TreeID) (*store_interface.TreeIntegrityReport, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	shape, report, err := inspectTree(tx, space, treeID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Orphans and cycle breakers become roots
+	for _, node := range shape.Detached {
+		var positionVal *float64
+		err = tx.QueryRow(`
+			UPDATE grove_nodes SET parent_id = NULL
+			WHERE app_id=$1 AND tenancy_id=$2 AND tree_id=$3 AND node_id=$4
+			RETURNING position`,
+			space.AppId, space.TenancyId, string(treeID), string(node)).Scan(&positionVal)
+		if err != nil {
+			return nil, err
+		}
+		change, err := nodeChange(store_interface.GroveChangeMove, node, nil, positionVal, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := recordChange(tx, space, treeID, change); err != nil {
+			return nil, err
+		}
+	}
+
+	// Stale rows go first so rows at the wrong depth can be reinserted
+	for _, pair := range report.StaleClosure {
+		_, err = tx.Exec(`
+			DELETE FROM grove_closure
+			WHERE app_id=$1 AND tenancy_id=$2 AND tree_id=$3 AND ancestor_id=$4 AND descendant_id=$5`,
+			space.AppId, space.TenancyId, string(treeID), string(pair.Ancestor), string(pair.Descendant))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, pair := range report.MissingClosure {
+		_, err = tx.Exec(`
+			INSERT INTO grove_closure (app_id, tenancy_id, tree_id, ancestor_id, descendant_id, depth)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			space.AppId, space.TenancyId, string(treeID), string(pair.Ancestor), string(pair.Descendant), pair.Depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, node := range report.DanglingAggregates {
+		_, err = tx.Exec(`
+			DELETE FROM grove_aggregates
+			WHERE app_id=$1 AND tenancy_id=$2 AND tree_id=$3 AND node_id=$4`,
+			space.AppId, space.TenancyId, string(treeID), string(node))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, node := range report.DanglingMutations {
+		_, err = tx.Exec(`
+			DELETE FROM grove_mutations
+			WHERE app_id=$1 AND tenancy_id=$2 AND tree_id=$3 AND node_id=$4`,
+			space.AppId, space.TenancyId, string(treeID), string(node))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	report.Repaired = true
+	return report, nil
+}
+
+// inspectTree loads a tree's parent pointers and closure rows and compares them.
+// Depth is derived from the closure table here, so DepthMismatches is always empty.
+func inspectTree(tx *sql.Tx, space store_interface.TenancySpace, treeID store_interface.TreeID) (*store_interface.TreeShape, *store_interface.TreeIntegrityReport, error) {
+	rows, err := tx.Query(`
+		SELECT node_id, parent_id FROM grove_nodes
+		WHERE app_id=$1 AND tenancy_id=$2 AND tree_id=$3 AND is_deleted=FALSE`,
+		space.AppId, space.TenancyId, string(treeID))
+	if err != nil {
+		return nil, nil, err
+	}
+	parents := make(map[store_interface.NodeID]*store_interface.NodeID)
+	for rows.Next() {
+		var id string
+		var parentIDStr *string
+		if err := rows.Scan(&id, &parentIDStr); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		var parent *store_interface.NodeID
+		if parentIDStr != nil {
+			p := store_interface.NodeID(*parentIDStr)
+			parent = &p
+		}
+		parents[store_interface.NodeID(id)] = parent
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = tx.Query(`
+		SELECT ancestor_id, descendant_id, depth FROM grove_closure
+		WHERE app_id=$1 AND tenancy_id=$2 AND tree_id=$3`,
+		space.AppId, space.TenancyId, string(treeID))
+	if err != nil {
+		return nil, nil, err
+	}
+	var stored []store_interface.ClosurePair
+	for rows.Next() {
+		var ancestor, descendant string
+		var depth int
+		if err := rows.Scan(&ancestor, &descendant, &depth); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		stored = append(stored, store_interface.ClosurePair{
+			Ancestor:   store_interface.NodeID(ancestor),
+			Descendant: store_interface.NodeID(descendant),
+			Depth:      depth,
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	shape := store_interface.BuildTreeShape(parents)
+	report := shape.NewIntegrityReport(treeID, stored)
+
+	// Soft-deleted nodes keep their rows, so only rows with no node at all are dangling
+	report.DanglingAggregates, err = danglingNodeIDs(tx, "grove_aggregates", space, treeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	report.DanglingMutations, err = danglingNodeIDs(tx, "grove_mutations", space, treeID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return shape, report, nil
+}
+
+// danglingNodeIDs lists node IDs referenced by table that have no grove_nodes row.
+// table is always one of the grove side tables, never user input.
+func danglingNodeIDs(tx *sql.Tx, table string, space store_interface.TenancySpace, treeID store_interface.TreeID) ([]store_interface.NodeID, error) {
+	rows, err := tx.Query(`
+		SELECT DISTINCT t.node_id FROM `+table+` t
+		WHERE t.app_id=$1 AND t.tenancy_id=$2 AND t.tree_id=$3
+		AND NOT EXISTS (
+			SELECT 1 FROM grove_nodes n
+			WHERE n.app_id = t.app_id AND n.tenancy_id = t.tenancy_id AND n.tree_id = t.tree_id AND n.node_id = t.node_id
+		)
+		ORDER BY t.node_id`,
+		space.AppId, space.TenancyId, string(treeID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []store_interface.NodeID
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, store_interface.NodeID(id))
+	}
+	return result, rows.Err()
+}
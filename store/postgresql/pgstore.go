@@ -59,23 +59,168 @@ func (s *PostgreSQLStore) initSchema() error {
 			key TEXT,
 			value BIGINT,
 			tag BIGINT,
+			tags TEXT,
 			metric DOUBLE PRECISION,
+			expires_at BIGINT,
 			PRIMARY KEY (app_id, tenancy_id, bucket_id, key)
 		);`,
 
+		// Track tables created before keys could expire, or carry further tags, lack the columns
+		`ALTER TABLE track ADD COLUMN IF NOT EXISTS expires_at BIGINT;`,
+
+		`ALTER TABLE track ADD COLUMN IF NOT EXISTS tags TEXT;`,
+
+		`CREATE INDEX IF NOT EXISTS track_expires_idx
+		 ON track(expires_at) WHERE expires_at IS NOT NULL;`,
+
 		`CREATE INDEX IF NOT EXISTS track_prefix_idx
 		 ON track(app_id, tenancy_id, bucket_id, key);`,
 
+		// Byte-ordered keys, for LIKE prefix scans and paging in a stable order whatever the database collation
+		`CREATE INDEX IF NOT EXISTS track_key_c_idx
+		 ON track(app_id, tenancy_id, bucket_id, key COLLATE "C");`,
+
+		// Top-K queries ordered by metric
+		`CREATE INDEX IF NOT EXISTS track_metric_idx
+		 ON track(app_id, tenancy_id, bucket_id, metric);`,
+
+		// Top-K queries ordered by value, and reverse lookups of keys by value in key order
+		`CREATE INDEX IF NOT EXISTS track_value_key_idx
+		 ON track(app_id, tenancy_id, bucket_id, value, key COLLATE "C");`,
+
+		// Superseded by track_value_key_idx
+		`DROP INDEX IF EXISTS track_value_idx;`,
+
+		// Every row's tag set, its tag and the JSON array in tags, for tag filters to join
+		// against. A database from before the table existed is backfilled from the tag column.
+		`DO $$
+		BEGIN
+			IF to_regclass('track_tags') IS NULL THEN
+				CREATE TABLE track_tags (
+					app_id INTEGER,
+					tenancy_id BIGINT,
+					bucket_id INTEGER,
+					key TEXT,
+					tag BIGINT,
+					PRIMARY KEY (app_id, tenancy_id, bucket_id, key, tag)
+				);
+				INSERT INTO track_tags (app_id, tenancy_id, bucket_id, key, tag)
+				SELECT app_id, tenancy_id, bucket_id, key, tag FROM track WHERE tag IS NOT NULL;
+			END IF;
+		END
+		$$;`,
+
+		`CREATE INDEX IF NOT EXISTS track_tags_tag_idx
+		 ON track_tags(app_id, tenancy_id, bucket_id, tag, key);`,
+
+		`CREATE OR REPLACE FUNCTION track_sync_tags() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP <> 'INSERT' THEN
+				DELETE FROM track_tags
+				WHERE app_id = OLD.app_id AND tenancy_id = OLD.tenancy_id AND bucket_id = OLD.bucket_id AND key = OLD.key;
+			END IF;
+			IF TG_OP <> 'DELETE' THEN
+				INSERT INTO track_tags (app_id, tenancy_id, bucket_id, key, tag)
+				SELECT NEW.app_id, NEW.tenancy_id, NEW.bucket_id, NEW.key, t.tag FROM (
+					SELECT NEW.tag AS tag WHERE NEW.tag IS NOT NULL
+					UNION SELECT jsonb_array_elements_text(COALESCE(NEW.tags, '[]')::jsonb)::BIGINT
+				) t
+				ON CONFLICT DO NOTHING;
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;`,
+
+		`DROP TRIGGER IF EXISTS track_tags_trigger ON track;`,
+
+		`CREATE TRIGGER track_tags_trigger
+		 AFTER INSERT OR UPDATE OF tag, tags OR DELETE ON track
+		 FOR EACH ROW EXECUTE FUNCTION track_sync_tags();`,
+
+		// Per-bucket history: a policy row opts a bucket in, and the trigger below copies a
+		// row's live value into track_history whenever an update or delete replaces it
+		`CREATE TABLE IF NOT EXISTS track_history_policy (
+			app_id INTEGER,
+			tenancy_id BIGINT,
+			bucket_id INTEGER,
+			max_versions INTEGER NOT NULL,
+			max_age_ms BIGINT NOT NULL,
+			PRIMARY KEY (app_id, tenancy_id, bucket_id)
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS track_history (
+			id BIGSERIAL PRIMARY KEY,
+			app_id INTEGER NOT NULL,
+			tenancy_id BIGINT NOT NULL,
+			bucket_id INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			value BIGINT,
+			tag BIGINT,
+			tags TEXT,
+			metric DOUBLE PRECISION,
+			expires_at BIGINT,
+			replaced_at BIGINT NOT NULL
+		);`,
+
+		`ALTER TABLE track_history ADD COLUMN IF NOT EXISTS tags TEXT;`,
+
+		`CREATE INDEX IF NOT EXISTS track_history_key_idx
+		 ON track_history(app_id, tenancy_id, bucket_id, key, id);`,
+
+		`CREATE OR REPLACE FUNCTION track_record_history() RETURNS trigger AS $$
+		DECLARE
+			now_ms BIGINT := (EXTRACT(EPOCH FROM clock_timestamp()) * 1000)::BIGINT;
+		BEGIN
+			IF (OLD.expires_at IS NULL OR OLD.expires_at > now_ms) AND EXISTS (
+				SELECT 1 FROM track_history_policy p
+				WHERE p.app_id = OLD.app_id AND p.tenancy_id = OLD.tenancy_id AND p.bucket_id = OLD.bucket_id
+			) THEN
+				INSERT INTO track_history (app_id, tenancy_id, bucket_id, key, value, tag, tags, metric, expires_at, replaced_at)
+				VALUES (OLD.app_id, OLD.tenancy_id, OLD.bucket_id, OLD.key, OLD.value, OLD.tag, OLD.tags, OLD.metric, OLD.expires_at, now_ms);
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;`,
+
+		`DROP TRIGGER IF EXISTS track_history_trigger ON track;`,
+
+		`CREATE TRIGGER track_history_trigger
+		 AFTER UPDATE OR DELETE ON track
+		 FOR EACH ROW EXECUTE FUNCTION track_record_history();`,
+
 		`CREATE TABLE IF NOT EXISTS depot (
 			id BIGSERIAL PRIMARY KEY,
 			app_id INTEGER NOT NULL,
 			tenancy_id BIGINT NOT NULL,
 			bucket_id INTEGER NOT NULL,
-			value TEXT NOT NULL
+			value TEXT NOT NULL,
+			revision BIGINT NOT NULL DEFAULT 1,
+			content_type TEXT,
+			data BYTEA
 		);`,
 
-		`CREATE INDEX IF NOT EXISTS depot_space_bucket_idx
-		 ON depot(app_id, tenancy_id, bucket_id);`,
+		// Depot tables created before items had revisions lack the column
+		`ALTER TABLE depot ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 1;`,
+
+		// Or raw values, which keep their bytes in data with content_type set
+		`ALTER TABLE depot ADD COLUMN IF NOT EXISTS content_type TEXT;`,
+		`ALTER TABLE depot ADD COLUMN IF NOT EXISTS data BYTEA;`,
+
+		// Ends in id so that bucket pages are read in order; it replaces depot_space_bucket_idx
+		`CREATE INDEX IF NOT EXISTS depot_space_bucket_id_idx
+		 ON depot(app_id, tenancy_id, bucket_id, id);`,
+
+		`DROP INDEX IF EXISTS depot_space_bucket_idx;`,
+
+		// Depot values need not be JSON; queries read them through this, which gives NULL
+		// rather than failing for those that are not
+		`CREATE OR REPLACE FUNCTION depot_jsonb(v TEXT) RETURNS jsonb AS $$
+		BEGIN
+			RETURN v::jsonb;
+		EXCEPTION WHEN invalid_text_representation OR untranslatable_character THEN
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql IMMUTABLE;`,
 
 		`CREATE TABLE IF NOT EXISTS grove_nodes (
 			app_id INTEGER,
@@ -126,6 +271,31 @@ func (s *PostgreSQLStore) initSchema() error {
 
 		`CREATE INDEX IF NOT EXISTS grove_aggregates_key_idx
 		 ON grove_aggregates(app_id, tenancy_id, tree_id, aggregate_key);`,
+
+		`CREATE TABLE IF NOT EXISTS grove_change_seqs (
+			app_id INTEGER,
+			tenancy_id BIGINT,
+			tree_id TEXT,
+			last_seq BIGINT,
+			PRIMARY KEY (app_id, tenancy_id, tree_id)
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS grove_changes (
+			app_id INTEGER,
+			tenancy_id BIGINT,
+			tree_id TEXT,
+			seq BIGINT,
+			kind TEXT,
+			node_id TEXT,
+			parent_id TEXT,
+			position DOUBLE PRECISION,
+			metadata TEXT,
+			mutation_id TEXT,
+			deltas TEXT,
+			soft BOOLEAN DEFAULT FALSE,
+			created_at BIGINT,
+			PRIMARY KEY (app_id, tenancy_id, tree_id, seq)
+		);`,
 	}
 
 	for _, stmt := range schema {
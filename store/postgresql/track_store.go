@@ -4,7 +4,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/store_interface"
@@ -20,7 +22,8 @@ func (s *PostgreSQLStore) TrackGet(
 	err := s.db.QueryRow(`
 		SELECT value FROM track
 		WHERE app_id=$1 AND tenancy_id=$2 AND bucket_id=$3 AND key=$4
-	`, space.AppId, space.TenancyId, bucketID, key).Scan(&value)
+		  AND (expires_at IS NULL OR expires_at > $5)
+	`, space.AppId, space.TenancyId, bucketID, key, time.Now().UnixMilli()).Scan(&value)
 
 	if errors.Is(err, sql.ErrNoRows) {
 		return 0, errors.New("not found")
@@ -28,81 +31,182 @@ func (s *PostgreSQLStore) TrackGet(
 	return value, err
 }
 
+func (s *PostgreSQLStore) TrackGetValue(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	key string,
+) (model.TrackValue, error) {
+
+	var value model.TrackValue
+	var tags *string
+	var expiresAt *int64
+	err := s.db.QueryRow(`
+		SELECT value, tag, tags, metric, expires_at FROM track
+		WHERE app_id=$1 AND tenancy_id=$2 AND bucket_id=$3 AND key=$4
+		  AND (expires_at IS NULL OR expires_at > $5)
+	`, space.AppId, space.TenancyId, bucketID, key, time.Now().UnixMilli()).Scan(&value.Value, &value.Tag, &tags, &value.Metric, &expiresAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.TrackValue{}, store_interface.ErrTrackKeyNotFound
+	}
+	if err != nil {
+		return model.TrackValue{}, err
+	}
+	if value.Tags, err = store_interface.TrackTagsFromJSON(tags); err != nil {
+		return model.TrackValue{}, err
+	}
+	value.ExpiresAt = store_interface.ExpiryFromMillis(expiresAt)
+	return value, nil
+}
+
 func (s *PostgreSQLStore) GetItemsByKeyPrefix(
 	space store_interface.TenancySpace,
 	bucketID int32,
 	prefix string,
 	tags []int64,
-	metricValue *float64,
-	metricIsGt bool,
+	metric *store_interface.MetricFilter,
 ) ([]model.TrackKeyValueItem, error) {
 
-	// PostgreSQL rejects U+FFFF (a Unicode noncharacter) so we use LIKE for prefix matching.
-	query := `
-		SELECT key, value, tag, metric
-		FROM track
-		WHERE app_id=$1 AND tenancy_id=$2 AND bucket_id=$3
-		  AND key LIKE $4
-	`
-	args := []any{space.AppId, space.TenancyId, bucketID, pgLikePrefix(prefix)}
-	argIdx := 5
+	return s.GetItemsByKeyPrefixes(space, bucketID, []string{prefix}, tags, metric)
+}
 
-	if len(tags) > 0 {
-		query += " AND tag IN (" + placeholders(argIdx, len(tags)) + ")"
-		argIdx += len(tags)
-		for _, t := range tags {
-			args = append(args, t)
+func (s *PostgreSQLStore) GetItemsByKeyPrefixes(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+) ([]model.TrackKeyValueItem, error) {
+
+	items, _, err := s.GetItemsByKeyPrefixesPage(space, bucketID, prefixes, tags, metric, store_interface.TrackQueryOptions{})
+	return items, err
+}
+
+func (s *PostgreSQLStore) GetItemsByKeyPrefixesPage(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, "", err
 		}
 	}
 
-	if metricValue != nil {
-		if metricIsGt {
-			query += fmt.Sprintf(" AND metric > $%d", argIdx)
-		} else {
-			query += fmt.Sprintf(" AND metric < $%d", argIdx)
+	if len(prefixes) == 0 {
+		return nil, "", nil
+	}
+
+	return s.queryTrackItems(space, bucketID, func(placeholder func() string) ([]string, []any) {
+		condition := "("
+		var args []any
+		for i, p := range prefixes {
+			if i > 0 {
+				condition += " OR "
+			}
+			condition += "key LIKE " + placeholder()
+			args = append(args, pgLikePrefix(p))
+		}
+		return []string{condition + ")"}, args
+	}, tags, metric, opts)
+}
+
+func (s *PostgreSQLStore) GetItemsByKeyRange(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	keyRange store_interface.TrackKeyRange,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	if err := keyRange.Validate(); err != nil {
+		return nil, "", err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, "", err
 		}
-		args = append(args, *metricValue)
 	}
 
-	rows, err := s.db.Query(query, args...)
-	if err != nil {
-		return nil, err
+	return s.queryTrackItems(space, bucketID, func(placeholder func() string) ([]string, []any) {
+		return keyRange.SQLConditions(`key COLLATE "C"`, placeholder)
+	}, tags, metric, opts)
+}
+
+func (s *PostgreSQLStore) GetKeysByValue(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	values []int64,
+	prefix string,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+
+	if err := store_interface.ValidateKeysByValue(values, opts); err != nil {
+		return nil, "", err
 	}
-	defer rows.Close()
 
-	var out []model.TrackKeyValueItem
-	for rows.Next() {
-		var item model.TrackKeyValueItem
-		if err := rows.Scan(&item.Key, &item.Value.Value, &item.Value.Tag, &item.Value.Metric); err != nil {
-			return nil, err
+	return s.queryTrackItems(space, bucketID, func(placeholder func() string) ([]string, []any) {
+		condition := "value IN ("
+		var args []any
+		for i, v := range values {
+			if i > 0 {
+				condition += ","
+			}
+			condition += placeholder()
+			args = append(args, v)
 		}
-		out = append(out, item)
-	}
-	return out, nil
+		conditions := []string{condition + ")"}
+		if prefix != "" {
+			conditions = append(conditions, "key LIKE "+placeholder())
+			args = append(args, pgLikePrefix(prefix))
+		}
+		return conditions, args
+	}, nil, nil, opts)
 }
 
-func (s *PostgreSQLStore) GetItemsByKeyPrefixes(
+func (s *PostgreSQLStore) TrackAggregate(
 	space store_interface.TenancySpace,
 	bucketID int32,
 	prefixes []string,
 	tags []int64,
-	metricValue *float64,
-	metricIsGt bool,
-) ([]model.TrackKeyValueItem, error) {
+	metric *store_interface.MetricFilter,
+	groupByTag bool,
+) ([]model.TrackAggregateGroup, error) {
 
 	if len(prefixes) == 0 {
-		return nil, nil
+		return nil, fmt.Errorf("%w: at least one prefix is required", store_interface.ErrInvalidQueryOptions)
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, err
+		}
 	}
 
+	tagColumn := "NULL::BIGINT"
+	if groupByTag {
+		tagColumn = "tag"
+	}
+	// One query with ORed prefixes counts each row once, so overlapping prefixes need no special care
 	query := `
-		SELECT key, value, tag, metric
+		SELECT ` + tagColumn + `, COUNT(*), COALESCE(SUM(value), 0)::BIGINT, MIN(value), MAX(value),
+		       COUNT(metric), COALESCE(SUM(metric), 0), MIN(metric), MAX(metric)
 		FROM track
 		WHERE app_id=$1 AND tenancy_id=$2 AND bucket_id=$3
-		  AND (
-	`
-	args := []any{space.AppId, space.TenancyId, bucketID}
-	argIdx := 4
-
+		  AND (expires_at IS NULL OR expires_at > $4)
+		  AND (`
+	args := []any{space.AppId, space.TenancyId, bucketID, time.Now().UnixMilli()}
+	argIdx := 5
 	for i, p := range prefixes {
 		if i > 0 {
 			query += " OR "
@@ -114,37 +218,243 @@ func (s *PostgreSQLStore) GetItemsByKeyPrefixes(
 	query += ")"
 
 	if len(tags) > 0 {
-		query += " AND tag IN (" + placeholders(argIdx, len(tags)) + ")"
-		argIdx += len(tags)
-		for _, t := range tags {
-			args = append(args, t)
+		condition, tagArgs := tagCondition(tags, store_interface.TrackTagMatchAny, func() string {
+			argIdx++
+			return fmt.Sprintf("$%d", argIdx-1)
+		})
+		query += " AND " + condition
+		args = append(args, tagArgs...)
+	}
+	if metric != nil {
+		condition, metricArgs := metric.SQLCondition("metric", func() string {
+			argIdx++
+			return fmt.Sprintf("$%d", argIdx-1)
+		})
+		query += " AND " + condition
+		args = append(args, metricArgs...)
+	}
+	if groupByTag {
+		query += " GROUP BY tag"
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agg := store_interface.NewTrackAggregator(groupByTag)
+	for rows.Next() {
+		var g model.TrackAggregateGroup
+		if err := rows.Scan(&g.Tag, &g.Count, &g.Value.Sum, &g.Value.Min, &g.Value.Max,
+			&g.Metric.Count, &g.Metric.Sum, &g.Metric.Min, &g.Metric.Max); err != nil {
+			return nil, err
+		}
+		agg.Merge(g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return agg.Groups(), nil
+}
+
+func (s *PostgreSQLStore) ListTrackBuckets(space store_interface.TenancySpace) ([]int32, error) {
+	rows, err := s.db.Query(`
+		SELECT DISTINCT bucket_id
+		FROM track
+		WHERE app_id=$1 AND tenancy_id=$2 AND (expires_at IS NULL OR expires_at > $3)
+		ORDER BY bucket_id`,
+		space.AppId, space.TenancyId, time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []int32
+	for rows.Next() {
+		var bucketID int32
+		if err := rows.Scan(&bucketID); err != nil {
+			return nil, err
 		}
+		out = append(out, bucketID)
+	}
+	return out, rows.Err()
+}
+
+// TrackBucketStats computes the stats in one query; the byte estimate matches
+// store_interface.TrackEntryBytes.
+func (s *PostgreSQLStore) TrackBucketStats(space store_interface.TenancySpace, bucketID int32) (model.TrackBucketStats, error) {
+	var partial model.TrackBucketStats
+	var minKey, maxKey *string
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), MIN(key COLLATE "C"), MAX(key COLLATE "C"),
+		       COALESCE(SUM(value), 0)::BIGINT, MIN(value), MAX(value),
+		       COUNT(metric), COALESCE(SUM(metric), 0), MIN(metric), MAX(metric),
+		       COALESCE(SUM(OCTET_LENGTH(key) + 8
+		           + CASE WHEN tag IS NULL THEN 0 ELSE 8 END
+		           + 8 * COALESCE(jsonb_array_length(tags::jsonb), 0)
+		           + CASE WHEN metric IS NULL THEN 0 ELSE 8 END
+		           + CASE WHEN expires_at IS NULL THEN 0 ELSE 8 END), 0)::BIGINT
+		FROM track
+		WHERE app_id=$1 AND tenancy_id=$2 AND bucket_id=$3 AND (expires_at IS NULL OR expires_at > $4)`,
+		space.AppId, space.TenancyId, bucketID, time.Now().UnixMilli(),
+	).Scan(&partial.KeyCount, &minKey, &maxKey,
+		&partial.Value.Sum, &partial.Value.Min, &partial.Value.Max,
+		&partial.Metric.Count, &partial.Metric.Sum, &partial.Metric.Min, &partial.Metric.Max,
+		&partial.ApproxBytes)
+	if err != nil {
+		return model.TrackBucketStats{}, err
+	}
+	if minKey != nil {
+		partial.MinKey, partial.MaxKey = *minKey, *maxKey
+	}
+
+	stats := store_interface.NewTrackBucketStatsBuilder(bucketID)
+	stats.Merge(partial)
+	return stats.Stats()
+}
+
+func (s *PostgreSQLStore) TrackScanBucket(space store_interface.TenancySpace, bucketID int32, fn func(model.TrackKeyValueItem) error) error {
+	rows, err := s.db.Query(`
+		SELECT key, value, tag, tags, metric, expires_at
+		FROM track
+		WHERE app_id=$1 AND tenancy_id=$2 AND bucket_id=$3 AND (expires_at IS NULL OR expires_at > $4)
+		ORDER BY key COLLATE "C"`,
+		space.AppId, space.TenancyId, bucketID, time.Now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scanTrackItem(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// queryTrackItems reads one page of a bucket's items. keyConditions renders the key
+// restrictions, numbering its parameters with placeholder.
+func (s *PostgreSQLStore) queryTrackItems(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	keyConditions func(placeholder func() string) ([]string, []any),
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+
+	query := `
+		SELECT key, value, tag, tags, metric, expires_at
+		FROM track
+		WHERE app_id=$1 AND tenancy_id=$2 AND bucket_id=$3
+		  AND (expires_at IS NULL OR expires_at > $4)`
+	args := []any{space.AppId, space.TenancyId, bucketID, time.Now().UnixMilli()}
+	argIdx := 5
+
+	conditions, keyArgs := keyConditions(func() string {
+		argIdx++
+		return fmt.Sprintf("$%d", argIdx-1)
+	})
+	for _, condition := range conditions {
+		query += " AND " + condition
+	}
+	args = append(args, keyArgs...)
+
+	if len(tags) > 0 {
+		condition, tagArgs := tagCondition(tags, opts.TagMatch, func() string {
+			argIdx++
+			return fmt.Sprintf("$%d", argIdx-1)
+		})
+		query += " AND " + condition
+		args = append(args, tagArgs...)
 	}
 
-	if metricValue != nil {
-		if metricIsGt {
-			query += fmt.Sprintf(" AND metric > $%d", argIdx)
+	if metric != nil {
+		condition, metricArgs := metric.SQLCondition("metric", func() string {
+			argIdx++
+			return fmt.Sprintf("$%d", argIdx-1)
+		})
+		query += " AND " + condition
+		args = append(args, metricArgs...)
+	}
+
+	// Compare and order with the C collation so pages follow byte order, as in the other backends
+	if opts.StartAfter != "" {
+		if opts.Descending {
+			query += fmt.Sprintf(" AND key COLLATE \"C\" < $%d", argIdx)
 		} else {
-			query += fmt.Sprintf(" AND metric < $%d", argIdx)
+			query += fmt.Sprintf(" AND key COLLATE \"C\" > $%d", argIdx)
 		}
-		args = append(args, *metricValue)
+		argIdx++
+		args = append(args, opts.StartAfter)
+	}
+	if opts.OrderBy == store_interface.TrackOrderByMetric {
+		query += " AND metric IS NOT NULL"
+	}
+	query += opts.SQLOrderBy(`key COLLATE "C"`)
+	if fetch := opts.FetchLimit(); fetch > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argIdx)
+		args = append(args, fetch)
 	}
 
 	rows, err := s.db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
 	var out []model.TrackKeyValueItem
 	for rows.Next() {
-		var item model.TrackKeyValueItem
-		if err := rows.Scan(&item.Key, &item.Value.Value, &item.Value.Tag, &item.Value.Metric); err != nil {
-			return nil, err
+		item, err := scanTrackItem(rows)
+		if err != nil {
+			return nil, "", err
 		}
 		out = append(out, item)
 	}
-	return out, nil
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	page, next := opts.PageItems(out)
+	return page, next, nil
+}
+
+// scanTrackItem reads a row of key, value, tag, tags, metric and expires_at.
+func scanTrackItem(rows *sql.Rows) (model.TrackKeyValueItem, error) {
+	var item model.TrackKeyValueItem
+	var tags *string
+	var expiresAt *int64
+	if err := rows.Scan(&item.Key, &item.Value.Value, &item.Value.Tag, &tags, &item.Value.Metric, &expiresAt); err != nil {
+		return model.TrackKeyValueItem{}, err
+	}
+	var err error
+	if item.Value.Tags, err = store_interface.TrackTagsFromJSON(tags); err != nil {
+		return model.TrackKeyValueItem{}, err
+	}
+	item.Value.ExpiresAt = store_interface.ExpiryFromMillis(expiresAt)
+	return item, nil
+}
+
+// tagCondition matches rows whose tag set in track_tags holds any of the tags, or all
+// of them for store_interface.TrackTagMatchAll. The tags are bound as one JSON array.
+func tagCondition(tags []int64, match store_interface.TrackTagMatch, placeholder func() string) (string, []any) {
+	tags = slices.Compact(slices.Sorted(slices.Values(tags)))
+	matches := `
+		FROM track_tags tt
+		WHERE tt.app_id = track.app_id AND tt.tenancy_id = track.tenancy_id
+		  AND tt.bucket_id = track.bucket_id AND tt.key = track.key
+		  AND tt.tag IN (SELECT jsonb_array_elements_text(` + placeholder() + `::jsonb)::BIGINT)`
+	args := []any{store_interface.TrackTagsToJSON(tags)}
+	if match == store_interface.TrackTagMatchAll {
+		return "(SELECT COUNT(*)" + matches + ") = " + placeholder(), append(args, len(tags))
+	}
+	return "EXISTS (SELECT 1" + matches + ")", args
 }
 
 // pgLikePrefix converts a plain prefix string into a LIKE pattern by escaping
@@ -187,6 +497,68 @@ func (s *PostgreSQLStore) TrackDeleteMany(
 	return tx.Commit()
 }
 
+func (s *PostgreSQLStore) TrackDeleteByPrefix(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+) (int, error) {
+
+	if len(prefixes) == 0 {
+		return 0, fmt.Errorf("%w: at least one prefix is required", store_interface.ErrInvalidQueryOptions)
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return 0, err
+		}
+	}
+
+	// One statement with ORed prefixes deletes each row once, however the prefixes overlap
+	query := `
+		DELETE FROM track
+		WHERE app_id=$1 AND tenancy_id=$2 AND bucket_id=$3
+		  AND (`
+	args := []any{space.AppId, space.TenancyId, bucketID}
+	argIdx := 4
+	for i, p := range prefixes {
+		if i > 0 {
+			query += " OR "
+		}
+		query += fmt.Sprintf("key LIKE $%d", argIdx)
+		argIdx++
+		args = append(args, pgLikePrefix(p))
+	}
+	query += ")"
+
+	if len(tags) > 0 {
+		condition, tagArgs := tagCondition(tags, store_interface.TrackTagMatchAny, func() string {
+			argIdx++
+			return fmt.Sprintf("$%d", argIdx-1)
+		})
+		query += " AND " + condition
+		args = append(args, tagArgs...)
+	}
+	if metric != nil {
+		condition, metricArgs := metric.SQLCondition("metric", func() string {
+			argIdx++
+			return fmt.Sprintf("$%d", argIdx-1)
+		})
+		query += " AND " + condition
+		args = append(args, metricArgs...)
+	}
+
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
 func (s *PostgreSQLStore) TrackPutMany(
 	space store_interface.TenancySpace,
 	items map[int32][]model.TrackKeyValueItem,
@@ -199,13 +571,15 @@ func (s *PostgreSQLStore) TrackPutMany(
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`
-		INSERT INTO track (app_id, tenancy_id, bucket_id, key, value, tag, metric)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO track (app_id, tenancy_id, bucket_id, key, value, tag, tags, metric, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		ON CONFLICT(app_id, tenancy_id, bucket_id, key)
 		DO UPDATE SET
-			value  = excluded.value,
-			tag    = excluded.tag,
-			metric = excluded.metric
+			value      = excluded.value,
+			tag        = excluded.tag,
+			tags       = excluded.tags,
+			metric     = excluded.metric,
+			expires_at = excluded.expires_at
 	`)
 	if err != nil {
 		return err
@@ -216,7 +590,8 @@ func (s *PostgreSQLStore) TrackPutMany(
 		for _, item := range bucketItems {
 			if _, err := stmt.Exec(
 				space.AppId, space.TenancyId, bucketID,
-				item.Key, item.Value.Value, item.Value.Tag, item.Value.Metric,
+				item.Key, item.Value.Value, item.Value.Tag, store_interface.TrackTagsToJSON(item.Value.Tags), item.Value.Metric,
+				store_interface.ExpiryMillis(item.Value.ExpiresAt),
 			); err != nil {
 				return err
 			}
@@ -225,6 +600,95 @@ func (s *PostgreSQLStore) TrackPutMany(
 	return tx.Commit()
 }
 
+// TrackPutManyIf inserts with a conflict clause that only replaces expired rows for
+// if_absent, and otherwise updates live rows in place, so each item is one statement.
+func (s *PostgreSQLStore) TrackPutManyIf(
+	space store_interface.TenancySpace,
+	items map[int32][]model.TrackKeyValueItem,
+	cond store_interface.TrackPutCondition,
+) (model.TrackPutManyResult, error) {
+	if err := cond.Validate(); err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+
+	var query string
+	switch cond.Mode {
+	case store_interface.TrackPutIfAbsent:
+		query = `
+			INSERT INTO track (app_id, tenancy_id, bucket_id, key, value, tag, tags, metric, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT(app_id, tenancy_id, bucket_id, key)
+			DO UPDATE SET
+				value      = excluded.value,
+				tag        = excluded.tag,
+				tags       = excluded.tags,
+				metric     = excluded.metric,
+				expires_at = excluded.expires_at
+			WHERE track.expires_at <= $10`
+	default:
+		query = `
+			UPDATE track
+			SET value = $1, tag = $2, tags = $3, metric = $4, expires_at = $5
+			WHERE app_id=$6 AND tenancy_id=$7 AND bucket_id=$8 AND key=$9
+			  AND (expires_at IS NULL OR expires_at > $10)`
+		if cond.Mode == store_interface.TrackPutIfTag {
+			query += " AND tag = $11"
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UnixMilli()
+	var result model.TrackPutManyResult
+	for _, bucketID := range store_interface.SortedTrackBuckets(items) {
+		for _, item := range items[bucketID] {
+			expiresAt := store_interface.ExpiryMillis(item.Value.ExpiresAt)
+			tags := store_interface.TrackTagsToJSON(item.Value.Tags)
+			var args []any
+			if cond.Mode == store_interface.TrackPutIfAbsent {
+				args = []any{space.AppId, space.TenancyId, bucketID, item.Key,
+					item.Value.Value, item.Value.Tag, tags, item.Value.Metric, expiresAt, now}
+			} else {
+				args = []any{item.Value.Value, item.Value.Tag, tags, item.Value.Metric, expiresAt,
+					space.AppId, space.TenancyId, bucketID, item.Key, now}
+				if cond.Mode == store_interface.TrackPutIfTag {
+					args = append(args, *cond.Tag)
+				}
+			}
+
+			res, err := stmt.Exec(args...)
+			if err != nil {
+				return model.TrackPutManyResult{}, err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return model.TrackPutManyResult{}, err
+			}
+			pair := model.TrackBucketKeyPair{BucketID: bucketID, Key: item.Key}
+			if n == 0 {
+				result.Rejected = append(result.Rejected, pair)
+			} else {
+				result.Written = append(result.Written, pair)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+	return result, nil
+}
+
 func (s *PostgreSQLStore) TrackGetMany(
 	space store_interface.TenancySpace,
 	keys map[int32][]string,
@@ -232,6 +696,7 @@ func (s *PostgreSQLStore) TrackGetMany(
 
 	values := make(map[int32]map[string]model.TrackValue)
 	missing := make(map[int32][]string)
+	now := time.Now().UnixMilli()
 
 	for bucketID, bucketKeys := range keys {
 		if len(bucketKeys) == 0 {
@@ -239,12 +704,13 @@ func (s *PostgreSQLStore) TrackGetMany(
 		}
 
 		query := `
-			SELECT key, value, tag, metric
+			SELECT key, value, tag, tags, metric, expires_at
 			FROM track
 			WHERE app_id=$1 AND tenancy_id=$2 AND bucket_id=$3
-			  AND key IN (` + placeholders(4, len(bucketKeys)) + `)`
+			  AND (expires_at IS NULL OR expires_at > $4)
+			  AND key IN (` + placeholders(5, len(bucketKeys)) + `)`
 
-		args := []any{space.AppId, space.TenancyId, bucketID}
+		args := []any{space.AppId, space.TenancyId, bucketID, now}
 		for _, k := range bucketKeys {
 			args = append(args, k)
 		}
@@ -260,14 +726,13 @@ func (s *PostgreSQLStore) TrackGetMany(
 		}
 
 		for rows.Next() {
-			var key string
-			var tv model.TrackValue
-			if err := rows.Scan(&key, &tv.Value, &tv.Tag, &tv.Metric); err != nil {
+			item, err := scanTrackItem(rows)
+			if err != nil {
 				rows.Close()
 				return nil, nil, err
 			}
-			values[bucketID][key] = tv
-			found[key] = struct{}{}
+			values[bucketID][item.Key] = item.Value
+			found[item.Key] = struct{}{}
 		}
 		rows.Close()
 
@@ -288,17 +753,130 @@ func (s *PostgreSQLStore) TrackPut(
 	value int64,
 	tag *int64,
 	metric *float64,
+	expiresAt *time.Time,
 ) error {
 
 	_, err := s.db.Exec(`
-		INSERT INTO track (app_id, tenancy_id, bucket_id, key, value, tag, metric)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO track (app_id, tenancy_id, bucket_id, key, value, tag, metric, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		ON CONFLICT(app_id, tenancy_id, bucket_id, key)
 		DO UPDATE SET
-			value  = excluded.value,
-			tag    = excluded.tag,
-			metric = excluded.metric
-	`, space.AppId, space.TenancyId, bucketID, key, value, tag, metric)
+			value      = excluded.value,
+			tag        = excluded.tag,
+			tags       = NULL,
+			metric     = excluded.metric,
+			expires_at = excluded.expires_at
+	`, space.AppId, space.TenancyId, bucketID, key, value, tag, metric, store_interface.ExpiryMillis(expiresAt))
 
 	return err
 }
+
+func (s *PostgreSQLStore) TrackIncrement(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	key string,
+	delta int64,
+) (model.TrackValue, error) {
+
+	// An expired row starts again from zero, without its tag, metric or expiry
+	var value model.TrackValue
+	var tags *string
+	var expiresAt *int64
+	err := s.db.QueryRow(`
+		INSERT INTO track (app_id, tenancy_id, bucket_id, key, value)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT(app_id, tenancy_id, bucket_id, key)
+		DO UPDATE SET
+			value      = CASE WHEN track.expires_at IS NULL OR track.expires_at > $6 THEN track.value + excluded.value ELSE excluded.value END,
+			tag        = CASE WHEN track.expires_at IS NULL OR track.expires_at > $6 THEN track.tag END,
+			tags       = CASE WHEN track.expires_at IS NULL OR track.expires_at > $6 THEN track.tags END,
+			metric     = CASE WHEN track.expires_at IS NULL OR track.expires_at > $6 THEN track.metric END,
+			expires_at = CASE WHEN track.expires_at IS NULL OR track.expires_at > $6 THEN track.expires_at END
+		RETURNING value, tag, tags, metric, expires_at
+	`, space.AppId, space.TenancyId, bucketID, key, delta, time.Now().UnixMilli()).Scan(&value.Value, &value.Tag, &tags, &value.Metric, &expiresAt)
+	if err != nil {
+		return model.TrackValue{}, err
+	}
+	if value.Tags, err = store_interface.TrackTagsFromJSON(tags); err != nil {
+		return model.TrackValue{}, err
+	}
+	value.ExpiresAt = store_interface.ExpiryFromMillis(expiresAt)
+	return value, nil
+}
+
+func (s *PostgreSQLStore) TrackCompareAndSwap(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	key string,
+	expected *int64,
+	value int64,
+	tag *int64,
+	metric *float64,
+) error {
+
+	var res sql.Result
+	var err error
+	if expected == nil {
+		// An expired row counts as absent and is replaced
+		res, err = s.db.Exec(`
+			INSERT INTO track (app_id, tenancy_id, bucket_id, key, value, tag, metric)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT(app_id, tenancy_id, bucket_id, key)
+			DO UPDATE SET
+				value      = excluded.value,
+				tag        = excluded.tag,
+				tags       = NULL,
+				metric     = excluded.metric,
+				expires_at = NULL
+			WHERE track.expires_at <= $8
+		`, space.AppId, space.TenancyId, bucketID, key, value, tag, metric, time.Now().UnixMilli())
+	} else {
+		res, err = s.db.Exec(`
+			UPDATE track
+			SET value = $1, tag = $2, tags = NULL, metric = $3, expires_at = NULL
+			WHERE app_id=$4 AND tenancy_id=$5 AND bucket_id=$6 AND key=$7 AND value=$8
+			  AND (expires_at IS NULL OR expires_at > $9)
+		`, value, tag, metric, space.AppId, space.TenancyId, bucketID, key, *expected, time.Now().UnixMilli())
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return store_interface.ErrTrackValueMismatch
+	}
+	return nil
+}
+
+func (s *PostgreSQLStore) TrackDeleteExpired(now time.Time, limit int) ([]store_interface.TrackExpiredKey, error) {
+	rows, err := s.db.Query(`
+		DELETE FROM track
+		WHERE ctid IN (
+			SELECT ctid FROM track
+			WHERE expires_at <= $1
+			LIMIT $2
+		)
+		RETURNING app_id, tenancy_id, bucket_id, key
+	`, now.UnixMilli(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTrackExpiredKeys(rows)
+}
+
+func scanTrackExpiredKeys(rows *sql.Rows) ([]store_interface.TrackExpiredKey, error) {
+	var deleted []store_interface.TrackExpiredKey
+	for rows.Next() {
+		var k store_interface.TrackExpiredKey
+		if err := rows.Scan(&k.Space.AppId, &k.Space.TenancyId, &k.BucketID, &k.Key); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, k)
+	}
+	return deleted, rows.Err()
+}
@@ -1,6 +1,9 @@
 package ram
 
 import (
+	"slices"
+
+	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/store_interface"
 )
 
@@ -15,14 +18,14 @@ func (m *RamStore) depotGenID(space store_interface.TenancySpace) int64 {
 	return m.depotNextIDs[space]
 }
 
-func (m *RamStore) DepotCreate(space store_interface.TenancySpace, bucketID int32, value string) (int64, error) {
+func (m *RamStore) DepotCreate(space store_interface.TenancySpace, bucketID int32, value string) (int64, int64, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	m.depotEnsureSpace(space)
 	id := m.depotGenID(space)
-	m.depots[space][id] = depotEntry{value: value, bucketID: bucketID}
-	return id, nil
+	m.depots[space][id] = depotEntry{value: value, bucketID: bucketID, revision: store_interface.DepotInitialRevision}
+	return id, store_interface.DepotInitialRevision, nil
 }
 
 func (m *RamStore) DepotCreateMany(space store_interface.TenancySpace, bucketID int32, values []string) ([]int64, error) {
@@ -33,24 +36,88 @@ func (m *RamStore) DepotCreateMany(space store_interface.TenancySpace, bucketID
 	ids := make([]int64, len(values))
 	for i, v := range values {
 		id := m.depotGenID(space)
-		m.depots[space][id] = depotEntry{value: v, bucketID: bucketID}
+		m.depots[space][id] = depotEntry{value: v, bucketID: bucketID, revision: store_interface.DepotInitialRevision}
 		ids[i] = id
 	}
 	return ids, nil
 }
 
+func (m *RamStore) DepotCreateRaw(space store_interface.TenancySpace, bucketID int32, data []byte, contentType string) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.depotEnsureSpace(space)
+	id := m.depotGenID(space)
+	m.depots[space][id] = depotEntry{
+		bucketID:    bucketID,
+		revision:    store_interface.DepotInitialRevision,
+		data:        slices.Clone(data),
+		contentType: store_interface.DepotRawContentType(contentType),
+	}
+	return id, store_interface.DepotInitialRevision, nil
+}
+
 func (m *RamStore) DepotUpdate(space store_interface.TenancySpace, id int64, value string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if spaceMap, ok := m.depots[space]; ok {
 		if entry, ok := spaceMap[id]; ok {
-			entry.value = value
+			entry.value, entry.data, entry.contentType = value, nil, ""
+			entry.revision++
 			spaceMap[id] = entry
 			return nil
 		}
 	}
-	return store_interface.ErrNodeNotFound
+	return store_interface.ErrDepotItemNotFound
+}
+
+func (m *RamStore) DepotUpdateIfRevision(space store_interface.TenancySpace, id int64, value string, revision int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.depots[space][id]
+	if !ok {
+		return 0, store_interface.ErrDepotItemNotFound
+	}
+	if entry.revision != revision {
+		return 0, &store_interface.DepotRevisionConflictError{ID: id, Expected: revision, Current: entry.revision}
+	}
+	entry.value, entry.data, entry.contentType = value, nil, ""
+	entry.revision++
+	m.depots[space][id] = entry
+	return entry.revision, nil
+}
+
+func (m *RamStore) DepotUpdateRaw(space store_interface.TenancySpace, id int64, data []byte, contentType string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.depots[space][id]
+	if !ok {
+		return 0, store_interface.ErrDepotItemNotFound
+	}
+	entry.value, entry.data, entry.contentType = "", slices.Clone(data), store_interface.DepotRawContentType(contentType)
+	entry.revision++
+	m.depots[space][id] = entry
+	return entry.revision, nil
+}
+
+func (m *RamStore) DepotUpdateRawIfRevision(space store_interface.TenancySpace, id int64, data []byte, contentType string, revision int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.depots[space][id]
+	if !ok {
+		return 0, store_interface.ErrDepotItemNotFound
+	}
+	if entry.revision != revision {
+		return 0, &store_interface.DepotRevisionConflictError{ID: id, Expected: revision, Current: entry.revision}
+	}
+	entry.value, entry.data, entry.contentType = "", slices.Clone(data), store_interface.DepotRawContentType(contentType)
+	entry.revision++
+	m.depots[space][id] = entry
+	return entry.revision, nil
 }
 
 func (m *RamStore) DepotGet(space store_interface.TenancySpace, id int64) (string, error) {
@@ -62,7 +129,33 @@ func (m *RamStore) DepotGet(space store_interface.TenancySpace, id int64) (strin
 			return entry.value, nil
 		}
 	}
-	return "", store_interface.ErrNodeNotFound
+	return "", store_interface.ErrDepotItemNotFound
+}
+
+func (m *RamStore) DepotGetItem(space store_interface.TenancySpace, id int64) (model.DepotItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.depots[space][id]
+	if !ok {
+		return model.DepotItem{}, store_interface.ErrDepotItemNotFound
+	}
+	return entry.item(id), nil
+}
+
+func (m *RamStore) DepotGetRaw(space store_interface.TenancySpace, id int64) (model.DepotRawItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.depots[space][id]
+	if !ok {
+		return model.DepotRawItem{}, store_interface.ErrDepotItemNotFound
+	}
+	return store_interface.DepotRawFromItem(entry.item(id), slices.Clone(entry.data)), nil
+}
+
+func (e depotEntry) item(id int64) model.DepotItem {
+	return model.DepotItem{ID: id, Value: e.value, Revision: e.revision, ContentType: e.contentType}
 }
 
 func (m *RamStore) DepotGetMany(space store_interface.TenancySpace, ids []int64) (map[int64]string, []int64, error) {
@@ -123,3 +216,25 @@ func (m *RamStore) DepotGetAllByBucket(space store_interface.TenancySpace, bucke
 	}
 	return result, nil
 }
+
+func (m *RamStore) DepotGetBucketPage(space store_interface.TenancySpace, bucketID int32, opts store_interface.DepotPageOptions) ([]model.DepotItem, int64, error) {
+	return m.DepotQuery(space, bucketID, nil, opts)
+}
+
+func (m *RamStore) DepotQuery(space store_interface.TenancySpace, bucketID int32, filters []store_interface.DepotFieldFilter, opts store_interface.DepotPageOptions) ([]model.DepotItem, int64, error) {
+	if err := store_interface.ValidateDepotQuery(filters, opts); err != nil {
+		return nil, 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []model.DepotItem
+	for id, entry := range m.depots[space] {
+		if entry.bucketID == bucketID && opts.After(id) && store_interface.DepotValueMatches(filters, entry.value) {
+			items = append(items, entry.item(id))
+		}
+	}
+	page, next := opts.PageItems(items)
+	return page, next, nil
+}
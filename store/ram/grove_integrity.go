@@ -0,0 +1,150 @@
+package ram
+
+import (
+	"sort"
+
+	"github.com/vixac/bullet/store/store_interface"
+)
+
+// VerifyTree reports inconsistencies between a tree's parent pointers and its derived data
+func (r *RamStore) VerifyTree(space store_interface.TenancySpace, treeID store_interface.TreeID) (*store_interface.TreeIntegrityReport, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, report := r.inspectTree(space, treeID)
+	return report, nil
+}
+
+// RepairTree rebuilds a tree's closure map, depths and children lists from its parent pointers
+func (r *RamStore) RepairTree(space store_interface.TenancySpace, treeID store_interface.TreeID) (*store_interface.TreeIntegrityReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shape, report := r.inspectTree(space, treeID)
+	r.ensureGroveTree(space, treeID)
+	nodes := r.groveNodes[space][treeID]
+
+	for id, nodeObj := range nodes {
+		nodeObj.parent = shape.Parents[id]
+		nodeObj.depth = shape.Depths[id]
+	}
+	for _, id := range shape.Detached {
+		r.recordChange(space, treeID, store_interface.GroveChange{
+			Kind:     store_interface.GroveChangeMove,
+			NodeID:   id,
+			Position: nodes[id].position,
+		})
+	}
+
+	closure := make(map[store_interface.NodeID]map[store_interface.NodeID]int, len(nodes))
+	for pair := range shape.Closure {
+		if closure[pair.Ancestor] == nil {
+			closure[pair.Ancestor] = make(map[store_interface.NodeID]int)
+		}
+		closure[pair.Ancestor][pair.Descendant] = pair.Depth
+	}
+	r.groveClosure[space][treeID] = closure
+
+	// Keep the existing sibling order where it is still valid, appending anything missing
+	children := make(map[store_interface.NodeID][]store_interface.NodeID)
+	listed := make(map[store_interface.NodeID]bool)
+	for parent, list := range r.groveChildren[space][treeID] {
+		for _, child := range list {
+			nodeObj, exists := nodes[child]
+			if !exists || listed[child] || nodeObj.parent == nil || *nodeObj.parent != parent {
+				continue
+			}
+			children[parent] = append(children[parent], child)
+			listed[child] = true
+		}
+	}
+	var unlisted []store_interface.NodeID
+	for id, nodeObj := range nodes {
+		if nodeObj.parent != nil && !listed[id] {
+			unlisted = append(unlisted, id)
+		}
+	}
+	sort.Slice(unlisted, func(i, j int) bool { return unlisted[i] < unlisted[j] })
+	for _, id := range unlisted {
+		parent := *nodes[id].parent
+		children[parent] = append(children[parent], id)
+	}
+	r.groveChildren[space][treeID] = children
+
+	for _, node := range report.DanglingAggregates {
+		delete(r.groveAggregates[space][treeID], node)
+	}
+	for _, node := range report.DanglingMutations {
+		delete(r.groveMutations[space][treeID], node)
+	}
+
+	report.Repaired = true
+	return report, nil
+}
+
+// inspectTree compares a tree's derived data with its parent pointers (must be called with lock held)
+func (r *RamStore) inspectTree(space store_interface.TenancySpace, treeID store_interface.TreeID) (*store_interface.TreeShape, *store_interface.TreeIntegrityReport) {
+	nodes := map[store_interface.NodeID]*nodeData{}
+	if r.groveNodes != nil && r.groveNodes[space] != nil && r.groveNodes[space][treeID] != nil {
+		nodes = r.groveNodes[space][treeID]
+	}
+
+	parents := make(map[store_interface.NodeID]*store_interface.NodeID, len(nodes))
+	for id, nodeObj := range nodes {
+		parents[id] = nodeObj.parent
+	}
+
+	var stored []store_interface.ClosurePair
+	if r.groveClosure != nil && r.groveClosure[space] != nil {
+		for ancestor, descendants := range r.groveClosure[space][treeID] {
+			for descendant, depth := range descendants {
+				stored = append(stored, store_interface.ClosurePair{Ancestor: ancestor, Descendant: descendant, Depth: depth})
+			}
+		}
+	}
+
+	shape := store_interface.BuildTreeShape(parents)
+	report := shape.NewIntegrityReport(treeID, stored)
+
+	for id, nodeObj := range nodes {
+		if nodeObj.depth != shape.Depths[id] {
+			report.DepthMismatches = append(report.DepthMismatches, id)
+		}
+	}
+	sortNodeIDs(report.DepthMismatches)
+
+	// Soft-deleted nodes can be restored, so their aggregates and markers are kept
+	known := func(id store_interface.NodeID) bool {
+		if _, exists := nodes[id]; exists {
+			return true
+		}
+		if r.groveDeletedNodes != nil && r.groveDeletedNodes[space] != nil {
+			if _, exists := r.groveDeletedNodes[space][treeID][id]; exists {
+				return true
+			}
+		}
+		return false
+	}
+	if r.groveAggregates != nil && r.groveAggregates[space] != nil {
+		for id, aggs := range r.groveAggregates[space][treeID] {
+			if len(aggs) > 0 && !known(id) {
+				report.DanglingAggregates = append(report.DanglingAggregates, id)
+			}
+		}
+	}
+	if r.groveMutations != nil && r.groveMutations[space] != nil {
+		for id, mutations := range r.groveMutations[space][treeID] {
+			if len(mutations) > 0 && !known(id) {
+				report.DanglingMutations = append(report.DanglingMutations, id)
+			}
+		}
+	}
+	sortNodeIDs(report.DanglingAggregates)
+	sortNodeIDs(report.DanglingMutations)
+
+	return shape, report
+}
+
+func sortNodeIDs(ids []store_interface.NodeID) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
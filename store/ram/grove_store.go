@@ -2,6 +2,8 @@ package ram
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/vixac/bullet/store/store_interface"
 )
@@ -26,34 +28,7 @@ func (r *RamStore) CreateNode(
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// Initialize maps if needed
-	if r.groveNodes == nil {
-		r.groveNodes = make(map[store_interface.TenancySpace]map[store_interface.TreeID]map[store_interface.NodeID]*nodeData)
-	}
-	if r.groveNodes[space] == nil {
-		r.groveNodes[space] = make(map[store_interface.TreeID]map[store_interface.NodeID]*nodeData)
-	}
-	if r.groveNodes[space][treeID] == nil {
-		r.groveNodes[space][treeID] = make(map[store_interface.NodeID]*nodeData)
-	}
-	if r.groveClosure == nil {
-		r.groveClosure = make(map[store_interface.TenancySpace]map[store_interface.TreeID]map[store_interface.NodeID]map[store_interface.NodeID]int)
-	}
-	if r.groveClosure[space] == nil {
-		r.groveClosure[space] = make(map[store_interface.TreeID]map[store_interface.NodeID]map[store_interface.NodeID]int)
-	}
-	if r.groveClosure[space][treeID] == nil {
-		r.groveClosure[space][treeID] = make(map[store_interface.NodeID]map[store_interface.NodeID]int)
-	}
-	if r.groveChildren == nil {
-		r.groveChildren = make(map[store_interface.TenancySpace]map[store_interface.TreeID]map[store_interface.NodeID][]store_interface.NodeID)
-	}
-	if r.groveChildren[space] == nil {
-		r.groveChildren[space] = make(map[store_interface.TreeID]map[store_interface.NodeID][]store_interface.NodeID)
-	}
-	if r.groveChildren[space][treeID] == nil {
-		r.groveChildren[space][treeID] = make(map[store_interface.NodeID][]store_interface.NodeID)
-	}
+	r.ensureGroveTree(space, treeID)
 
 	// Check if node already exists
 	if _, exists := r.groveNodes[space][treeID][node]; exists {
@@ -103,6 +78,14 @@ func (r *RamStore) CreateNode(
 		// TODO: respect position for ordering
 	}
 
+	r.recordChange(space, treeID, store_interface.GroveChange{
+		Kind:     store_interface.GroveChangeCreate,
+		NodeID:   node,
+		Parent:   parent,
+		Position: position,
+		Metadata: metadata,
+	})
+
 	return nil
 }
 
@@ -159,6 +142,12 @@ func (r *RamStore) DeleteNode(space store_interface.TenancySpace, treeID store_i
 	// Remove from nodes
 	delete(r.groveNodes[space][treeID], node)
 
+	r.recordChange(space, treeID, store_interface.GroveChange{
+		Kind:   store_interface.GroveChangeDelete,
+		NodeID: node,
+		Soft:   soft,
+	})
+
 	return nil
 }
 
@@ -281,6 +270,46 @@ func (r *RamStore) MoveNode(
 		// TODO: respect newPosition for ordering
 	}
 
+	r.recordChange(space, treeID, store_interface.GroveChange{
+		Kind:     store_interface.GroveChangeMove,
+		NodeID:   node,
+		Parent:   newParent,
+		Position: newPosition,
+	})
+
+	return nil
+}
+
+// UpdateNodeMetadata replaces a node's metadata
+func (r *RamStore) UpdateNodeMetadata(
+	space store_interface.TenancySpace,
+	treeID store_interface.TreeID,
+	node store_interface.NodeID,
+	metadata store_interface.NodeMetadata,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.groveNodes == nil || r.groveNodes[space] == nil || r.groveNodes[space][treeID] == nil {
+		return store_interface.ErrNodeNotFound
+	}
+	nodeObj, exists := r.groveNodes[space][treeID][node]
+	if !exists {
+		return store_interface.ErrNodeNotFound
+	}
+
+	copied := make(store_interface.NodeMetadata, len(metadata))
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	nodeObj.metadata = &copied
+
+	r.recordChange(space, treeID, store_interface.GroveChange{
+		Kind:     store_interface.GroveChangeMetadata,
+		NodeID:   node,
+		Metadata: &copied,
+	})
+
 	return nil
 }
 
@@ -577,6 +606,17 @@ func (r *RamStore) ApplyAggregateMutation(
 	// Mark mutation as applied
 	r.groveMutations[space][treeID][node][mutation] = true
 
+	recorded := make(store_interface.AggregateDeltas, len(deltas))
+	for key, delta := range deltas {
+		recorded[key] = delta
+	}
+	r.recordChange(space, treeID, store_interface.GroveChange{
+		Kind:       store_interface.GroveChangeMutation,
+		NodeID:     node,
+		MutationID: &mutation,
+		Deltas:     recorded,
+	})
+
 	return nil
 }
 
@@ -740,8 +780,361 @@ func (r *RamStore) GetNodeWithDescendantsAggregates(
 	return result, nil
 }
 
+// MoveSubtreeToTree moves a node and its descendants, with their aggregates and mutation history, to another tree
+func (r *RamStore) MoveSubtreeToTree(
+	space store_interface.TenancySpace,
+	srcTree store_interface.TreeID,
+	node store_interface.NodeID,
+	dstTree store_interface.TreeID,
+	dstParent *store_interface.NodeID,
+	position *store_interface.ChildPosition,
+) error {
+	if srcTree == dstTree {
+		return r.MoveNode(space, srcTree, node, dstParent, position)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.groveNodes == nil || r.groveNodes[space] == nil || r.groveNodes[space][srcTree] == nil {
+		return store_interface.ErrNodeNotFound
+	}
+	nodeObj, exists := r.groveNodes[space][srcTree][node]
+	if !exists {
+		return store_interface.ErrNodeNotFound
+	}
+
+	r.ensureGroveTree(space, dstTree)
+
+	newDepth := 0
+	if dstParent != nil {
+		parentNode, exists := r.groveNodes[space][dstTree][*dstParent]
+		if !exists {
+			return store_interface.ErrNodeNotFound
+		}
+		newDepth = parentNode.depth + 1
+	}
+
+	descendants := r.getDescendantsInternal(space, srcTree, node)
+	descendants = append(descendants, node) // include node itself
+
+	for _, desc := range descendants {
+		if _, exists := r.groveNodes[space][dstTree][desc]; exists {
+			return store_interface.ErrNodeAlreadyExists
+		}
+		if r.groveDeletedNodes != nil && r.groveDeletedNodes[space] != nil && r.groveDeletedNodes[space][dstTree] != nil {
+			if _, exists := r.groveDeletedNodes[space][dstTree][desc]; exists {
+				return store_interface.ErrNodeAlreadyExists
+			}
+		}
+	}
+
+	// Detach from the old parent
+	if nodeObj.parent != nil {
+		children := r.groveChildren[space][srcTree][*nodeObj.parent]
+		for i, child := range children {
+			if child == node {
+				r.groveChildren[space][srcTree][*nodeObj.parent] = append(children[:i], children[i+1:]...)
+				break
+			}
+		}
+	}
+
+	// Remove every closure relationship whose ancestor is outside the subtree
+	subtreeSet := make(map[store_interface.NodeID]bool, len(descendants))
+	for _, desc := range descendants {
+		subtreeSet[desc] = true
+	}
+	for ancestor := range r.groveClosure[space][srcTree] {
+		if subtreeSet[ancestor] {
+			continue
+		}
+		for _, desc := range descendants {
+			delete(r.groveClosure[space][srcTree][ancestor], desc)
+		}
+	}
+
+	depthDelta := newDepth - nodeObj.depth
+	nodeObj.parent = dstParent
+	nodeObj.position = position
+
+	for _, desc := range descendants {
+		descNode := r.groveNodes[space][srcTree][desc]
+		descNode.depth += depthDelta
+		relativeDepth := descNode.depth - newDepth
+
+		r.groveNodes[space][dstTree][desc] = descNode
+		delete(r.groveNodes[space][srcTree], desc)
+
+		r.groveClosure[space][dstTree][desc] = r.groveClosure[space][srcTree][desc]
+		delete(r.groveClosure[space][srcTree], desc)
+
+		if children, ok := r.groveChildren[space][srcTree][desc]; ok {
+			r.groveChildren[space][dstTree][desc] = children
+			delete(r.groveChildren[space][srcTree], desc)
+		}
+
+		if dstParent != nil {
+			for ancestor, descendantsOfAncestor := range r.groveClosure[space][dstTree] {
+				if subtreeSet[ancestor] {
+					continue
+				}
+				if depthToParent, hasParent := descendantsOfAncestor[*dstParent]; hasParent {
+					r.groveClosure[space][dstTree][ancestor][desc] = depthToParent + 1 + relativeDepth
+				}
+			}
+		}
+
+		// Aggregates and mutation markers left behind by hard-deleted nodes in the
+		// destination are replaced by the moved node's own
+		if r.groveAggregates != nil && r.groveAggregates[space] != nil {
+			delete(r.groveAggregates[space][dstTree], desc)
+		}
+		if r.groveMutations != nil && r.groveMutations[space] != nil {
+			delete(r.groveMutations[space][dstTree], desc)
+		}
+		if r.groveAggregates != nil && r.groveAggregates[space] != nil && r.groveAggregates[space][srcTree] != nil {
+			if aggs, ok := r.groveAggregates[space][srcTree][desc]; ok {
+				if r.groveAggregates[space][dstTree] == nil {
+					r.groveAggregates[space][dstTree] = make(map[store_interface.NodeID]map[store_interface.AggregateKey]store_interface.AggregateValue)
+				}
+				r.groveAggregates[space][dstTree][desc] = aggs
+				delete(r.groveAggregates[space][srcTree], desc)
+			}
+		}
+		if r.groveMutations != nil && r.groveMutations[space] != nil && r.groveMutations[space][srcTree] != nil {
+			if mutations, ok := r.groveMutations[space][srcTree][desc]; ok {
+				if r.groveMutations[space][dstTree] == nil {
+					r.groveMutations[space][dstTree] = make(map[store_interface.NodeID]map[store_interface.MutationID]bool)
+				}
+				r.groveMutations[space][dstTree][desc] = mutations
+				delete(r.groveMutations[space][srcTree], desc)
+			}
+		}
+	}
+
+	if dstParent != nil {
+		r.groveChildren[space][dstTree][*dstParent] = append(r.groveChildren[space][dstTree][*dstParent], node)
+	}
+
+	// The source tree sees the subtree disappear and the destination sees it appear, parents first
+	sort.Slice(descendants, func(i, j int) bool {
+		return r.groveNodes[space][dstTree][descendants[i]].depth < r.groveNodes[space][dstTree][descendants[j]].depth
+	})
+	for _, desc := range descendants {
+		r.recordChange(space, srcTree, store_interface.GroveChange{
+			Kind:   store_interface.GroveChangeDelete,
+			NodeID: desc,
+		})
+	}
+	for _, desc := range descendants {
+		descNode := r.groveNodes[space][dstTree][desc]
+		r.recordChange(space, dstTree, store_interface.GroveChange{
+			Kind:     store_interface.GroveChangeCreate,
+			NodeID:   desc,
+			Parent:   descNode.parent,
+			Position: descNode.position,
+			Metadata: descNode.metadata,
+		})
+	}
+
+	return nil
+}
+
+// CopySubtree clones a node and its descendants under a new parent, possibly in another tree
+func (r *RamStore) CopySubtree(
+	space store_interface.TenancySpace,
+	srcTree store_interface.TreeID,
+	srcNode store_interface.NodeID,
+	dstTree store_interface.TreeID,
+	dstParent *store_interface.NodeID,
+	opts store_interface.CopySubtreeOptions,
+) (map[store_interface.NodeID]store_interface.NodeID, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.groveNodes == nil || r.groveNodes[space] == nil || r.groveNodes[space][srcTree] == nil {
+		return nil, store_interface.ErrNodeNotFound
+	}
+	srcRoot, exists := r.groveNodes[space][srcTree][srcNode]
+	if !exists {
+		return nil, store_interface.ErrNodeNotFound
+	}
+
+	// Collect the subtree breadth first so parents are copied before their children
+	// and sibling order is preserved.
+	order := []store_interface.NodeID{srcNode}
+	for i := 0; i < len(order); i++ {
+		order = append(order, r.groveChildren[space][srcTree][order[i]]...)
+	}
+
+	r.ensureGroveTree(space, dstTree)
+
+	var rootDepth int
+	if dstParent != nil {
+		parentNode, exists := r.groveNodes[space][dstTree][*dstParent]
+		if !exists {
+			return nil, store_interface.ErrNodeNotFound
+		}
+		rootDepth = parentNode.depth + 1
+	}
+
+	mapping, err := opts.MapSubtreeIDs(order)
+	if err != nil {
+		return nil, err
+	}
+	for _, newID := range mapping {
+		if _, exists := r.groveNodes[space][dstTree][newID]; exists {
+			return nil, store_interface.ErrNodeAlreadyExists
+		}
+		if r.groveDeletedNodes != nil && r.groveDeletedNodes[space] != nil && r.groveDeletedNodes[space][dstTree] != nil {
+			if _, exists := r.groveDeletedNodes[space][dstTree][newID]; exists {
+				return nil, store_interface.ErrNodeAlreadyExists
+			}
+		}
+	}
+
+	for _, oldID := range order {
+		src := r.groveNodes[space][srcTree][oldID]
+		newID := mapping[oldID]
+
+		var parent *store_interface.NodeID
+		position := src.position
+		if oldID == srcNode {
+			parent = dstParent
+			if opts.Position != nil {
+				position = opts.Position
+			}
+		} else {
+			p := mapping[*src.parent]
+			parent = &p
+		}
+
+		var metadata *store_interface.NodeMetadata
+		if src.metadata != nil {
+			copied := make(store_interface.NodeMetadata, len(*src.metadata))
+			for k, v := range *src.metadata {
+				copied[k] = v
+			}
+			metadata = &copied
+		}
+
+		r.groveNodes[space][dstTree][newID] = &nodeData{
+			id:       newID,
+			parent:   parent,
+			position: position,
+			metadata: metadata,
+			depth:    rootDepth + src.depth - srcRoot.depth,
+		}
+
+		// Closure: self reference plus every ancestor of the new parent
+		r.groveClosure[space][dstTree][newID] = map[store_interface.NodeID]int{newID: 0}
+		if parent != nil {
+			for ancestor, descendants := range r.groveClosure[space][dstTree] {
+				if depthToParent, hasParent := descendants[*parent]; hasParent {
+					r.groveClosure[space][dstTree][ancestor][newID] = depthToParent + 1
+				}
+			}
+			r.groveChildren[space][dstTree][*parent] = append(r.groveChildren[space][dstTree][*parent], newID)
+		}
+
+		if opts.CopyAggregates && r.groveAggregates != nil && r.groveAggregates[space] != nil && r.groveAggregates[space][srcTree] != nil {
+			if aggs := r.groveAggregates[space][srcTree][oldID]; len(aggs) > 0 {
+				if r.groveAggregates[space][dstTree] == nil {
+					r.groveAggregates[space][dstTree] = make(map[store_interface.NodeID]map[store_interface.AggregateKey]store_interface.AggregateValue)
+				}
+				copied := make(map[store_interface.AggregateKey]store_interface.AggregateValue, len(aggs))
+				for k, v := range aggs {
+					copied[k] = v
+				}
+				r.groveAggregates[space][dstTree][newID] = copied
+			}
+		}
+
+		r.recordChange(space, dstTree, store_interface.GroveChange{
+			Kind:     store_interface.GroveChangeCreate,
+			NodeID:   newID,
+			Parent:   parent,
+			Position: position,
+			Metadata: metadata,
+		})
+	}
+
+	return mapping, nil
+}
+
+// GetChanges returns a tree's changes after the given sequence number
+func (r *RamStore) GetChanges(space store_interface.TenancySpace, treeID store_interface.TreeID, since int64, limit int) ([]store_interface.GroveChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var log []store_interface.GroveChange
+	if r.groveChanges != nil && r.groveChanges[space] != nil {
+		log = r.groveChanges[space][treeID]
+	}
+	if since < 0 {
+		since = 0
+	}
+	if since >= int64(len(log)) {
+		return []store_interface.GroveChange{}, nil
+	}
+	changes := log[since:]
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return append([]store_interface.GroveChange(nil), changes...), nil
+}
+
 // Helper functions (must be called with lock held)
 
+// recordChange appends a change to the tree's log, assigning its sequence number and timestamp.
+func (r *RamStore) recordChange(space store_interface.TenancySpace, treeID store_interface.TreeID, change store_interface.GroveChange) {
+	if r.groveChanges == nil {
+		r.groveChanges = make(map[store_interface.TenancySpace]map[store_interface.TreeID][]store_interface.GroveChange)
+	}
+	if r.groveChanges[space] == nil {
+		r.groveChanges[space] = make(map[store_interface.TreeID][]store_interface.GroveChange)
+	}
+	change.Seq = int64(len(r.groveChanges[space][treeID]) + 1)
+	change.At = time.Now().UTC()
+	r.groveChanges[space][treeID] = append(r.groveChanges[space][treeID], change)
+}
+
+// ensureGroveTree initializes the node, closure and children maps for a tree.
+func (r *RamStore) ensureGroveTree(space store_interface.TenancySpace, treeID store_interface.TreeID) {
+	if r.groveNodes == nil {
+		r.groveNodes = make(map[store_interface.TenancySpace]map[store_interface.TreeID]map[store_interface.NodeID]*nodeData)
+	}
+	if r.groveNodes[space] == nil {
+		r.groveNodes[space] = make(map[store_interface.TreeID]map[store_interface.NodeID]*nodeData)
+	}
+	if r.groveNodes[space][treeID] == nil {
+		r.groveNodes[space][treeID] = make(map[store_interface.NodeID]*nodeData)
+	}
+	if r.groveClosure == nil {
+		r.groveClosure = make(map[store_interface.TenancySpace]map[store_interface.TreeID]map[store_interface.NodeID]map[store_interface.NodeID]int)
+	}
+	if r.groveClosure[space] == nil {
+		r.groveClosure[space] = make(map[store_interface.TreeID]map[store_interface.NodeID]map[store_interface.NodeID]int)
+	}
+	if r.groveClosure[space][treeID] == nil {
+		r.groveClosure[space][treeID] = make(map[store_interface.NodeID]map[store_interface.NodeID]int)
+	}
+	if r.groveChildren == nil {
+		r.groveChildren = make(map[store_interface.TenancySpace]map[store_interface.TreeID]map[store_interface.NodeID][]store_interface.NodeID)
+	}
+	if r.groveChildren[space] == nil {
+		r.groveChildren[space] = make(map[store_interface.TreeID]map[store_interface.NodeID][]store_interface.NodeID)
+	}
+	if r.groveChildren[space][treeID] == nil {
+		r.groveChildren[space][treeID] = make(map[store_interface.NodeID][]store_interface.NodeID)
+	}
+}
+
 func (r *RamStore) isDescendant(space store_interface.TenancySpace, treeID store_interface.TreeID, ancestor, node store_interface.NodeID) bool {
 	if r.groveClosure == nil || r.groveClosure[space] == nil || r.groveClosure[space][treeID] == nil {
 		return false
@@ -17,8 +17,11 @@ type nodeData struct {
 }
 
 type depotEntry struct {
-	value    string
-	bucketID int32
+	value       string
+	bucketID    int32
+	revision    int64
+	data        []byte // with contentType, set for raw items
+	contentType string
 }
 
 type RamStore struct {
@@ -28,6 +31,9 @@ type RamStore struct {
 	depots       map[store_interface.TenancySpace]map[int64]depotEntry                  // space -> id -> entry
 	depotNextIDs map[store_interface.TenancySpace]int64                                 // space -> next auto-increment id
 
+	trackHistoryPolicies map[store_interface.TenancySpace]map[int32]store_interface.TrackHistoryPolicy
+	trackHistory         map[store_interface.TenancySpace]map[int32]map[string][]model.TrackHistoryEntry // oldest first
+
 	// Grove data structures (with TreeID for logical tree separation)
 	groveNodes        map[store_interface.TenancySpace]map[store_interface.TreeID]map[store_interface.NodeID]*nodeData
 	groveClosure      map[store_interface.TenancySpace]map[store_interface.TreeID]map[store_interface.NodeID]map[store_interface.NodeID]int // ancestor -> descendant -> relative_depth
@@ -35,6 +41,7 @@ type RamStore struct {
 	groveDeletedNodes map[store_interface.TenancySpace]map[store_interface.TreeID]map[store_interface.NodeID]*nodeData
 	groveMutations    map[store_interface.TenancySpace]map[store_interface.TreeID]map[store_interface.NodeID]map[store_interface.MutationID]bool
 	groveAggregates   map[store_interface.TenancySpace]map[store_interface.TreeID]map[store_interface.NodeID]map[store_interface.AggregateKey]store_interface.AggregateValue
+	groveChanges      map[store_interface.TenancySpace]map[store_interface.TreeID][]store_interface.GroveChange // ordered change log, Seq = index + 1
 }
 
 // NewRamStore returns a new empty in-memory store
@@ -43,5 +50,8 @@ func NewRamStore() *RamStore {
 		tracks:       make(map[store_interface.TenancySpace]map[int32]map[string]model.TrackValue),
 		depots:       make(map[store_interface.TenancySpace]map[int64]depotEntry),
 		depotNextIDs: make(map[store_interface.TenancySpace]int64),
+
+		trackHistoryPolicies: make(map[store_interface.TenancySpace]map[int32]store_interface.TrackHistoryPolicy),
+		trackHistory:         make(map[store_interface.TenancySpace]map[int32]map[string][]model.TrackHistoryEntry),
 	}
 }
@@ -3,7 +3,9 @@ package ram
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/store_interface"
@@ -20,32 +22,140 @@ func (r *RamStore) TrackDeleteMany(space store_interface.TenancySpace, items []m
 	}
 
 	for _, item := range items {
-		bucket, ok := appBuckets[item.BucketID]
-		if !ok {
+		if _, ok := appBuckets[item.BucketID]; !ok {
 			// Bucket missing — consistent with TrackDelete (silent no-op)
 			continue
 		}
-		delete(bucket, item.Key)
+		r.deleteTrackValue(space, item.BucketID, item.Key)
 	}
 
 	return nil
 }
-func (r *RamStore) TrackPut(space store_interface.TenancySpace, bucketID int32, key string, value int64, tag *int64, metric *float64) error {
+func (r *RamStore) TrackDeleteByPrefix(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+) (int, error) {
+	if _, err := store_interface.DisjointPrefixes(prefixes); err != nil {
+		return 0, err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return 0, err
+		}
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	bucket := r.tracks[space][bucketID]
+	tagFilter := store_interface.NewTrackTagFilter(tags, store_interface.TrackTagMatchAny)
+	deleted := 0
+	for k, v := range bucket {
+		if !slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(k, p) }) {
+			continue
+		}
+		if !tagFilter.Matches(v) {
+			continue
+		}
+		if metric != nil && !metric.Matches(v.Metric) {
+			continue
+		}
+		r.deleteTrackValue(space, bucketID, k)
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (r *RamStore) TrackPut(space store_interface.TenancySpace, bucketID int32, key string, value int64, tag *int64, metric *float64, expiresAt *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.setTrackValue(space, bucketID, key, model.TrackValue{
+		Value:     value,
+		Tag:       tag,
+		Metric:    metric,
+		ExpiresAt: expiresAt,
+	})
+	return nil
+}
+
+// liveTrackValue looks up key, treating expired entries as missing. Callers hold r.mu.
+func (r *RamStore) liveTrackValue(space store_interface.TenancySpace, bucketID int32, key string) (model.TrackValue, bool) {
+	val, ok := r.tracks[space][bucketID][key]
+	if !ok || store_interface.TrackExpired(val, time.Now()) {
+		return model.TrackValue{}, false
+	}
+	return val, true
+}
+
+// setTrackValue writes value under key, first recording the value it replaces when the
+// bucket keeps history. Callers hold r.mu.
+func (r *RamStore) setTrackValue(space store_interface.TenancySpace, bucketID int32, key string, value model.TrackValue) {
+	r.recordTrackHistory(space, bucketID, key)
+	r.ensureTrackBucket(space, bucketID)[key] = value
+}
+
+// deleteTrackValue is the delete counterpart of setTrackValue. Callers hold r.mu.
+func (r *RamStore) deleteTrackValue(space store_interface.TenancySpace, bucketID int32, key string) {
+	r.recordTrackHistory(space, bucketID, key)
+	delete(r.tracks[space][bucketID], key)
+}
+
+func (r *RamStore) recordTrackHistory(space store_interface.TenancySpace, bucketID int32, key string) {
+	if !r.trackHistoryPolicies[space][bucketID].Enabled() {
+		return
+	}
+	now := time.Now()
+	prior, ok := r.tracks[space][bucketID][key]
+	if !ok || store_interface.TrackExpired(prior, now) {
+		return
+	}
+	if r.trackHistory[space] == nil {
+		r.trackHistory[space] = make(map[int32]map[string][]model.TrackHistoryEntry)
+	}
+	if r.trackHistory[space][bucketID] == nil {
+		r.trackHistory[space][bucketID] = make(map[string][]model.TrackHistoryEntry)
+	}
+	r.trackHistory[space][bucketID][key] = append(r.trackHistory[space][bucketID][key], store_interface.TrackHistoryEntry(prior, now))
+}
+
+// ensureTrackBucket returns the bucket's map, creating it if needed. Callers hold r.mu.
+func (r *RamStore) ensureTrackBucket(space store_interface.TenancySpace, bucketID int32) map[string]model.TrackValue {
 	if r.tracks[space] == nil {
 		r.tracks[space] = make(map[int32]map[string]model.TrackValue)
 	}
 	if r.tracks[space][bucketID] == nil {
 		r.tracks[space][bucketID] = make(map[string]model.TrackValue)
 	}
+	return r.tracks[space][bucketID]
+}
+
+func (r *RamStore) TrackIncrement(space store_interface.TenancySpace, bucketID int32, key string, delta int64) (model.TrackValue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	val, _ := r.liveTrackValue(space, bucketID, key)
+	val.Value += delta
+	r.setTrackValue(space, bucketID, key, val)
+	return val, nil
+}
 
-	r.tracks[space][bucketID][key] = model.TrackValue{
+func (r *RamStore) TrackCompareAndSwap(space store_interface.TenancySpace, bucketID int32, key string, expected *int64, value int64, tag *int64, metric *float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, exists := r.liveTrackValue(space, bucketID, key)
+	if expected == nil && exists || expected != nil && (!exists || current.Value != *expected) {
+		return store_interface.ErrTrackValueMismatch
+	}
+	r.setTrackValue(space, bucketID, key, model.TrackValue{
 		Value:  value,
 		Tag:    tag,
 		Metric: metric,
-	}
+	})
 	return nil
 }
 
@@ -58,19 +168,28 @@ func (r *RamStore) TrackGet(space store_interface.TenancySpace, bucketID int32,
 		return 0, errors.New("bucket not found in ram Store.")
 	}
 	val, ok := bucket[key]
-	if !ok {
+	if !ok || store_interface.TrackExpired(val, time.Now()) {
 		return 0, errors.New("key not found")
 	}
 	return val.Value, nil
 }
 
+func (r *RamStore) TrackGetValue(space store_interface.TenancySpace, bucketID int32, key string) (model.TrackValue, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	val, ok := r.liveTrackValue(space, bucketID, key)
+	if !ok {
+		return model.TrackValue{}, store_interface.ErrTrackKeyNotFound
+	}
+	return val, nil
+}
+
 func (r *RamStore) TrackDelete(space store_interface.TenancySpace, bucketID int32, key string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if bucket, ok := r.tracks[space][bucketID]; ok {
-		delete(bucket, key)
-	}
+	r.deleteTrackValue(space, bucketID, key)
 	return nil
 }
 
@@ -79,22 +198,48 @@ func (r *RamStore) TrackClose() error {
 }
 
 func (r *RamStore) TrackPutMany(space store_interface.TenancySpace, items map[int32][]model.TrackKeyValueItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	for bucketID, kvList := range items {
 		for _, kv := range kvList {
-			if err := r.TrackPut(space, bucketID, kv.Key, kv.Value.Value, kv.Value.Tag, kv.Value.Metric); err != nil {
-				return err
-			}
+			r.setTrackValue(space, bucketID, kv.Key, kv.Value)
 		}
 	}
 	return nil
 }
 
+func (r *RamStore) TrackPutManyIf(space store_interface.TenancySpace, items map[int32][]model.TrackKeyValueItem, cond store_interface.TrackPutCondition) (model.TrackPutManyResult, error) {
+	if err := cond.Validate(); err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result model.TrackPutManyResult
+	for _, bucketID := range store_interface.SortedTrackBuckets(items) {
+		for _, kv := range items[bucketID] {
+			pair := model.TrackBucketKeyPair{BucketID: bucketID, Key: kv.Key}
+			current, live := r.liveTrackValue(space, bucketID, kv.Key)
+			if !cond.Allows(current, live) {
+				result.Rejected = append(result.Rejected, pair)
+				continue
+			}
+			r.setTrackValue(space, bucketID, kv.Key, kv.Value)
+			result.Written = append(result.Written, pair)
+		}
+	}
+	return result, nil
+}
+
 func (r *RamStore) TrackGetMany(space store_interface.TenancySpace, keys map[int32][]string) (map[int32]map[string]model.TrackValue, map[int32][]string, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	found := make(map[int32]map[string]model.TrackValue)
 	missing := make(map[int32][]string)
+	now := time.Now()
 
 	for bucketID, keyList := range keys {
 		if r.tracks[space] == nil {
@@ -107,7 +252,7 @@ func (r *RamStore) TrackGetMany(space store_interface.TenancySpace, keys map[int
 			continue
 		}
 		for _, k := range keyList {
-			if val, ok := bucket[k]; ok {
+			if val, ok := bucket[k]; ok && !store_interface.TrackExpired(val, now) {
 				if found[bucketID] == nil {
 					found[bucketID] = make(map[string]model.TrackValue)
 				}
@@ -126,19 +271,33 @@ func (b *RamStore) GetItemsByKeyPrefix(
 	bucketID int32,
 	prefix string,
 	tags []int64,
-	metricValue *float64,
-	metricIsGt bool,
+	metric *store_interface.MetricFilter,
 ) ([]model.TrackKeyValueItem, error) {
-	return b.GetItemsByKeyPrefixes(space, bucketID, []string{prefix}, tags, metricValue, metricIsGt)
+	return b.GetItemsByKeyPrefixes(space, bucketID, []string{prefix}, tags, metric)
 }
 func (r *RamStore) GetItemsByKeyPrefixes(
 	space store_interface.TenancySpace,
 	bucketID int32,
 	prefixes []string,
 	tags []int64,
-	metricValue *float64,
-	metricIsGt bool,
+	metric *store_interface.MetricFilter,
 ) ([]model.TrackKeyValueItem, error) {
+	return r.itemsByKeyPrefixes(space, bucketID, prefixes, store_interface.NewTrackTagFilter(tags, store_interface.TrackTagMatchAny), metric)
+}
+
+func (r *RamStore) itemsByKeyPrefixes(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	prefixes []string,
+	tagFilter store_interface.TrackTagFilter,
+	metric *store_interface.MetricFilter,
+) ([]model.TrackKeyValueItem, error) {
+
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, err
+		}
+	}
 
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -171,32 +330,8 @@ func (r *RamStore) GetItemsByKeyPrefixes(
 		prefixList = nil // signal to match all
 	}
 
-	tagFilter := func(tag *int64) bool {
-		if len(tags) == 0 {
-			return true
-		}
-		if tag == nil {
-			return false
-		}
-		for _, t := range tags {
-			if *tag == t {
-				return true
-			}
-		}
-		return false
-	}
-
-	metricFilter := func(metric *float64) bool {
-		if metricValue == nil {
-			return true
-		}
-		if metric == nil {
-			return false
-		}
-		if metricIsGt {
-			return *metric > *metricValue
-		}
-		return *metric < *metricValue
+	metricFilter := func(m *float64) bool {
+		return metric == nil || metric.Matches(m)
 	}
 
 	matchesPrefix := func(k string) bool {
@@ -212,8 +347,12 @@ func (r *RamStore) GetItemsByKeyPrefixes(
 		return false
 	}
 
+	now := time.Now()
 	for k, v := range bucket {
-		if matchesPrefix(k) && tagFilter(v.Tag) && metricFilter(v.Metric) {
+		if store_interface.TrackExpired(v, now) {
+			continue
+		}
+		if matchesPrefix(k) && tagFilter.Matches(v) && metricFilter(v.Metric) {
 			result = append(result, model.TrackKeyValueItem{
 				Key:   k,
 				Value: v,
@@ -223,3 +362,283 @@ func (r *RamStore) GetItemsByKeyPrefixes(
 
 	return result, nil
 }
+
+// GetItemsByKeyPrefixesPage filters the whole bucket and then pages in key order,
+// as the ram store keeps no ordered index.
+func (r *RamStore) GetItemsByKeyPrefixesPage(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	items, err := r.itemsByKeyPrefixes(space, bucketID, prefixes, store_interface.NewTrackTagFilter(tags, opts.TagMatch), metric)
+	if err != nil {
+		return nil, "", err
+	}
+	page, next := opts.PageItems(items)
+	return page, next, nil
+}
+
+func (r *RamStore) GetItemsByKeyRange(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	keyRange store_interface.TrackKeyRange,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	if err := keyRange.Validate(); err != nil {
+		return nil, "", err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	tagFilter := store_interface.NewTrackTagFilter(tags, opts.TagMatch)
+	var items []model.TrackKeyValueItem
+	for k, v := range r.tracks[space][bucketID] {
+		if !keyRange.Contains(k) || !opts.After(k) || store_interface.TrackExpired(v, now) {
+			continue
+		}
+		if !tagFilter.Matches(v) {
+			continue
+		}
+		if metric != nil && !metric.Matches(v.Metric) {
+			continue
+		}
+		items = append(items, model.TrackKeyValueItem{Key: k, Value: v})
+	}
+
+	page, next := opts.PageItems(items)
+	return page, next, nil
+}
+
+// GetKeysByValue scans the bucket; the ram store keeps no value index.
+func (r *RamStore) GetKeysByValue(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	values []int64,
+	prefix string,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+	if err := store_interface.ValidateKeysByValue(values, opts); err != nil {
+		return nil, "", err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	var items []model.TrackKeyValueItem
+	for k, v := range r.tracks[space][bucketID] {
+		if !strings.HasPrefix(k, prefix) || !opts.After(k) || store_interface.TrackExpired(v, now) {
+			continue
+		}
+		if slices.Contains(values, v.Value) {
+			items = append(items, model.TrackKeyValueItem{Key: k, Value: v})
+		}
+	}
+
+	page, next := opts.PageItems(items)
+	return page, next, nil
+}
+
+func (r *RamStore) ListTrackBuckets(space store_interface.TenancySpace) ([]int32, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	var out []int32
+	for bucketID, bucket := range r.tracks[space] {
+		for _, v := range bucket {
+			if !store_interface.TrackExpired(v, now) {
+				out = append(out, bucketID)
+				break
+			}
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+func (r *RamStore) TrackBucketStats(space store_interface.TenancySpace, bucketID int32) (model.TrackBucketStats, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	stats := store_interface.NewTrackBucketStatsBuilder(bucketID)
+	for k, v := range r.tracks[space][bucketID] {
+		if !store_interface.TrackExpired(v, now) {
+			stats.Add(k, v)
+		}
+	}
+	return stats.Stats()
+}
+
+// TrackScanBucket copies the bucket under the lock and calls fn after releasing it,
+// so a slow fn does not hold up writers.
+func (r *RamStore) TrackScanBucket(space store_interface.TenancySpace, bucketID int32, fn func(model.TrackKeyValueItem) error) error {
+	r.mu.RLock()
+	now := time.Now()
+	var items []model.TrackKeyValueItem
+	for k, v := range r.tracks[space][bucketID] {
+		if !store_interface.TrackExpired(v, now) {
+			items = append(items, model.TrackKeyValueItem{Key: k, Value: v})
+		}
+	}
+	r.mu.RUnlock()
+
+	slices.SortFunc(items, func(a, b model.TrackKeyValueItem) int { return strings.Compare(a.Key, b.Key) })
+	for _, item := range items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RamStore) TrackAggregate(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	groupByTag bool,
+) ([]model.TrackAggregateGroup, error) {
+	prefixes, err := store_interface.DisjointPrefixes(prefixes)
+	if err != nil {
+		return nil, err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	agg := store_interface.NewTrackAggregator(groupByTag)
+	tagFilter := store_interface.NewTrackTagFilter(tags, store_interface.TrackTagMatchAny)
+	for k, v := range r.tracks[space][bucketID] {
+		if store_interface.TrackExpired(v, now) {
+			continue
+		}
+		if !slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(k, p) }) {
+			continue
+		}
+		if !tagFilter.Matches(v) {
+			continue
+		}
+		if metric != nil && !metric.Matches(v.Metric) {
+			continue
+		}
+		agg.Add(v)
+	}
+	return agg.Groups(), nil
+}
+
+func (r *RamStore) TrackDeleteExpired(now time.Time, limit int) ([]store_interface.TrackExpiredKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted []store_interface.TrackExpiredKey
+	for space, buckets := range r.tracks {
+		for bucketID, bucket := range buckets {
+			for k, v := range bucket {
+				if len(deleted) >= limit {
+					return deleted, nil
+				}
+				if store_interface.TrackExpired(v, now) {
+					delete(bucket, k)
+					deleted = append(deleted, store_interface.TrackExpiredKey{Space: space, BucketID: bucketID, Key: k})
+				}
+			}
+		}
+	}
+	return deleted, nil
+}
+
+func (r *RamStore) TrackSetHistoryPolicy(space store_interface.TenancySpace, bucketID int32, policy store_interface.TrackHistoryPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !policy.Enabled() {
+		delete(r.trackHistoryPolicies[space], bucketID)
+		delete(r.trackHistory[space], bucketID)
+		return nil
+	}
+	if r.trackHistoryPolicies[space] == nil {
+		r.trackHistoryPolicies[space] = make(map[int32]store_interface.TrackHistoryPolicy)
+	}
+	r.trackHistoryPolicies[space][bucketID] = policy
+	return nil
+}
+
+func (r *RamStore) TrackGetHistoryPolicy(space store_interface.TenancySpace, bucketID int32) (store_interface.TrackHistoryPolicy, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.trackHistoryPolicies[space][bucketID], nil
+}
+
+func (r *RamStore) TrackGetHistory(space store_interface.TenancySpace, bucketID int32, key string, limit int) ([]model.TrackHistoryEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := r.trackHistory[space][bucketID][key]
+	newestFirst := make([]model.TrackHistoryEntry, len(entries))
+	for i, e := range entries {
+		newestFirst[len(entries)-1-i] = e
+	}
+	return r.trackHistoryPolicies[space][bucketID].Retained(newestFirst, time.Now(), limit), nil
+}
+
+func (r *RamStore) TrackPruneHistory(now time.Time, limit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pruned := 0
+	for space, buckets := range r.trackHistory {
+		for bucketID, keys := range buckets {
+			policy := r.trackHistoryPolicies[space][bucketID]
+			for key, entries := range keys {
+				// Entries are oldest first, so the ones to prune form a prefix
+				n := len(entries)
+				drop := 0
+				for drop < n && pruned < limit && !policy.Retains(n-1-drop, entries[drop].ReplacedAt, now) {
+					drop++
+					pruned++
+				}
+				if drop == n {
+					delete(keys, key)
+				} else if drop > 0 {
+					keys[key] = append([]model.TrackHistoryEntry(nil), entries[drop:]...)
+				}
+				if pruned >= limit {
+					return pruned, nil
+				}
+			}
+		}
+	}
+	return pruned, nil
+}
@@ -1,6 +1,11 @@
 package sqlite_store
 
 import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/store_interface"
 )
 
@@ -8,23 +13,31 @@ func (s *SQLiteStore) DepotCreate(
 	space store_interface.TenancySpace,
 	bucketID int32,
 	value string,
-) (int64, error) {
+) (int64, int64, error) {
 
-	res, err := s.db.Exec(`
+	var id, revision int64
+	err := s.db.QueryRow(`
         INSERT INTO depot (app_id, tenancy_id, bucket_id, value)
         VALUES (?, ?, ?, ?)
-    `, space.AppId, space.TenancyId, bucketID, value)
-
-	if err != nil {
-		return 0, err
-	}
+        RETURNING id, revision
+    `, space.AppId, space.TenancyId, bucketID, value).Scan(&id, &revision)
+	return id, revision, err
+}
 
-	id, err := res.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
+func (s *SQLiteStore) DepotCreateRaw(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	data []byte,
+	contentType string,
+) (int64, int64, error) {
 
-	return id, nil
+	var id, revision int64
+	err := s.db.QueryRow(`
+        INSERT INTO depot (app_id, tenancy_id, bucket_id, value, content_type, data)
+        VALUES (?, ?, ?, '', ?, ?)
+        RETURNING id, revision
+    `, space.AppId, space.TenancyId, bucketID, store_interface.DepotRawContentType(contentType), data).Scan(&id, &revision)
+	return id, revision, err
 }
 
 func (s *SQLiteStore) DepotDeleteByBucket(
@@ -92,13 +105,86 @@ func (s *SQLiteStore) DepotUpdate(
 
 	_, err := s.db.Exec(`
         UPDATE depot
-        SET value=?
+        SET value=?, content_type=NULL, data=NULL, revision=revision+1
         WHERE id=? AND app_id=? AND tenancy_id=?
     `, value, id, space.AppId, space.TenancyId)
 
 	return err
 }
 
+func (s *SQLiteStore) DepotUpdateIfRevision(
+	space store_interface.TenancySpace,
+	id int64,
+	value string,
+	revision int64,
+) (int64, error) {
+
+	var next int64
+	err := s.db.QueryRow(`
+        UPDATE depot
+        SET value=?, content_type=NULL, data=NULL, revision=revision+1
+        WHERE id=? AND app_id=? AND tenancy_id=? AND revision=?
+        RETURNING revision
+    `, value, id, space.AppId, space.TenancyId, revision).Scan(&next)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return next, err
+	}
+
+	// Nothing was updated, so report why
+	item, err := s.DepotGetItem(space, id)
+	if err != nil {
+		return 0, err
+	}
+	return 0, &store_interface.DepotRevisionConflictError{ID: id, Expected: revision, Current: item.Revision}
+}
+
+func (s *SQLiteStore) DepotUpdateRaw(
+	space store_interface.TenancySpace,
+	id int64,
+	data []byte,
+	contentType string,
+) (int64, error) {
+
+	var next int64
+	err := s.db.QueryRow(`
+        UPDATE depot
+        SET value='', content_type=?, data=?, revision=revision+1
+        WHERE id=? AND app_id=? AND tenancy_id=?
+        RETURNING revision
+    `, store_interface.DepotRawContentType(contentType), data, id, space.AppId, space.TenancyId).Scan(&next)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, store_interface.ErrDepotItemNotFound
+	}
+	return next, err
+}
+
+func (s *SQLiteStore) DepotUpdateRawIfRevision(
+	space store_interface.TenancySpace,
+	id int64,
+	data []byte,
+	contentType string,
+	revision int64,
+) (int64, error) {
+
+	var next int64
+	err := s.db.QueryRow(`
+        UPDATE depot
+        SET value='', content_type=?, data=?, revision=revision+1
+        WHERE id=? AND app_id=? AND tenancy_id=? AND revision=?
+        RETURNING revision
+    `, store_interface.DepotRawContentType(contentType), data, id, space.AppId, space.TenancyId, revision).Scan(&next)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return next, err
+	}
+
+	// Nothing was updated, so report why
+	item, err := s.DepotGetItem(space, id)
+	if err != nil {
+		return 0, err
+	}
+	return 0, &store_interface.DepotRevisionConflictError{ID: id, Expected: revision, Current: item.Revision}
+}
+
 func (s *SQLiteStore) DepotDelete(
 	space store_interface.TenancySpace,
 	id int64,
@@ -128,6 +214,42 @@ func (s *SQLiteStore) DepotGet(
 	return value, err
 }
 
+func (s *SQLiteStore) DepotGetItem(
+	space store_interface.TenancySpace,
+	id int64,
+) (model.DepotItem, error) {
+
+	item := model.DepotItem{ID: id}
+	err := s.db.QueryRow(`
+        SELECT value, revision, COALESCE(content_type, '') FROM depot
+        WHERE id=? AND app_id=? AND tenancy_id=?
+    `, id, space.AppId, space.TenancyId).Scan(&item.Value, &item.Revision, &item.ContentType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.DepotItem{}, store_interface.ErrDepotItemNotFound
+	}
+	return item, err
+}
+
+func (s *SQLiteStore) DepotGetRaw(
+	space store_interface.TenancySpace,
+	id int64,
+) (model.DepotRawItem, error) {
+
+	item := model.DepotItem{ID: id}
+	var data []byte
+	err := s.db.QueryRow(`
+        SELECT value, revision, COALESCE(content_type, ''), data FROM depot
+        WHERE id=? AND app_id=? AND tenancy_id=?
+    `, id, space.AppId, space.TenancyId).Scan(&item.Value, &item.Revision, &item.ContentType, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.DepotRawItem{}, store_interface.ErrDepotItemNotFound
+	}
+	if err != nil {
+		return model.DepotRawItem{}, err
+	}
+	return store_interface.DepotRawFromItem(item, data), nil
+}
+
 func (s *SQLiteStore) DepotGetMany(
 	space store_interface.TenancySpace,
 	ids []int64,
@@ -211,3 +333,117 @@ func (s *SQLiteStore) DepotGetAllByBucket(
 
 	return result, nil
 }
+
+func (s *SQLiteStore) DepotGetBucketPage(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	opts store_interface.DepotPageOptions,
+) ([]model.DepotItem, int64, error) {
+	return s.DepotQuery(space, bucketID, nil, opts)
+}
+
+// DepotQuery reads the page, plus one row to detect a next page, in ID order through
+// depot_space_bucket_idx, which ends in the rowid. Filters are checked with json_type
+// and json_extract on rows whose value is valid JSON.
+func (s *SQLiteStore) DepotQuery(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	filters []store_interface.DepotFieldFilter,
+	opts store_interface.DepotPageOptions,
+) ([]model.DepotItem, int64, error) {
+
+	if err := store_interface.ValidateDepotQuery(filters, opts); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+        SELECT id, value, revision, COALESCE(content_type, '')
+        FROM depot
+        WHERE app_id=? AND tenancy_id=? AND bucket_id=?`
+	args := []any{space.AppId, space.TenancyId, bucketID}
+	if len(filters) > 0 {
+		// json_extract fails on malformed JSON, so only evaluate the filters on valid values
+		var conds []string
+		for _, f := range filters {
+			cond, condArgs := depotFilterSQL(f)
+			conds = append(conds, cond)
+			args = append(args, condArgs...)
+		}
+		query += ` AND CASE WHEN json_valid(value) THEN ` + strings.Join(conds, " AND ") + ` ELSE 0 END`
+	}
+	order := "ASC"
+	if opts.Descending {
+		order = "DESC"
+	}
+	if opts.StartAfter != 0 {
+		if opts.Descending {
+			query += ` AND id < ?`
+		} else {
+			query += ` AND id > ?`
+		}
+		args = append(args, opts.StartAfter)
+	}
+	query += ` ORDER BY id ` + order
+	if fetch := opts.FetchLimit(); fetch > 0 {
+		query += ` LIMIT ?`
+		args = append(args, fetch)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var items []model.DepotItem
+	for rows.Next() {
+		var item model.DepotItem
+		if err := rows.Scan(&item.ID, &item.Value, &item.Revision, &item.ContentType); err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	page, next := opts.PageItems(items)
+	return page, next, nil
+}
+
+// depotFilterSQL renders a filter as a condition on the JSON in value. The path is
+// quoted key by key, which Validate makes safe.
+func depotFilterSQL(f store_interface.DepotFieldFilter) (string, []any) {
+	path := `$."` + strings.Join(f.PathSegments(), `"."`) + `"`
+	switch f.Op {
+	case store_interface.DepotFilterExists:
+		// json_type is 'null' for a JSON null and NULL for a missing path
+		return `json_type(value, ?) IS NOT NULL`, []any{path}
+	case store_interface.DepotFilterIn:
+		var conds []string
+		var args []any
+		for _, v := range f.Values {
+			cond, condArgs := depotCompareSQL(path, "=", v)
+			conds = append(conds, cond)
+			args = append(args, condArgs...)
+		}
+		return `(` + strings.Join(conds, " OR ") + `)`, args
+	}
+	return depotCompareSQL(path, f.SQLOperator(), f.Value)
+}
+
+// depotCompareSQL compares the field at path with an operand, which it must match in type.
+func depotCompareSQL(path, op string, operand any) (string, []any) {
+	v, kind, _ := store_interface.DepotOperand(operand)
+	switch kind {
+	case store_interface.DepotKindBool:
+		// json_extract gives booleans as 0 and 1, so match them by type alone
+		if v.(bool) {
+			return `json_type(value, ?) = 'true'`, []any{path}
+		}
+		return `json_type(value, ?) = 'false'`, []any{path}
+	case store_interface.DepotKindString:
+		return `(json_type(value, ?) = 'text' AND json_extract(value, ?) ` + op + ` ?)`, []any{path, path, v}
+	}
+	return `(json_type(value, ?) IN ('integer', 'real') AND json_extract(value, ?) ` + op + ` ?)`, []any{path, path, v}
+}
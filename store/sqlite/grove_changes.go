@@ -0,0 +1,149 @@
+package sqlite_store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/vixac/bullet/store/store_interface"
+)
+
+// GetChanges returns a tree's changes after the given sequence number
+func (s *SQLiteStore) GetChanges(space store_interface.TenancySpace, treeID store_interface.TreeID, since int64, limit int) ([]store_interface.GroveChange, error) {
+	query := `
+		SELECT seq, kind, node_id, parent_id, position, metadata, mutation_id, deltas, soft, created_at
+		FROM grove_changes
+		WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND seq > ?
+		ORDER BY seq`
+	args := []any{space.AppId, space.TenancyId, string(treeID), since}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []store_interface.GroveChange{}
+	for rows.Next() {
+		var change store_interface.GroveChange
+		var kind, nodeID string
+		var parentIDStr, metadataJSON, mutationIDStr, deltasJSON *string
+		var positionVal *float64
+		var createdAt int64
+		if err := rows.Scan(&change.Seq, &kind, &nodeID, &parentIDStr, &positionVal, &metadataJSON, &mutationIDStr, &deltasJSON, &change.Soft, &createdAt); err != nil {
+			return nil, err
+		}
+		change.Kind = store_interface.GroveChangeKind(kind)
+		change.NodeID = store_interface.NodeID(nodeID)
+		change.At = time.UnixMilli(createdAt).UTC()
+		if parentIDStr != nil {
+			p := store_interface.NodeID(*parentIDStr)
+			change.Parent = &p
+		}
+		if positionVal != nil {
+			p := store_interface.ChildPosition(*positionVal)
+			change.Position = &p
+		}
+		if metadataJSON != nil {
+			var m store_interface.NodeMetadata
+			if err := json.Unmarshal([]byte(*metadataJSON), &m); err != nil {
+				return nil, err
+			}
+			change.Metadata = &m
+		}
+		if mutationIDStr != nil {
+			m := store_interface.MutationID(*mutationIDStr)
+			change.MutationID = &m
+		}
+		if deltasJSON != nil {
+			if err := json.Unmarshal([]byte(*deltasJSON), &change.Deltas); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// recordChange appends a change to the tree's log inside the writing transaction.
+// Bumping the counter row serialises writers per tree, so sequence numbers become
+// visible in order.
+func recordChange(tx *sql.Tx, space store_interface.TenancySpace, treeID store_interface.TreeID, change store_interface.GroveChange) error {
+	var seq int64
+	err := tx.QueryRow(`
+		INSERT INTO grove_change_seqs (app_id, tenancy_id, tree_id, last_seq)
+		VALUES (?, ?, ?, 1)
+		ON CONFLICT(app_id, tenancy_id, tree_id)
+		DO UPDATE SET last_seq = last_seq + 1
+		RETURNING last_seq`,
+		space.AppId, space.TenancyId, string(treeID)).Scan(&seq)
+	if err != nil {
+		return err
+	}
+
+	var parentIDStr *string
+	if change.Parent != nil {
+		p := string(*change.Parent)
+		parentIDStr = &p
+	}
+	var positionVal *float64
+	if change.Position != nil {
+		p := float64(*change.Position)
+		positionVal = &p
+	}
+	var metadataJSON *string
+	if change.Metadata != nil {
+		data, err := json.Marshal(change.Metadata)
+		if err != nil {
+			return err
+		}
+		m := string(data)
+		metadataJSON = &m
+	}
+	var mutationIDStr *string
+	if change.MutationID != nil {
+		m := string(*change.MutationID)
+		mutationIDStr = &m
+	}
+	var deltasJSON *string
+	if change.Deltas != nil {
+		data, err := json.Marshal(change.Deltas)
+		if err != nil {
+			return err
+		}
+		d := string(data)
+		deltasJSON = &d
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO grove_changes (app_id, tenancy_id, tree_id, seq, kind, node_id, parent_id, position, metadata, mutation_id, deltas, soft, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		space.AppId, space.TenancyId, string(treeID), seq, string(change.Kind), string(change.NodeID),
+		parentIDStr, positionVal, metadataJSON, mutationIDStr, deltasJSON, change.Soft, time.Now().UnixMilli())
+	return err
+}
+
+// nodeChange builds a change from a node's stored column values.
+func nodeChange(kind store_interface.GroveChangeKind, node store_interface.NodeID, parentIDStr *string, positionVal *float64, metadataJSON *string) (store_interface.GroveChange, error) {
+	change := store_interface.GroveChange{Kind: kind, NodeID: node}
+	if parentIDStr != nil {
+		p := store_interface.NodeID(*parentIDStr)
+		change.Parent = &p
+	}
+	if positionVal != nil {
+		p := store_interface.ChildPosition(*positionVal)
+		change.Position = &p
+	}
+	if metadataJSON != nil {
+		var m store_interface.NodeMetadata
+		if err := json.Unmarshal([]byte(*metadataJSON), &m); err != nil {
+			return change, err
+		}
+		change.Metadata = &m
+	}
+	return change, nil
+}
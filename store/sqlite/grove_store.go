@@ -3,6 +3,7 @@ package sqlite_store
 import (
 	"database/sql"
 	"encoding/json"
+	"sort"
 	"strings"
 
 	"github.com/vixac/bullet/store/store_interface"
@@ -106,6 +107,17 @@ func (s *SQLiteStore) CreateNode(
 		}
 	}
 
+	err = recordChange(tx, space, treeID, store_interface.GroveChange{
+		Kind:     store_interface.GroveChangeCreate,
+		NodeID:   node,
+		Parent:   parent,
+		Position: position,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
@@ -182,6 +194,15 @@ func (s *SQLiteStore) DeleteNode(space store_interface.TenancySpace, treeID stor
 		return err
 	}
 
+	err = recordChange(tx, space, treeID, store_interface.GroveChange{
+		Kind:   store_interface.GroveChangeDelete,
+		NodeID: node,
+		Soft:   soft,
+	})
+	if err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
@@ -323,9 +344,451 @@ func (s *SQLiteStore) MoveNode(
 		}
 	}
 
+	err = recordChange(tx, space, treeID, store_interface.GroveChange{
+		Kind:     store_interface.GroveChangeMove,
+		NodeID:   node,
+		Parent:   newParent,
+		Position: newPosition,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateNodeMetadata replaces a node's metadata
+func (s *SQLiteStore) UpdateNodeMetadata(
+	space store_interface.TenancySpace,
+	treeID store_interface.TreeID,
+	node store_interface.NodeID,
+	metadata store_interface.NodeMetadata,
+) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE grove_nodes SET metadata = ?
+		WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ? AND is_deleted = 0`,
+		string(data), space.AppId, space.TenancyId, string(treeID), string(node))
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return store_interface.ErrNodeNotFound
+	}
+
+	err = recordChange(tx, space, treeID, store_interface.GroveChange{
+		Kind:     store_interface.GroveChangeMetadata,
+		NodeID:   node,
+		Metadata: &metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MoveSubtreeToTree moves a node and its descendants, with their aggregates and mutation history, to another tree
+func (s *SQLiteStore) MoveSubtreeToTree(
+	space store_interface.TenancySpace,
+	srcTree store_interface.TreeID,
+	node store_interface.NodeID,
+	dstTree store_interface.TreeID,
+	dstParent *store_interface.NodeID,
+	position *store_interface.ChildPosition,
+) error {
+	if srcTree == dstTree {
+		return s.MoveNode(space, srcTree, node, dstParent, position)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Check if node exists
+	var exists bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM grove_nodes
+			WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ? AND is_deleted = 0
+		)`, space.AppId, space.TenancyId, string(srcTree), string(node)).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return store_interface.ErrNodeNotFound
+	}
+
+	if dstParent != nil {
+		err = tx.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM grove_nodes
+				WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ? AND is_deleted = 0
+			)`, space.AppId, space.TenancyId, string(dstTree), string(*dstParent)).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return store_interface.ErrNodeNotFound
+		}
+	}
+
+	// Get all descendants (including node itself)
+	rows, err := tx.Query(`
+		SELECT descendant_id, depth FROM grove_closure
+		WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND ancestor_id = ?`,
+		space.AppId, space.TenancyId, string(srcTree), string(node))
+	if err != nil {
+		return err
+	}
+
+	type descendantInfo struct {
+		id    string
+		depth int
+	}
+	var descendants []descendantInfo
+	for rows.Next() {
+		var d descendantInfo
+		if err := rows.Scan(&d.id, &d.depth); err != nil {
+			rows.Close()
+			return err
+		}
+		descendants = append(descendants, d)
+	}
+	rows.Close()
+
+	// Soft-deleted rows still own their IDs, so any existing row is a collision
+	for _, desc := range descendants {
+		err = tx.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM grove_nodes
+				WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ?
+			)`, space.AppId, space.TenancyId, string(dstTree), desc.id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return store_interface.ErrNodeAlreadyExists
+		}
+	}
+
+	// Remove ancestor relationships that are external to the moved subtree
+	_, err = tx.Exec(`
+		DELETE FROM grove_closure
+		WHERE app_id = ? AND tenancy_id = ? AND tree_id = ?
+		AND descendant_id IN (
+			SELECT descendant_id FROM grove_closure
+			WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND ancestor_id = ?
+		)
+		AND ancestor_id NOT IN (
+			SELECT descendant_id FROM grove_closure
+			WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND ancestor_id = ?
+		)`,
+		space.AppId, space.TenancyId, string(srcTree),
+		space.AppId, space.TenancyId, string(srcTree), string(node),
+		space.AppId, space.TenancyId, string(srcTree), string(node))
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range descendants {
+		// Aggregates and mutation markers left behind by hard-deleted nodes in the
+		// destination would otherwise clash with the rows being moved in
+		for _, stmt := range []string{
+			`DELETE FROM grove_aggregates WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ?`,
+			`DELETE FROM grove_mutations WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ?`,
+		} {
+			if _, err = tx.Exec(stmt, space.AppId, space.TenancyId, string(dstTree), desc.id); err != nil {
+				return err
+			}
+		}
+
+		for _, stmt := range []string{
+			`UPDATE grove_nodes SET tree_id = ? WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ?`,
+			`UPDATE grove_aggregates SET tree_id = ? WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ?`,
+			`UPDATE grove_mutations SET tree_id = ? WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ?`,
+			`UPDATE grove_closure SET tree_id = ? WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND descendant_id = ?`,
+		} {
+			if _, err = tx.Exec(stmt, string(dstTree), space.AppId, space.TenancyId, string(srcTree), desc.id); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Update node's parent and position in the destination tree
+	var parentIDStr *string
+	if dstParent != nil {
+		p := string(*dstParent)
+		parentIDStr = &p
+	}
+	var positionVal *float64
+	if position != nil {
+		p := float64(*position)
+		positionVal = &p
+	}
+
+	_, err = tx.Exec(`
+		UPDATE grove_nodes SET parent_id = ?, position = ?
+		WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ?`,
+		parentIDStr, positionVal, space.AppId, space.TenancyId, string(dstTree), string(node))
+	if err != nil {
+		return err
+	}
+
+	// Link node and descendants to the ancestors of the new parent
+	if dstParent != nil {
+		for _, desc := range descendants {
+			_, err = tx.Exec(`
+				INSERT INTO grove_closure (app_id, tenancy_id, tree_id, ancestor_id, descendant_id, depth)
+				SELECT app_id, tenancy_id, tree_id, ancestor_id, ?, depth + ? + 1
+				FROM grove_closure
+				WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND descendant_id = ?`,
+				desc.id, desc.depth, space.AppId, space.TenancyId, string(dstTree), string(*dstParent))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The source tree sees the subtree disappear and the destination sees it appear, parents first
+	sort.Slice(descendants, func(i, j int) bool {
+		if descendants[i].depth != descendants[j].depth {
+			return descendants[i].depth < descendants[j].depth
+		}
+		return descendants[i].id < descendants[j].id
+	})
+	for _, desc := range descendants {
+		err = recordChange(tx, space, srcTree, store_interface.GroveChange{
+			Kind:   store_interface.GroveChangeDelete,
+			NodeID: store_interface.NodeID(desc.id),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, desc := range descendants {
+		var parentIDStr, metadataJSON *string
+		var positionVal *float64
+		err = tx.QueryRow(`
+			SELECT parent_id, position, metadata FROM grove_nodes
+			WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ?`,
+			space.AppId, space.TenancyId, string(dstTree), desc.id).Scan(&parentIDStr, &positionVal, &metadataJSON)
+		if err != nil {
+			return err
+		}
+		change, err := nodeChange(store_interface.GroveChangeCreate, store_interface.NodeID(desc.id), parentIDStr, positionVal, metadataJSON)
+		if err != nil {
+			return err
+		}
+		if err := recordChange(tx, space, dstTree, change); err != nil {
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
 
+// CopySubtree clones a node and its descendants under a new parent, possibly in another tree
+func (s *SQLiteStore) CopySubtree(
+	space store_interface.TenancySpace,
+	srcTree store_interface.TreeID,
+	srcNode store_interface.NodeID,
+	dstTree store_interface.TreeID,
+	dstParent *store_interface.NodeID,
+	opts store_interface.CopySubtreeOptions,
+) (map[store_interface.NodeID]store_interface.NodeID, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Load the source subtree, parents first
+	rows, err := tx.Query(`
+		SELECT n.node_id, n.parent_id, n.position, n.metadata, c.depth
+		FROM grove_closure c
+		INNER JOIN grove_nodes n ON
+			n.app_id = c.app_id AND
+			n.tenancy_id = c.tenancy_id AND
+			n.tree_id = c.tree_id AND
+			n.node_id = c.descendant_id
+		WHERE c.app_id = ? AND c.tenancy_id = ? AND c.tree_id = ? AND c.ancestor_id = ? AND n.is_deleted = 0
+		ORDER BY c.depth, n.node_id`,
+		space.AppId, space.TenancyId, string(srcTree), string(srcNode))
+	if err != nil {
+		return nil, err
+	}
+
+	type subtreeNode struct {
+		id       store_interface.NodeID
+		parent   *string
+		position *float64
+		metadata *string
+		depth    int
+	}
+	var nodes []subtreeNode
+	for rows.Next() {
+		var n subtreeNode
+		var id string
+		if err := rows.Scan(&id, &n.parent, &n.position, &n.metadata, &n.depth); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		n.id = store_interface.NodeID(id)
+		nodes = append(nodes, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 || nodes[0].id != srcNode {
+		return nil, store_interface.ErrNodeNotFound
+	}
+
+	if dstParent != nil {
+		var parentExists bool
+		err = tx.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM grove_nodes
+				WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ? AND is_deleted = 0
+			)`, space.AppId, space.TenancyId, string(dstTree), string(*dstParent)).Scan(&parentExists)
+		if err != nil {
+			return nil, err
+		}
+		if !parentExists {
+			return nil, store_interface.ErrNodeNotFound
+		}
+	}
+
+	ids := make([]store_interface.NodeID, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.id
+	}
+	mapping, err := opts.MapSubtreeIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Soft-deleted rows still own their IDs, so any existing row is a collision
+	for _, newID := range mapping {
+		var taken bool
+		err = tx.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM grove_nodes
+				WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ?
+			)`, space.AppId, space.TenancyId, string(dstTree), string(newID)).Scan(&taken)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, store_interface.ErrNodeAlreadyExists
+		}
+	}
+
+	parents := make(map[store_interface.NodeID]store_interface.NodeID, len(nodes))
+	for _, n := range nodes {
+		var parentIDStr *string
+		positionVal := n.position
+		if n.id == srcNode {
+			if dstParent != nil {
+				p := string(*dstParent)
+				parentIDStr = &p
+			}
+			if opts.Position != nil {
+				p := float64(*opts.Position)
+				positionVal = &p
+			}
+		} else {
+			p := mapping[store_interface.NodeID(*n.parent)]
+			parents[mapping[n.id]] = p
+			ps := string(p)
+			parentIDStr = &ps
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO grove_nodes (app_id, tenancy_id, tree_id, node_id, parent_id, position, metadata, is_deleted)
+			VALUES (?, ?, ?, ?, ?, ?, ?, 0)`,
+			space.AppId, space.TenancyId, string(dstTree), string(mapping[n.id]), parentIDStr, positionVal, n.metadata)
+		if err != nil {
+			return nil, err
+		}
+
+		change, err := nodeChange(store_interface.GroveChangeCreate, mapping[n.id], parentIDStr, positionVal, n.metadata)
+		if err != nil {
+			return nil, err
+		}
+		if err := recordChange(tx, space, dstTree, change); err != nil {
+			return nil, err
+		}
+
+		if opts.CopyAggregates {
+			_, err = tx.Exec(`
+				INSERT INTO grove_aggregates (app_id, tenancy_id, tree_id, node_id, aggregate_key, aggregate_value)
+				SELECT app_id, tenancy_id, ?, ?, aggregate_key, aggregate_value
+				FROM grove_aggregates
+				WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND node_id = ?
+				ON CONFLICT(app_id, tenancy_id, tree_id, node_id, aggregate_key)
+				DO UPDATE SET aggregate_value = excluded.aggregate_value`,
+				string(dstTree), string(mapping[n.id]),
+				space.AppId, space.TenancyId, string(srcTree), string(n.id))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Closure rows inside the copied subtree
+	for _, pair := range store_interface.SubtreeClosure(mapping[srcNode], parents) {
+		_, err = tx.Exec(`
+			INSERT INTO grove_closure (app_id, tenancy_id, tree_id, ancestor_id, descendant_id, depth)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			space.AppId, space.TenancyId, string(dstTree), string(pair.Ancestor), string(pair.Descendant), pair.Depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Closure rows linking the copy to the ancestors of its new parent
+	if dstParent != nil {
+		for _, n := range nodes {
+			_, err = tx.Exec(`
+				INSERT INTO grove_closure (app_id, tenancy_id, tree_id, ancestor_id, descendant_id, depth)
+				SELECT app_id, tenancy_id, tree_id, ancestor_id, ?, depth + ? + 1
+				FROM grove_closure
+				WHERE app_id = ? AND tenancy_id = ? AND tree_id = ? AND descendant_id = ?`,
+				string(mapping[n.id]), n.depth, space.AppId, space.TenancyId, string(dstTree), string(*dstParent))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
 // Exists checks if a node exists
 func (s *SQLiteStore) Exists(space store_interface.TenancySpace, treeID store_interface.TreeID, node store_interface.NodeID) (bool, error) {
 	var exists bool
@@ -648,6 +1111,16 @@ func (s *SQLiteStore) ApplyAggregateMutation(
 		return err
 	}
 
+	err = recordChange(tx, space, treeID, store_interface.GroveChange{
+		Kind:       store_interface.GroveChangeMutation,
+		NodeID:     node,
+		MutationID: &mutation,
+		Deltas:     deltas,
+	})
+	if err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
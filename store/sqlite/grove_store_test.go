@@ -85,7 +85,7 @@ func TestTrackQueriesChunkLargeFilters(t *testing.T) {
 	for i := 1; i < len(tags); i++ {
 		tags[i] = int64(i + 100)
 	}
-	byPrefix, err := store.GetItemsByKeyPrefix(space, bucketID, "key-", tags, nil, false)
+	byPrefix, err := store.GetItemsByKeyPrefix(space, bucketID, "key-", tags, nil)
 	if err != nil {
 		t.Fatalf("get by key prefix: %v", err)
 	}
@@ -98,7 +98,7 @@ func TestTrackQueriesChunkLargeFilters(t *testing.T) {
 	for i := 1; i < len(prefixes); i++ {
 		prefixes[i] = fmt.Sprintf("not-a-match-%d", i)
 	}
-	byPrefixes, err := store.GetItemsByKeyPrefixes(space, bucketID, prefixes, tags, nil, false)
+	byPrefixes, err := store.GetItemsByKeyPrefixes(space, bucketID, prefixes, tags, nil)
 	if err != nil {
 		t.Fatalf("get by key prefixes: %v", err)
 	}
@@ -106,3 +106,76 @@ func TestTrackQueriesChunkLargeFilters(t *testing.T) {
 		t.Errorf("prefixes result has %d items, want %d", len(byPrefixes), itemCount)
 	}
 }
+
+func TestRepairTreeRebuildsCorruptedClosure(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("create SQLite store: %v", err)
+	}
+	defer store.db.Close()
+
+	space := store_interface.TenancySpace{AppId: 1, TenancyId: 1}
+	treeID := store_interface.TreeID("repair")
+	root := store_interface.NodeID("root")
+	a := store_interface.NodeID("a")
+	b := store_interface.NodeID("b")
+	store.CreateNode(space, treeID, root, nil, nil, nil)
+	store.CreateNode(space, treeID, a, &root, nil, nil)
+	store.CreateNode(space, treeID, b, &a, nil, nil)
+
+	// Simulate a bad manual fix: b is pointed at a missing parent, c and d form a loop,
+	// and a's closure rows are dropped
+	for _, stmt := range []string{
+		`UPDATE grove_nodes SET parent_id = 'ghost' WHERE tree_id = 'repair' AND node_id = 'b'`,
+		`INSERT INTO grove_nodes (app_id, tenancy_id, tree_id, node_id, parent_id, is_deleted) VALUES (1, 1, 'repair', 'c', 'd', 0)`,
+		`INSERT INTO grove_nodes (app_id, tenancy_id, tree_id, node_id, parent_id, is_deleted) VALUES (1, 1, 'repair', 'd', 'c', 0)`,
+		`DELETE FROM grove_closure WHERE tree_id = 'repair' AND descendant_id = 'a'`,
+	} {
+		if _, err := store.db.Exec(stmt); err != nil {
+			t.Fatalf("corrupt tree: %v", err)
+		}
+	}
+
+	report, err := store.VerifyTree(space, treeID)
+	if err != nil {
+		t.Fatalf("verify tree: %v", err)
+	}
+	if report.Consistent() {
+		t.Fatal("expected corrupted tree to be reported as inconsistent")
+	}
+	if len(report.OrphanedNodes) != 1 || report.OrphanedNodes[0] != b {
+		t.Errorf("orphaned nodes = %v, want [b]", report.OrphanedNodes)
+	}
+	if len(report.CycleNodes) != 2 {
+		t.Errorf("cycle nodes = %v, want [c d]", report.CycleNodes)
+	}
+	if len(report.MissingClosure) == 0 || len(report.StaleClosure) == 0 {
+		t.Errorf("expected missing and stale closure rows, got %v and %v", report.MissingClosure, report.StaleClosure)
+	}
+
+	if _, err := store.RepairTree(space, treeID); err != nil {
+		t.Fatalf("repair tree: %v", err)
+	}
+	report, err = store.VerifyTree(space, treeID)
+	if err != nil {
+		t.Fatalf("verify repaired tree: %v", err)
+	}
+	if !report.Consistent() {
+		t.Errorf("expected repaired tree to be consistent, got %+v", report)
+	}
+
+	info, err := store.GetNodeInfo(space, treeID, b)
+	if err != nil {
+		t.Fatalf("get b: %v", err)
+	}
+	if info.Parent != nil || info.Depth != 0 {
+		t.Errorf("b should be a root after repair, got parent %v depth %d", info.Parent, info.Depth)
+	}
+	ancestors, _, err := store.GetAncestors(space, treeID, a, nil)
+	if err != nil {
+		t.Fatalf("get ancestors: %v", err)
+	}
+	if len(ancestors) != 1 || ancestors[0] != root {
+		t.Errorf("ancestors of a = %v, want [root]", ancestors)
+	}
+}
@@ -3,6 +3,8 @@ package sqlite_store
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -31,6 +33,10 @@ func NewSQLiteStore(path string) (*SQLiteStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Each connection to ":memory:" opens a database of its own, so keep to one
+	if path == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
 
 	store := &SQLiteStore{db: db}
 	if err := store.initSchema(); err != nil {
@@ -50,18 +56,33 @@ func (s *SQLiteStore) initSchema() error {
 			value INTEGER,
 			tag INTEGER,
 			metric REAL,
+			expires_at INTEGER,
 			PRIMARY KEY (app_id, tenancy_id, bucket_id, key)
 		);`,
 
 		`CREATE INDEX IF NOT EXISTS track_prefix_idx
 		 ON track(app_id, tenancy_id, bucket_id, key);`,
 
+		// Top-K queries ordered by metric
+		`CREATE INDEX IF NOT EXISTS track_metric_idx
+		 ON track(app_id, tenancy_id, bucket_id, metric);`,
+
+		// Top-K queries ordered by value, and reverse lookups of keys by value in key order
+		`CREATE INDEX IF NOT EXISTS track_value_key_idx
+		 ON track(app_id, tenancy_id, bucket_id, value, key);`,
+
+		// Superseded by track_value_key_idx
+		`DROP INDEX IF EXISTS track_value_idx;`,
+
 		`CREATE TABLE IF NOT EXISTS depot (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			app_id INTEGER NOT NULL,
 			tenancy_id INTEGER NOT NULL,
 			bucket_id INTEGER NOT NULL,
-			value TEXT NOT NULL
+			value TEXT NOT NULL,
+			revision INTEGER NOT NULL DEFAULT 1,
+			content_type TEXT,
+			data BLOB
 		);`,
 
 		`CREATE INDEX IF NOT EXISTS depot_space_bucket_idx
@@ -120,6 +141,124 @@ func (s *SQLiteStore) initSchema() error {
 
 		`CREATE INDEX IF NOT EXISTS grove_aggregates_key_idx
 		 ON grove_aggregates(app_id, tenancy_id, tree_id, aggregate_key);`,
+
+		// Per-tree change log and its sequence counter
+		`CREATE TABLE IF NOT EXISTS grove_change_seqs (
+			app_id INTEGER,
+			tenancy_id INTEGER,
+			tree_id TEXT,
+			last_seq INTEGER,
+			PRIMARY KEY (app_id, tenancy_id, tree_id)
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS grove_changes (
+			app_id INTEGER,
+			tenancy_id INTEGER,
+			tree_id TEXT,
+			seq INTEGER,
+			kind TEXT,
+			node_id TEXT,
+			parent_id TEXT,
+			position REAL,
+			metadata TEXT,
+			mutation_id TEXT,
+			deltas TEXT,
+			soft BOOLEAN DEFAULT 0,
+			created_at INTEGER,
+			PRIMARY KEY (app_id, tenancy_id, tree_id, seq)
+		);`,
+	}
+
+	for _, stmt := range schema {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	// Track tables created before keys could expire, or carry further tags, lack the columns
+	if err := s.addColumnIfMissing("track", "expires_at", "INTEGER"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("track", "tags", "TEXT"); err != nil {
+		return err
+	}
+	// As do depot tables created before items had revisions
+	if err := s.addColumnIfMissing("depot", "revision", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	// Or raw values, which keep their bytes in data with content_type set
+	if err := s.addColumnIfMissing("depot", "content_type", "TEXT"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("depot", "data", "BLOB"); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS track_expires_idx
+		 ON track(expires_at) WHERE expires_at IS NOT NULL;`); err != nil {
+		return err
+	}
+	if err := s.initTrackTagsSchema(); err != nil {
+		return err
+	}
+	return s.initTrackHistorySchema()
+}
+
+// insertTrackTags adds the tag set of the NEW row, its tag and the JSON array in tags,
+// to track_tags.
+const insertTrackTags = `INSERT OR IGNORE INTO track_tags (app_id, tenancy_id, bucket_id, key, tag)
+	SELECT NEW.app_id, NEW.tenancy_id, NEW.bucket_id, NEW.key, t.tag FROM (
+		SELECT NEW.tag AS tag WHERE NEW.tag IS NOT NULL
+		UNION SELECT value FROM json_each(COALESCE(NEW.tags, '[]'))
+	) t;`
+
+// initTrackTagsSchema adds track_tags, which holds every row's tag set for tag filters
+// to join against, and the triggers that keep it in step with track. A database from
+// before the table existed is backfilled from the tag column once.
+func (s *SQLiteStore) initTrackTagsSchema() error {
+	var exists int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'track_tags'`).Scan(&exists); err != nil {
+		return err
+	}
+	schema := []string{
+		`CREATE TABLE IF NOT EXISTS track_tags (
+			app_id INTEGER,
+			tenancy_id INTEGER,
+			bucket_id INTEGER,
+			key TEXT,
+			tag INTEGER,
+			PRIMARY KEY (app_id, tenancy_id, bucket_id, key, tag)
+		);`,
+
+		`CREATE INDEX IF NOT EXISTS track_tags_tag_idx
+		 ON track_tags(app_id, tenancy_id, bucket_id, tag, key);`,
+
+		`CREATE TRIGGER IF NOT EXISTS track_tags_on_insert
+			AFTER INSERT ON track
+			FOR EACH ROW
+			BEGIN
+				` + insertTrackTags + `
+			END;`,
+
+		`CREATE TRIGGER IF NOT EXISTS track_tags_on_update
+			AFTER UPDATE OF tag, tags ON track
+			FOR EACH ROW
+			BEGIN
+				DELETE FROM track_tags
+				WHERE app_id = OLD.app_id AND tenancy_id = OLD.tenancy_id AND bucket_id = OLD.bucket_id AND key = OLD.key;
+				` + insertTrackTags + `
+			END;`,
+
+		`CREATE TRIGGER IF NOT EXISTS track_tags_on_delete
+			AFTER DELETE ON track
+			FOR EACH ROW
+			BEGIN
+				DELETE FROM track_tags
+				WHERE app_id = OLD.app_id AND tenancy_id = OLD.tenancy_id AND bucket_id = OLD.bucket_id AND key = OLD.key;
+			END;`,
+	}
+	if exists == 0 {
+		schema = append(schema, `INSERT OR IGNORE INTO track_tags (app_id, tenancy_id, bucket_id, key, tag)
+			SELECT app_id, tenancy_id, bucket_id, key, tag FROM track WHERE tag IS NOT NULL;`)
 	}
 
 	for _, stmt := range schema {
@@ -129,3 +268,84 @@ func (s *SQLiteStore) initSchema() error {
 	}
 	return nil
 }
+
+// sqliteNowMillis is the current time in unix milliseconds, for use inside triggers.
+const sqliteNowMillis = "CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER)"
+
+// initTrackHistorySchema adds the history tables and the triggers that fill them. The
+// triggers copy a row's live value into track_history whenever an update or delete
+// replaces it in a bucket that has a policy, so every writer records history for free.
+func (s *SQLiteStore) initTrackHistorySchema() error {
+	schema := []string{
+		`CREATE TABLE IF NOT EXISTS track_history_policy (
+			app_id INTEGER,
+			tenancy_id INTEGER,
+			bucket_id INTEGER,
+			max_versions INTEGER NOT NULL,
+			max_age_ms INTEGER NOT NULL,
+			PRIMARY KEY (app_id, tenancy_id, bucket_id)
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS track_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			app_id INTEGER NOT NULL,
+			tenancy_id INTEGER NOT NULL,
+			bucket_id INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			value INTEGER,
+			tag INTEGER,
+			tags TEXT,
+			metric REAL,
+			expires_at INTEGER,
+			replaced_at INTEGER NOT NULL
+		);`,
+
+		`CREATE INDEX IF NOT EXISTS track_history_key_idx
+		 ON track_history(app_id, tenancy_id, bucket_id, key, id);`,
+	}
+	for _, stmt := range schema {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	// History tables and triggers from before tags were kept neither hold nor copy them
+	if err := s.addColumnIfMissing("track_history", "tags", "TEXT"); err != nil {
+		return err
+	}
+	var triggers []string
+	for _, event := range []string{"UPDATE", "DELETE"} {
+		triggers = append(triggers, fmt.Sprintf(`DROP TRIGGER IF EXISTS track_history_on_%s;`, strings.ToLower(event)))
+		triggers = append(triggers, fmt.Sprintf(`CREATE TRIGGER track_history_on_%[1]s
+			AFTER %[2]s ON track
+			FOR EACH ROW
+			WHEN (OLD.expires_at IS NULL OR OLD.expires_at > %[3]s)
+			 AND EXISTS (
+				SELECT 1 FROM track_history_policy p
+				WHERE p.app_id = OLD.app_id AND p.tenancy_id = OLD.tenancy_id AND p.bucket_id = OLD.bucket_id
+			 )
+			BEGIN
+				INSERT INTO track_history (app_id, tenancy_id, bucket_id, key, value, tag, tags, metric, expires_at, replaced_at)
+				VALUES (OLD.app_id, OLD.tenancy_id, OLD.bucket_id, OLD.key, OLD.value, OLD.tag, OLD.tags, OLD.metric, OLD.expires_at, %[3]s);
+			END;`, strings.ToLower(event), event, sqliteNowMillis))
+	}
+
+	for _, stmt := range triggers {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) addColumnIfMissing(table, column, columnType string) error {
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, columnType))
+	return err
+}
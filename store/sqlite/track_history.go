@@ -0,0 +1,133 @@
+package sqlite_store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/vixac/bullet/model"
+	"github.com/vixac/bullet/store/store_interface"
+)
+
+// The triggers in initTrackHistorySchema record history, so writers need no changes here.
+
+func (s *SQLiteStore) TrackSetHistoryPolicy(space store_interface.TenancySpace, bucketID int32, policy store_interface.TrackHistoryPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if policy.Enabled() {
+		_, err = tx.Exec(`
+			INSERT INTO track_history_policy (app_id, tenancy_id, bucket_id, max_versions, max_age_ms)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(app_id, tenancy_id, bucket_id)
+			DO UPDATE SET max_versions = excluded.max_versions, max_age_ms = excluded.max_age_ms
+		`, space.AppId, space.TenancyId, bucketID, policy.MaxVersions, policy.MaxAge.Milliseconds())
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	for _, table := range []string{"track_history_policy", "track_history"} {
+		_, err := tx.Exec(`DELETE FROM `+table+` WHERE app_id=? AND tenancy_id=? AND bucket_id=?`,
+			space.AppId, space.TenancyId, bucketID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) TrackGetHistoryPolicy(space store_interface.TenancySpace, bucketID int32) (store_interface.TrackHistoryPolicy, error) {
+	var policy store_interface.TrackHistoryPolicy
+	var maxAgeMs int64
+	err := s.db.QueryRow(`
+		SELECT max_versions, max_age_ms FROM track_history_policy
+		WHERE app_id=? AND tenancy_id=? AND bucket_id=?
+	`, space.AppId, space.TenancyId, bucketID).Scan(&policy.MaxVersions, &maxAgeMs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return store_interface.TrackHistoryPolicy{}, nil
+	}
+	if err != nil {
+		return store_interface.TrackHistoryPolicy{}, err
+	}
+	policy.MaxAge = time.Duration(maxAgeMs) * time.Millisecond
+	return policy, nil
+}
+
+func (s *SQLiteStore) TrackGetHistory(space store_interface.TenancySpace, bucketID int32, key string, limit int) ([]model.TrackHistoryEntry, error) {
+	policy, err := s.TrackGetHistoryPolicy(space, bucketID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retained entries are always the newest, so the limit can go in the query
+	query := `
+		SELECT value, tag, tags, metric, expires_at, replaced_at FROM track_history
+		WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND key=?
+		ORDER BY id DESC`
+	args := []any{space.AppId, space.TenancyId, bucketID, key}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []model.TrackHistoryEntry
+	for rows.Next() {
+		var value model.TrackValue
+		var tags *string
+		var expiresAt *int64
+		var replacedAt int64
+		if err := rows.Scan(&value.Value, &value.Tag, &tags, &value.Metric, &expiresAt, &replacedAt); err != nil {
+			return nil, err
+		}
+		if value.Tags, err = store_interface.TrackTagsFromJSON(tags); err != nil {
+			return nil, err
+		}
+		value.ExpiresAt = store_interface.ExpiryFromMillis(expiresAt)
+		entries = append(entries, store_interface.TrackHistoryEntry(value, time.UnixMilli(replacedAt).UTC()))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return policy.Retained(entries, time.Now(), limit), nil
+}
+
+func (s *SQLiteStore) TrackPruneHistory(now time.Time, limit int) (int, error) {
+	res, err := s.db.Exec(`
+		DELETE FROM track_history
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT h.id, h.replaced_at, p.max_versions, p.max_age_ms,
+					ROW_NUMBER() OVER (
+						PARTITION BY h.app_id, h.tenancy_id, h.bucket_id, h.key
+						ORDER BY h.id DESC
+					) AS position
+				FROM track_history h
+				JOIN track_history_policy p
+				  ON p.app_id = h.app_id AND p.tenancy_id = h.tenancy_id AND p.bucket_id = h.bucket_id
+			)
+			WHERE (max_versions > 0 AND position > max_versions)
+			   OR (max_age_ms > 0 AND replaced_at < ? - max_age_ms)
+			LIMIT ?
+		)
+	`, now.UnixMilli(), limit)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -3,6 +3,7 @@ package sqlite_store
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/store_interface"
@@ -22,8 +23,9 @@ func (s *SQLiteStore) TrackGet(
 	err := s.db.QueryRow(`
 		SELECT value FROM track
 		WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND key=?
+		  AND `+liveCondition+`
 	`,
-		space.AppId, space.TenancyId, bucketID, key,
+		space.AppId, space.TenancyId, bucketID, key, time.Now().UnixMilli(),
 	).Scan(&value)
 
 	if errors.Is(err, sql.ErrNoRows) {
@@ -32,15 +34,48 @@ func (s *SQLiteStore) TrackGet(
 	return value, err
 }
 
+func (s *SQLiteStore) TrackGetValue(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	key string,
+) (model.TrackValue, error) {
+
+	var value model.TrackValue
+	var tags *string
+	var expiresAt *int64
+	err := s.db.QueryRow(`
+		SELECT value, tag, tags, metric, expires_at FROM track
+		WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND key=?
+		  AND `+liveCondition+`
+	`,
+		space.AppId, space.TenancyId, bucketID, key, time.Now().UnixMilli(),
+	).Scan(&value.Value, &value.Tag, &tags, &value.Metric, &expiresAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.TrackValue{}, store_interface.ErrTrackKeyNotFound
+	}
+	if err != nil {
+		return model.TrackValue{}, err
+	}
+	if value.Tags, err = store_interface.TrackTagsFromJSON(tags); err != nil {
+		return model.TrackValue{}, err
+	}
+	value.ExpiresAt = store_interface.ExpiryFromMillis(expiresAt)
+	return value, nil
+}
+
+// liveCondition leaves out expired rows; it takes the current time in unix milliseconds.
+const liveCondition = "(expires_at IS NULL OR expires_at > ?)"
+
 func (s *SQLiteStore) GetItemsByKeyPrefix(
 	space store_interface.TenancySpace,
 	bucketID int32,
 	prefix string,
 	tags []int64,
-	metricValue *float64,
-	metricIsGt bool,
+	metric *store_interface.MetricFilter,
 ) ([]model.TrackKeyValueItem, error) {
-	return s.getItemsByKeyPrefixChunks(space, bucketID, []string{prefix}, tags, metricValue, metricIsGt)
+	items, _, err := s.getItemsByKeyPrefixChunks(space, bucketID, []string{prefix}, tags, metric, store_interface.TrackQueryOptions{})
+	return items, err
 }
 
 func (s *SQLiteStore) GetItemsByKeyPrefixes(
@@ -48,36 +83,61 @@ func (s *SQLiteStore) GetItemsByKeyPrefixes(
 	bucketID int32,
 	prefixes []string,
 	tags []int64,
-	metricValue *float64,
-	metricIsGt bool,
+	metric *store_interface.MetricFilter,
 ) ([]model.TrackKeyValueItem, error) {
 
 	if len(prefixes) == 0 {
 		return nil, nil
 	}
 
-	return s.getItemsByKeyPrefixChunks(space, bucketID, prefixes, tags, metricValue, metricIsGt)
+	items, _, err := s.getItemsByKeyPrefixChunks(space, bucketID, prefixes, tags, metric, store_interface.TrackQueryOptions{})
+	return items, err
 }
 
-// getItemsByKeyPrefixChunks splits caller-provided prefix and tag filters so
-// dynamically-generated OR and IN expressions stay below SQLite's expression
-// depth limit. Results are de-duplicated because overlapping prefix chunks can
-// match the same key.
+func (s *SQLiteStore) GetItemsByKeyPrefixesPage(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+
+	if len(prefixes) == 0 {
+		return nil, "", nil
+	}
+
+	return s.getItemsByKeyPrefixChunks(space, bucketID, prefixes, tags, metric, opts)
+}
+
+// getItemsByKeyPrefixChunks splits caller-provided prefixes so the dynamically
+// generated OR expression stays below SQLite's expression depth limit; tags are
+// bound as a single JSON array and need no chunks. Results are de-duplicated
+// because overlapping prefix chunks can match the same key. When paging, every chunk reads at most a page (plus one)
+// from the primary key index and the chunk results are merged.
 func (s *SQLiteStore) getItemsByKeyPrefixChunks(
 	space store_interface.TenancySpace,
 	bucketID int32,
 	prefixes []string,
 	tags []int64,
-	metricValue *float64,
-	metricIsGt bool,
-) ([]model.TrackKeyValueItem, error) {
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, "", err
+		}
+	}
 	prefixes = uniqueStrings(prefixes)
 	tags = uniqueInt64s(tags)
 
 	// Keep all chunks in one read snapshot.
 	tx, err := s.db.Begin()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer tx.Rollback()
 
@@ -89,32 +149,23 @@ func (s *SQLiteStore) getItemsByKeyPrefixChunks(
 			prefixEnd = len(prefixes)
 		}
 
-		// A nil tag slice represents no tag filter, and thus one query for this
-		// prefix chunk rather than zero queries.
-		for tagStart := 0; tagStart < max(1, len(tags)); tagStart += sqliteQueryChunkSize {
-			tagEnd := tagStart + sqliteQueryChunkSize
-			if tagEnd > len(tags) {
-				tagEnd = len(tags)
-			}
-			chunkTags := tags[tagStart:tagEnd]
-
-			items, err := getItemsByKeyPrefixQuery(tx, space, bucketID, prefixes[prefixStart:prefixEnd], chunkTags, metricValue, metricIsGt)
-			if err != nil {
-				return nil, err
-			}
-			for _, item := range items {
-				if _, ok := seen[item.Key]; ok {
-					continue
-				}
-				seen[item.Key] = struct{}{}
-				out = append(out, item)
+		items, err := getItemsByKeyPrefixQuery(tx, space, bucketID, prefixes[prefixStart:prefixEnd], tags, metric, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, item := range items {
+			if _, ok := seen[item.Key]; ok {
+				continue
 			}
+			seen[item.Key] = struct{}{}
+			out = append(out, item)
 		}
 	}
 	if err := tx.Commit(); err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return out, nil
+	page, next := opts.PageItems(out)
+	return page, next, nil
 }
 
 func getItemsByKeyPrefixQuery(
@@ -123,36 +174,90 @@ func getItemsByKeyPrefixQuery(
 	bucketID int32,
 	prefixes []string,
 	tags []int64,
-	metricValue *float64,
-	metricIsGt bool,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
 ) ([]model.TrackKeyValueItem, error) {
-	query := `
-		SELECT key, value, tag, metric
-		FROM track
-		WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND (`
-	args := []any{space.AppId, space.TenancyId, bucketID}
+	condition, args := prefixCondition(prefixes)
+	return queryTrackItems(db, space, bucketID, []string{condition}, args, tags, metric, opts)
+}
+
+// prefixCondition matches keys starting with any of the prefixes.
+func prefixCondition(prefixes []string) (string, []any) {
+	condition := "("
+	var args []any
 	for i, prefix := range prefixes {
 		if i > 0 {
-			query += " OR "
+			condition += " OR "
 		}
-		query += "(key >= ? AND key < ?)"
+		condition += "(key >= ? AND key < ?)"
 		args = append(args, prefix, prefix+"\uffff")
 	}
-	query += ")"
+	return condition + ")", args
+}
+
+// tagCondition matches rows whose tag set in track_tags holds any of the tags, or all
+// of them for store_interface.TrackTagMatchAll. The tags are bound as one JSON array,
+// so there is no limit on how many are given; they must be distinct.
+func tagCondition(tags []int64, match store_interface.TrackTagMatch) (string, []any) {
+	matches := `
+		FROM track_tags tt
+		WHERE tt.app_id = track.app_id AND tt.tenancy_id = track.tenancy_id
+		  AND tt.bucket_id = track.bucket_id AND tt.key = track.key
+		  AND tt.tag IN (SELECT value FROM json_each(?))`
+	args := []any{store_interface.TrackTagsToJSON(tags)}
+	if match == store_interface.TrackTagMatchAll {
+		return "(SELECT COUNT(*)" + matches + ") = ?", append(args, len(tags))
+	}
+	return "EXISTS (SELECT 1" + matches + ")", args
+}
+
+// queryTrackItems reads one bucket's items matching the given key conditions and
+// filters, in the order and up to the fetch limit of opts.
+func queryTrackItems(
+	db sqlQueryer,
+	space store_interface.TenancySpace,
+	bucketID int32,
+	keyConditions []string,
+	keyArgs []any,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, error) {
+	query := `
+		SELECT key, value, tag, tags, metric, expires_at
+		FROM track
+		WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND ` + liveCondition
+	args := []any{space.AppId, space.TenancyId, bucketID, time.Now().UnixMilli()}
+	for _, condition := range keyConditions {
+		query += " AND " + condition
+	}
+	args = append(args, keyArgs...)
 
 	if len(tags) > 0 {
-		query += " AND tag IN (" + placeholders(len(tags)) + ")"
-		for _, tag := range tags {
-			args = append(args, tag)
-		}
+		condition, tagArgs := tagCondition(tags, opts.TagMatch)
+		query += " AND " + condition
+		args = append(args, tagArgs...)
 	}
-	if metricValue != nil {
-		if metricIsGt {
-			query += " AND metric > ?"
+	if metric != nil {
+		condition, metricArgs := metric.SQLCondition("metric", func() string { return "?" })
+		query += " AND " + condition
+		args = append(args, metricArgs...)
+	}
+	if opts.StartAfter != "" {
+		if opts.Descending {
+			query += " AND key < ?"
 		} else {
-			query += " AND metric < ?"
+			query += " AND key > ?"
 		}
-		args = append(args, *metricValue)
+		args = append(args, opts.StartAfter)
+	}
+	if opts.OrderBy == store_interface.TrackOrderByMetric {
+		query += " AND metric IS NOT NULL"
+	}
+	query += opts.SQLOrderBy("key")
+	if fetch := opts.FetchLimit(); fetch > 0 {
+		query += " LIMIT ?"
+		args = append(args, fetch)
 	}
 
 	rows, err := db.Query(query, args...)
@@ -163,8 +268,8 @@ func getItemsByKeyPrefixQuery(
 
 	var out []model.TrackKeyValueItem
 	for rows.Next() {
-		var item model.TrackKeyValueItem
-		if err := rows.Scan(&item.Key, &item.Value.Value, &item.Value.Tag, &item.Value.Metric); err != nil {
+		item, err := scanTrackItem(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, item)
@@ -172,6 +277,284 @@ func getItemsByKeyPrefixQuery(
 	return out, rows.Err()
 }
 
+// scanTrackItem reads a row of key, value, tag, tags, metric and expires_at.
+func scanTrackItem(rows *sql.Rows) (model.TrackKeyValueItem, error) {
+	var item model.TrackKeyValueItem
+	var tags *string
+	var expiresAt *int64
+	if err := rows.Scan(&item.Key, &item.Value.Value, &item.Value.Tag, &tags, &item.Value.Metric, &expiresAt); err != nil {
+		return model.TrackKeyValueItem{}, err
+	}
+	var err error
+	if item.Value.Tags, err = store_interface.TrackTagsFromJSON(tags); err != nil {
+		return model.TrackKeyValueItem{}, err
+	}
+	item.Value.ExpiresAt = store_interface.ExpiryFromMillis(expiresAt)
+	return item, nil
+}
+
+func (s *SQLiteStore) GetItemsByKeyRange(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	keyRange store_interface.TrackKeyRange,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	if err := keyRange.Validate(); err != nil {
+		return nil, "", err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, "", err
+		}
+	}
+	tags = uniqueInt64s(tags)
+	conditions, args := keyRange.SQLConditions("key", func() string { return "?" })
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	out, err := queryTrackItems(tx, space, bucketID, conditions, args, tags, metric, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+	page, next := opts.PageItems(out)
+	return page, next, nil
+}
+
+// GetKeysByValue reads a page per chunk of values through track_value_key_idx; chunks
+// never overlap, so merging them only needs the page cut.
+func (s *SQLiteStore) GetKeysByValue(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	values []int64,
+	prefix string,
+	opts store_interface.TrackQueryOptions,
+) ([]model.TrackKeyValueItem, string, error) {
+	if err := store_interface.ValidateKeysByValue(values, opts); err != nil {
+		return nil, "", err
+	}
+	values = uniqueInt64s(values)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	var out []model.TrackKeyValueItem
+	for start := 0; start < len(values); start += sqliteQueryChunkSize {
+		end := min(start+sqliteQueryChunkSize, len(values))
+		conditions := []string{"value IN (" + placeholders(end-start) + ")"}
+		var args []any
+		for _, v := range values[start:end] {
+			args = append(args, v)
+		}
+		if prefix != "" {
+			condition, prefixArgs := prefixCondition([]string{prefix})
+			conditions = append(conditions, condition)
+			args = append(args, prefixArgs...)
+		}
+		items, err := queryTrackItems(tx, space, bucketID, conditions, args, nil, nil, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		out = append(out, items...)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+	page, next := opts.PageItems(out)
+	return page, next, nil
+}
+
+// TrackAggregate runs one GROUP BY query per prefix chunk and merges the partial
+// groups. The prefixes are made disjoint first, so the chunks never overlap.
+func (s *SQLiteStore) TrackAggregate(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	groupByTag bool,
+) ([]model.TrackAggregateGroup, error) {
+	prefixes, err := store_interface.DisjointPrefixes(prefixes)
+	if err != nil {
+		return nil, err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	tags = uniqueInt64s(tags)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	agg := store_interface.NewTrackAggregator(groupByTag)
+	for prefixStart := 0; prefixStart < len(prefixes); prefixStart += sqliteQueryChunkSize {
+		prefixEnd := min(prefixStart+sqliteQueryChunkSize, len(prefixes))
+		groups, err := aggregateTrackQuery(tx, space, bucketID, prefixes[prefixStart:prefixEnd], tags, metric, groupByTag)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			agg.Merge(g)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return agg.Groups(), nil
+}
+
+func aggregateTrackQuery(
+	db sqlQueryer,
+	space store_interface.TenancySpace,
+	bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+	groupByTag bool,
+) ([]model.TrackAggregateGroup, error) {
+	tagColumn := "NULL"
+	if groupByTag {
+		tagColumn = "tag"
+	}
+	condition, prefixArgs := prefixCondition(prefixes)
+	query := `
+		SELECT ` + tagColumn + `, COUNT(*), COALESCE(SUM(value), 0), MIN(value), MAX(value),
+		       COUNT(metric), COALESCE(SUM(metric), 0), MIN(metric), MAX(metric)
+		FROM track
+		WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND ` + liveCondition + ` AND ` + condition
+	args := append([]any{space.AppId, space.TenancyId, bucketID, time.Now().UnixMilli()}, prefixArgs...)
+
+	if len(tags) > 0 {
+		condition, tagArgs := tagCondition(tags, store_interface.TrackTagMatchAny)
+		query += " AND " + condition
+		args = append(args, tagArgs...)
+	}
+	if metric != nil {
+		condition, metricArgs := metric.SQLCondition("metric", func() string { return "?" })
+		query += " AND " + condition
+		args = append(args, metricArgs...)
+	}
+	if groupByTag {
+		query += " GROUP BY tag"
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.TrackAggregateGroup
+	for rows.Next() {
+		var g model.TrackAggregateGroup
+		if err := rows.Scan(&g.Tag, &g.Count, &g.Value.Sum, &g.Value.Min, &g.Value.Max,
+			&g.Metric.Count, &g.Metric.Sum, &g.Metric.Min, &g.Metric.Max); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteStore) ListTrackBuckets(space store_interface.TenancySpace) ([]int32, error) {
+	rows, err := s.db.Query(`
+		SELECT DISTINCT bucket_id
+		FROM track
+		WHERE app_id=? AND tenancy_id=? AND `+liveCondition+`
+		ORDER BY bucket_id`,
+		space.AppId, space.TenancyId, time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []int32
+	for rows.Next() {
+		var bucketID int32
+		if err := rows.Scan(&bucketID); err != nil {
+			return nil, err
+		}
+		out = append(out, bucketID)
+	}
+	return out, rows.Err()
+}
+
+// TrackBucketStats computes the stats in one query; the byte estimate matches
+// store_interface.TrackEntryBytes.
+func (s *SQLiteStore) TrackBucketStats(space store_interface.TenancySpace, bucketID int32) (model.TrackBucketStats, error) {
+	var partial model.TrackBucketStats
+	var minKey, maxKey *string
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), MIN(key), MAX(key),
+		       COALESCE(SUM(value), 0), MIN(value), MAX(value),
+		       COUNT(metric), COALESCE(SUM(metric), 0), MIN(metric), MAX(metric),
+		       COALESCE(SUM(LENGTH(CAST(key AS BLOB)) + 8
+		           + CASE WHEN tag IS NULL THEN 0 ELSE 8 END
+		           + 8 * COALESCE(json_array_length(tags), 0)
+		           + CASE WHEN metric IS NULL THEN 0 ELSE 8 END
+		           + CASE WHEN expires_at IS NULL THEN 0 ELSE 8 END), 0)
+		FROM track
+		WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND `+liveCondition,
+		space.AppId, space.TenancyId, bucketID, time.Now().UnixMilli(),
+	).Scan(&partial.KeyCount, &minKey, &maxKey,
+		&partial.Value.Sum, &partial.Value.Min, &partial.Value.Max,
+		&partial.Metric.Count, &partial.Metric.Sum, &partial.Metric.Min, &partial.Metric.Max,
+		&partial.ApproxBytes)
+	if err != nil {
+		return model.TrackBucketStats{}, err
+	}
+	if minKey != nil {
+		partial.MinKey, partial.MaxKey = *minKey, *maxKey
+	}
+
+	stats := store_interface.NewTrackBucketStatsBuilder(bucketID)
+	stats.Merge(partial)
+	return stats.Stats()
+}
+
+func (s *SQLiteStore) TrackScanBucket(space store_interface.TenancySpace, bucketID int32, fn func(model.TrackKeyValueItem) error) error {
+	rows, err := s.db.Query(`
+		SELECT key, value, tag, tags, metric, expires_at
+		FROM track
+		WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND `+liveCondition+`
+		ORDER BY key`,
+		space.AppId, space.TenancyId, bucketID, time.Now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scanTrackItem(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 func uniqueStrings(values []string) []string {
 	seen := make(map[string]struct{}, len(values))
 	unique := make([]string, 0, len(values))
@@ -236,6 +619,67 @@ func (s *SQLiteStore) TrackDeleteMany(
 	return tx.Commit()
 }
 
+// TrackDeleteByPrefix runs one range delete per prefix chunk in a single
+// transaction. The prefixes are made disjoint first, so no row is counted twice.
+func (s *SQLiteStore) TrackDeleteByPrefix(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	prefixes []string,
+	tags []int64,
+	metric *store_interface.MetricFilter,
+) (int, error) {
+	prefixes, err := store_interface.DisjointPrefixes(prefixes)
+	if err != nil {
+		return 0, err
+	}
+	if metric != nil {
+		if err := metric.Validate(); err != nil {
+			return 0, err
+		}
+	}
+	tags = uniqueInt64s(tags)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	deleted := 0
+	for prefixStart := 0; prefixStart < len(prefixes); prefixStart += sqliteQueryChunkSize {
+		prefixEnd := min(prefixStart+sqliteQueryChunkSize, len(prefixes))
+		condition, prefixArgs := prefixCondition(prefixes[prefixStart:prefixEnd])
+		query := `
+			DELETE FROM track
+			WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND ` + condition
+		args := append([]any{space.AppId, space.TenancyId, bucketID}, prefixArgs...)
+		if len(tags) > 0 {
+			tagFilter, tagArgs := tagCondition(tags, store_interface.TrackTagMatchAny)
+			query += " AND " + tagFilter
+			args = append(args, tagArgs...)
+		}
+		if metric != nil {
+			metricCondition, metricArgs := metric.SQLCondition("metric", func() string { return "?" })
+			query += " AND " + metricCondition
+			args = append(args, metricArgs...)
+		}
+
+		res, err := tx.Exec(query, args...)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deleted += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
 func (s *SQLiteStore) TrackPutMany(
 	space store_interface.TenancySpace,
 	items map[int32][]model.TrackKeyValueItem,
@@ -249,13 +693,15 @@ func (s *SQLiteStore) TrackPutMany(
 
 	stmt, err := tx.Prepare(`
 		INSERT INTO track
-		(app_id, tenancy_id, bucket_id, key, value, tag, metric)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		(app_id, tenancy_id, bucket_id, key, value, tag, tags, metric, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(app_id, tenancy_id, bucket_id, key)
 		DO UPDATE SET
 			value=excluded.value,
 			tag=excluded.tag,
-			metric=excluded.metric
+			tags=excluded.tags,
+			metric=excluded.metric,
+			expires_at=excluded.expires_at
 	`)
 	if err != nil {
 		return err
@@ -271,7 +717,9 @@ func (s *SQLiteStore) TrackPutMany(
 				item.Key,
 				item.Value.Value,
 				item.Value.Tag,
+				store_interface.TrackTagsToJSON(item.Value.Tags),
 				item.Value.Metric,
+				store_interface.ExpiryMillis(item.Value.ExpiresAt),
 			); err != nil {
 				return err
 			}
@@ -281,6 +729,95 @@ func (s *SQLiteStore) TrackPutMany(
 	return tx.Commit()
 }
 
+// TrackPutManyIf inserts with a conflict clause that only replaces expired rows for
+// if_absent, and otherwise updates live rows in place, so each item is one statement.
+func (s *SQLiteStore) TrackPutManyIf(
+	space store_interface.TenancySpace,
+	items map[int32][]model.TrackKeyValueItem,
+	cond store_interface.TrackPutCondition,
+) (model.TrackPutManyResult, error) {
+	if err := cond.Validate(); err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+
+	var query string
+	switch cond.Mode {
+	case store_interface.TrackPutIfAbsent:
+		query = `
+			INSERT INTO track
+				(app_id, tenancy_id, bucket_id, key, value, tag, tags, metric, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(app_id, tenancy_id, bucket_id, key)
+			DO UPDATE SET
+				value      = excluded.value,
+				tag        = excluded.tag,
+				tags       = excluded.tags,
+				metric     = excluded.metric,
+				expires_at = excluded.expires_at
+			WHERE NOT ` + liveCondition
+	default:
+		query = `
+			UPDATE track
+			SET value = ?, tag = ?, tags = ?, metric = ?, expires_at = ?
+			WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND key=? AND ` + liveCondition
+		if cond.Mode == store_interface.TrackPutIfTag {
+			query += " AND tag = ?"
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UnixMilli()
+	var result model.TrackPutManyResult
+	for _, bucketID := range store_interface.SortedTrackBuckets(items) {
+		for _, item := range items[bucketID] {
+			expiresAt := store_interface.ExpiryMillis(item.Value.ExpiresAt)
+			tags := store_interface.TrackTagsToJSON(item.Value.Tags)
+			var args []any
+			if cond.Mode == store_interface.TrackPutIfAbsent {
+				args = []any{space.AppId, space.TenancyId, bucketID, item.Key,
+					item.Value.Value, item.Value.Tag, tags, item.Value.Metric, expiresAt, now}
+			} else {
+				args = []any{item.Value.Value, item.Value.Tag, tags, item.Value.Metric, expiresAt,
+					space.AppId, space.TenancyId, bucketID, item.Key, now}
+				if cond.Mode == store_interface.TrackPutIfTag {
+					args = append(args, *cond.Tag)
+				}
+			}
+
+			res, err := stmt.Exec(args...)
+			if err != nil {
+				return model.TrackPutManyResult{}, err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return model.TrackPutManyResult{}, err
+			}
+			pair := model.TrackBucketKeyPair{BucketID: bucketID, Key: item.Key}
+			if n == 0 {
+				result.Rejected = append(result.Rejected, pair)
+			} else {
+				result.Written = append(result.Written, pair)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.TrackPutManyResult{}, err
+	}
+	return result, nil
+}
+
 func (s *SQLiteStore) TrackGetMany(
 	space store_interface.TenancySpace,
 	keys map[int32][]string,
@@ -288,6 +825,7 @@ func (s *SQLiteStore) TrackGetMany(
 
 	values := make(map[int32]map[string]model.TrackValue)
 	missing := make(map[int32][]string)
+	now := time.Now().UnixMilli()
 
 	// Keep a single read snapshot while querying multiple buckets and chunks.
 	tx, err := s.db.Begin()
@@ -313,12 +851,12 @@ func (s *SQLiteStore) TrackGetMany(
 			}
 
 			query := `
-				SELECT key, value, tag, metric
+				SELECT key, value, tag, tags, metric, expires_at
 				FROM track
-				WHERE app_id=? AND tenancy_id=? AND bucket_id=?
+				WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND ` + liveCondition + `
 				  AND key IN (` + placeholders(end-start) + `)
 			`
-			args := []any{space.AppId, space.TenancyId, bucketID}
+			args := []any{space.AppId, space.TenancyId, bucketID, now}
 			for _, key := range bucketKeys[start:end] {
 				args = append(args, key)
 			}
@@ -328,14 +866,13 @@ func (s *SQLiteStore) TrackGetMany(
 				return nil, nil, err
 			}
 			for rows.Next() {
-				var key string
-				var tv model.TrackValue
-				if err := rows.Scan(&key, &tv.Value, &tv.Tag, &tv.Metric); err != nil {
+				item, err := scanTrackItem(rows)
+				if err != nil {
 					rows.Close()
 					return nil, nil, err
 				}
-				values[bucketID][key] = tv
-				found[key] = struct{}{}
+				values[bucketID][item.Key] = item.Value
+				found[item.Key] = struct{}{}
 			}
 			if err := rows.Err(); err != nil {
 				rows.Close()
@@ -366,17 +903,20 @@ func (s *SQLiteStore) TrackPut(
 	value int64,
 	tag *int64,
 	metric *float64,
+	expiresAt *time.Time,
 ) error {
 
 	_, err := s.db.Exec(`
 		INSERT INTO track
-			(app_id, tenancy_id, bucket_id, key, value, tag, metric)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+			(app_id, tenancy_id, bucket_id, key, value, tag, metric, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(app_id, tenancy_id, bucket_id, key)
 		DO UPDATE SET
-			value  = excluded.value,
-			tag    = excluded.tag,
-			metric = excluded.metric
+			value      = excluded.value,
+			tag        = excluded.tag,
+			tags       = NULL,
+			metric     = excluded.metric,
+			expires_at = excluded.expires_at
 	`,
 		space.AppId,
 		space.TenancyId,
@@ -385,7 +925,129 @@ func (s *SQLiteStore) TrackPut(
 		value,
 		tag,
 		metric,
+		store_interface.ExpiryMillis(expiresAt),
 	)
 
 	return err
 }
+
+func (s *SQLiteStore) TrackIncrement(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	key string,
+	delta int64,
+) (model.TrackValue, error) {
+
+	// An expired row starts again from zero, without its tag, metric or expiry.
+	// Every SET expression sees the row as it was before the update.
+	now := time.Now().UnixMilli()
+	var value model.TrackValue
+	var tags *string
+	var expiresAt *int64
+	err := s.db.QueryRow(`
+		INSERT INTO track
+			(app_id, tenancy_id, bucket_id, key, value)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(app_id, tenancy_id, bucket_id, key)
+		DO UPDATE SET
+			value      = CASE WHEN `+liveCondition+` THEN value + excluded.value ELSE excluded.value END,
+			tag        = CASE WHEN `+liveCondition+` THEN tag END,
+			tags       = CASE WHEN `+liveCondition+` THEN tags END,
+			metric     = CASE WHEN `+liveCondition+` THEN metric END,
+			expires_at = CASE WHEN `+liveCondition+` THEN expires_at END
+		RETURNING value, tag, tags, metric, expires_at
+	`,
+		space.AppId, space.TenancyId, bucketID, key, delta, now, now, now, now, now,
+	).Scan(&value.Value, &value.Tag, &tags, &value.Metric, &expiresAt)
+	if err != nil {
+		return model.TrackValue{}, err
+	}
+	if value.Tags, err = store_interface.TrackTagsFromJSON(tags); err != nil {
+		return model.TrackValue{}, err
+	}
+	value.ExpiresAt = store_interface.ExpiryFromMillis(expiresAt)
+	return value, nil
+}
+
+func (s *SQLiteStore) TrackCompareAndSwap(
+	space store_interface.TenancySpace,
+	bucketID int32,
+	key string,
+	expected *int64,
+	value int64,
+	tag *int64,
+	metric *float64,
+) error {
+
+	var res sql.Result
+	var err error
+	if expected == nil {
+		// An expired row counts as absent and is replaced
+		res, err = s.db.Exec(`
+			INSERT INTO track
+				(app_id, tenancy_id, bucket_id, key, value, tag, metric)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(app_id, tenancy_id, bucket_id, key)
+			DO UPDATE SET
+				value      = excluded.value,
+				tag        = excluded.tag,
+				tags       = NULL,
+				metric     = excluded.metric,
+				expires_at = NULL
+			WHERE NOT `+liveCondition+`
+		`,
+			space.AppId, space.TenancyId, bucketID, key, value, tag, metric, time.Now().UnixMilli(),
+		)
+	} else {
+		res, err = s.db.Exec(`
+			UPDATE track
+			SET value = ?, tag = ?, tags = NULL, metric = ?, expires_at = NULL
+			WHERE app_id=? AND tenancy_id=? AND bucket_id=? AND key=? AND value=?
+			  AND `+liveCondition+`
+		`,
+			value, tag, metric,
+			space.AppId, space.TenancyId, bucketID, key, *expected, time.Now().UnixMilli(),
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return store_interface.ErrTrackValueMismatch
+	}
+	return nil
+}
+
+func (s *SQLiteStore) TrackDeleteExpired(now time.Time, limit int) ([]store_interface.TrackExpiredKey, error) {
+	rows, err := s.db.Query(`
+		DELETE FROM track
+		WHERE rowid IN (
+			SELECT rowid FROM track
+			WHERE expires_at IS NOT NULL AND expires_at <= ?
+			LIMIT ?
+		)
+		RETURNING app_id, tenancy_id, bucket_id, key
+	`, now.UnixMilli(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTrackExpiredKeys(rows)
+}
+
+func scanTrackExpiredKeys(rows *sql.Rows) ([]store_interface.TrackExpiredKey, error) {
+	var deleted []store_interface.TrackExpiredKey
+	for rows.Next() {
+		var k store_interface.TrackExpiredKey
+		if err := rows.Scan(&k.Space.AppId, &k.Space.TenancyId, &k.BucketID, &k.Key); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, k)
+	}
+	return deleted, rows.Err()
+}
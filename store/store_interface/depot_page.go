@@ -0,0 +1,70 @@
+package store_interface
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/vixac/bullet/model"
+)
+
+// DepotPageOptions pages through a depot bucket in ID order. IDs are positive, so a
+// zero StartAfter means the first page.
+type DepotPageOptions struct {
+	Limit      int   // maximum items per page, 0 for no limit
+	StartAfter int64 // resume after this ID, exclusive; 0 starts at the first (or last) ID
+	Descending bool
+}
+
+func (o DepotPageOptions) Validate() error {
+	if o.Limit < 0 {
+		return fmt.Errorf("%w: limit must not be negative", ErrInvalidQueryOptions)
+	}
+	if o.StartAfter < 0 {
+		return fmt.Errorf("%w: start_after must not be negative", ErrInvalidQueryOptions)
+	}
+	return nil
+}
+
+// After reports whether id comes after the cursor in the page's direction.
+func (o DepotPageOptions) After(id int64) bool {
+	if o.StartAfter == 0 {
+		return true
+	}
+	if o.Descending {
+		return id < o.StartAfter
+	}
+	return id > o.StartAfter
+}
+
+// FetchLimit is how many rows a backend should read to fill a page and know whether
+// another one follows, or 0 when unlimited.
+func (o DepotPageOptions) FetchLimit() int {
+	if o.Limit == 0 {
+		return 0
+	}
+	return o.Limit + 1
+}
+
+// PageItems sorts items in the page's direction, drops anything not after the cursor
+// and cuts the result to the limit. The returned cursor is the last ID of the page when
+// more items follow, and 0 otherwise.
+func (o DepotPageOptions) PageItems(items []model.DepotItem) ([]model.DepotItem, int64) {
+	sort.Slice(items, func(i, j int) bool {
+		if o.Descending {
+			return items[i].ID > items[j].ID
+		}
+		return items[i].ID < items[j].ID
+	})
+
+	out := make([]model.DepotItem, 0, len(items))
+	for _, item := range items {
+		if o.After(item.ID) {
+			out = append(out, item)
+		}
+	}
+
+	if o.Limit > 0 && len(out) > o.Limit {
+		return out[:o.Limit], out[o.Limit-1].ID
+	}
+	return out, 0
+}
@@ -0,0 +1,230 @@
+package store_interface
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidDepotQuery = errors.New("invalid depot query")
+
+type DepotFilterOp string
+
+const (
+	DepotFilterEq     DepotFilterOp = "eq"
+	DepotFilterIn     DepotFilterOp = "in"
+	DepotFilterGt     DepotFilterOp = "gt"
+	DepotFilterGte    DepotFilterOp = "gte"
+	DepotFilterLt     DepotFilterOp = "lt"
+	DepotFilterLte    DepotFilterOp = "lte"
+	DepotFilterExists DepotFilterOp = "exists" // the path is present, even if null
+)
+
+// DepotFieldFilter matches depot values that are JSON objects by the field at Path, a
+// dot-separated list of object keys. Eq and in compare strings, numbers and booleans
+// with the field, which has to be of the same type; the range operators order numbers
+// numerically and strings bytewise. Values that are not JSON never match.
+type DepotFieldFilter struct {
+	Path   string
+	Op     DepotFilterOp
+	Value  any   // the operand of eq and the range operators
+	Values []any // the operands of in
+}
+
+var depotPathSegment = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// DepotFilterKind is the JSON type a filter operand compares against.
+type DepotFilterKind string
+
+const (
+	DepotKindString DepotFilterKind = "string"
+	DepotKindNumber DepotFilterKind = "number"
+	DepotKindBool   DepotFilterKind = "boolean"
+)
+
+// DepotOperand normalises a filter operand to a string, float64 or bool, and reports its kind.
+func DepotOperand(v any) (any, DepotFilterKind, error) {
+	switch x := v.(type) {
+	case string:
+		return x, DepotKindString, nil
+	case bool:
+		return x, DepotKindBool, nil
+	case float64:
+		return x, DepotKindNumber, nil
+	case float32:
+		return float64(x), DepotKindNumber, nil
+	case int:
+		return float64(x), DepotKindNumber, nil
+	case int32:
+		return float64(x), DepotKindNumber, nil
+	case int64:
+		return float64(x), DepotKindNumber, nil
+	case json.Number:
+		f, err := x.Float64()
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: invalid number %q", ErrInvalidDepotQuery, x)
+		}
+		return f, DepotKindNumber, nil
+	}
+	return nil, "", fmt.Errorf("%w: operand %v is not a string, number or boolean", ErrInvalidDepotQuery, v)
+}
+
+// PathSegments splits Path into its object keys.
+func (f DepotFieldFilter) PathSegments() []string {
+	return strings.Split(f.Path, ".")
+}
+
+// Validate checks the path and that the operands suit the operator.
+func (f DepotFieldFilter) Validate() error {
+	for _, segment := range f.PathSegments() {
+		if !depotPathSegment.MatchString(segment) {
+			return fmt.Errorf("%w: path %q must be dot-separated keys of letters, digits, _ and -", ErrInvalidDepotQuery, f.Path)
+		}
+	}
+	switch f.Op {
+	case DepotFilterEq:
+		_, _, err := DepotOperand(f.Value)
+		return err
+	case DepotFilterIn:
+		if len(f.Values) == 0 {
+			return fmt.Errorf("%w: in needs at least one value", ErrInvalidDepotQuery)
+		}
+		for _, v := range f.Values {
+			if _, _, err := DepotOperand(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case DepotFilterGt, DepotFilterGte, DepotFilterLt, DepotFilterLte:
+		_, kind, err := DepotOperand(f.Value)
+		if err != nil {
+			return err
+		}
+		if kind == DepotKindBool {
+			return fmt.Errorf("%w: %s needs a string or number", ErrInvalidDepotQuery, f.Op)
+		}
+		return nil
+	case DepotFilterExists:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown operator %q", ErrInvalidDepotQuery, f.Op)
+}
+
+// SQLOperator is the comparison of eq and the range operators; in compares with "=".
+func (f DepotFieldFilter) SQLOperator() string {
+	ops := map[DepotFilterOp]string{
+		DepotFilterGt:  ">",
+		DepotFilterGte: ">=",
+		DepotFilterLt:  "<",
+		DepotFilterLte: "<=",
+	}
+	if op, ok := ops[f.Op]; ok {
+		return op
+	}
+	return "="
+}
+
+// ValidateDepotQuery checks the filters and page options of a depot query.
+func ValidateDepotQuery(filters []DepotFieldFilter, opts DepotPageOptions) error {
+	for _, f := range filters {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	return opts.Validate()
+}
+
+// DepotValueMatches applies the filters in Go, for backends without JSON queries. Every
+// filter has to match.
+func DepotValueMatches(filters []DepotFieldFilter, value string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	var doc any
+	if err := json.Unmarshal([]byte(value), &doc); err != nil {
+		return false
+	}
+	for _, f := range filters {
+		if !f.matches(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f DepotFieldFilter) matches(doc any) bool {
+	field := doc
+	for _, segment := range f.PathSegments() {
+		obj, ok := field.(map[string]any)
+		if !ok {
+			return false
+		}
+		if field, ok = obj[segment]; !ok {
+			return false
+		}
+	}
+
+	switch f.Op {
+	case DepotFilterExists:
+		return true
+	case DepotFilterIn:
+		for _, v := range f.Values {
+			if depotCompare(field, v) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	c := depotCompare(field, f.Value)
+	switch f.Op {
+	case DepotFilterEq:
+		return c == 0
+	case DepotFilterGt:
+		return c == 1
+	case DepotFilterGte:
+		return c == 0 || c == 1
+	case DepotFilterLt:
+		return c == -1
+	case DepotFilterLte:
+		return c == 0 || c == -1
+	}
+	return false
+}
+
+// depotCompare orders a decoded JSON field against an operand of the same kind, and
+// returns 2 when they are of different kinds and so never match.
+func depotCompare(field, operand any) int {
+	v, kind, err := DepotOperand(operand)
+	if err != nil {
+		return 2
+	}
+	switch kind {
+	case DepotKindString:
+		s, ok := field.(string)
+		if !ok {
+			return 2
+		}
+		return strings.Compare(s, v.(string))
+	case DepotKindNumber:
+		n, ok := field.(float64)
+		if !ok {
+			return 2
+		}
+		switch m := v.(float64); {
+		case n < m:
+			return -1
+		case n > m:
+			return 1
+		}
+		return 0
+	case DepotKindBool:
+		b, ok := field.(bool)
+		if !ok || b != v.(bool) {
+			return 2
+		}
+		return 0
+	}
+	return 2
+}
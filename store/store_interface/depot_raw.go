@@ -0,0 +1,29 @@
+package store_interface
+
+import "github.com/vixac/bullet/model"
+
+// Raw depot items hold bytes of a content type in place of a text value, whose Value is
+// then empty. A text update turns a raw item back into a text one.
+const (
+	// DepotTextContentType is the content type raw reads give text items.
+	DepotTextContentType = "text/plain; charset=utf-8"
+	// DepotBinaryContentType is the content type of raw items stored without one.
+	DepotBinaryContentType = "application/octet-stream"
+)
+
+// DepotRawContentType is the content type to store raw data with.
+func DepotRawContentType(contentType string) string {
+	if contentType == "" {
+		return DepotBinaryContentType
+	}
+	return contentType
+}
+
+// DepotRawFromItem gives an item as raw bytes: data for a raw item, and the value for a
+// text item, which has no content type.
+func DepotRawFromItem(item model.DepotItem, data []byte) model.DepotRawItem {
+	if item.ContentType == "" {
+		return model.DepotRawItem{ID: item.ID, Data: []byte(item.Value), ContentType: DepotTextContentType, Revision: item.Revision}
+	}
+	return model.DepotRawItem{ID: item.ID, Data: data, ContentType: item.ContentType, Revision: item.Revision}
+}
@@ -0,0 +1,27 @@
+package store_interface
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DepotInitialRevision is the revision of a newly created depot item.
+const DepotInitialRevision int64 = 1
+
+var ErrDepotRevisionConflict = errors.New("depot item revision conflict")
+
+// DepotRevisionConflictError reports that a depot item was no longer at the revision
+// an update expected. It matches ErrDepotRevisionConflict with errors.Is.
+type DepotRevisionConflictError struct {
+	ID       int64
+	Expected int64
+	Current  int64
+}
+
+func (e *DepotRevisionConflictError) Error() string {
+	return fmt.Sprintf("%s: item %d is at revision %d, not %d", ErrDepotRevisionConflict, e.ID, e.Current, e.Expected)
+}
+
+func (e *DepotRevisionConflictError) Unwrap() error {
+	return ErrDepotRevisionConflict
+}
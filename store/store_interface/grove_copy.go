@@ -0,0 +1,73 @@
+package store_interface
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Validate reports whether the options describe a usable ID strategy.
+func (o CopySubtreeOptions) Validate() error {
+	switch o.IDStrategy {
+	case NodeIDStrategyAffix, NodeIDStrategyUUID:
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown id strategy %q", ErrInvalidCopyOptions, o.IDStrategy)
+	}
+}
+
+// NewNodeID returns the ID the copy of node should be created with.
+func (o CopySubtreeOptions) NewNodeID(node NodeID) NodeID {
+	if o.IDStrategy == NodeIDStrategyUUID {
+		return NodeID(uuid.NewString())
+	}
+	return NodeID(o.Prefix + string(node) + o.Suffix)
+}
+
+// MapSubtreeIDs generates the new ID for every node in a subtree.
+// Returns ErrNodeAlreadyExists if two source nodes would map to the same ID.
+func (o CopySubtreeOptions) MapSubtreeIDs(nodes []NodeID) (map[NodeID]NodeID, error) {
+	mapping := make(map[NodeID]NodeID, len(nodes))
+	used := make(map[NodeID]bool, len(nodes))
+	for _, node := range nodes {
+		newID := o.NewNodeID(node)
+		if used[newID] {
+			return nil, ErrNodeAlreadyExists
+		}
+		used[newID] = true
+		mapping[node] = newID
+	}
+	return mapping, nil
+}
+
+// ClosurePair is a single ancestor/descendant row of a closure table.
+type ClosurePair struct {
+	Ancestor   NodeID
+	Descendant NodeID
+	Depth      int
+}
+
+// SubtreeClosure computes the closure rows, including self references, of the
+// subtree rooted at root. parents maps every other member of the subtree to its
+// parent; members whose parent chain does not lead back to root are skipped.
+func SubtreeClosure(root NodeID, parents map[NodeID]NodeID) []ClosurePair {
+	pairs := []ClosurePair{{Ancestor: root, Descendant: root, Depth: 0}}
+	for node := range parents {
+		var chain []ClosurePair
+		current, depth := node, 0
+		for {
+			chain = append(chain, ClosurePair{Ancestor: current, Descendant: node, Depth: depth})
+			if current == root {
+				pairs = append(pairs, chain...)
+				break
+			}
+			parent, ok := parents[current]
+			if !ok || depth > len(parents) {
+				break
+			}
+			current = parent
+			depth++
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,174 @@
+package store_interface
+
+import "sort"
+
+// TreeIntegrityReport describes how a tree's derived data (closure rows, depths)
+// and side tables (aggregates, mutation markers) disagree with its parent pointers.
+type TreeIntegrityReport struct {
+	TreeID             TreeID
+	NodeCount          int
+	OrphanedNodes      []NodeID      // live nodes whose parent does not exist
+	CycleNodes         []NodeID      // nodes whose parent chain loops back on itself
+	MissingClosure     []ClosurePair // closure rows implied by parent pointers but absent or at the wrong depth
+	StaleClosure       []ClosurePair // closure rows present but not implied by parent pointers
+	DepthMismatches    []NodeID      // stored depth disagrees with parent pointers (backends that store depth)
+	DanglingAggregates []NodeID      // aggregate rows for nodes that do not exist
+	DanglingMutations  []NodeID      // mutation markers for nodes that do not exist
+	Repaired           bool          // set by RepairTree once the problems above have been fixed
+}
+
+// Consistent reports whether no problems were found.
+func (r *TreeIntegrityReport) Consistent() bool {
+	return len(r.OrphanedNodes) == 0 &&
+		len(r.CycleNodes) == 0 &&
+		len(r.MissingClosure) == 0 &&
+		len(r.StaleClosure) == 0 &&
+		len(r.DepthMismatches) == 0 &&
+		len(r.DanglingAggregates) == 0 &&
+		len(r.DanglingMutations) == 0
+}
+
+// TreeShape is the structure of a tree as derived from parent pointers alone.
+// Orphans and one member of every cycle are detached and treated as roots,
+// which is also how RepairTree fixes them.
+type TreeShape struct {
+	Parents  map[NodeID]*NodeID // effective parent of every node, nil for roots
+	Depths   map[NodeID]int
+	Closure  map[ClosurePair]bool // keyed with Depth included
+	Orphans  []NodeID
+	Cycles   []NodeID
+	Detached []NodeID // nodes whose parent pointer has to be cleared
+}
+
+// BuildTreeShape derives depths and closure rows from each live node's parent.
+func BuildTreeShape(parents map[NodeID]*NodeID) *TreeShape {
+	shape := &TreeShape{
+		Parents: make(map[NodeID]*NodeID, len(parents)),
+		Depths:  make(map[NodeID]int, len(parents)),
+		Closure: make(map[ClosurePair]bool, len(parents)),
+	}
+
+	ids := make([]NodeID, 0, len(parents))
+	for id := range parents {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	detached := make(map[NodeID]bool)
+	for _, id := range ids {
+		shape.Parents[id] = parents[id]
+		if parent := parents[id]; parent != nil {
+			if _, ok := parents[*parent]; !ok {
+				shape.Orphans = append(shape.Orphans, id)
+				detached[id] = true
+			}
+		}
+	}
+
+	// Find cycles by walking parent chains; a node revisited within the current walk closes a loop
+	state := make(map[NodeID]int) // 0 unvisited, 1 on current path, 2 done
+	for _, id := range ids {
+		var path []NodeID
+		current := id
+		looped := false
+		for {
+			if state[current] != 0 {
+				looped = state[current] == 1
+				break
+			}
+			state[current] = 1
+			path = append(path, current)
+			parent := parents[current]
+			if parent == nil || detached[current] {
+				break
+			}
+			current = *parent
+		}
+		if looped {
+			// current is on the path, so the loop is path[index(current):]
+			start := 0
+			for i, n := range path {
+				if n == current {
+					start = i
+					break
+				}
+			}
+			loop := path[start:]
+			breakAt := loop[0]
+			for _, n := range loop {
+				shape.Cycles = append(shape.Cycles, n)
+				if n < breakAt {
+					breakAt = n
+				}
+			}
+			detached[breakAt] = true
+		}
+		for _, n := range path {
+			state[n] = 2
+		}
+	}
+	sort.Slice(shape.Cycles, func(i, j int) bool { return shape.Cycles[i] < shape.Cycles[j] })
+
+	for _, id := range ids {
+		if detached[id] {
+			shape.Parents[id] = nil
+			shape.Detached = append(shape.Detached, id)
+		}
+	}
+
+	for _, id := range ids {
+		shape.Closure[ClosurePair{Ancestor: id, Descendant: id, Depth: 0}] = true
+		depth := 0
+		for current := shape.Parents[id]; current != nil; current = shape.Parents[*current] {
+			depth++
+			shape.Closure[ClosurePair{Ancestor: *current, Descendant: id, Depth: depth}] = true
+		}
+		shape.Depths[id] = depth
+	}
+
+	return shape
+}
+
+// DiffClosure compares stored closure rows against the shape.
+func (s *TreeShape) DiffClosure(stored []ClosurePair) (missing, stale []ClosurePair) {
+	present := make(map[ClosurePair]bool, len(stored))
+	for _, pair := range stored {
+		present[pair] = true
+		if !s.Closure[pair] {
+			stale = append(stale, pair)
+		}
+	}
+	for pair := range s.Closure {
+		if !present[pair] {
+			missing = append(missing, pair)
+		}
+	}
+	sortClosurePairs(missing)
+	sortClosurePairs(stale)
+	return missing, stale
+}
+
+// NewIntegrityReport fills in the structural part of a report from the shape and stored closure.
+func (s *TreeShape) NewIntegrityReport(treeID TreeID, stored []ClosurePair) *TreeIntegrityReport {
+	missing, stale := s.DiffClosure(stored)
+	return &TreeIntegrityReport{
+		TreeID:         treeID,
+		NodeCount:      len(s.Parents),
+		OrphanedNodes:  s.Orphans,
+		CycleNodes:     s.Cycles,
+		MissingClosure: missing,
+		StaleClosure:   stale,
+	}
+}
+
+func sortClosurePairs(pairs []ClosurePair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Ancestor != pairs[j].Ancestor {
+			return pairs[i].Ancestor < pairs[j].Ancestor
+		}
+		if pairs[i].Descendant != pairs[j].Descendant {
+			return pairs[i].Descendant < pairs[j].Descendant
+		}
+		return pairs[i].Depth < pairs[j].Depth
+	})
+}
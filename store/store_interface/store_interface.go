@@ -2,6 +2,7 @@ package store_interface
 
 import (
 	"errors"
+	"time"
 
 	"github.com/vixac/bullet/model"
 )
@@ -10,21 +11,66 @@ type TenancySpace struct {
 	AppId     int32
 	TenancyId int64
 }
+
+var (
+	ErrTrackKeyNotFound   = errors.New("track key not found")
+	ErrTrackValueMismatch = errors.New("track value does not match expected")
+	// ErrTrackBucketNotFound is returned for buckets without live keys.
+	ErrTrackBucketNotFound = errors.New("track bucket not found")
+	ErrInvalidPutCondition = errors.New("invalid put condition")
+	// ErrTrackPutRejected reports a single conditional put whose condition was not met.
+	ErrTrackPutRejected = errors.New("track put condition not met")
+	// ErrDepotItemNotFound is returned for IDs without an item in the space.
+	ErrDepotItemNotFound = errors.New("depot item not found")
+	// ErrNotImplemented is wrapped by backends for operations they do not support yet.
+	ErrNotImplemented = errors.New("not implemented by this store")
+)
+
+// Track keys may carry an expiry. Once it has passed, every read treats the key as
+// missing, even before TrackDeleteExpired has removed it.
+//
+// Tag filters match an item's tag set, its Tag and Tags together (see TrackTagSet). They
+// match any of the tags, unless the query options ask for all of them.
 type TrackStore interface {
-	TrackPut(space TenancySpace, bucketID int32, key string, value int64, tag *int64, metric *float64) error
+	// TrackPut replaces the entry under key. A nil expiresAt keeps the key until it is deleted.
+	TrackPut(space TenancySpace, bucketID int32, key string, value int64, tag *int64, metric *float64, expiresAt *time.Time) error
 	TrackGet(space TenancySpace, bucketID int32, key string) (int64, error)
+	// TrackGetValue returns the value, tag, metric and expiry stored under key, or ErrTrackKeyNotFound.
+	TrackGetValue(space TenancySpace, bucketID int32, key string) (model.TrackValue, error)
+	// TrackIncrement atomically adds delta to the value under key, creating it from zero
+	// if missing or expired, and returns the entry it wrote. Tag, metric and expiry are left untouched.
+	TrackIncrement(space TenancySpace, bucketID int32, key string, delta int64) (model.TrackValue, error)
+	// TrackCompareAndSwap atomically replaces the entry under key if its value equals expected,
+	// or, when expected is nil, creates it only if the key is absent (or expired).
+	// The new entry never expires. Returns ErrTrackValueMismatch otherwise.
+	TrackCompareAndSwap(space TenancySpace, bucketID int32, key string, expected *int64, value int64, tag *int64, metric *float64) error
+	// TrackDeleteExpired removes up to limit keys, across all spaces and buckets, whose
+	// expiry is at or before now, and returns the keys it removed.
+	TrackDeleteExpired(now time.Time, limit int) ([]TrackExpiredKey, error)
 
 	TrackDeleteMany(space TenancySpace, items []model.TrackBucketKeyPair) error
+	// TrackDeleteByPrefix deletes the keys under the prefixes that pass the tag and metric
+	// filters, expired ones included, and returns how many it deleted. An empty prefix
+	// clears the bucket; an empty prefixes slice is ErrInvalidQueryOptions.
+	TrackDeleteByPrefix(space TenancySpace,
+		bucketID int32,
+		prefixes []string,
+		tags []int64,
+		metric *MetricFilter,
+	) (int, error)
 	TrackClose() error
 	TrackPutMany(space TenancySpace, items map[int32][]model.TrackKeyValueItem) error
+	// TrackPutManyIf writes each item whose key passes cond and rejects the rest; each key
+	// is checked and written atomically. Items are checked in order, so a key repeated in
+	// items sees the earlier write. Keys are reported by ascending bucket, then in input order.
+	TrackPutManyIf(space TenancySpace, items map[int32][]model.TrackKeyValueItem, cond TrackPutCondition) (model.TrackPutManyResult, error)
 	TrackGetMany(space TenancySpace, keys map[int32][]string) (map[int32]map[string]model.TrackValue, map[int32][]string, error)
 	GetItemsByKeyPrefix(
 		space TenancySpace,
 		bucketID int32,
 		prefix string,
 		tags []int64, // optional slice of tags
-		metricValue *float64, // optional metric value
-		metricIsGt bool, // "gt" or "lt"
+		metric *MetricFilter, // optional, returns ErrInvalidMetricFilter if it fails Validate
 	) ([]model.TrackKeyValueItem, error)
 
 	//Slower. Advisable to keep the number of prefix strings < 30 as it is implemented via  $or clause
@@ -32,23 +78,110 @@ type TrackStore interface {
 		bucketID int32,
 		prefixes []string,
 		tags []int64,
-		metricValue *float64,
-		metricIsGt bool,
+		metric *MetricFilter,
 	) ([]model.TrackKeyValueItem, error)
+
+	// GetItemsByKeyPrefixesPage returns one page of GetItemsByKeyPrefixes in key order, and
+	// the key to pass as opts.StartAfter for the next page, empty once there are no more.
+	GetItemsByKeyPrefixesPage(space TenancySpace,
+		bucketID int32,
+		prefixes []string,
+		tags []int64,
+		metric *MetricFilter,
+		opts TrackQueryOptions,
+	) ([]model.TrackKeyValueItem, string, error)
+
+	// GetItemsByKeyRange returns one page of the keys within keyRange, paged like GetItemsByKeyPrefixesPage.
+	GetItemsByKeyRange(space TenancySpace,
+		bucketID int32,
+		keyRange TrackKeyRange,
+		tags []int64,
+		metric *MetricFilter,
+		opts TrackQueryOptions,
+	) ([]model.TrackKeyValueItem, string, error)
+
+	// GetKeysByValue is a reverse lookup: it returns one page of the live items whose value
+	// is one of values, optionally only those under prefix, in key order and paged like
+	// GetItemsByKeyPrefixesPage. Every backend indexes track values for it.
+	GetKeysByValue(space TenancySpace,
+		bucketID int32,
+		values []int64,
+		prefix string,
+		opts TrackQueryOptions,
+	) ([]model.TrackKeyValueItem, string, error)
+
+	// ListTrackBuckets returns, in ascending order, the buckets of space holding at least one live key.
+	ListTrackBuckets(space TenancySpace) ([]int32, error)
+	// TrackBucketStats describes the live keys of a bucket, or returns ErrTrackBucketNotFound.
+	TrackBucketStats(space TenancySpace, bucketID int32) (model.TrackBucketStats, error)
+
+	// TrackScanBucket calls fn with each live key of a bucket in ascending key order,
+	// reading through a cursor rather than loading the whole bucket. It stops at the
+	// first error from fn and returns it.
+	TrackScanBucket(space TenancySpace, bucketID int32, fn func(model.TrackKeyValueItem) error) error
+
+	// TrackSetHistoryPolicy opts a bucket in or out of history. Turning it off drops the
+	// bucket's history. Once on, every write or delete of a live key records its prior value.
+	TrackSetHistoryPolicy(space TenancySpace, bucketID int32, policy TrackHistoryPolicy) error
+	// TrackGetHistoryPolicy returns the bucket's policy, the zero policy when history is off.
+	TrackGetHistoryPolicy(space TenancySpace, bucketID int32) (TrackHistoryPolicy, error)
+	// TrackGetHistory returns up to limit of the prior values the policy retains for key,
+	// newest first; a limit of zero returns them all.
+	TrackGetHistory(space TenancySpace, bucketID int32, key string, limit int) ([]model.TrackHistoryEntry, error)
+	// TrackPruneHistory deletes up to limit history entries, across all spaces and buckets,
+	// that their bucket's policy no longer retains.
+	TrackPruneHistory(now time.Time, limit int) (int, error)
+
+	// TrackAggregate summarises the items under the prefixes that pass the tag and metric
+	// filters, either as one group or one group per tag. See TrackAggregator.Groups for the order.
+	TrackAggregate(space TenancySpace,
+		bucketID int32,
+		prefixes []string,
+		tags []int64,
+		metric *MetricFilter,
+		groupByTag bool,
+	) ([]model.TrackAggregateGroup, error)
 }
 
+// Depot items carry a revision, DepotInitialRevision when created and one more on every
+// update, so that a client can update only the revision it last read.
 type DepotStore interface {
-	DepotCreate(space TenancySpace, bucketID int32, value string) (int64, error)
+	// DepotCreate returns the new item's ID and revision.
+	DepotCreate(space TenancySpace, bucketID int32, value string) (int64, int64, error)
 	DepotCreateMany(space TenancySpace, bucketID int32, values []string) ([]int64, error)
+	// DepotCreateRaw creates a raw item of data, stored with DepotRawContentType(contentType),
+	// and returns its ID and revision.
+	DepotCreateRaw(space TenancySpace, bucketID int32, data []byte, contentType string) (int64, int64, error)
 
 	DepotUpdate(space TenancySpace, id int64, value string) error
+	// DepotUpdateIfRevision updates the item only while it is at revision, and returns
+	// its new revision. Otherwise it returns a *DepotRevisionConflictError, or
+	// ErrDepotItemNotFound when there is no such item.
+	DepotUpdateIfRevision(space TenancySpace, id int64, value string, revision int64) (int64, error)
+	// DepotUpdateRaw replaces the item's value with data, making it a raw item, and
+	// returns its new revision, or ErrDepotItemNotFound.
+	DepotUpdateRaw(space TenancySpace, id int64, data []byte, contentType string) (int64, error)
+	// DepotUpdateRawIfRevision is DepotUpdateRaw conditional on revision, like DepotUpdateIfRevision.
+	DepotUpdateRawIfRevision(space TenancySpace, id int64, data []byte, contentType string, revision int64) (int64, error)
 
 	DepotGet(space TenancySpace, id int64) (string, error)
+	// DepotGetItem returns the item with its revision, or ErrDepotItemNotFound.
+	DepotGetItem(space TenancySpace, id int64) (model.DepotItem, error)
+	// DepotGetRaw returns the item as bytes, see DepotRawFromItem, or ErrDepotItemNotFound.
+	// The string reads give raw items as their empty Value.
+	DepotGetRaw(space TenancySpace, id int64) (model.DepotRawItem, error)
 	DepotGetMany(space TenancySpace, ids []int64) (map[int64]string, []int64, error)
 
 	DepotDelete(space TenancySpace, id int64) error
 	DepotDeleteByBucket(space TenancySpace, bucketID int32) error
-	DepotGetAllByBucket(space TenancySpace, bucketID int32) (map[int64]string, error) //VX:Note this wants to become paginated at some point.
+	// DepotGetAllByBucket returns the whole bucket at once; page through large buckets with DepotGetBucketPage.
+	DepotGetAllByBucket(space TenancySpace, bucketID int32) (map[int64]string, error)
+	// DepotGetBucketPage returns one page of a bucket in ID order, and the ID to pass as
+	// opts.StartAfter for the next page, 0 once there are no more.
+	DepotGetBucketPage(space TenancySpace, bucketID int32, opts DepotPageOptions) ([]model.DepotItem, int64, error)
+	// DepotQuery pages through the items of a bucket whose JSON values match every
+	// filter, like DepotGetBucketPage. Filters are not indexed, so a query reads the bucket.
+	DepotQuery(space TenancySpace, bucketID int32, filters []DepotFieldFilter, opts DepotPageOptions) ([]model.DepotItem, int64, error)
 }
 
 // Grove types
@@ -121,13 +254,59 @@ type TreeStats struct {
 	TotalLeaves        int64
 }
 
+// Subtree copying
+type NodeIDStrategy string
+
+const (
+	// NodeIDStrategyAffix names each copy Prefix + original ID + Suffix.
+	NodeIDStrategyAffix NodeIDStrategy = "affix"
+	// NodeIDStrategyUUID gives each copy a freshly generated UUID.
+	NodeIDStrategyUUID NodeIDStrategy = "uuid"
+)
+
+type CopySubtreeOptions struct {
+	IDStrategy     NodeIDStrategy
+	Prefix         string
+	Suffix         string
+	Position       *ChildPosition // Position of the copied root; nil keeps the source root's position
+	CopyAggregates bool           // Copy local aggregates (mutation markers are never copied)
+}
+
+// Change feed
+type GroveChangeKind string
+
+const (
+	GroveChangeCreate   GroveChangeKind = "create"
+	GroveChangeMove     GroveChangeKind = "move"
+	GroveChangeDelete   GroveChangeKind = "delete"
+	GroveChangeMetadata GroveChangeKind = "metadata"
+	GroveChangeMutation GroveChangeKind = "mutation"
+)
+
+// GroveChange is one entry of a tree's change log. Which optional fields are set depends on Kind:
+// create and move carry Parent and Position, create and metadata carry Metadata,
+// mutation carries MutationID and Deltas, and delete carries Soft.
+type GroveChange struct {
+	Seq        int64 // Per-tree sequence number, starting at 1 and strictly increasing
+	Kind       GroveChangeKind
+	NodeID     NodeID
+	Parent     *NodeID
+	Position   *ChildPosition
+	Metadata   *NodeMetadata
+	MutationID *MutationID
+	Deltas     AggregateDeltas
+	Soft       bool
+	At         time.Time
+}
+
 var (
-	ErrNodeNotFound      = errors.New("node not found")
-	ErrNodeAlreadyExists = errors.New("node already exists")
-	ErrCycleDetected     = errors.New("cycle detected")
-	ErrMutationConflict  = errors.New("mutation already applied")
-	ErrInvalidPosition   = errors.New("invalid child position")
-	ErrInvalidFilter     = errors.New("invalid node filter")
+	ErrNodeNotFound       = errors.New("node not found")
+	ErrNodeAlreadyExists  = errors.New("node already exists")
+	ErrCycleDetected      = errors.New("cycle detected")
+	ErrMutationConflict   = errors.New("mutation already applied")
+	ErrInvalidPosition    = errors.New("invalid child position")
+	ErrInvalidFilter      = errors.New("invalid node filter")
+	ErrInvalidCopyOptions = errors.New("invalid copy options")
 )
 
 type GroveStore interface {
@@ -135,6 +314,14 @@ type GroveStore interface {
 	CreateNode(space TenancySpace, treeID TreeID, node NodeID, parent *NodeID, position *ChildPosition, metadata *NodeMetadata) error
 	DeleteNode(space TenancySpace, treeID TreeID, node NodeID, soft bool) error
 	MoveNode(space TenancySpace, treeID TreeID, node NodeID, newParent *NodeID, newPosition *ChildPosition) error
+	// UpdateNodeMetadata replaces a node's metadata.
+	UpdateNodeMetadata(space TenancySpace, treeID TreeID, node NodeID, metadata NodeMetadata) error
+
+	// MoveSubtreeToTree transfers node and all of its descendants from srcTree to dstTree,
+	// placing node under dstParent (nil makes it a root). Node IDs are kept, along with
+	// aggregates and applied mutation markers, so the move happens in one transaction.
+	// Returns ErrNodeAlreadyExists if any moved ID is already present in dstTree.
+	MoveSubtreeToTree(space TenancySpace, srcTree TreeID, node NodeID, dstTree TreeID, dstParent *NodeID, position *ChildPosition) error
 
 	ApplyAggregateMutation(
 		space TenancySpace,
@@ -160,6 +347,28 @@ type GroveStore interface {
 	// The second return value lists node IDs that were not found.
 	GetNodeLocalAggregatesBulk(space TenancySpace, treeID TreeID, nodes []NodeID) (map[NodeID]map[AggregateKey]AggregateValue, []NodeID, error)
 	GetDescendants(space TenancySpace, treeID TreeID, node NodeID, opts *DescendantOptions) ([]NodeWithDepth, *PaginationResult, error)
+
+	// CopySubtree clones srcNode and all of its descendants under dstParent in dstTree
+	// (nil dstParent makes the copy a root) in a single transaction. Structure, positions
+	// and metadata are always copied; local aggregates only when opts.CopyAggregates is set.
+	// The returned map is keyed by source node ID with the generated node ID as value.
+	// Returns ErrNodeAlreadyExists if any generated ID is already taken in dstTree.
+	CopySubtree(space TenancySpace, srcTree TreeID, srcNode NodeID, dstTree TreeID, dstParent *NodeID, opts CopySubtreeOptions) (map[NodeID]NodeID, error)
+
+	// VerifyTree checks closure rows, depths, aggregates and mutation markers against
+	// parent pointers without changing anything.
+	VerifyTree(space TenancySpace, treeID TreeID) (*TreeIntegrityReport, error)
+
+	// RepairTree fixes everything VerifyTree reports in a single transaction: closure rows
+	// and depths are rebuilt from parent pointers, orphans and one node of every cycle
+	// become roots, and dangling aggregates and mutation markers are deleted.
+	// The returned report describes the tree as it was before the repair.
+	RepairTree(space TenancySpace, treeID TreeID) (*TreeIntegrityReport, error)
+
+	// GetChanges returns the tree's changes with a sequence number greater than since,
+	// oldest first. Every write records its changes in the same transaction as the write.
+	// A limit of zero or less returns everything.
+	GetChanges(space TenancySpace, treeID TreeID, since int64, limit int) ([]GroveChange, error)
 }
 
 type Store interface {
@@ -200,7 +409,6 @@ type Store interface {
 
 // Metadata operations
 //	GetNodeMetadata(space TenancySpace, treeID TreeID, node NodeID) (*NodeMetadata, error)
-//	UpdateNodeMetadata(space TenancySpace, treeID TreeID, node NodeID, metadata NodeMetadata) error
 // Statistics
 //	GetTreeStats(space TenancySpace, treeID TreeID, root NodeID) (*TreeStats, error)
 
@@ -0,0 +1,148 @@
+package store_interface
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vixac/bullet/model"
+)
+
+// TrackAggregator folds track values, or partial groups computed elsewhere, into
+// aggregate groups. Backends that aggregate in the database only need it to merge
+// results from several queries.
+type TrackAggregator struct {
+	groupByTag bool
+	groups     map[int64]*model.TrackAggregateGroup
+	untagged   *model.TrackAggregateGroup
+}
+
+func NewTrackAggregator(groupByTag bool) *TrackAggregator {
+	return &TrackAggregator{
+		groupByTag: groupByTag,
+		groups:     make(map[int64]*model.TrackAggregateGroup),
+	}
+}
+
+func (a *TrackAggregator) group(tag *int64) *model.TrackAggregateGroup {
+	if !a.groupByTag || tag == nil {
+		if a.untagged == nil {
+			a.untagged = &model.TrackAggregateGroup{}
+		}
+		return a.untagged
+	}
+	g, ok := a.groups[*tag]
+	if !ok {
+		t := *tag
+		g = &model.TrackAggregateGroup{Tag: &t}
+		a.groups[t] = g
+	}
+	return g
+}
+
+// Add folds a single item's value into its group.
+func (a *TrackAggregator) Add(value model.TrackValue) {
+	a.Merge(trackValueGroup(value))
+}
+
+// trackValueGroup is the group holding only value.
+func trackValueGroup(value model.TrackValue) model.TrackAggregateGroup {
+	metricCount := int64(0)
+	metricSum := 0.0
+	if value.Metric != nil {
+		metricCount = 1
+		metricSum = *value.Metric
+	}
+	return model.TrackAggregateGroup{
+		Tag:    value.Tag,
+		Count:  1,
+		Value:  model.TrackValueStats{Sum: value.Value, Min: &value.Value, Max: &value.Value},
+		Metric: model.TrackMetricStats{Count: metricCount, Sum: metricSum, Min: value.Metric, Max: value.Metric},
+	}
+}
+
+// Merge folds a partial group into the group for its tag. Averages are ignored and
+// recomputed by Groups.
+func (a *TrackAggregator) Merge(partial model.TrackAggregateGroup) {
+	if partial.Count == 0 {
+		return
+	}
+	g := a.group(partial.Tag)
+	g.Count += partial.Count
+	g.Value.Sum += partial.Value.Sum
+	g.Value.Min = minOf(g.Value.Min, partial.Value.Min)
+	g.Value.Max = maxOf(g.Value.Max, partial.Value.Max)
+	g.Metric.Count += partial.Metric.Count
+	g.Metric.Sum += partial.Metric.Sum
+	g.Metric.Min = minOf(g.Metric.Min, partial.Metric.Min)
+	g.Metric.Max = maxOf(g.Metric.Max, partial.Metric.Max)
+}
+
+// Groups returns the untagged group first and then one group per tag in tag order.
+// Without grouping there is always exactly one group, even when nothing matched.
+func (a *TrackAggregator) Groups() []model.TrackAggregateGroup {
+	var out []model.TrackAggregateGroup
+	if a.untagged != nil {
+		out = append(out, *a.untagged)
+	} else if !a.groupByTag {
+		out = append(out, model.TrackAggregateGroup{})
+	}
+	tags := make([]int64, 0, len(a.groups))
+	for tag := range a.groups {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	for _, tag := range tags {
+		out = append(out, *a.groups[tag])
+	}
+
+	for i := range out {
+		g := &out[i]
+		if g.Count > 0 {
+			avg := float64(g.Value.Sum) / float64(g.Count)
+			g.Value.Avg = &avg
+		}
+		if g.Metric.Count > 0 {
+			avg := g.Metric.Sum / float64(g.Metric.Count)
+			g.Metric.Avg = &avg
+		}
+	}
+	return out
+}
+
+// minOf and maxOf copy the winning value so groups never alias their inputs.
+func minOf[T int64 | float64](a, b *T) *T {
+	if b != nil && (a == nil || *b < *a) {
+		v := *b
+		return &v
+	}
+	return a
+}
+
+func maxOf[T int64 | float64](a, b *T) *T {
+	if b != nil && (a == nil || *b > *a) {
+		v := *b
+		return &v
+	}
+	return a
+}
+
+// DisjointPrefixes drops duplicate prefixes and those covered by a shorter one, so that
+// aggregating each prefix separately counts every key once. An empty prefix covers
+// everything. It errors when no prefixes are given.
+func DisjointPrefixes(prefixes []string) ([]string, error) {
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("%w: at least one prefix is required", ErrInvalidQueryOptions)
+	}
+	sorted := append([]string{}, prefixes...)
+	sort.Strings(sorted)
+	// Sorted, a prefix comes before everything it covers
+	var out []string
+	for _, p := range sorted {
+		if len(out) > 0 && strings.HasPrefix(p, out[len(out)-1]) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
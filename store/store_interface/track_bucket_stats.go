@@ -0,0 +1,80 @@
+package store_interface
+
+import (
+	"fmt"
+
+	"github.com/vixac/bullet/model"
+)
+
+// TrackEntryBytes estimates the stored size of one entry: the key, the value, each of
+// tag, metric and expiry that is set and each of the further tags, at 8 bytes apiece.
+// SQL backends compute the same sum in the database.
+func TrackEntryBytes(key string, value model.TrackValue) int64 {
+	n := int64(len(key)) + 8 + 8*int64(len(value.Tags))
+	if value.Tag != nil {
+		n += 8
+	}
+	if value.Metric != nil {
+		n += 8
+	}
+	if value.ExpiresAt != nil {
+		n += 8
+	}
+	return n
+}
+
+// TrackBucketStatsBuilder folds entries, or partial stats computed elsewhere, into the
+// stats of one bucket.
+type TrackBucketStatsBuilder struct {
+	stats model.TrackBucketStats
+	agg   *TrackAggregator
+}
+
+func NewTrackBucketStatsBuilder(bucketID int32) *TrackBucketStatsBuilder {
+	return &TrackBucketStatsBuilder{
+		stats: model.TrackBucketStats{BucketID: bucketID},
+		agg:   NewTrackAggregator(false),
+	}
+}
+
+// Add folds a single live entry into the stats.
+func (b *TrackBucketStatsBuilder) Add(key string, value model.TrackValue) {
+	group := trackValueGroup(value)
+	b.Merge(model.TrackBucketStats{
+		KeyCount:    1,
+		MinKey:      key,
+		MaxKey:      key,
+		Value:       group.Value,
+		Metric:      group.Metric,
+		ApproxBytes: TrackEntryBytes(key, value),
+	})
+}
+
+// Merge folds partial stats in, value and metric stats included. Averages are ignored
+// and recomputed by Stats.
+func (b *TrackBucketStatsBuilder) Merge(partial model.TrackBucketStats) {
+	if partial.KeyCount == 0 {
+		return
+	}
+	if b.stats.KeyCount == 0 || partial.MinKey < b.stats.MinKey {
+		b.stats.MinKey = partial.MinKey
+	}
+	if b.stats.KeyCount == 0 || partial.MaxKey > b.stats.MaxKey {
+		b.stats.MaxKey = partial.MaxKey
+	}
+	b.stats.KeyCount += partial.KeyCount
+	b.stats.ApproxBytes += partial.ApproxBytes
+	b.agg.Merge(model.TrackAggregateGroup{Count: partial.KeyCount, Value: partial.Value, Metric: partial.Metric})
+}
+
+// Stats returns the folded stats, or ErrTrackBucketNotFound when the bucket has no live keys.
+func (b *TrackBucketStatsBuilder) Stats() (model.TrackBucketStats, error) {
+	if b.stats.KeyCount == 0 {
+		return model.TrackBucketStats{}, fmt.Errorf("%w: %d", ErrTrackBucketNotFound, b.stats.BucketID)
+	}
+	group := b.agg.Groups()[0]
+	stats := b.stats
+	stats.Value = group.Value
+	stats.Metric = group.Metric
+	return stats, nil
+}
@@ -0,0 +1,37 @@
+package store_interface
+
+import (
+	"time"
+
+	"github.com/vixac/bullet/model"
+)
+
+// TrackExpiredKey is a key TrackDeleteExpired removed.
+type TrackExpiredKey struct {
+	Space    TenancySpace
+	BucketID int32
+	Key      string
+}
+
+// TrackExpired reports whether v's expiry is at or before now.
+func TrackExpired(v model.TrackValue, now time.Time) bool {
+	return v.ExpiresAt != nil && !now.Before(*v.ExpiresAt)
+}
+
+// ExpiryMillis converts an expiry to the unix milliseconds SQL backends store, keeping nil as NULL.
+func ExpiryMillis(t *time.Time) *int64 {
+	if t == nil {
+		return nil
+	}
+	ms := t.UnixMilli()
+	return &ms
+}
+
+// ExpiryFromMillis is the inverse of ExpiryMillis.
+func ExpiryFromMillis(ms *int64) *time.Time {
+	if ms == nil {
+		return nil
+	}
+	t := time.UnixMilli(*ms).UTC()
+	return &t
+}
@@ -0,0 +1,84 @@
+package store_interface
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidMetricFilter = errors.New("invalid metric filter")
+
+type MetricOperator string
+
+const (
+	MetricGt      MetricOperator = "gt"
+	MetricGte     MetricOperator = "gte"
+	MetricLt      MetricOperator = "lt"
+	MetricLte     MetricOperator = "lte"
+	MetricEq      MetricOperator = "eq"
+	MetricNe      MetricOperator = "ne"
+	MetricBetween MetricOperator = "between" // Value <= metric <= Upper
+)
+
+// MetricFilter restricts track items by their metric. Items without a metric never match.
+type MetricFilter struct {
+	Operator MetricOperator
+	Value    float64
+	Upper    float64 // only used by MetricBetween
+}
+
+// Validate checks the operator is known and, for between, that the bounds are ordered.
+func (f MetricFilter) Validate() error {
+	switch f.Operator {
+	case MetricGt, MetricGte, MetricLt, MetricLte, MetricEq, MetricNe:
+		return nil
+	case MetricBetween:
+		if f.Upper < f.Value {
+			return fmt.Errorf("%w: between bounds %v and %v are reversed", ErrInvalidMetricFilter, f.Value, f.Upper)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown operator %q", ErrInvalidMetricFilter, f.Operator)
+	}
+}
+
+// Matches applies the filter in Go, for backends without a query language.
+func (f MetricFilter) Matches(metric *float64) bool {
+	if metric == nil {
+		return false
+	}
+	m := *metric
+	switch f.Operator {
+	case MetricGt:
+		return m > f.Value
+	case MetricGte:
+		return m >= f.Value
+	case MetricLt:
+		return m < f.Value
+	case MetricLte:
+		return m <= f.Value
+	case MetricEq:
+		return m == f.Value
+	case MetricNe:
+		return m != f.Value
+	case MetricBetween:
+		return m >= f.Value && m <= f.Upper
+	}
+	return false
+}
+
+// SQLCondition renders the filter as a condition on column. placeholder is called once
+// per returned argument, in order, so callers can number their parameters.
+func (f MetricFilter) SQLCondition(column string, placeholder func() string) (string, []any) {
+	if f.Operator == MetricBetween {
+		return fmt.Sprintf("%s BETWEEN %s AND %s", column, placeholder(), placeholder()), []any{f.Value, f.Upper}
+	}
+	ops := map[MetricOperator]string{
+		MetricGt:  ">",
+		MetricGte: ">=",
+		MetricLt:  "<",
+		MetricLte: "<=",
+		MetricEq:  "=",
+		MetricNe:  "<>",
+	}
+	return fmt.Sprintf("%s %s %s", column, ops[f.Operator], placeholder()), []any{f.Value}
+}
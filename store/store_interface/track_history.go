@@ -0,0 +1,76 @@
+package store_interface
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vixac/bullet/model"
+)
+
+var ErrInvalidHistoryPolicy = errors.New("invalid history policy")
+
+// TrackHistoryPolicy opts a bucket into keeping the values its keys held before each
+// write or delete. MaxVersions keeps the newest entries per key and MaxAge the entries
+// replaced within that window; when both are set an entry must satisfy both. The zero
+// policy turns history off.
+type TrackHistoryPolicy struct {
+	MaxVersions int
+	MaxAge      time.Duration
+}
+
+func (p TrackHistoryPolicy) Validate() error {
+	if p.MaxVersions < 0 {
+		return fmt.Errorf("%w: max versions must not be negative", ErrInvalidHistoryPolicy)
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("%w: max age must not be negative", ErrInvalidHistoryPolicy)
+	}
+	return nil
+}
+
+func (p TrackHistoryPolicy) Enabled() bool {
+	return p.MaxVersions > 0 || p.MaxAge > 0
+}
+
+// Retains reports whether the policy keeps an entry, given its position among the
+// key's entries counting from the newest at zero.
+func (p TrackHistoryPolicy) Retains(index int, replacedAt, now time.Time) bool {
+	if !p.Enabled() {
+		return false
+	}
+	if p.MaxVersions > 0 && index >= p.MaxVersions {
+		return false
+	}
+	if p.MaxAge > 0 && replacedAt.Before(now.Add(-p.MaxAge)) {
+		return false
+	}
+	return true
+}
+
+// Retained keeps the entries, newest first, that the policy retains, up to limit when
+// limit is positive. Backends prune in the background, so reads apply the policy too.
+func (p TrackHistoryPolicy) Retained(entries []model.TrackHistoryEntry, now time.Time, limit int) []model.TrackHistoryEntry {
+	out := make([]model.TrackHistoryEntry, 0, len(entries))
+	for i, e := range entries {
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		if p.Retains(i, e.ReplacedAt, now) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// TrackHistoryEntry converts a value replaced at replacedAt into its history entry.
+func TrackHistoryEntry(v model.TrackValue, replacedAt time.Time) model.TrackHistoryEntry {
+	return model.TrackHistoryEntry{
+		Value:      v.Value,
+		Tag:        v.Tag,
+		Tags:       v.Tags,
+		Metric:     v.Metric,
+		ExpiresAt:  v.ExpiresAt,
+		ReplacedAt: replacedAt,
+	}
+}
@@ -0,0 +1,64 @@
+package store_interface
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/vixac/bullet/model"
+)
+
+// TrackPutMode selects when a conditional put writes a key. Expired keys count as absent.
+type TrackPutMode string
+
+const (
+	TrackPutIfAbsent  TrackPutMode = "if_absent"  // no live entry under the key
+	TrackPutIfPresent TrackPutMode = "if_present" // a live entry exists
+	TrackPutIfTag     TrackPutMode = "if_tag"     // a live entry exists and its tag equals Tag
+)
+
+type TrackPutCondition struct {
+	Mode TrackPutMode
+	Tag  *int64 // required by TrackPutIfTag and rejected by the other modes
+}
+
+// Validate returns a wrapped ErrInvalidPutCondition for unknown modes or a misplaced tag.
+func (c TrackPutCondition) Validate() error {
+	switch c.Mode {
+	case TrackPutIfAbsent, TrackPutIfPresent:
+		if c.Tag != nil {
+			return fmt.Errorf("%w: a tag is only used by %s", ErrInvalidPutCondition, TrackPutIfTag)
+		}
+	case TrackPutIfTag:
+		if c.Tag == nil {
+			return fmt.Errorf("%w: %s needs a tag", ErrInvalidPutCondition, TrackPutIfTag)
+		}
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidPutCondition, c.Mode)
+	}
+	return nil
+}
+
+// Allows reports whether the condition lets a put replace current, where live is false
+// when the key is missing or expired.
+func (c TrackPutCondition) Allows(current model.TrackValue, live bool) bool {
+	switch c.Mode {
+	case TrackPutIfAbsent:
+		return !live
+	case TrackPutIfPresent:
+		return live
+	case TrackPutIfTag:
+		return live && current.Tag != nil && *current.Tag == *c.Tag
+	}
+	return false
+}
+
+// SortedTrackBuckets returns the bucket IDs of items in ascending order, so conditional
+// puts report their keys in a stable order.
+func SortedTrackBuckets(items map[int32][]model.TrackKeyValueItem) []int32 {
+	bucketIDs := make([]int32, 0, len(items))
+	for bucketID := range items {
+		bucketIDs = append(bucketIDs, bucketID)
+	}
+	slices.Sort(bucketIDs)
+	return bucketIDs
+}
@@ -0,0 +1,228 @@
+package store_interface
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/vixac/bullet/model"
+)
+
+var ErrInvalidQueryOptions = errors.New("invalid query options")
+
+// TrackOrderBy is the field track query results are sorted on.
+type TrackOrderBy string
+
+const (
+	TrackOrderByKey    TrackOrderBy = "key" // the default, and the only order that can be paged with a cursor
+	TrackOrderByValue  TrackOrderBy = "value"
+	TrackOrderByMetric TrackOrderBy = "metric" // items without a metric are left out
+)
+
+// TrackQueryOptions pages through track query results in key order, or returns the
+// top Limit items by value or metric. Ties are broken by key in the same direction.
+type TrackQueryOptions struct {
+	Limit      int    // maximum items per page, 0 for no limit
+	StartAfter string // resume after this key, exclusive; empty starts at the first (or last) key
+	Descending bool
+	OrderBy    TrackOrderBy  // empty orders by key
+	TagMatch   TrackTagMatch // how the query's tag filter matches; empty means any
+}
+
+func (o TrackQueryOptions) Validate() error {
+	if o.Limit < 0 {
+		return ErrInvalidQueryOptions
+	}
+	if err := o.TagMatch.Validate(); err != nil {
+		return err
+	}
+	switch o.OrderBy {
+	case "", TrackOrderByKey:
+	case TrackOrderByValue, TrackOrderByMetric:
+		if o.StartAfter != "" {
+			return fmt.Errorf("%w: start_after only pages results ordered by key", ErrInvalidQueryOptions)
+		}
+	default:
+		return fmt.Errorf("%w: unknown order %q", ErrInvalidQueryOptions, o.OrderBy)
+	}
+	return nil
+}
+
+// ByKey reports whether results are ordered by key.
+func (o TrackQueryOptions) ByKey() bool {
+	return o.OrderBy == "" || o.OrderBy == TrackOrderByKey
+}
+
+// Less reports whether a comes before b in the query's order.
+func (o TrackQueryOptions) Less(a, b model.TrackKeyValueItem) bool {
+	less, equal := a.Key < b.Key, a.Key == b.Key
+	switch o.OrderBy {
+	case TrackOrderByValue:
+		if a.Value.Value != b.Value.Value {
+			less, equal = a.Value.Value < b.Value.Value, false
+		}
+	case TrackOrderByMetric:
+		am, bm := metricOrZero(a.Value.Metric), metricOrZero(b.Value.Metric)
+		if am != bm {
+			less, equal = am < bm, false
+		}
+	}
+	if o.Descending {
+		return !less && !equal
+	}
+	return less
+}
+
+// Includes reports whether an item can appear in the results at all under the query's order.
+func (o TrackQueryOptions) Includes(item model.TrackKeyValueItem) bool {
+	return o.OrderBy != TrackOrderByMetric || item.Value.Metric != nil
+}
+
+func metricOrZero(m *float64) float64 {
+	if m == nil {
+		return 0
+	}
+	return *m
+}
+
+// After reports whether key comes after the cursor in the query's direction.
+func (o TrackQueryOptions) After(key string) bool {
+	if o.StartAfter == "" {
+		return true
+	}
+	if o.Descending {
+		return key < o.StartAfter
+	}
+	return key > o.StartAfter
+}
+
+// PageItems sorts items in the query's direction, drops duplicates and anything not
+// after the cursor, and cuts the result to the limit. The returned cursor is the last
+// key of the page when more items follow, and empty otherwise. Backends that fetch
+// Limit+1 candidates per range can pass the union of those through here.
+func (o TrackQueryOptions) PageItems(items []model.TrackKeyValueItem) ([]model.TrackKeyValueItem, string) {
+	if !o.ByKey() {
+		top := NewTrackTopK(o)
+		for _, item := range items {
+			top.Push(item)
+		}
+		return top.Items(), ""
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if o.Descending {
+			return items[i].Key > items[j].Key
+		}
+		return items[i].Key < items[j].Key
+	})
+
+	out := make([]model.TrackKeyValueItem, 0, len(items))
+	for i, item := range items {
+		if !o.After(item.Key) {
+			continue
+		}
+		if i > 0 && items[i-1].Key == item.Key {
+			continue
+		}
+		out = append(out, item)
+	}
+
+	if o.Limit > 0 && len(out) > o.Limit {
+		return out[:o.Limit], out[o.Limit-1].Key
+	}
+	return out, ""
+}
+
+// FetchLimit is how many rows a backend should read per range to fill a page and
+// know whether another one follows, or 0 when unlimited. Top-K queries have no next
+// page, so they read exactly Limit rows.
+func (o TrackQueryOptions) FetchLimit() int {
+	if o.Limit == 0 {
+		return 0
+	}
+	if !o.ByKey() {
+		return o.Limit
+	}
+	return o.Limit + 1
+}
+
+// SQLOrderBy renders the ORDER BY clause for the query's order, given the key column
+// expression to sort and break ties on.
+func (o TrackQueryOptions) SQLOrderBy(keyColumn string) string {
+	direction := ""
+	if o.Descending {
+		direction = " DESC"
+	}
+	switch o.OrderBy {
+	case TrackOrderByValue:
+		return " ORDER BY value" + direction + ", " + keyColumn + direction
+	case TrackOrderByMetric:
+		return " ORDER BY metric" + direction + ", " + keyColumn + direction
+	}
+	return " ORDER BY " + keyColumn + direction
+}
+
+// TrackKeyRange selects keys between Start and End. An empty bound leaves that side open.
+type TrackKeyRange struct {
+	Start          string
+	End            string
+	StartInclusive bool
+	EndInclusive   bool
+}
+
+func (r TrackKeyRange) Validate() error {
+	if r.Start != "" && r.End != "" && r.Start > r.End {
+		return fmt.Errorf("%w: range start %q is after end %q", ErrInvalidQueryOptions, r.Start, r.End)
+	}
+	return nil
+}
+
+// Contains reports whether key falls within the range.
+func (r TrackKeyRange) Contains(key string) bool {
+	if r.Start != "" && (key < r.Start || key == r.Start && !r.StartInclusive) {
+		return false
+	}
+	if r.End != "" && (key > r.End || key == r.End && !r.EndInclusive) {
+		return false
+	}
+	return true
+}
+
+// SQLConditions renders the range as conditions on column, to be ANDed together.
+// placeholder is called once per returned argument, in order.
+func (r TrackKeyRange) SQLConditions(column string, placeholder func() string) ([]string, []any) {
+	var conditions []string
+	var args []any
+	if r.Start != "" {
+		op := ">"
+		if r.StartInclusive {
+			op = ">="
+		}
+		conditions = append(conditions, fmt.Sprintf("%s %s %s", column, op, placeholder()))
+		args = append(args, r.Start)
+	}
+	if r.End != "" {
+		op := "<"
+		if r.EndInclusive {
+			op = "<="
+		}
+		conditions = append(conditions, fmt.Sprintf("%s %s %s", column, op, placeholder()))
+		args = append(args, r.End)
+	}
+	return conditions, args
+}
+
+// ValidateKeysByValue checks the arguments of GetKeysByValue, which needs at least one
+// value and pages in key order only.
+func ValidateKeysByValue(values []int64, opts TrackQueryOptions) error {
+	if len(values) == 0 {
+		return fmt.Errorf("%w: at least one value is required", ErrInvalidQueryOptions)
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if !opts.ByKey() {
+		return fmt.Errorf("%w: keys by value are ordered by key", ErrInvalidQueryOptions)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package store_interface
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/vixac/bullet/model"
+)
+
+// TrackTagMatch is how a tag filter compares its tags with an item's tag set.
+type TrackTagMatch string
+
+const (
+	TrackTagMatchAny TrackTagMatch = "any" // the default: the item has at least one of the tags
+	TrackTagMatchAll TrackTagMatch = "all" // the item has every one of the tags
+)
+
+func (m TrackTagMatch) Validate() error {
+	switch m {
+	case "", TrackTagMatchAny, TrackTagMatchAll:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown tag match %q", ErrInvalidQueryOptions, m)
+}
+
+// TrackTagSet returns the tag set of v: Tag, if set, then Tags, without duplicates.
+func TrackTagSet(v model.TrackValue) []int64 {
+	var set []int64
+	if v.Tag != nil {
+		set = append(set, *v.Tag)
+	}
+	for _, t := range v.Tags {
+		if !slices.Contains(set, t) {
+			set = append(set, t)
+		}
+	}
+	return set
+}
+
+// TrackTagFilter matches items by their tag set. An empty filter matches every item.
+type TrackTagFilter struct {
+	tags  []int64
+	match TrackTagMatch
+}
+
+func NewTrackTagFilter(tags []int64, match TrackTagMatch) TrackTagFilter {
+	return TrackTagFilter{tags: tags, match: match}
+}
+
+func (f TrackTagFilter) Matches(v model.TrackValue) bool {
+	if len(f.tags) == 0 {
+		return true
+	}
+	set := TrackTagSet(v)
+	for _, t := range f.tags {
+		has := slices.Contains(set, t)
+		if f.match == TrackTagMatchAll && !has {
+			return false
+		}
+		if f.match != TrackTagMatchAll && has {
+			return true
+		}
+	}
+	return f.match == TrackTagMatchAll
+}
+
+// TrackTagsToJSON renders tags as the JSON array the SQL backends store in their tags
+// column and bind for tag filters, or nil (NULL) when there are none.
+func TrackTagsToJSON(tags []int64) any {
+	if len(tags) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(tags)
+	return string(b)
+}
+
+// TrackTagsFromJSON parses the SQL tags column.
+func TrackTagsFromJSON(column *string) ([]int64, error) {
+	if column == nil {
+		return nil, nil
+	}
+	var tags []int64
+	if err := json.Unmarshal([]byte(*column), &tags); err != nil {
+		return nil, fmt.Errorf("invalid tags column %q: %w", *column, err)
+	}
+	return tags, nil
+}
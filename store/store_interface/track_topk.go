@@ -0,0 +1,72 @@
+package store_interface
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/vixac/bullet/model"
+)
+
+// TrackTopK keeps the first Limit items of a query's order while items are streamed
+// through it, for backends that cannot sort on value or metric natively. Without a
+// limit it keeps everything. Pushing the same key twice keeps the first copy.
+type TrackTopK struct {
+	opts  TrackQueryOptions
+	items trackItemHeap
+	seen  map[string]struct{}
+}
+
+func NewTrackTopK(opts TrackQueryOptions) *TrackTopK {
+	return &TrackTopK{
+		opts:  opts,
+		items: trackItemHeap{opts: opts},
+		seen:  make(map[string]struct{}),
+	}
+}
+
+func (t *TrackTopK) Push(item model.TrackKeyValueItem) {
+	if !t.opts.Includes(item) {
+		return
+	}
+	if _, ok := t.seen[item.Key]; ok {
+		return
+	}
+	t.seen[item.Key] = struct{}{}
+
+	if t.opts.Limit == 0 || t.items.Len() < t.opts.Limit {
+		heap.Push(&t.items, item)
+		return
+	}
+	// The heap root is the last of the kept items; replace it if the new item comes earlier
+	if t.opts.Less(item, t.items.items[0]) {
+		t.items.items[0] = item
+		heap.Fix(&t.items, 0)
+	}
+}
+
+// Items returns the kept items in the query's order.
+func (t *TrackTopK) Items() []model.TrackKeyValueItem {
+	out := append([]model.TrackKeyValueItem{}, t.items.items...)
+	sort.Slice(out, func(i, j int) bool { return t.opts.Less(out[i], out[j]) })
+	return out
+}
+
+// trackItemHeap is a max-heap in the query's order, so the root is the item to evict first.
+type trackItemHeap struct {
+	opts  TrackQueryOptions
+	items []model.TrackKeyValueItem
+}
+
+func (h trackItemHeap) Len() int           { return len(h.items) }
+func (h trackItemHeap) Less(i, j int) bool { return h.opts.Less(h.items[j], h.items[i]) }
+func (h trackItemHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *trackItemHeap) Push(x any) {
+	h.items = append(h.items, x.(model.TrackKeyValueItem))
+}
+
+func (h *trackItemHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
@@ -0,0 +1,171 @@
+package store_interface
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/vixac/bullet/model"
+)
+
+// ErrTrackWatchResumeGap is returned when a watch resumes from a sequence the change
+// backlog no longer holds, or one this process never issued. The watcher has to re-read
+// what it watches before watching again without a sequence.
+var ErrTrackWatchResumeGap = errors.New("track watch cannot resume from that sequence")
+
+const (
+	TrackWatchEventPut    = "put"
+	TrackWatchEventDelete = "delete"
+
+	// trackWatchBuffer is how many events a subscriber may fall behind by before it is dropped.
+	trackWatchBuffer = 256
+)
+
+// TrackWatchFilter selects the changes under Prefix in one bucket of a space. An empty
+// Prefix selects the whole bucket.
+type TrackWatchFilter struct {
+	Space    TenancySpace
+	BucketID int32
+	Prefix   string
+}
+
+// Matches reports whether a change in space falls under the filter. A delete by prefix
+// matches when its prefix and the filter's overlap, as either may hold keys of the other.
+func (f TrackWatchFilter) Matches(space TenancySpace, e model.TrackWatchEvent) bool {
+	if space != f.Space || e.BucketID != f.BucketID {
+		return false
+	}
+	if e.Prefix != nil {
+		return strings.HasPrefix(*e.Prefix, f.Prefix) || strings.HasPrefix(f.Prefix, *e.Prefix)
+	}
+	return strings.HasPrefix(e.Key, f.Prefix)
+}
+
+// TrackWatcher is implemented by track stores that publish their changes.
+type TrackWatcher interface {
+	// TrackWatch subscribes to the changes filter selects. With a since sequence it also
+	// returns the retained changes after it, or ErrTrackWatchResumeGap when some of them
+	// are no longer retained.
+	TrackWatch(filter TrackWatchFilter, since *uint64) (*TrackSubscription, []model.TrackWatchEvent, error)
+}
+
+type trackChange struct {
+	space TenancySpace
+	event model.TrackWatchEvent
+}
+
+// TrackChanges broadcasts track changes to subscribers in sequence order, and keeps the
+// latest of them so that a watcher can resume after reconnecting. Sequences start at 1
+// and restart with the process.
+type TrackChanges struct {
+	mu      sync.Mutex
+	seq     uint64
+	backlog []trackChange // a ring of the latest changes, oldest at next once full
+	next    int
+	subs    map[*TrackSubscription]struct{}
+}
+
+// NewTrackChanges returns a broadcaster retaining the latest backlog changes for resuming.
+func NewTrackChanges(backlog int) *TrackChanges {
+	return &TrackChanges{
+		backlog: make([]trackChange, 0, max(backlog, 0)),
+		subs:    make(map[*TrackSubscription]struct{}),
+	}
+}
+
+// Publish numbers the events of a change in space and sends them to the matching subscribers.
+// A subscriber that has fallen trackWatchBuffer events behind is dropped.
+func (b *TrackChanges) Publish(space TenancySpace, events ...model.TrackWatchEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range events {
+		b.seq++
+		e.Sequence = b.seq
+		b.retain(trackChange{space: space, event: e})
+		for sub := range b.subs {
+			if !sub.filter.Matches(space, e) {
+				continue
+			}
+			select {
+			case sub.events <- e:
+			default:
+				sub.lagged = true
+				b.drop(sub)
+			}
+		}
+	}
+}
+
+func (b *TrackChanges) retain(c trackChange) {
+	if cap(b.backlog) == 0 {
+		return
+	}
+	if len(b.backlog) < cap(b.backlog) {
+		b.backlog = append(b.backlog, c)
+		return
+	}
+	b.backlog[b.next] = c
+	b.next = (b.next + 1) % len(b.backlog)
+}
+
+func (b *TrackChanges) drop(sub *TrackSubscription) {
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+}
+
+// Subscribe implements TrackWatcher.TrackWatch.
+func (b *TrackChanges) Subscribe(filter TrackWatchFilter, since *uint64) (*TrackSubscription, []model.TrackWatchEvent, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var missed []model.TrackWatchEvent
+	if since != nil {
+		oldest := b.seq - uint64(len(b.backlog)) + 1
+		if *since > b.seq || *since+1 < oldest {
+			return nil, nil, fmt.Errorf("%w: %d (retained %d to %d)", ErrTrackWatchResumeGap, *since, oldest, b.seq)
+		}
+		for i := range b.backlog {
+			c := b.backlog[(b.next+i)%len(b.backlog)]
+			if c.event.Sequence > *since && filter.Matches(c.space, c.event) {
+				missed = append(missed, c.event)
+			}
+		}
+	}
+	sub := &TrackSubscription{
+		filter:  filter,
+		events:  make(chan model.TrackWatchEvent, trackWatchBuffer),
+		changes: b,
+	}
+	b.subs[sub] = struct{}{}
+	return sub, missed, nil
+}
+
+// TrackSubscription receives the changes of one watch until it is closed.
+type TrackSubscription struct {
+	filter  TrackWatchFilter
+	events  chan model.TrackWatchEvent
+	changes *TrackChanges
+	lagged  bool // guarded by changes.mu
+}
+
+// Events delivers the changes in sequence order. It is closed once the subscription is
+// closed or has been dropped for falling behind; see Lagged.
+func (s *TrackSubscription) Events() <-chan model.TrackWatchEvent {
+	return s.events
+}
+
+// Lagged reports whether the subscription was dropped for falling behind. Its watcher
+// can resume from the last sequence it received.
+func (s *TrackSubscription) Lagged() bool {
+	s.changes.mu.Lock()
+	defer s.changes.mu.Unlock()
+	return s.lagged
+}
+
+func (s *TrackSubscription) Close() {
+	s.changes.mu.Lock()
+	defer s.changes.mu.Unlock()
+	s.changes.drop(s)
+}
@@ -0,0 +1,232 @@
+package store_interface
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/vixac/bullet/model"
+)
+
+// WatchedTrackStore wraps a TrackStore and publishes each successful write to its
+// TrackChanges. Puts, increments and compare-and-swaps publish the entry they wrote;
+// deletes publish the keys, or for an unfiltered TrackDeleteByPrefix each prefix, they
+// targeted, and TrackDeleteExpired publishes a delete for each key it removed.
+//
+// Each write holds the locks of the buckets it writes until it has published, so that the
+// events of a bucket are sequenced in the order its writes committed, and so that the
+// reads some writes make for their events see no other write in between. Buckets share
+// trackWatchStripes locks by hash, so writes to different buckets rarely wait on each
+// other. TrackDeleteExpired cannot know its buckets beforehand and takes every lock, for
+// one batch at a time.
+type WatchedTrackStore struct {
+	TrackStore
+	changes *TrackChanges
+	stripes [trackWatchStripes]sync.Mutex
+}
+
+const trackWatchStripes = 64
+
+// NewWatchedTrackStore wraps store, retaining the latest backlog changes for resuming watches.
+func NewWatchedTrackStore(store TrackStore, backlog int) *WatchedTrackStore {
+	return &WatchedTrackStore{TrackStore: store, changes: NewTrackChanges(backlog)}
+}
+
+func trackWatchStripe(space TenancySpace, bucketID int32) int {
+	h := fnv.New32a()
+	binary.Write(h, binary.BigEndian, space.AppId)
+	binary.Write(h, binary.BigEndian, space.TenancyId)
+	binary.Write(h, binary.BigEndian, bucketID)
+	return int(h.Sum32() % trackWatchStripes)
+}
+
+// lock takes the locks of the buckets in ascending stripe order, so that writes to several
+// buckets cannot deadlock, and returns the function that releases them.
+func (s *WatchedTrackStore) lock(space TenancySpace, bucketIDs ...int32) func() {
+	stripes := make([]int, 0, len(bucketIDs))
+	for _, bucketID := range bucketIDs {
+		stripes = append(stripes, trackWatchStripe(space, bucketID))
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+	for _, i := range stripes {
+		s.stripes[i].Lock()
+	}
+	return func() {
+		for _, i := range stripes {
+			s.stripes[i].Unlock()
+		}
+	}
+}
+
+func (s *WatchedTrackStore) TrackWatch(filter TrackWatchFilter, since *uint64) (*TrackSubscription, []model.TrackWatchEvent, error) {
+	return s.changes.Subscribe(filter, since)
+}
+
+func trackPutEvent(bucketID int32, key string, v model.TrackValue) model.TrackWatchEvent {
+	return model.TrackWatchEvent{
+		Type:      TrackWatchEventPut,
+		BucketID:  bucketID,
+		Key:       key,
+		Value:     &v.Value,
+		Tag:       v.Tag,
+		Tags:      v.Tags,
+		Metric:    v.Metric,
+		ExpiresAt: v.ExpiresAt,
+	}
+}
+
+func (s *WatchedTrackStore) TrackPut(space TenancySpace, bucketID int32, key string, value int64, tag *int64, metric *float64, expiresAt *time.Time) error {
+	defer s.lock(space, bucketID)()
+
+	if err := s.TrackStore.TrackPut(space, bucketID, key, value, tag, metric, expiresAt); err != nil {
+		return err
+	}
+	s.changes.Publish(space, trackPutEvent(bucketID, key, model.TrackValue{Value: value, Tag: tag, Metric: metric, ExpiresAt: expiresAt}))
+	return nil
+}
+
+func (s *WatchedTrackStore) TrackPutMany(space TenancySpace, items map[int32][]model.TrackKeyValueItem) error {
+	defer s.lock(space, slices.Collect(maps.Keys(items))...)()
+
+	if err := s.TrackStore.TrackPutMany(space, items); err != nil {
+		return err
+	}
+	var events []model.TrackWatchEvent
+	for _, bucketID := range slices.Sorted(maps.Keys(items)) {
+		for _, item := range items[bucketID] {
+			events = append(events, trackPutEvent(bucketID, item.Key, item.Value))
+		}
+	}
+	s.changes.Publish(space, events...)
+	return nil
+}
+
+func (s *WatchedTrackStore) TrackPutManyIf(space TenancySpace, items map[int32][]model.TrackKeyValueItem, cond TrackPutCondition) (model.TrackPutManyResult, error) {
+	defer s.lock(space, slices.Collect(maps.Keys(items))...)()
+
+	result, err := s.TrackStore.TrackPutManyIf(space, items, cond)
+	if err != nil {
+		return result, err
+	}
+	// Written follows the input order within each bucket, so it pairs off with the items in one pass
+	var events []model.TrackWatchEvent
+	written := result.Written
+	for _, bucketID := range slices.Sorted(maps.Keys(items)) {
+		for _, item := range items[bucketID] {
+			if len(written) > 0 && written[0].BucketID == bucketID && written[0].Key == item.Key {
+				events = append(events, trackPutEvent(bucketID, item.Key, item.Value))
+				written = written[1:]
+			}
+		}
+	}
+	s.changes.Publish(space, events...)
+	return result, nil
+}
+
+func (s *WatchedTrackStore) TrackIncrement(space TenancySpace, bucketID int32, key string, delta int64) (model.TrackValue, error) {
+	defer s.lock(space, bucketID)()
+
+	v, err := s.TrackStore.TrackIncrement(space, bucketID, key, delta)
+	if err != nil {
+		return v, err
+	}
+	s.changes.Publish(space, trackPutEvent(bucketID, key, v))
+	return v, nil
+}
+
+func (s *WatchedTrackStore) TrackCompareAndSwap(space TenancySpace, bucketID int32, key string, expected *int64, value int64, tag *int64, metric *float64) error {
+	defer s.lock(space, bucketID)()
+
+	if err := s.TrackStore.TrackCompareAndSwap(space, bucketID, key, expected, value, tag, metric); err != nil {
+		return err
+	}
+	s.changes.Publish(space, trackPutEvent(bucketID, key, model.TrackValue{Value: value, Tag: tag, Metric: metric}))
+	return nil
+}
+
+func (s *WatchedTrackStore) TrackDeleteMany(space TenancySpace, items []model.TrackBucketKeyPair) error {
+	bucketIDs := make([]int32, len(items))
+	for i, item := range items {
+		bucketIDs[i] = item.BucketID
+	}
+	defer s.lock(space, bucketIDs...)()
+
+	if err := s.TrackStore.TrackDeleteMany(space, items); err != nil {
+		return err
+	}
+	events := make([]model.TrackWatchEvent, len(items))
+	for i, item := range items {
+		events[i] = model.TrackWatchEvent{Type: TrackWatchEventDelete, BucketID: item.BucketID, Key: item.Key}
+	}
+	s.changes.Publish(space, events...)
+	return nil
+}
+
+// TrackDeleteByPrefix publishes a prefix delete for each prefix when it clears them, but
+// when tags or a metric filter narrow it, it reads the matching keys first and publishes
+// a delete for each, as watchers would otherwise drop the keys the filters kept.
+func (s *WatchedTrackStore) TrackDeleteByPrefix(space TenancySpace, bucketID int32, prefixes []string, tags []int64, metric *MetricFilter) (int, error) {
+	defer s.lock(space, bucketID)()
+
+	var matched []model.TrackKeyValueItem
+	filtered := len(tags) > 0 || metric != nil
+	if filtered && len(prefixes) > 0 {
+		var err error
+		if matched, err = s.TrackStore.GetItemsByKeyPrefixes(space, bucketID, prefixes, tags, metric); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.TrackStore.TrackDeleteByPrefix(space, bucketID, prefixes, tags, metric)
+	if err != nil || n == 0 {
+		return n, err
+	}
+
+	var events []model.TrackWatchEvent
+	if filtered {
+		for _, item := range matched {
+			events = append(events, model.TrackWatchEvent{Type: TrackWatchEventDelete, BucketID: bucketID, Key: item.Key})
+		}
+	} else {
+		for i := range prefixes {
+			events = append(events, model.TrackWatchEvent{Type: TrackWatchEventDelete, BucketID: bucketID, Prefix: &prefixes[i]})
+		}
+	}
+	s.changes.Publish(space, events...)
+	return n, nil
+}
+
+func (s *WatchedTrackStore) TrackDeleteExpired(now time.Time, limit int) ([]TrackExpiredKey, error) {
+	for i := range s.stripes {
+		s.stripes[i].Lock()
+	}
+	defer func() {
+		for i := range s.stripes {
+			s.stripes[i].Unlock()
+		}
+	}()
+
+	deleted, err := s.TrackStore.TrackDeleteExpired(now, limit)
+	if err != nil {
+		return deleted, err
+	}
+	// Publish per space, in the order the store reported the keys
+	for pending := deleted; len(pending) > 0; {
+		space := pending[0].Space
+		var events []model.TrackWatchEvent
+		var rest []TrackExpiredKey
+		for _, k := range pending {
+			if k.Space == space {
+				events = append(events, model.TrackWatchEvent{Type: TrackWatchEventDelete, BucketID: k.BucketID, Key: k.Key})
+			} else {
+				rest = append(rest, k)
+			}
+		}
+		s.changes.Publish(space, events...)
+		pending = rest
+	}
+	return deleted, nil
+}
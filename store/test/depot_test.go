@@ -1,9 +1,14 @@
 package store_test
 
 import (
+	"bytes"
+	"errors"
+	"reflect"
+	"slices"
 	"sort"
 	"testing"
 
+	"github.com/vixac/bullet/model"
 	"github.com/vixac/bullet/store/store_interface"
 )
 
@@ -20,7 +25,7 @@ func testDepotCreateAndGet(store store_interface.DepotStore, name string, t *tes
 		space := store_interface.TenancySpace{AppId: 200, TenancyId: 1}
 		const bucket = int32(1)
 
-		id, err := store.DepotCreate(space, bucket, "hello")
+		id, _, err := store.DepotCreate(space, bucket, "hello")
 		if err != nil {
 			t.Fatalf("DepotCreate failed: %v", err)
 		}
@@ -37,7 +42,7 @@ func testDepotCreateAndGet(store store_interface.DepotStore, name string, t *tes
 		}
 
 		// IDs should be unique across sequential creates
-		id2, err := store.DepotCreate(space, bucket, "world")
+		id2, _, err := store.DepotCreate(space, bucket, "world")
 		if err != nil {
 			t.Fatalf("second DepotCreate failed: %v", err)
 		}
@@ -106,7 +111,7 @@ func testDepotUpdate(store store_interface.DepotStore, name string, t *testing.T
 		space := store_interface.TenancySpace{AppId: 202, TenancyId: 1}
 		const bucket = int32(1)
 
-		id, err := store.DepotCreate(space, bucket, "original")
+		id, _, err := store.DepotCreate(space, bucket, "original")
 		if err != nil {
 			t.Fatalf("DepotCreate failed: %v", err)
 		}
@@ -137,7 +142,7 @@ func testDepotDelete(store store_interface.DepotStore, name string, t *testing.T
 		space := store_interface.TenancySpace{AppId: 203, TenancyId: 1}
 		const bucket = int32(1)
 
-		id, err := store.DepotCreate(space, bucket, "to_delete")
+		id, _, err := store.DepotCreate(space, bucket, "to_delete")
 		if err != nil {
 			t.Fatalf("DepotCreate failed: %v", err)
 		}
@@ -172,9 +177,9 @@ func testDepotDeleteByBucket(store store_interface.DepotStore, name string, t *t
 		const bucketA = int32(100)
 		const bucketB = int32(200)
 
-		idA1, _ := store.DepotCreate(space, bucketA, "a1")
-		idA2, _ := store.DepotCreate(space, bucketA, "a2")
-		idB1, _ := store.DepotCreate(space, bucketB, "b1")
+		idA1, _, _ := store.DepotCreate(space, bucketA, "a1")
+		idA2, _, _ := store.DepotCreate(space, bucketA, "a2")
+		idB1, _, _ := store.DepotCreate(space, bucketB, "b1")
 
 		err := store.DepotDeleteByBucket(space, bucketA)
 		if err != nil {
@@ -220,9 +225,9 @@ func testDepotGetAllByBucket(store store_interface.DepotStore, name string, t *t
 		const bucketA = int32(300)
 		const bucketB = int32(400)
 
-		idA1, _ := store.DepotCreate(space, bucketA, "alpha")
-		idA2, _ := store.DepotCreate(space, bucketA, "beta")
-		_, _ = store.DepotCreate(space, bucketB, "gamma")
+		idA1, _, _ := store.DepotCreate(space, bucketA, "alpha")
+		idA2, _, _ := store.DepotCreate(space, bucketA, "beta")
+		_, _, _ = store.DepotCreate(space, bucketB, "gamma")
 
 		all, err := store.DepotGetAllByBucket(space, bucketA)
 		if err != nil {
@@ -315,11 +320,11 @@ func testDepotMultiTenancy(store store_interface.DepotStore, name string, t *tes
 		space3 := store_interface.TenancySpace{AppId: 208, TenancyId: 1}
 		const bucket = int32(1)
 
-		id1, _ := store.DepotCreate(space1, bucket, "space1_value")
+		id1, _, _ := store.DepotCreate(space1, bucket, "space1_value")
 		// Create an extra item in space1 so its highest id is beyond space2's range
-		id1Extra, _ := store.DepotCreate(space1, bucket, "space1_extra")
-		id2, _ := store.DepotCreate(space2, bucket, "space2_value")
-		id3, _ := store.DepotCreate(space3, bucket, "space3_value")
+		id1Extra, _, _ := store.DepotCreate(space1, bucket, "space1_extra")
+		id2, _, _ := store.DepotCreate(space2, bucket, "space2_value")
+		id3, _, _ := store.DepotCreate(space3, bucket, "space3_value")
 
 		val1, err := store.DepotGet(space1, id1)
 		if err != nil || val1 != "space1_value" {
@@ -373,7 +378,7 @@ func testDepotLargeValues(store store_interface.DepotStore, name string, t *test
 			largeValue[i] = byte('a' + (i % 26))
 		}
 
-		id, err := store.DepotCreate(space, bucket, string(largeValue))
+		id, _, err := store.DepotCreate(space, bucket, string(largeValue))
 		if err != nil {
 			t.Fatalf("DepotCreate large value failed: %v", err)
 		}
@@ -387,7 +392,7 @@ func testDepotLargeValues(store store_interface.DepotStore, name string, t *test
 		}
 
 		unicodeValue := "Hello 世界 🌍 مرحبا שלום"
-		id2, err := store.DepotCreate(space, bucket, unicodeValue)
+		id2, _, err := store.DepotCreate(space, bucket, unicodeValue)
 		if err != nil {
 			t.Fatalf("DepotCreate unicode failed: %v", err)
 		}
@@ -444,3 +449,341 @@ func testDepotBucketIsolationInGetAll(store store_interface.DepotStore, name str
 		}
 	})
 }
+
+func TestDepotGetBucketPage(t *testing.T) {
+	for name, store := range depotStores {
+		testDepotGetBucketPage(store, name, t)
+	}
+}
+
+func testDepotGetBucketPage(store store_interface.DepotStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 211, TenancyId: 1}
+		const bucket = int32(1)
+
+		ids, err := store.DepotCreateMany(space, bucket, []string{"a", "b", "c", "d", "e"})
+		if err != nil {
+			t.Fatalf("DepotCreateMany failed: %v", err)
+		}
+		if _, _, err := store.DepotCreate(space, bucket+1, "other"); err != nil {
+			t.Fatalf("DepotCreate failed: %v", err)
+		}
+
+		// Walk the bucket two at a time, in both directions
+		for _, descending := range []bool{false, true} {
+			want := slices.Clone(ids)
+			if descending {
+				slices.Reverse(want)
+			}
+			var got []int64
+			var values []string
+			opts := store_interface.DepotPageOptions{Limit: 2, Descending: descending}
+			for pages := 0; ; pages++ {
+				if pages > len(ids) {
+					t.Fatalf("paging did not end")
+				}
+				items, next, err := store.DepotGetBucketPage(space, bucket, opts)
+				if err != nil {
+					t.Fatalf("DepotGetBucketPage failed: %v", err)
+				}
+				for _, item := range items {
+					got = append(got, item.ID)
+					values = append(values, item.Value)
+				}
+				if next == 0 {
+					break
+				}
+				if len(items) != 2 || next != items[1].ID {
+					t.Errorf("expected a full page ending at the cursor, got %v and %d", items, next)
+				}
+				opts.StartAfter = next
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("descending=%v: expected ids %v, got %v", descending, want, got)
+			}
+			if descending && values[0] != "e" || !descending && values[0] != "a" {
+				t.Errorf("descending=%v: unexpected values %v", descending, values)
+			}
+		}
+
+		// No limit returns the rest of the bucket
+		items, next, err := store.DepotGetBucketPage(space, bucket, store_interface.DepotPageOptions{StartAfter: ids[2]})
+		if err != nil || next != 0 || len(items) != 2 || items[0].ID != ids[3] {
+			t.Errorf("expected the last two items and no cursor, got %v %d %v", items, next, err)
+		}
+
+		items, next, err = store.DepotGetBucketPage(space, int32(9999), store_interface.DepotPageOptions{Limit: 2})
+		if err != nil || next != 0 || len(items) != 0 {
+			t.Errorf("expected an empty page for an empty bucket, got %v %d %v", items, next, err)
+		}
+
+		if _, _, err := store.DepotGetBucketPage(space, bucket, store_interface.DepotPageOptions{Limit: -1}); !errors.Is(err, store_interface.ErrInvalidQueryOptions) {
+			t.Errorf("expected ErrInvalidQueryOptions for a negative limit, got %v", err)
+		}
+	})
+}
+
+func TestDepotUpdateIfRevision(t *testing.T) {
+	for name, store := range depotStores {
+		testDepotUpdateIfRevision(store, name, t)
+	}
+}
+
+func testDepotUpdateIfRevision(store store_interface.DepotStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 212, TenancyId: 1}
+		const bucket = int32(1)
+
+		id, created, err := store.DepotCreate(space, bucket, "v1")
+		if err != nil || created != store_interface.DepotInitialRevision {
+			t.Fatalf("expected DepotCreate to write revision 1, got %d %v", created, err)
+		}
+		item, err := store.DepotGetItem(space, id)
+		if err != nil {
+			t.Fatalf("DepotGetItem failed: %v", err)
+		}
+		if item.Value != "v1" || item.Revision != store_interface.DepotInitialRevision {
+			t.Errorf("expected v1 at the initial revision, got %+v", item)
+		}
+
+		revision, err := store.DepotUpdateIfRevision(space, id, "v2", item.Revision)
+		if err != nil || revision != item.Revision+1 {
+			t.Fatalf("expected the update to move to revision %d, got %d %v", item.Revision+1, revision, err)
+		}
+
+		// A second writer still holding the first revision loses
+		_, err = store.DepotUpdateIfRevision(space, id, "stale", item.Revision)
+		var conflict *store_interface.DepotRevisionConflictError
+		if !errors.As(err, &conflict) || !errors.Is(err, store_interface.ErrDepotRevisionConflict) {
+			t.Fatalf("expected a revision conflict, got %v", err)
+		}
+		if conflict.Current != revision || conflict.Expected != item.Revision {
+			t.Errorf("unexpected conflict %+v", conflict)
+		}
+
+		// Unconditional updates move the revision too
+		if err := store.DepotUpdate(space, id, "v3"); err != nil {
+			t.Fatalf("DepotUpdate failed: %v", err)
+		}
+		item, err = store.DepotGetItem(space, id)
+		if err != nil || item.Value != "v3" || item.Revision != revision+1 {
+			t.Errorf("expected v3 at revision %d, got %+v %v", revision+1, item, err)
+		}
+		items, _, err := store.DepotGetBucketPage(space, bucket, store_interface.DepotPageOptions{})
+		if err != nil || len(items) != 1 || items[0] != item {
+			t.Errorf("expected the page to carry the revision, got %v %v", items, err)
+		}
+
+		if _, err := store.DepotGetItem(space, id+99999); !errors.Is(err, store_interface.ErrDepotItemNotFound) {
+			t.Errorf("expected ErrDepotItemNotFound from DepotGetItem, got %v", err)
+		}
+		if _, err := store.DepotUpdateIfRevision(space, id+99999, "x", 1); !errors.Is(err, store_interface.ErrDepotItemNotFound) {
+			t.Errorf("expected ErrDepotItemNotFound from DepotUpdateIfRevision, got %v", err)
+		}
+	})
+}
+
+func TestDepotQuery(t *testing.T) {
+	for name, store := range depotStores {
+		testDepotQuery(store, name, t)
+	}
+}
+
+func testDepotQuery(store store_interface.DepotStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 213, TenancyId: 1}
+		const bucket = int32(1)
+
+		ids, err := store.DepotCreateMany(space, bucket, []string{
+			`{"name":"ada","age":36,"admin":true,"team":{"name":"core"}}`,
+			`{"name":"bob","age":"36","admin":false,"team":{"name":"web"}}`,
+			`{"name":"cy","age":52,"team":null}`,
+			`{"name":"di","age":17.5,"admin":1}`,
+			`not json`,
+			`[1,2,3]`,
+		})
+		if err != nil {
+			t.Fatalf("DepotCreateMany failed: %v", err)
+		}
+		if _, _, err := store.DepotCreate(space, bucket+1, `{"name":"ada"}`); err != nil {
+			t.Fatalf("DepotCreate failed: %v", err)
+		}
+
+		cases := []struct {
+			desc    string
+			filters []store_interface.DepotFieldFilter
+			want    []int64
+		}{
+			{"eq string", []store_interface.DepotFieldFilter{{Path: "name", Op: "eq", Value: "bob"}}, []int64{ids[1]}},
+			{"eq number ignores strings", []store_interface.DepotFieldFilter{{Path: "age", Op: "eq", Value: 36}}, []int64{ids[0]}},
+			{"eq string ignores numbers", []store_interface.DepotFieldFilter{{Path: "age", Op: "eq", Value: "36"}}, []int64{ids[1]}},
+			{"eq bool ignores numbers", []store_interface.DepotFieldFilter{{Path: "admin", Op: "eq", Value: true}}, []int64{ids[0]}},
+			{"eq false", []store_interface.DepotFieldFilter{{Path: "admin", Op: "eq", Value: false}}, []int64{ids[1]}},
+			{"nested path", []store_interface.DepotFieldFilter{{Path: "team.name", Op: "eq", Value: "web"}}, []int64{ids[1]}},
+			{"in", []store_interface.DepotFieldFilter{{Path: "name", Op: "in", Values: []any{"cy", "ada", "zed"}}}, []int64{ids[0], ids[2]}},
+			{"gte number", []store_interface.DepotFieldFilter{{Path: "age", Op: "gte", Value: 36}}, []int64{ids[0], ids[2]}},
+			{"lt fraction", []store_interface.DepotFieldFilter{{Path: "age", Op: "lt", Value: 17.6}}, []int64{ids[3]}},
+			{"string range", []store_interface.DepotFieldFilter{{Path: "name", Op: "gt", Value: "b"}, {Path: "name", Op: "lte", Value: "cy"}}, []int64{ids[1], ids[2]}},
+			{"exists includes null", []store_interface.DepotFieldFilter{{Path: "team", Op: "exists"}}, []int64{ids[0], ids[1], ids[2]}},
+			{"exists nested", []store_interface.DepotFieldFilter{{Path: "team.name", Op: "exists"}}, []int64{ids[0], ids[1]}},
+			{"all filters match", []store_interface.DepotFieldFilter{{Path: "team", Op: "exists"}, {Path: "age", Op: "gt", Value: 40}}, []int64{ids[2]}},
+			{"no filters", nil, ids},
+		}
+		for _, tc := range cases {
+			items, next, err := store.DepotQuery(space, bucket, tc.filters, store_interface.DepotPageOptions{})
+			if err != nil {
+				t.Errorf("%s: DepotQuery failed: %v", tc.desc, err)
+				continue
+			}
+			var got []int64
+			for _, item := range items {
+				got = append(got, item.ID)
+			}
+			if !slices.Equal(got, tc.want) || next != 0 {
+				t.Errorf("%s: expected ids %v, got %v (next %d)", tc.desc, tc.want, got, next)
+			}
+		}
+
+		// Pages follow the matches, not the bucket
+		adults := []store_interface.DepotFieldFilter{{Path: "age", Op: "gt", Value: 18}}
+		items, next, err := store.DepotQuery(space, bucket, adults, store_interface.DepotPageOptions{Limit: 1, Descending: true})
+		if err != nil || len(items) != 1 || items[0].ID != ids[2] || next != ids[2] {
+			t.Fatalf("expected the first page to hold %d, got %v %d %v", ids[2], items, next, err)
+		}
+		items, next, err = store.DepotQuery(space, bucket, adults, store_interface.DepotPageOptions{Limit: 1, Descending: true, StartAfter: next})
+		if err != nil || len(items) != 1 || items[0].ID != ids[0] || next != 0 {
+			t.Errorf("expected the last page to hold %d, got %v %d %v", ids[0], items, next, err)
+		}
+		if items[0].Value != `{"name":"ada","age":36,"admin":true,"team":{"name":"core"}}` || items[0].Revision != store_interface.DepotInitialRevision {
+			t.Errorf("unexpected item %v", items[0])
+		}
+
+		for _, bad := range []store_interface.DepotFieldFilter{
+			{Path: "", Op: "exists"},
+			{Path: "a..b", Op: "exists"},
+			{Path: "a'b", Op: "exists"},
+			{Path: "a", Op: "like", Value: "x"},
+			{Path: "a", Op: "eq"},
+			{Path: "a", Op: "in"},
+			{Path: "a", Op: "gt", Value: true},
+			{Path: "a", Op: "eq", Value: []any{1}},
+		} {
+			if _, _, err := store.DepotQuery(space, bucket, []store_interface.DepotFieldFilter{bad}, store_interface.DepotPageOptions{}); !errors.Is(err, store_interface.ErrInvalidDepotQuery) {
+				t.Errorf("expected ErrInvalidDepotQuery for %+v, got %v", bad, err)
+			}
+		}
+	})
+}
+
+func TestDepotRaw(t *testing.T) {
+	for name, store := range depotStores {
+		testDepotRaw(store, name, t)
+	}
+}
+
+func testDepotRaw(store store_interface.DepotStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 214, TenancyId: 1}
+		const bucket = int32(1)
+
+		blob := []byte{0x00, 0xff, 0xfe, 'a', 0x00, 0x80}
+		id, created, err := store.DepotCreateRaw(space, bucket, blob, "application/x-protobuf")
+		if err != nil || created != store_interface.DepotInitialRevision {
+			t.Fatalf("expected DepotCreateRaw to write revision 1, got %d %v", created, err)
+		}
+		raw, err := store.DepotGetRaw(space, id)
+		if err != nil {
+			t.Fatalf("DepotGetRaw failed: %v", err)
+		}
+		want := model.DepotRawItem{ID: id, Data: blob, ContentType: "application/x-protobuf", Revision: store_interface.DepotInitialRevision}
+		if !reflect.DeepEqual(raw, want) {
+			t.Errorf("expected %+v, got %+v", want, raw)
+		}
+		item, err := store.DepotGetItem(space, id)
+		if err != nil || item.Value != "" || item.ContentType != "application/x-protobuf" {
+			t.Errorf("expected an empty value with the content type, got %+v %v", item, err)
+		}
+
+		// Replace the bytes, defaulting the content type
+		revision, err := store.DepotUpdateRaw(space, id, []byte{1, 2}, "")
+		if err != nil || revision != store_interface.DepotInitialRevision+1 {
+			t.Fatalf("expected DepotUpdateRaw to move to revision 2, got %d %v", revision, err)
+		}
+		raw, _ = store.DepotGetRaw(space, id)
+		if !bytes.Equal(raw.Data, []byte{1, 2}) || raw.ContentType != store_interface.DepotBinaryContentType || raw.Revision != revision {
+			t.Errorf("unexpected raw item after update %+v", raw)
+		}
+
+		if _, err := store.DepotUpdateRawIfRevision(space, id, []byte{3}, "", revision-1); !errors.Is(err, store_interface.ErrDepotRevisionConflict) {
+			t.Errorf("expected a revision conflict for a stale raw update, got %v", err)
+		}
+		if revision, err = store.DepotUpdateRawIfRevision(space, id, []byte{3}, "image/gif", revision); err != nil || revision != store_interface.DepotInitialRevision+2 {
+			t.Fatalf("expected the conditional raw update to move to revision 3, got %d %v", revision, err)
+		}
+		raw, _ = store.DepotGetRaw(space, id)
+		if !bytes.Equal(raw.Data, []byte{3}) || raw.ContentType != "image/gif" {
+			t.Errorf("unexpected raw item after conditional update %+v", raw)
+		}
+
+		// A text update makes it a text item again
+		if err := store.DepotUpdate(space, id, "text again"); err != nil {
+			t.Fatalf("DepotUpdate failed: %v", err)
+		}
+		raw, _ = store.DepotGetRaw(space, id)
+		if string(raw.Data) != "text again" || raw.ContentType != store_interface.DepotTextContentType || raw.Revision != revision+1 {
+			t.Errorf("expected the text value as text/plain, got %+v", raw)
+		}
+		items, _, err := store.DepotGetBucketPage(space, bucket, store_interface.DepotPageOptions{})
+		if err != nil || len(items) != 1 || items[0].ContentType != "" || items[0].Value != "text again" {
+			t.Errorf("expected one text item in the page, got %v %v", items, err)
+		}
+
+		// An empty body is still a raw item
+		empty, _, err := store.DepotCreateRaw(space, bucket, nil, "image/png")
+		if err != nil {
+			t.Fatalf("DepotCreateRaw failed: %v", err)
+		}
+		raw, err = store.DepotGetRaw(space, empty)
+		if err != nil || len(raw.Data) != 0 || raw.ContentType != "image/png" {
+			t.Errorf("expected an empty png, got %+v %v", raw, err)
+		}
+
+		// Large bodies are kept whole, whether they replace small or large ones
+		large := make([]byte, 3<<20+17)
+		for i := range large {
+			large[i] = byte(i * 7)
+		}
+		for i, data := range [][]byte{large, large[:100<<10], blob, large} {
+			if _, err := store.DepotUpdateRaw(space, empty, data, "image/png"); err != nil {
+				t.Fatalf("DepotUpdateRaw of %d bytes failed: %v", len(data), err)
+			}
+			raw, err = store.DepotGetRaw(space, empty)
+			if err != nil || !bytes.Equal(raw.Data, data) {
+				t.Errorf("expected update %d to read back its %d bytes, got %d %v", i, len(data), len(raw.Data), err)
+			}
+		}
+		bigID, _, err := store.DepotCreateRaw(space, bucket, large, "")
+		if err != nil {
+			t.Fatalf("DepotCreateRaw of a large body failed: %v", err)
+		}
+		if raw, err = store.DepotGetRaw(space, bigID); err != nil || !bytes.Equal(raw.Data, large) {
+			t.Errorf("expected the large body to read back whole, got %d bytes %v", len(raw.Data), err)
+		}
+		if err := store.DepotDelete(space, bigID); err != nil {
+			t.Fatalf("DepotDelete failed: %v", err)
+		}
+		if _, err := store.DepotGetRaw(space, bigID); !errors.Is(err, store_interface.ErrDepotItemNotFound) {
+			t.Errorf("expected the deleted large item to be gone, got %v", err)
+		}
+
+		if _, err := store.DepotGetRaw(space, empty+99999); !errors.Is(err, store_interface.ErrDepotItemNotFound) {
+			t.Errorf("expected ErrDepotItemNotFound from DepotGetRaw, got %v", err)
+		}
+		if _, err := store.DepotUpdateRaw(space, empty+99999, blob, ""); !errors.Is(err, store_interface.ErrDepotItemNotFound) {
+			t.Errorf("expected ErrDepotItemNotFound from DepotUpdateRaw, got %v", err)
+		}
+		if _, err := store.DepotUpdateRawIfRevision(space, empty+99999, blob, "", 1); !errors.Is(err, store_interface.ErrDepotItemNotFound) {
+			t.Errorf("expected ErrDepotItemNotFound from DepotUpdateRawIfRevision, got %v", err)
+		}
+	})
+}
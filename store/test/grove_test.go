@@ -1,6 +1,7 @@
 package store_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/vixac/bullet/store/store_interface"
@@ -1056,3 +1057,471 @@ func testGroveGetNodeWithDescendantsAggregatesBulk(store store_interface.GroveSt
 		})
 	})
 }
+
+func TestGroveCopySubtree(t *testing.T) {
+	for name, store := range groveStores {
+		testGroveCopySubtree(store, name, t)
+	}
+}
+
+func testGroveCopySubtree(store store_interface.GroveStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 12, TenancyId: 1}
+		treeID := store_interface.TreeID("tree12")
+		otherTree := store_interface.TreeID("tree12-other")
+
+		// Create tree:
+		//     root
+		//     /  \
+		//    A    Z
+		//    |
+		//    B
+		root := store_interface.NodeID("root12")
+		A := store_interface.NodeID("A12")
+		B := store_interface.NodeID("B12")
+		Z := store_interface.NodeID("Z12")
+
+		position := store_interface.ChildPosition(2.5)
+		metadata := store_interface.NodeMetadata{"kind": "template"}
+		store.CreateNode(space, treeID, root, nil, nil, nil)
+		store.CreateNode(space, treeID, A, &root, &position, &metadata)
+		store.CreateNode(space, treeID, B, &A, nil, nil)
+		store.CreateNode(space, treeID, Z, &root, nil, nil)
+
+		deltas := store_interface.AggregateDeltas{store_interface.AggregateKey("count"): 3}
+		if err := store.ApplyAggregateMutation(space, treeID, store_interface.MutationID("m1"), B, deltas); err != nil {
+			t.Fatalf("Failed to apply mutation: %v", err)
+		}
+
+		// Copy A under Z within the same tree using a prefix
+		mapping, err := store.CopySubtree(space, treeID, A, treeID, &Z, store_interface.CopySubtreeOptions{
+			IDStrategy: store_interface.NodeIDStrategyAffix,
+			Prefix:     "copy-",
+		})
+		if err != nil {
+			t.Fatalf("Failed to copy subtree: %v", err)
+		}
+		if len(mapping) != 2 || mapping[A] != "copy-A12" || mapping[B] != "copy-B12" {
+			t.Fatalf("Unexpected mapping: %v", mapping)
+		}
+
+		aInfo, err := store.GetNodeInfo(space, treeID, mapping[A])
+		if err != nil {
+			t.Fatalf("Failed to get copied A info: %v", err)
+		}
+		if aInfo.Parent == nil || *aInfo.Parent != Z {
+			t.Errorf("Expected copied A's parent to be Z, got %v", aInfo.Parent)
+		}
+		if aInfo.Depth != 2 {
+			t.Errorf("Expected copied A's depth to be 2, got %d", aInfo.Depth)
+		}
+		if aInfo.Position == nil || *aInfo.Position != position {
+			t.Errorf("Expected copied A's position to be %v, got %v", position, aInfo.Position)
+		}
+		if aInfo.Metadata == nil || (*aInfo.Metadata)["kind"] != "template" {
+			t.Errorf("Expected copied A's metadata to be copied, got %v", aInfo.Metadata)
+		}
+
+		ancestors, _, err := store.GetAncestors(space, treeID, mapping[B], nil)
+		if err != nil {
+			t.Fatalf("Failed to get ancestors: %v", err)
+		}
+		if len(ancestors) != 3 {
+			t.Errorf("Expected copied B to have 3 ancestors, got %v", ancestors)
+		}
+
+		// Aggregates are not copied unless asked for
+		local, err := store.GetNodeLocalAggregates(space, treeID, mapping[B])
+		if err != nil {
+			t.Fatalf("Failed to get local aggregates: %v", err)
+		}
+		if len(local) != 0 {
+			t.Errorf("Expected no aggregates on copied B, got %v", local)
+		}
+
+		// The source subtree is untouched
+		bInfo, err := store.GetNodeInfo(space, treeID, B)
+		if err != nil {
+			t.Fatalf("Failed to get B info: %v", err)
+		}
+		if *bInfo.Parent != A || bInfo.Depth != 2 {
+			t.Errorf("Expected B to stay under A at depth 2, got parent %v depth %d", *bInfo.Parent, bInfo.Depth)
+		}
+
+		// Copying again with the same prefix collides
+		_, err = store.CopySubtree(space, treeID, A, treeID, &Z, store_interface.CopySubtreeOptions{
+			IDStrategy: store_interface.NodeIDStrategyAffix,
+			Prefix:     "copy-",
+		})
+		if err != store_interface.ErrNodeAlreadyExists {
+			t.Errorf("Expected ErrNodeAlreadyExists, got %v", err)
+		}
+
+		// Copy A into another tree as a root with fresh IDs and aggregates
+		mapping, err = store.CopySubtree(space, treeID, A, otherTree, nil, store_interface.CopySubtreeOptions{
+			IDStrategy:     store_interface.NodeIDStrategyUUID,
+			CopyAggregates: true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to copy subtree to other tree: %v", err)
+		}
+		if len(mapping) != 2 || mapping[A] == A || mapping[B] == B {
+			t.Fatalf("Unexpected mapping: %v", mapping)
+		}
+
+		rootInfo, err := store.GetNodeInfo(space, otherTree, mapping[A])
+		if err != nil {
+			t.Fatalf("Failed to get copied root info: %v", err)
+		}
+		if rootInfo.Parent != nil || rootInfo.Depth != 0 {
+			t.Errorf("Expected copied root to have no parent and depth 0, got %v depth %d", rootInfo.Parent, rootInfo.Depth)
+		}
+
+		totals, err := store.GetNodeWithDescendantsAggregates(space, otherTree, mapping[A])
+		if err != nil {
+			t.Fatalf("Failed to get subtree aggregates: %v", err)
+		}
+		if totals[store_interface.AggregateKey("count")] != 3 {
+			t.Errorf("Expected copied subtree count 3, got %d", totals[store_interface.AggregateKey("count")])
+		}
+
+		// Invalid options and missing nodes are rejected
+		_, err = store.CopySubtree(space, treeID, A, otherTree, nil, store_interface.CopySubtreeOptions{IDStrategy: "bogus"})
+		if !errors.Is(err, store_interface.ErrInvalidCopyOptions) {
+			t.Errorf("Expected ErrInvalidCopyOptions, got %v", err)
+		}
+		_, err = store.CopySubtree(space, treeID, "missing12", otherTree, nil, store_interface.CopySubtreeOptions{IDStrategy: store_interface.NodeIDStrategyUUID})
+		if err != store_interface.ErrNodeNotFound {
+			t.Errorf("Expected ErrNodeNotFound, got %v", err)
+		}
+	})
+}
+
+func TestGroveMoveSubtreeToTree(t *testing.T) {
+	for name, store := range groveStores {
+		testGroveMoveSubtreeToTree(store, name, t)
+	}
+}
+
+func testGroveMoveSubtreeToTree(store store_interface.GroveStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 13, TenancyId: 1}
+		srcTree := store_interface.TreeID("tree13")
+		dstTree := store_interface.TreeID("tree13-dst")
+
+		// Source tree:      Destination tree:
+		//     root              droot
+		//     /  \
+		//    A    Z
+		//    |
+		//    B
+		root := store_interface.NodeID("root13")
+		A := store_interface.NodeID("A13")
+		B := store_interface.NodeID("B13")
+		Z := store_interface.NodeID("Z13")
+		droot := store_interface.NodeID("droot13")
+
+		store.CreateNode(space, srcTree, root, nil, nil, nil)
+		store.CreateNode(space, srcTree, A, &root, nil, nil)
+		store.CreateNode(space, srcTree, B, &A, nil, nil)
+		store.CreateNode(space, srcTree, Z, &root, nil, nil)
+		store.CreateNode(space, dstTree, droot, nil, nil, nil)
+
+		mutation := store_interface.MutationID("m1")
+		deltas := store_interface.AggregateDeltas{store_interface.AggregateKey("count"): 4}
+		if err := store.ApplyAggregateMutation(space, srcTree, mutation, B, deltas); err != nil {
+			t.Fatalf("Failed to apply mutation: %v", err)
+		}
+
+		position := store_interface.ChildPosition(1)
+		if err := store.MoveSubtreeToTree(space, srcTree, A, dstTree, &droot, &position); err != nil {
+			t.Fatalf("Failed to move subtree: %v", err)
+		}
+
+		// The subtree is gone from the source tree
+		for _, node := range []store_interface.NodeID{A, B} {
+			exists, _ := store.Exists(space, srcTree, node)
+			if exists {
+				t.Errorf("Expected %s to no longer exist in the source tree", node)
+			}
+		}
+		children, _, err := store.GetChildren(space, srcTree, root, nil)
+		if err != nil {
+			t.Fatalf("Failed to get source root children: %v", err)
+		}
+		if len(children) != 1 || children[0] != Z {
+			t.Errorf("Expected source root children [Z13], got %v", children)
+		}
+		srcTotals, err := store.GetNodeWithDescendantsAggregates(space, srcTree, root)
+		if err != nil {
+			t.Fatalf("Failed to get source aggregates: %v", err)
+		}
+		if srcTotals[store_interface.AggregateKey("count")] != 0 {
+			t.Errorf("Expected source count 0, got %d", srcTotals[store_interface.AggregateKey("count")])
+		}
+
+		// And present in the destination tree
+		aInfo, err := store.GetNodeInfo(space, dstTree, A)
+		if err != nil {
+			t.Fatalf("Failed to get A info: %v", err)
+		}
+		if aInfo.Parent == nil || *aInfo.Parent != droot || aInfo.Depth != 1 {
+			t.Errorf("Expected A under droot at depth 1, got parent %v depth %d", aInfo.Parent, aInfo.Depth)
+		}
+		if aInfo.Position == nil || *aInfo.Position != position {
+			t.Errorf("Expected A's position to be %v, got %v", position, aInfo.Position)
+		}
+		bInfo, err := store.GetNodeInfo(space, dstTree, B)
+		if err != nil {
+			t.Fatalf("Failed to get B info: %v", err)
+		}
+		if *bInfo.Parent != A || bInfo.Depth != 2 {
+			t.Errorf("Expected B under A at depth 2, got parent %v depth %d", *bInfo.Parent, bInfo.Depth)
+		}
+		ancestors, _, err := store.GetAncestors(space, dstTree, B, nil)
+		if err != nil {
+			t.Fatalf("Failed to get ancestors: %v", err)
+		}
+		if len(ancestors) != 2 {
+			t.Errorf("Expected B to have 2 ancestors, got %v", ancestors)
+		}
+
+		// Aggregates and mutation history moved with the nodes
+		dstTotals, err := store.GetNodeWithDescendantsAggregates(space, dstTree, droot)
+		if err != nil {
+			t.Fatalf("Failed to get destination aggregates: %v", err)
+		}
+		if dstTotals[store_interface.AggregateKey("count")] != 4 {
+			t.Errorf("Expected destination count 4, got %d", dstTotals[store_interface.AggregateKey("count")])
+		}
+		err = store.ApplyAggregateMutation(space, dstTree, mutation, B, deltas)
+		if err != store_interface.ErrMutationConflict {
+			t.Errorf("Expected ErrMutationConflict for a replayed mutation, got %v", err)
+		}
+
+		// Colliding IDs are rejected and nothing moves
+		C := store_interface.NodeID("C13")
+		store.CreateNode(space, srcTree, C, &root, nil, nil)
+		store.CreateNode(space, dstTree, C, nil, nil, nil)
+		err = store.MoveSubtreeToTree(space, srcTree, C, dstTree, nil, nil)
+		if err != store_interface.ErrNodeAlreadyExists {
+			t.Errorf("Expected ErrNodeAlreadyExists, got %v", err)
+		}
+		exists, _ := store.Exists(space, srcTree, C)
+		if !exists {
+			t.Error("Expected C13 to remain in the source tree after a rejected move")
+		}
+
+		err = store.MoveSubtreeToTree(space, srcTree, "missing13", dstTree, nil, nil)
+		if err != store_interface.ErrNodeNotFound {
+			t.Errorf("Expected ErrNodeNotFound, got %v", err)
+		}
+	})
+}
+
+func TestGroveVerifyAndRepairTree(t *testing.T) {
+	for name, store := range groveStores {
+		testGroveVerifyAndRepairTree(store, name, t)
+	}
+}
+
+func testGroveVerifyAndRepairTree(store store_interface.GroveStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 14, TenancyId: 1}
+		treeID := store_interface.TreeID("tree14")
+
+		root := store_interface.NodeID("root14")
+		A := store_interface.NodeID("A14")
+		leaf := store_interface.NodeID("leaf14")
+
+		store.CreateNode(space, treeID, root, nil, nil, nil)
+		store.CreateNode(space, treeID, A, &root, nil, nil)
+		store.CreateNode(space, treeID, leaf, &A, nil, nil)
+
+		report, err := store.VerifyTree(space, treeID)
+		if err != nil {
+			t.Fatalf("Failed to verify tree: %v", err)
+		}
+		if !report.Consistent() {
+			t.Errorf("Expected a healthy tree to be consistent, got %+v", report)
+		}
+		if report.NodeCount != 3 {
+			t.Errorf("Expected 3 nodes, got %d", report.NodeCount)
+		}
+
+		// Hard deleting a node leaves its aggregates and mutation markers behind
+		deltas := store_interface.AggregateDeltas{store_interface.AggregateKey("count"): 1}
+		if err := store.ApplyAggregateMutation(space, treeID, store_interface.MutationID("m1"), leaf, deltas); err != nil {
+			t.Fatalf("Failed to apply mutation: %v", err)
+		}
+		if err := store.DeleteNode(space, treeID, leaf, false); err != nil {
+			t.Fatalf("Failed to delete leaf: %v", err)
+		}
+
+		report, err = store.VerifyTree(space, treeID)
+		if err != nil {
+			t.Fatalf("Failed to verify tree: %v", err)
+		}
+		if len(report.DanglingAggregates) != 1 || report.DanglingAggregates[0] != leaf {
+			t.Errorf("Expected dangling aggregates [leaf14], got %v", report.DanglingAggregates)
+		}
+		if len(report.DanglingMutations) != 1 || report.DanglingMutations[0] != leaf {
+			t.Errorf("Expected dangling mutations [leaf14], got %v", report.DanglingMutations)
+		}
+
+		report, err = store.RepairTree(space, treeID)
+		if err != nil {
+			t.Fatalf("Failed to repair tree: %v", err)
+		}
+		if !report.Repaired || report.Consistent() {
+			t.Errorf("Expected the repair report to describe the problems it fixed, got %+v", report)
+		}
+
+		report, err = store.VerifyTree(space, treeID)
+		if err != nil {
+			t.Fatalf("Failed to verify repaired tree: %v", err)
+		}
+		if !report.Consistent() {
+			t.Errorf("Expected repaired tree to be consistent, got %+v", report)
+		}
+
+		// A node recreated with the same ID starts clean
+		store.CreateNode(space, treeID, leaf, &A, nil, nil)
+		local, err := store.GetNodeLocalAggregates(space, treeID, leaf)
+		if err != nil {
+			t.Fatalf("Failed to get local aggregates: %v", err)
+		}
+		if len(local) != 0 {
+			t.Errorf("Expected no aggregates on recreated leaf, got %v", local)
+		}
+	})
+}
+
+func TestGroveChanges(t *testing.T) {
+	for name, store := range groveStores {
+		testGroveChanges(store, name, t)
+	}
+}
+
+func testGroveChanges(store store_interface.GroveStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 15, TenancyId: 1}
+		treeID := store_interface.TreeID("tree15")
+		otherTree := store_interface.TreeID("other15")
+
+		root := store_interface.NodeID("root15")
+		A := store_interface.NodeID("A15")
+		B := store_interface.NodeID("B15")
+
+		changes, err := store.GetChanges(space, treeID, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to get changes: %v", err)
+		}
+		if len(changes) != 0 {
+			t.Fatalf("Expected no changes for an empty tree, got %d", len(changes))
+		}
+
+		metadata := store_interface.NodeMetadata{"title": "first"}
+		store.CreateNode(space, treeID, root, nil, nil, nil)
+		store.CreateNode(space, treeID, A, &root, nil, &metadata)
+		store.CreateNode(space, treeID, B, &root, nil, nil)
+		store.CreateNode(space, otherTree, root, nil, nil, nil)
+
+		pos := store_interface.ChildPosition(2.5)
+		if err := store.MoveNode(space, treeID, B, &A, &pos); err != nil {
+			t.Fatalf("Failed to move node: %v", err)
+		}
+		if err := store.UpdateNodeMetadata(space, treeID, A, store_interface.NodeMetadata{"title": "second"}); err != nil {
+			t.Fatalf("Failed to update metadata: %v", err)
+		}
+		deltas := store_interface.AggregateDeltas{store_interface.AggregateKey("count"): 3}
+		if err := store.ApplyAggregateMutation(space, treeID, store_interface.MutationID("m1"), A, deltas); err != nil {
+			t.Fatalf("Failed to apply mutation: %v", err)
+		}
+		if err := store.DeleteNode(space, treeID, B, true); err != nil {
+			t.Fatalf("Failed to delete node: %v", err)
+		}
+
+		// Failed writes are not recorded
+		if err := store.UpdateNodeMetadata(space, treeID, store_interface.NodeID("missing15"), metadata); err != store_interface.ErrNodeNotFound {
+			t.Errorf("Expected ErrNodeNotFound updating a missing node, got %v", err)
+		}
+		if err := store.ApplyAggregateMutation(space, treeID, store_interface.MutationID("m1"), A, deltas); err != store_interface.ErrMutationConflict {
+			t.Errorf("Expected ErrMutationConflict, got %v", err)
+		}
+
+		changes, err = store.GetChanges(space, treeID, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to get changes: %v", err)
+		}
+		expectedKinds := []store_interface.GroveChangeKind{
+			store_interface.GroveChangeCreate,
+			store_interface.GroveChangeCreate,
+			store_interface.GroveChangeCreate,
+			store_interface.GroveChangeMove,
+			store_interface.GroveChangeMetadata,
+			store_interface.GroveChangeMutation,
+			store_interface.GroveChangeDelete,
+		}
+		if len(changes) != len(expectedKinds) {
+			t.Fatalf("Expected %d changes, got %d: %+v", len(expectedKinds), len(changes), changes)
+		}
+		for i, change := range changes {
+			if change.Kind != expectedKinds[i] {
+				t.Errorf("Change %d: expected kind %s, got %s", i, expectedKinds[i], change.Kind)
+			}
+			if i > 0 && change.Seq <= changes[i-1].Seq {
+				t.Errorf("Expected increasing sequence numbers, got %d after %d", change.Seq, changes[i-1].Seq)
+			}
+			if change.At.IsZero() {
+				t.Errorf("Change %d: expected a timestamp", i)
+			}
+		}
+
+		if changes[1].NodeID != A || changes[1].Parent == nil || *changes[1].Parent != root {
+			t.Errorf("Expected create of A15 under root15, got %+v", changes[1])
+		}
+		if changes[1].Metadata == nil || (*changes[1].Metadata)["title"] != "first" {
+			t.Errorf("Expected create to carry metadata, got %+v", changes[1].Metadata)
+		}
+		move := changes[3]
+		if move.NodeID != B || move.Parent == nil || *move.Parent != A || move.Position == nil || *move.Position != pos {
+			t.Errorf("Expected move of B15 under A15 at 2.5, got %+v", move)
+		}
+		if changes[4].Metadata == nil || (*changes[4].Metadata)["title"] != "second" {
+			t.Errorf("Expected metadata change to carry new metadata, got %+v", changes[4].Metadata)
+		}
+		mutation := changes[5]
+		if mutation.NodeID != A || mutation.MutationID == nil || *mutation.MutationID != "m1" || mutation.Deltas["count"] != 3 {
+			t.Errorf("Expected mutation m1 on A15 with count delta 3, got %+v", mutation)
+		}
+		if changes[6].NodeID != B || !changes[6].Soft {
+			t.Errorf("Expected soft delete of B15, got %+v", changes[6])
+		}
+
+		// since and limit page through the log
+		page, err := store.GetChanges(space, treeID, changes[2].Seq, 2)
+		if err != nil {
+			t.Fatalf("Failed to get changes: %v", err)
+		}
+		if len(page) != 2 || page[0].Seq != changes[3].Seq || page[1].Seq != changes[4].Seq {
+			t.Errorf("Expected changes 4 and 5, got %+v", page)
+		}
+		page, err = store.GetChanges(space, treeID, changes[6].Seq, 0)
+		if err != nil {
+			t.Fatalf("Failed to get changes: %v", err)
+		}
+		if len(page) != 0 {
+			t.Errorf("Expected no changes after the last one, got %d", len(page))
+		}
+
+		// Each tree has its own log
+		other, err := store.GetChanges(space, otherTree, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to get changes: %v", err)
+		}
+		if len(other) != 1 || other[0].NodeID != root {
+			t.Errorf("Expected a single create in the other tree, got %+v", other)
+		}
+	})
+}
@@ -40,7 +40,14 @@ func init() {
 	}
 	trackStores["boltdb"] = boltStore
 	groveStores["boltdb"] = boltStore
-	// Note: boltdb is not added to depotStores (not yet implemented for boltdb)
+	depotStores["boltdb"] = boltStore
+
+	// The same track tests again, with filtered reads planned through the indexes
+	indexedBoltStore, err := boltdb.NewBoltStoreWithOptions("test-track.db", boltdb.BoltOptions{TrackFilterIndexes: true})
+	if err != nil {
+		panic(err)
+	}
+	trackStores["boltdb-indexed"] = indexedBoltStore
 }
 
 // TestMain starts a PostgreSQL container, wires it into all store maps, runs the
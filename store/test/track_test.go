@@ -1,10 +1,18 @@
 package store_test
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/vixac/bullet/model"
+	"github.com/vixac/bullet/store/boltdb"
 	"github.com/vixac/bullet/store/store_interface"
 )
 
@@ -24,7 +32,7 @@ func testTrackBasicOperations(store store_interface.TrackStore, name string, t *
 		// Test basic put and get
 		key := "test_key_1"
 		value := int64(42)
-		err := store.TrackPut(space, bucketID, key, value, nil, nil)
+		err := store.TrackPut(space, bucketID, key, value, nil, nil, nil)
 		if err != nil {
 			t.Fatalf("Failed to put: %v", err)
 		}
@@ -42,7 +50,7 @@ func testTrackBasicOperations(store store_interface.TrackStore, name string, t *
 		value2 := int64(100)
 		tag := int64(5)
 		metric := 3.14
-		err = store.TrackPut(space, bucketID, key2, value2, &tag, &metric)
+		err = store.TrackPut(space, bucketID, key2, value2, &tag, &metric, nil)
 		if err != nil {
 			t.Fatalf("Failed to put with tag/metric: %v", err)
 		}
@@ -57,7 +65,7 @@ func testTrackBasicOperations(store store_interface.TrackStore, name string, t *
 
 		// Test overwrite
 		newValue := int64(999)
-		err = store.TrackPut(space, bucketID, key, newValue, nil, nil)
+		err = store.TrackPut(space, bucketID, key, newValue, nil, nil, nil)
 		if err != nil {
 			t.Fatalf("Failed to overwrite: %v", err)
 		}
@@ -297,7 +305,7 @@ func testTrackGetItemsByKeyPrefix(store store_interface.TrackStore, name string,
 		}
 
 		// Test basic prefix query
-		results, err := store.GetItemsByKeyPrefix(space, bucketID, "user:", nil, nil, false)
+		results, err := store.GetItemsByKeyPrefix(space, bucketID, "user:", nil, nil)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefix failed: %v", err)
 		}
@@ -306,7 +314,7 @@ func testTrackGetItemsByKeyPrefix(store store_interface.TrackStore, name string,
 		}
 
 		// Test more specific prefix
-		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:1:", nil, nil, false)
+		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:1:", nil, nil)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefix user:1: failed: %v", err)
 		}
@@ -316,7 +324,7 @@ func testTrackGetItemsByKeyPrefix(store store_interface.TrackStore, name string,
 
 		// Test prefix with tag filter
 		filterTags := []int64{tag1}
-		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:", filterTags, nil, false)
+		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:", filterTags, nil)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefix with tag filter failed: %v", err)
 		}
@@ -331,7 +339,7 @@ func testTrackGetItemsByKeyPrefix(store store_interface.TrackStore, name string,
 
 		// Test prefix with multiple tags filter
 		filterMultiTags := []int64{tag1, tag2}
-		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:", filterMultiTags, nil, false)
+		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:", filterMultiTags, nil)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefix with multi-tag filter failed: %v", err)
 		}
@@ -341,7 +349,8 @@ func testTrackGetItemsByKeyPrefix(store store_interface.TrackStore, name string,
 
 		// Test prefix with metric filter (greater than)
 		metricThreshold := 15.0
-		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:", nil, &metricThreshold, true)
+		gtFilter := &store_interface.MetricFilter{Operator: store_interface.MetricGt, Value: metricThreshold}
+		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:", nil, gtFilter)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefix with metric > filter failed: %v", err)
 		}
@@ -353,7 +362,8 @@ func testTrackGetItemsByKeyPrefix(store store_interface.TrackStore, name string,
 		}
 
 		// Test prefix with metric filter (less than)
-		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:", nil, &metricThreshold, false)
+		ltFilter := &store_interface.MetricFilter{Operator: store_interface.MetricLt, Value: metricThreshold}
+		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:", nil, ltFilter)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefix with metric < filter failed: %v", err)
 		}
@@ -365,7 +375,7 @@ func testTrackGetItemsByKeyPrefix(store store_interface.TrackStore, name string,
 		}
 
 		// Test combined tag and metric filter
-		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:", filterTags, &metricThreshold, true)
+		results, err = store.GetItemsByKeyPrefix(space, bucketID, "user:", filterTags, gtFilter)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefix with combined filter failed: %v", err)
 		}
@@ -380,7 +390,7 @@ func testTrackGetItemsByKeyPrefix(store store_interface.TrackStore, name string,
 		}
 
 		// Test non-existent prefix
-		results, err = store.GetItemsByKeyPrefix(space, bucketID, "nonexistent:", nil, nil, false)
+		results, err = store.GetItemsByKeyPrefix(space, bucketID, "nonexistent:", nil, nil)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefix non-existent failed: %v", err)
 		}
@@ -389,7 +399,7 @@ func testTrackGetItemsByKeyPrefix(store store_interface.TrackStore, name string,
 		}
 
 		// Test from non-existent bucket
-		results, err = store.GetItemsByKeyPrefix(space, int32(9999), "user:", nil, nil, false)
+		results, err = store.GetItemsByKeyPrefix(space, int32(9999), "user:", nil, nil)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefix from non-existent bucket failed: %v", err)
 		}
@@ -428,7 +438,7 @@ func testTrackGetItemsByKeyPrefixes(store store_interface.TrackStore, name strin
 
 		// Test multiple prefixes
 		prefixes := []string{"cat:", "dog:"}
-		results, err := store.GetItemsByKeyPrefixes(space, bucketID, prefixes, nil, nil, false)
+		results, err := store.GetItemsByKeyPrefixes(space, bucketID, prefixes, nil, nil)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefixes failed: %v", err)
 		}
@@ -451,7 +461,7 @@ func testTrackGetItemsByKeyPrefixes(store store_interface.TrackStore, name strin
 		}
 
 		// Test single prefix (should work same as GetItemsByKeyPrefix)
-		results, err = store.GetItemsByKeyPrefixes(space, bucketID, []string{"bird:"}, nil, nil, false)
+		results, err = store.GetItemsByKeyPrefixes(space, bucketID, []string{"bird:"}, nil, nil)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefixes single prefix failed: %v", err)
 		}
@@ -460,14 +470,14 @@ func testTrackGetItemsByKeyPrefixes(store store_interface.TrackStore, name strin
 		}
 
 		// Test empty prefix list
-		results, err = store.GetItemsByKeyPrefixes(space, bucketID, []string{}, nil, nil, false)
+		results, err = store.GetItemsByKeyPrefixes(space, bucketID, []string{}, nil, nil)
 		// Behavior varies: some return error, some return empty
 		if err == nil && len(results) != 0 {
 			t.Logf("Note: %s returns %d items for empty prefix list", name, len(results))
 		}
 
 		// Test empty string prefix (matches all)
-		results, err = store.GetItemsByKeyPrefixes(space, bucketID, []string{""}, nil, nil, false)
+		results, err = store.GetItemsByKeyPrefixes(space, bucketID, []string{""}, nil, nil)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefixes empty string prefix failed: %v", err)
 		}
@@ -476,7 +486,7 @@ func testTrackGetItemsByKeyPrefixes(store store_interface.TrackStore, name strin
 		}
 
 		// Test with tag filter
-		results, err = store.GetItemsByKeyPrefixes(space, bucketID, []string{"cat:", "dog:"}, []int64{tag1}, nil, false)
+		results, err = store.GetItemsByKeyPrefixes(space, bucketID, []string{"cat:", "dog:"}, []int64{tag1}, nil)
 		if err != nil {
 			t.Fatalf("GetItemsByKeyPrefixes with tag failed: %v", err)
 		}
@@ -502,15 +512,15 @@ func testTrackMultiTenancy(store store_interface.TrackStore, name string, t *tes
 		// Put same key in different tenancy spaces
 		key := "shared_key"
 
-		err := store.TrackPut(space1, bucketID, key, 100, nil, nil)
+		err := store.TrackPut(space1, bucketID, key, 100, nil, nil, nil)
 		if err != nil {
 			t.Fatalf("Put to space1 failed: %v", err)
 		}
-		err = store.TrackPut(space2, bucketID, key, 200, nil, nil)
+		err = store.TrackPut(space2, bucketID, key, 200, nil, nil, nil)
 		if err != nil {
 			t.Fatalf("Put to space2 failed: %v", err)
 		}
-		err = store.TrackPut(space3, bucketID, key, 300, nil, nil)
+		err = store.TrackPut(space3, bucketID, key, 300, nil, nil, nil)
 		if err != nil {
 			t.Fatalf("Put to space3 failed: %v", err)
 		}
@@ -584,11 +594,11 @@ func testTrackBucketIsolation(store store_interface.TrackStore, name string, t *
 		// Put same key in different buckets
 		key := "same_key"
 
-		err := store.TrackPut(space, bucket1, key, 111, nil, nil)
+		err := store.TrackPut(space, bucket1, key, 111, nil, nil, nil)
 		if err != nil {
 			t.Fatalf("Put to bucket1 failed: %v", err)
 		}
-		err = store.TrackPut(space, bucket2, key, 222, nil, nil)
+		err = store.TrackPut(space, bucket2, key, 222, nil, nil, nil)
 		if err != nil {
 			t.Fatalf("Put to bucket2 failed: %v", err)
 		}
@@ -644,7 +654,7 @@ func testTrackLargeValues(store store_interface.TrackStore, name string, t *test
 
 		// Test with max int64
 		maxVal := int64(9223372036854775807)
-		err := store.TrackPut(space, bucketID, "max", maxVal, nil, nil)
+		err := store.TrackPut(space, bucketID, "max", maxVal, nil, nil, nil)
 		if err != nil {
 			t.Fatalf("Put max value failed: %v", err)
 		}
@@ -659,7 +669,7 @@ func testTrackLargeValues(store store_interface.TrackStore, name string, t *test
 
 		// Test with min int64
 		minVal := int64(-9223372036854775808)
-		err = store.TrackPut(space, bucketID, "min", minVal, nil, nil)
+		err = store.TrackPut(space, bucketID, "min", minVal, nil, nil, nil)
 		if err != nil {
 			t.Fatalf("Put min value failed: %v", err)
 		}
@@ -673,7 +683,7 @@ func testTrackLargeValues(store store_interface.TrackStore, name string, t *test
 		}
 
 		// Test with zero
-		err = store.TrackPut(space, bucketID, "zero", 0, nil, nil)
+		err = store.TrackPut(space, bucketID, "zero", 0, nil, nil, nil)
 		if err != nil {
 			t.Fatalf("Put zero failed: %v", err)
 		}
@@ -687,3 +697,1710 @@ func testTrackLargeValues(store store_interface.TrackStore, name string, t *test
 		}
 	})
 }
+
+func TestTrackGetValue(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackGetValue(store, name, t)
+	}
+}
+
+func testTrackGetValue(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 109, TenancyId: 1}
+		bucketID := int32(1)
+
+		tag := int64(5)
+		metric := 2.5
+		if err := store.TrackPut(space, bucketID, "full", 10, &tag, &metric, nil); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		if err := store.TrackPut(space, bucketID, "bare", 20, nil, nil, nil); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+
+		got, err := store.TrackGetValue(space, bucketID, "full")
+		if err != nil {
+			t.Fatalf("Failed to get value: %v", err)
+		}
+		if got.Value != 10 {
+			t.Errorf("Expected value 10, got %d", got.Value)
+		}
+		if got.Tag == nil || *got.Tag != tag {
+			t.Errorf("Expected tag %d, got %v", tag, got.Tag)
+		}
+		if got.Metric == nil || *got.Metric != metric {
+			t.Errorf("Expected metric %f, got %v", metric, got.Metric)
+		}
+
+		got, err = store.TrackGetValue(space, bucketID, "bare")
+		if err != nil {
+			t.Fatalf("Failed to get value: %v", err)
+		}
+		if got.Value != 20 || got.Tag != nil || got.Metric != nil {
+			t.Errorf("Expected bare value 20 without tag or metric, got %+v", got)
+		}
+
+		if _, err := store.TrackGetValue(space, bucketID, "missing"); !errors.Is(err, store_interface.ErrTrackKeyNotFound) {
+			t.Errorf("Expected ErrTrackKeyNotFound for a missing key, got %v", err)
+		}
+		if _, err := store.TrackGetValue(space, int32(9999), "full"); !errors.Is(err, store_interface.ErrTrackKeyNotFound) {
+			t.Errorf("Expected ErrTrackKeyNotFound for a missing bucket, got %v", err)
+		}
+	})
+}
+
+func TestTrackIncrementAndCompareAndSwap(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackIncrementAndCompareAndSwap(store, name, t)
+	}
+}
+
+func testTrackIncrementAndCompareAndSwap(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 110, TenancyId: 1}
+		bucketID := int32(1)
+
+		// Incrementing a missing key starts from zero
+		got, err := store.TrackIncrement(space, bucketID, "counter", 5)
+		if err != nil {
+			t.Fatalf("Failed to increment: %v", err)
+		}
+		if got.Value != 5 {
+			t.Errorf("Expected 5, got %d", got.Value)
+		}
+		got, err = store.TrackIncrement(space, bucketID, "counter", -2)
+		if err != nil {
+			t.Fatalf("Failed to increment: %v", err)
+		}
+		if got.Value != 3 {
+			t.Errorf("Expected 3, got %d", got.Value)
+		}
+
+		// Increment keeps the tag and metric
+		tag := int64(4)
+		metric := 1.5
+		if err := store.TrackPut(space, bucketID, "tagged", 10, &tag, &metric, nil); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		incremented, err := store.TrackIncrement(space, bucketID, "tagged", 1)
+		if err != nil {
+			t.Fatalf("Failed to increment: %v", err)
+		}
+		value, err := store.TrackGetValue(space, bucketID, "tagged")
+		if err != nil {
+			t.Fatalf("Failed to get value: %v", err)
+		}
+		if value.Value != 11 || value.Tag == nil || *value.Tag != tag || value.Metric == nil || *value.Metric != metric {
+			t.Errorf("Expected 11 with tag and metric kept, got %+v", value)
+		}
+		if !reflect.DeepEqual(incremented, value) {
+			t.Errorf("Expected the increment to return the entry it wrote, %+v, got %+v", value, incremented)
+		}
+
+		// Concurrent increments are not lost
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := store.TrackIncrement(space, bucketID, "concurrent", 1); err != nil {
+					t.Errorf("Failed to increment: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		total, err := store.TrackGet(space, bucketID, "concurrent")
+		if err != nil {
+			t.Fatalf("Failed to get: %v", err)
+		}
+		if total != 20 {
+			t.Errorf("Expected 20 after concurrent increments, got %d", total)
+		}
+
+		// A nil expected value only succeeds while the key is absent
+		if err := store.TrackCompareAndSwap(space, bucketID, "lock", nil, 1, nil, nil); err != nil {
+			t.Fatalf("Failed to acquire lock: %v", err)
+		}
+		if err := store.TrackCompareAndSwap(space, bucketID, "lock", nil, 2, nil, nil); !errors.Is(err, store_interface.ErrTrackValueMismatch) {
+			t.Errorf("Expected ErrTrackValueMismatch acquiring a held lock, got %v", err)
+		}
+
+		wrong := int64(7)
+		if err := store.TrackCompareAndSwap(space, bucketID, "lock", &wrong, 0, nil, nil); !errors.Is(err, store_interface.ErrTrackValueMismatch) {
+			t.Errorf("Expected ErrTrackValueMismatch for a wrong expected value, got %v", err)
+		}
+		if err := store.TrackCompareAndSwap(space, bucketID, "missing", &wrong, 0, nil, nil); !errors.Is(err, store_interface.ErrTrackValueMismatch) {
+			t.Errorf("Expected ErrTrackValueMismatch for a missing key, got %v", err)
+		}
+
+		held := int64(1)
+		if err := store.TrackCompareAndSwap(space, bucketID, "lock", &held, 0, &tag, &metric); err != nil {
+			t.Fatalf("Failed to swap: %v", err)
+		}
+		value, err = store.TrackGetValue(space, bucketID, "lock")
+		if err != nil {
+			t.Fatalf("Failed to get value: %v", err)
+		}
+		if value.Value != 0 || value.Tag == nil || *value.Tag != tag || value.Metric == nil || *value.Metric != metric {
+			t.Errorf("Expected swapped value 0 with tag and metric, got %+v", value)
+		}
+	})
+}
+
+func TestTrackMetricFilters(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackMetricFilters(store, name, t)
+	}
+}
+
+func testTrackMetricFilters(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 111, TenancyId: 1}
+		bucketID := int32(1)
+
+		m10, m20, m30 := 10.0, 20.0, 30.0
+		items := map[int32][]model.TrackKeyValueItem{
+			bucketID: {
+				{Key: "m:a", Value: model.TrackValue{Value: 1, Metric: &m10}},
+				{Key: "m:b", Value: model.TrackValue{Value: 2, Metric: &m20}},
+				{Key: "m:c", Value: model.TrackValue{Value: 3, Metric: &m30}},
+				{Key: "m:d", Value: model.TrackValue{Value: 4}},
+			},
+		}
+		if err := store.TrackPutMany(space, items); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+
+		cases := []struct {
+			filter   store_interface.MetricFilter
+			expected []string
+		}{
+			{store_interface.MetricFilter{Operator: store_interface.MetricGt, Value: 20}, []string{"m:c"}},
+			{store_interface.MetricFilter{Operator: store_interface.MetricGte, Value: 20}, []string{"m:b", "m:c"}},
+			{store_interface.MetricFilter{Operator: store_interface.MetricLt, Value: 20}, []string{"m:a"}},
+			{store_interface.MetricFilter{Operator: store_interface.MetricLte, Value: 20}, []string{"m:a", "m:b"}},
+			{store_interface.MetricFilter{Operator: store_interface.MetricEq, Value: 20}, []string{"m:b"}},
+			{store_interface.MetricFilter{Operator: store_interface.MetricNe, Value: 20}, []string{"m:a", "m:c"}},
+			{store_interface.MetricFilter{Operator: store_interface.MetricBetween, Value: 10, Upper: 20}, []string{"m:a", "m:b"}},
+		}
+		for _, tc := range cases {
+			filter := tc.filter
+			for _, multi := range []bool{false, true} {
+				var results []model.TrackKeyValueItem
+				var err error
+				if multi {
+					results, err = store.GetItemsByKeyPrefixes(space, bucketID, []string{"m:"}, nil, &filter)
+				} else {
+					results, err = store.GetItemsByKeyPrefix(space, bucketID, "m:", nil, &filter)
+				}
+				if err != nil {
+					t.Fatalf("%s filter failed: %v", filter.Operator, err)
+				}
+				keys := make([]string, len(results))
+				for i, r := range results {
+					keys[i] = r.Key
+				}
+				sort.Strings(keys)
+				if len(keys) != len(tc.expected) {
+					t.Errorf("%s %v (multi=%v): expected %v, got %v", filter.Operator, filter.Value, multi, tc.expected, keys)
+					continue
+				}
+				for i := range keys {
+					if keys[i] != tc.expected[i] {
+						t.Errorf("%s %v (multi=%v): expected %v, got %v", filter.Operator, filter.Value, multi, tc.expected, keys)
+						break
+					}
+				}
+			}
+		}
+
+		invalid := []store_interface.MetricFilter{
+			{Operator: "approx", Value: 1},
+			{Operator: store_interface.MetricBetween, Value: 30, Upper: 10},
+		}
+		for _, filter := range invalid {
+			if _, err := store.GetItemsByKeyPrefix(space, bucketID, "m:", nil, &filter); !errors.Is(err, store_interface.ErrInvalidMetricFilter) {
+				t.Errorf("Expected ErrInvalidMetricFilter for %+v, got %v", filter, err)
+			}
+		}
+	})
+}
+
+func TestTrackGetItemsByKeyPrefixesPage(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackGetItemsByKeyPrefixesPage(store, name, t)
+	}
+}
+
+func testTrackGetItemsByKeyPrefixesPage(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 112, TenancyId: 1}
+		bucketID := int32(1)
+
+		even := int64(0)
+		var items []model.TrackKeyValueItem
+		for _, key := range []string{"a:1", "a:2", "a:3", "a:4", "a:5", "ab:1", "ab:2", "b:1"} {
+			item := model.TrackKeyValueItem{Key: key, Value: model.TrackValue{Value: 1}}
+			if key[len(key)-1]%2 == 0 {
+				item.Value.Tag = &even
+			}
+			items = append(items, item)
+		}
+		if err := store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{bucketID: items}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+
+		// Walks every page and returns the keys in the order they were returned
+		collect := func(prefixes []string, tags []int64, opts store_interface.TrackQueryOptions) ([]string, int) {
+			var keys []string
+			pages := 0
+			for {
+				page, next, err := store.GetItemsByKeyPrefixesPage(space, bucketID, prefixes, tags, nil, opts)
+				if err != nil {
+					t.Fatalf("GetItemsByKeyPrefixesPage failed: %v", err)
+				}
+				pages++
+				if opts.Limit > 0 && len(page) > opts.Limit {
+					t.Fatalf("Expected at most %d items per page, got %d", opts.Limit, len(page))
+				}
+				for _, item := range page {
+					keys = append(keys, item.Key)
+				}
+				if next == "" {
+					return keys, pages
+				}
+				if pages > 20 {
+					t.Fatalf("Paging did not terminate")
+				}
+				opts.StartAfter = next
+			}
+		}
+		assertKeys := func(label string, got []string, expected ...string) {
+			t.Helper()
+			if strings.Join(got, ",") != strings.Join(expected, ",") {
+				t.Errorf("%s: expected %v, got %v", label, expected, got)
+			}
+		}
+
+		keys, pages := collect([]string{"a:"}, nil, store_interface.TrackQueryOptions{Limit: 2})
+		assertKeys("ascending", keys, "a:1", "a:2", "a:3", "a:4", "a:5")
+		if pages != 3 {
+			t.Errorf("Expected 3 pages of 2, got %d", pages)
+		}
+
+		keys, _ = collect([]string{"a:"}, nil, store_interface.TrackQueryOptions{Limit: 2, Descending: true})
+		assertKeys("descending", keys, "a:5", "a:4", "a:3", "a:2", "a:1")
+
+		// An exactly full last page does not leave a dangling cursor
+		keys, pages = collect([]string{"ab:"}, nil, store_interface.TrackQueryOptions{Limit: 2})
+		assertKeys("full page", keys, "ab:1", "ab:2")
+		if pages != 1 {
+			t.Errorf("Expected a single page, got %d", pages)
+		}
+
+		// Overlapping prefixes are merged without duplicates
+		keys, _ = collect([]string{"a", "ab:", "b:"}, nil, store_interface.TrackQueryOptions{Limit: 3})
+		assertKeys("overlapping", keys, "a:1", "a:2", "a:3", "a:4", "a:5", "ab:1", "ab:2", "b:1")
+		keys, _ = collect([]string{"a", "ab:", "b:"}, nil, store_interface.TrackQueryOptions{Limit: 3, Descending: true})
+		assertKeys("overlapping descending", keys, "b:1", "ab:2", "ab:1", "a:5", "a:4", "a:3", "a:2", "a:1")
+
+		keys, _ = collect([]string{"a"}, []int64{even}, store_interface.TrackQueryOptions{Limit: 1})
+		assertKeys("tagged", keys, "a:2", "a:4", "ab:2")
+
+		keys, pages = collect([]string{"a:"}, nil, store_interface.TrackQueryOptions{StartAfter: "a:3"})
+		assertKeys("unlimited from cursor", keys, "a:4", "a:5")
+		if pages != 1 {
+			t.Errorf("Expected a single page without a limit, got %d", pages)
+		}
+
+		if _, _, err := store.GetItemsByKeyPrefixesPage(space, bucketID, []string{"a:"}, nil, nil, store_interface.TrackQueryOptions{Limit: -1}); !errors.Is(err, store_interface.ErrInvalidQueryOptions) {
+			t.Errorf("Expected ErrInvalidQueryOptions for a negative limit, got %v", err)
+		}
+	})
+}
+
+func TestTrackGetItemsByKeyRange(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackGetItemsByKeyRange(store, name, t)
+	}
+}
+
+func testTrackGetItemsByKeyRange(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 113, TenancyId: 1}
+		bucketID := int32(1)
+
+		even := int64(0)
+		var items []model.TrackKeyValueItem
+		for i, key := range []string{"2024-01-01", "2024-01-02", "2024-01-03", "2024-01-04", "2024-02-01", "2024-03-01"} {
+			metric := float64(i)
+			item := model.TrackKeyValueItem{Key: key, Value: model.TrackValue{Value: int64(i), Metric: &metric}}
+			if i%2 == 0 {
+				item.Value.Tag = &even
+			}
+			items = append(items, item)
+		}
+		if err := store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{bucketID: items}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+
+		collect := func(keyRange store_interface.TrackKeyRange, tags []int64, metric *store_interface.MetricFilter, opts store_interface.TrackQueryOptions) []string {
+			var keys []string
+			for pages := 0; ; pages++ {
+				page, next, err := store.GetItemsByKeyRange(space, bucketID, keyRange, tags, metric, opts)
+				if err != nil {
+					t.Fatalf("GetItemsByKeyRange failed: %v", err)
+				}
+				if opts.Limit > 0 && len(page) > opts.Limit {
+					t.Fatalf("Expected at most %d items per page, got %d", opts.Limit, len(page))
+				}
+				for _, item := range page {
+					keys = append(keys, item.Key)
+				}
+				if next == "" {
+					return keys
+				}
+				if pages > 20 {
+					t.Fatalf("Paging did not terminate")
+				}
+				opts.StartAfter = next
+			}
+		}
+		assertKeys := func(label string, got []string, expected ...string) {
+			t.Helper()
+			if strings.Join(got, ",") != strings.Join(expected, ",") {
+				t.Errorf("%s: expected %v, got %v", label, expected, got)
+			}
+		}
+
+		january := store_interface.TrackKeyRange{Start: "2024-01-02", End: "2024-02-01", StartInclusive: true}
+		assertKeys("half open", collect(january, nil, nil, store_interface.TrackQueryOptions{}),
+			"2024-01-02", "2024-01-03", "2024-01-04")
+		assertKeys("half open paged", collect(january, nil, nil, store_interface.TrackQueryOptions{Limit: 2}),
+			"2024-01-02", "2024-01-03", "2024-01-04")
+		assertKeys("half open descending", collect(january, nil, nil, store_interface.TrackQueryOptions{Limit: 2, Descending: true}),
+			"2024-01-04", "2024-01-03", "2024-01-02")
+
+		closed := store_interface.TrackKeyRange{Start: "2024-01-02", End: "2024-02-01", StartInclusive: true, EndInclusive: true}
+		assertKeys("closed", collect(closed, nil, nil, store_interface.TrackQueryOptions{}),
+			"2024-01-02", "2024-01-03", "2024-01-04", "2024-02-01")
+		assertKeys("closed descending", collect(closed, nil, nil, store_interface.TrackQueryOptions{Limit: 1, Descending: true}),
+			"2024-02-01", "2024-01-04", "2024-01-03", "2024-01-02")
+
+		open := store_interface.TrackKeyRange{Start: "2024-01-02", End: "2024-02-01"}
+		assertKeys("open", collect(open, nil, nil, store_interface.TrackQueryOptions{}),
+			"2024-01-03", "2024-01-04")
+
+		// Bounds need not be stored keys, and an empty bound is unbounded
+		assertKeys("from", collect(store_interface.TrackKeyRange{Start: "2024-01-9"}, nil, nil, store_interface.TrackQueryOptions{Limit: 1}),
+			"2024-02-01", "2024-03-01")
+		assertKeys("until descending", collect(store_interface.TrackKeyRange{End: "2024-01-025"}, nil, nil, store_interface.TrackQueryOptions{Descending: true}),
+			"2024-01-02", "2024-01-01")
+
+		assertKeys("tagged", collect(store_interface.TrackKeyRange{}, []int64{even}, nil, store_interface.TrackQueryOptions{Limit: 1}),
+			"2024-01-01", "2024-01-03", "2024-02-01")
+		metric := &store_interface.MetricFilter{Operator: store_interface.MetricGte, Value: 3}
+		assertKeys("metric", collect(january, nil, metric, store_interface.TrackQueryOptions{}), "2024-01-04")
+
+		if _, _, err := store.GetItemsByKeyRange(space, bucketID, store_interface.TrackKeyRange{Start: "b", End: "a"}, nil, nil, store_interface.TrackQueryOptions{}); !errors.Is(err, store_interface.ErrInvalidQueryOptions) {
+			t.Errorf("Expected ErrInvalidQueryOptions for a reversed range, got %v", err)
+		}
+		page, next, err := store.GetItemsByKeyRange(space, int32(99), january, nil, nil, store_interface.TrackQueryOptions{Limit: 1})
+		if err != nil || len(page) != 0 || next != "" {
+			t.Errorf("Expected an empty page for a missing bucket, got %v %q %v", page, next, err)
+		}
+	})
+}
+
+func TestTrackTopK(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackTopK(store, name, t)
+	}
+}
+
+func testTrackTopK(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 114, TenancyId: 1}
+		bucketID := int32(1)
+
+		score := func(m float64) *float64 { return &m }
+		team := int64(7)
+		items := []model.TrackKeyValueItem{
+			{Key: "p:ann", Value: model.TrackValue{Value: 3, Metric: score(12.5), Tag: &team}},
+			{Key: "p:bob", Value: model.TrackValue{Value: 9, Metric: score(40)}},
+			{Key: "p:cat", Value: model.TrackValue{Value: 1, Metric: score(-2), Tag: &team}},
+			{Key: "p:dan", Value: model.TrackValue{Value: 9, Metric: score(40), Tag: &team}},
+			{Key: "p:eve", Value: model.TrackValue{Value: 5}},
+			{Key: "q:fay", Value: model.TrackValue{Value: 100, Metric: score(100)}},
+		}
+		if err := store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{bucketID: items}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+
+		keysOf := func(page []model.TrackKeyValueItem) string {
+			var keys []string
+			for _, item := range page {
+				keys = append(keys, item.Key)
+			}
+			return strings.Join(keys, ",")
+		}
+		check := func(label string, tags []int64, opts store_interface.TrackQueryOptions, expected string) {
+			t.Helper()
+			page, next, err := store.GetItemsByKeyPrefixesPage(space, bucketID, []string{"p:"}, tags, nil, opts)
+			if err != nil {
+				t.Fatalf("%s: GetItemsByKeyPrefixesPage failed: %v", label, err)
+			}
+			if got := keysOf(page); got != expected {
+				t.Errorf("%s: expected %s, got %s", label, expected, got)
+			}
+			if next != "" {
+				t.Errorf("%s: expected no cursor for a top-K query, got %q", label, next)
+			}
+		}
+
+		// Ties on value or metric are broken by key in the same direction
+		check("value ascending", nil, store_interface.TrackQueryOptions{OrderBy: store_interface.TrackOrderByValue, Limit: 3}, "p:cat,p:ann,p:eve")
+		check("value descending", nil, store_interface.TrackQueryOptions{OrderBy: store_interface.TrackOrderByValue, Limit: 3, Descending: true}, "p:dan,p:bob,p:eve")
+		check("value unlimited", nil, store_interface.TrackQueryOptions{OrderBy: store_interface.TrackOrderByValue}, "p:cat,p:ann,p:eve,p:bob,p:dan")
+		check("metric skips unscored", nil, store_interface.TrackQueryOptions{OrderBy: store_interface.TrackOrderByMetric}, "p:cat,p:ann,p:bob,p:dan")
+		check("metric leaderboard", nil, store_interface.TrackQueryOptions{OrderBy: store_interface.TrackOrderByMetric, Limit: 2, Descending: true}, "p:dan,p:bob")
+		check("metric tagged", []int64{team}, store_interface.TrackQueryOptions{OrderBy: store_interface.TrackOrderByMetric, Limit: 2, Descending: true}, "p:dan,p:ann")
+
+		page, _, err := store.GetItemsByKeyRange(space, bucketID, store_interface.TrackKeyRange{Start: "p:b", StartInclusive: true}, nil,
+			&store_interface.MetricFilter{Operator: store_interface.MetricLt, Value: 50},
+			store_interface.TrackQueryOptions{OrderBy: store_interface.TrackOrderByMetric, Limit: 2})
+		if err != nil {
+			t.Fatalf("GetItemsByKeyRange failed: %v", err)
+		}
+		if got := keysOf(page); got != "p:cat,p:bob" {
+			t.Errorf("range by metric: expected p:cat,p:bob, got %s", got)
+		}
+
+		for _, opts := range []store_interface.TrackQueryOptions{
+			{OrderBy: store_interface.TrackOrderByValue, StartAfter: "p:ann"},
+			{OrderBy: "size"},
+		} {
+			if _, _, err := store.GetItemsByKeyPrefixesPage(space, bucketID, []string{"p:"}, nil, nil, opts); !errors.Is(err, store_interface.ErrInvalidQueryOptions) {
+				t.Errorf("Expected ErrInvalidQueryOptions for %+v, got %v", opts, err)
+			}
+		}
+	})
+}
+
+func TestTrackAggregate(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackAggregate(store, name, t)
+	}
+}
+
+func testTrackAggregate(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 115, TenancyId: 1}
+		bucketID := int32(1)
+
+		metric := func(m float64) *float64 { return &m }
+		red, blue := int64(1), int64(2)
+		items := []model.TrackKeyValueItem{
+			{Key: "g:1", Value: model.TrackValue{Value: 10, Tag: &red, Metric: metric(1.5)}},
+			{Key: "g:2", Value: model.TrackValue{Value: -4, Tag: &red}},
+			{Key: "g:3", Value: model.TrackValue{Value: 7, Tag: &blue, Metric: metric(4)}},
+			{Key: "g:4", Value: model.TrackValue{Value: 1, Metric: metric(-0.5)}},
+			{Key: "h:1", Value: model.TrackValue{Value: 1000, Tag: &red, Metric: metric(1000)}},
+		}
+		if err := store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{bucketID: items}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+
+		aggregate := func(prefixes []string, tags []int64, filter *store_interface.MetricFilter, groupByTag bool) []model.TrackAggregateGroup {
+			t.Helper()
+			groups, err := store.TrackAggregate(space, bucketID, prefixes, tags, filter, groupByTag)
+			if err != nil {
+				t.Fatalf("TrackAggregate failed: %v", err)
+			}
+			return groups
+		}
+		intOf := func(p *int64) any {
+			if p == nil {
+				return nil
+			}
+			return *p
+		}
+		floatOf := func(p *float64) any {
+			if p == nil {
+				return nil
+			}
+			return *p
+		}
+		summary := func(g model.TrackAggregateGroup) string {
+			return fmt.Sprintf("tag=%v count=%d value=%d/%v/%v/%v metric=%d/%v/%v/%v/%v",
+				intOf(g.Tag), g.Count, g.Value.Sum, intOf(g.Value.Min), intOf(g.Value.Max), floatOf(g.Value.Avg),
+				g.Metric.Count, g.Metric.Sum, floatOf(g.Metric.Min), floatOf(g.Metric.Max), floatOf(g.Metric.Avg))
+		}
+		assertGroups := func(label string, groups []model.TrackAggregateGroup, expected ...string) {
+			t.Helper()
+			var got []string
+			for _, g := range groups {
+				got = append(got, summary(g))
+			}
+			if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+				t.Errorf("%s:\nexpected\n%s\ngot\n%s", label, strings.Join(expected, "\n"), strings.Join(got, "\n"))
+			}
+		}
+
+		assertGroups("all", aggregate([]string{"g:"}, nil, nil, false),
+			"tag=<nil> count=4 value=14/-4/10/3.5 metric=3/5/-0.5/4/1.6666666666666667")
+		// Overlapping prefixes count each key once
+		assertGroups("overlapping", aggregate([]string{"g:", "g:1", "g:"}, nil, nil, false),
+			"tag=<nil> count=4 value=14/-4/10/3.5 metric=3/5/-0.5/4/1.6666666666666667")
+		assertGroups("by tag", aggregate([]string{"g:", "h:"}, nil, nil, true),
+			"tag=<nil> count=1 value=1/1/1/1 metric=1/-0.5/-0.5/-0.5/-0.5",
+			"tag=1 count=3 value=1006/-4/1000/335.3333333333333 metric=2/1001.5/1.5/1000/500.75",
+			"tag=2 count=1 value=7/7/7/7 metric=1/4/4/4/4")
+		assertGroups("tag filter", aggregate([]string{""}, []int64{blue}, nil, true),
+			"tag=2 count=1 value=7/7/7/7 metric=1/4/4/4/4")
+		assertGroups("metric filter", aggregate([]string{"g:"}, nil, &store_interface.MetricFilter{Operator: store_interface.MetricGte, Value: 1}, false),
+			"tag=<nil> count=2 value=17/7/10/8.5 metric=2/5.5/1.5/4/2.75")
+		assertGroups("nothing matched", aggregate([]string{"zz"}, nil, nil, false),
+			"tag=<nil> count=0 value=0/<nil>/<nil>/<nil> metric=0/0/<nil>/<nil>/<nil>")
+		if groups := aggregate([]string{"zz"}, nil, nil, true); len(groups) != 0 {
+			t.Errorf("Expected no groups when nothing matched, got %d", len(groups))
+		}
+
+		if _, err := store.TrackAggregate(space, bucketID, nil, nil, nil, false); !errors.Is(err, store_interface.ErrInvalidQueryOptions) {
+			t.Errorf("Expected ErrInvalidQueryOptions without prefixes, got %v", err)
+		}
+	})
+}
+
+func TestTrackExpiry(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackExpiry(store, name, t)
+	}
+}
+
+func testTrackExpiry(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 116, TenancyId: 1}
+		bucketID := int32(1)
+		past := time.Now().Add(-time.Minute)
+		future := time.Now().Add(time.Hour)
+		tag := int64(3)
+		keysOf := func(items []model.TrackKeyValueItem) string {
+			keys := make([]string, len(items))
+			for i, item := range items {
+				keys[i] = item.Key
+			}
+			return strings.Join(keys, ",")
+		}
+
+		if err := store.TrackPut(space, bucketID, "e:expired", 1, &tag, nil, &past); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if err := store.TrackPut(space, bucketID, "e:live", 2, &tag, nil, &future); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if err := store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{bucketID: {
+			{Key: "e:batch-expired", Value: model.TrackValue{Value: 3, ExpiresAt: &past}},
+			{Key: "e:forever", Value: model.TrackValue{Value: 4}},
+		}}); err != nil {
+			t.Fatalf("TrackPutMany failed: %v", err)
+		}
+
+		if _, err := store.TrackGet(space, bucketID, "e:expired"); err == nil {
+			t.Errorf("TrackGet returned an expired key")
+		}
+		if _, err := store.TrackGetValue(space, bucketID, "e:batch-expired"); err == nil {
+			t.Errorf("TrackGetValue returned an expired key")
+		}
+		live, err := store.TrackGetValue(space, bucketID, "e:live")
+		if err != nil {
+			t.Fatalf("TrackGetValue failed: %v", err)
+		}
+		if live.ExpiresAt == nil || live.ExpiresAt.Sub(future).Abs() > time.Second {
+			t.Errorf("expected expiry %v, got %v", future, live.ExpiresAt)
+		}
+
+		values, missing, err := store.TrackGetMany(space, map[int32][]string{bucketID: {"e:expired", "e:live"}})
+		if err != nil {
+			t.Fatalf("TrackGetMany failed: %v", err)
+		}
+		if _, ok := values[bucketID]["e:expired"]; ok || len(missing[bucketID]) != 1 {
+			t.Errorf("expected e:expired to be missing, got values %v missing %v", values, missing)
+		}
+
+		items, _, err := store.GetItemsByKeyPrefixesPage(space, bucketID, []string{"e:"}, nil, nil, store_interface.TrackQueryOptions{})
+		if err != nil {
+			t.Fatalf("GetItemsByKeyPrefixesPage failed: %v", err)
+		}
+		if keys := keysOf(items); keys != "e:forever,e:live" {
+			t.Errorf("expected only live keys, got %v", keys)
+		}
+		items, _, err = store.GetItemsByKeyRange(space, bucketID, store_interface.TrackKeyRange{Start: "e:", StartInclusive: true}, nil, nil, store_interface.TrackQueryOptions{})
+		if err != nil {
+			t.Fatalf("GetItemsByKeyRange failed: %v", err)
+		}
+		if keys := keysOf(items); keys != "e:forever,e:live" {
+			t.Errorf("expected only live keys, got %v", keys)
+		}
+		groups, err := store.TrackAggregate(space, bucketID, []string{"e:"}, nil, nil, false)
+		if err != nil {
+			t.Fatalf("TrackAggregate failed: %v", err)
+		}
+		if groups[0].Count != 2 || groups[0].Value.Sum != 6 {
+			t.Errorf("expected 2 live keys summing to 6, got %+v", groups[0])
+		}
+
+		// An expired key counts from zero and loses its tag and expiry
+		got, err := store.TrackIncrement(space, bucketID, "e:expired", 5)
+		if err != nil {
+			t.Fatalf("TrackIncrement failed: %v", err)
+		}
+		if got.Value != 5 || got.Tag != nil || got.ExpiresAt != nil {
+			t.Errorf("expected increment of an expired key to give a fresh 5, got %+v", got)
+		}
+		value, err := store.TrackGetValue(space, bucketID, "e:expired")
+		if err != nil {
+			t.Fatalf("TrackGetValue failed: %v", err)
+		}
+		if value.Tag != nil || value.ExpiresAt != nil {
+			t.Errorf("expected a fresh value, got %+v", value)
+		}
+
+		// A live key keeps its expiry through an increment
+		if _, err := store.TrackIncrement(space, bucketID, "e:live", 1); err != nil {
+			t.Fatalf("TrackIncrement failed: %v", err)
+		}
+		if value, _ := store.TrackGetValue(space, bucketID, "e:live"); value.Value != 3 || value.ExpiresAt == nil {
+			t.Errorf("expected 3 with its expiry kept, got %+v", value)
+		}
+
+		// CAS treats an expired key as absent
+		if err := store.TrackPut(space, bucketID, "e:cas", 9, nil, nil, &past); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		expected := int64(9)
+		if err := store.TrackCompareAndSwap(space, bucketID, "e:cas", &expected, 10, nil, nil); !errors.Is(err, store_interface.ErrTrackValueMismatch) {
+			t.Errorf("expected a mismatch swapping an expired value, got %v", err)
+		}
+		if err := store.TrackCompareAndSwap(space, bucketID, "e:cas", nil, 11, nil, nil); err != nil {
+			t.Fatalf("TrackCompareAndSwap on an expired key failed: %v", err)
+		}
+		if value, _ := store.TrackGetValue(space, bucketID, "e:cas"); value.Value != 11 || value.ExpiresAt != nil {
+			t.Errorf("expected 11 without expiry, got %+v", value)
+		}
+
+		// A key whose expiry is pushed back is not swept at its old expiry
+		if err := store.TrackPut(space, bucketID, "e:renewed", 12, nil, nil, &past); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if err := store.TrackPut(space, bucketID, "e:renewed", 12, nil, nil, &future); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+
+		// The sweep deletes only the expired row that remains
+		deleted, err := store.TrackDeleteExpired(time.Now(), 100)
+		if err != nil {
+			t.Fatalf("TrackDeleteExpired failed: %v", err)
+		}
+		if !slices.Contains(deleted, store_interface.TrackExpiredKey{Space: space, BucketID: bucketID, Key: "e:batch-expired"}) {
+			t.Errorf("expected e:batch-expired to be deleted, deleted %v", deleted)
+		}
+		if _, err := store.TrackGetValue(space, bucketID, "e:renewed"); err != nil {
+			t.Errorf("a key with a renewed expiry was swept: %v", err)
+		}
+		deleted, err = store.TrackDeleteExpired(future.Add(time.Second), 100)
+		if err != nil {
+			t.Fatalf("TrackDeleteExpired failed: %v", err)
+		}
+		if !slices.Contains(deleted, store_interface.TrackExpiredKey{Space: space, BucketID: bucketID, Key: "e:live"}) {
+			t.Errorf("expected e:live to be deleted, deleted %v", deleted)
+		}
+		if _, err := store.TrackGetValue(space, bucketID, "e:forever"); err != nil {
+			t.Errorf("a key without expiry was swept: %v", err)
+		}
+	})
+}
+
+func TestTrackDeleteByPrefix(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackDeleteByPrefix(store, name, t)
+	}
+}
+
+func testTrackDeleteByPrefix(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 117, TenancyId: 1}
+		bucketID := int32(1)
+
+		metric := func(m float64) *float64 { return &m }
+		red, blue := int64(1), int64(2)
+		var items []model.TrackKeyValueItem
+		for i := 0; i < 20; i++ {
+			items = append(items, model.TrackKeyValueItem{Key: fmt.Sprintf("user:1:%02d", i), Value: model.TrackValue{Value: int64(i)}})
+		}
+		items = append(items,
+			model.TrackKeyValueItem{Key: "user:10", Value: model.TrackValue{Value: 1}},
+			model.TrackKeyValueItem{Key: "user:2:red", Value: model.TrackValue{Value: 1, Tag: &red, Metric: metric(5)}},
+			model.TrackKeyValueItem{Key: "user:2:red-low", Value: model.TrackValue{Value: 1, Tag: &red, Metric: metric(1)}},
+			model.TrackKeyValueItem{Key: "user:2:blue", Value: model.TrackValue{Value: 1, Tag: &blue, Metric: metric(5)}},
+		)
+		if err := store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{bucketID: items}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		remaining := func(prefix string) int {
+			t.Helper()
+			left, _, err := store.GetItemsByKeyPrefixesPage(space, bucketID, []string{prefix}, nil, nil, store_interface.TrackQueryOptions{})
+			if err != nil {
+				t.Fatalf("GetItemsByKeyPrefixesPage failed: %v", err)
+			}
+			return len(left)
+		}
+
+		// Overlapping prefixes delete each key once, and consecutive keys are all removed
+		deleted, err := store.TrackDeleteByPrefix(space, bucketID, []string{"user:1:", "user:1:0"}, nil, nil)
+		if err != nil {
+			t.Fatalf("TrackDeleteByPrefix failed: %v", err)
+		}
+		if deleted != 20 {
+			t.Errorf("expected 20 deleted, got %d", deleted)
+		}
+		if n := remaining("user:1"); n != 1 {
+			t.Errorf("expected only user:10 to remain under user:1, got %d", n)
+		}
+
+		filter := &store_interface.MetricFilter{Operator: store_interface.MetricGt, Value: 2}
+		deleted, err = store.TrackDeleteByPrefix(space, bucketID, []string{"user:2:"}, []int64{red}, filter)
+		if err != nil {
+			t.Fatalf("TrackDeleteByPrefix failed: %v", err)
+		}
+		if deleted != 1 {
+			t.Errorf("expected only user:2:red to be deleted, got %d", deleted)
+		}
+		if n := remaining("user:2:"); n != 2 {
+			t.Errorf("expected 2 keys to remain under user:2:, got %d", n)
+		}
+
+		deleted, err = store.TrackDeleteByPrefix(space, bucketID, []string{"missing:"}, nil, nil)
+		if err != nil || deleted != 0 {
+			t.Errorf("expected nothing deleted, got %d, %v", deleted, err)
+		}
+		if _, err := store.TrackDeleteByPrefix(space, bucketID, nil, nil, nil); !errors.Is(err, store_interface.ErrInvalidQueryOptions) {
+			t.Errorf("expected ErrInvalidQueryOptions without prefixes, got %v", err)
+		}
+
+		deleted, err = store.TrackDeleteByPrefix(space, bucketID, []string{""}, nil, nil)
+		if err != nil {
+			t.Fatalf("TrackDeleteByPrefix failed: %v", err)
+		}
+		if deleted != 3 || remaining("") != 0 {
+			t.Errorf("expected the empty prefix to clear the remaining 3 keys, deleted %d", deleted)
+		}
+	})
+}
+
+func TestTrackBucketStats(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackBucketStats(store, name, t)
+	}
+}
+
+func testTrackBucketStats(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 118, TenancyId: 1}
+
+		buckets, err := store.ListTrackBuckets(space)
+		if err != nil {
+			t.Fatalf("ListTrackBuckets failed: %v", err)
+		}
+		if len(buckets) != 0 {
+			t.Errorf("expected no buckets, got %v", buckets)
+		}
+
+		tag := int64(4)
+		past := time.Now().Add(-time.Minute)
+		if err := store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{
+			10: {
+				{Key: "m", Value: model.TrackValue{Value: 5, Tag: &tag}},
+				{Key: "c", Value: model.TrackValue{Value: -2}},
+				{Key: "x", Value: model.TrackValue{Value: 9}},
+				{Key: "zz-expired", Value: model.TrackValue{Value: 100, ExpiresAt: &past}},
+			},
+			2:  {{Key: "only", Value: model.TrackValue{Value: 1}}},
+			30: {{Key: "gone", Value: model.TrackValue{Value: 1, ExpiresAt: &past}}},
+		}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		metric := 1.5
+		if err := store.TrackPut(space, 10, "x", 9, nil, &metric, nil); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+
+		buckets, err = store.ListTrackBuckets(space)
+		if err != nil {
+			t.Fatalf("ListTrackBuckets failed: %v", err)
+		}
+		if fmt.Sprint(buckets) != "[2 10]" {
+			t.Errorf("expected buckets [2 10], got %v", buckets)
+		}
+
+		stats, err := store.TrackBucketStats(space, 10)
+		if err != nil {
+			t.Fatalf("TrackBucketStats failed: %v", err)
+		}
+		if stats.BucketID != 10 || stats.KeyCount != 3 || stats.MinKey != "c" || stats.MaxKey != "x" {
+			t.Errorf("unexpected key stats %+v", stats)
+		}
+		if stats.Value.Sum != 12 || stats.Value.Min == nil || *stats.Value.Min != -2 || stats.Value.Max == nil || *stats.Value.Max != 9 {
+			t.Errorf("unexpected value stats %+v", stats.Value)
+		}
+		if stats.Metric.Count != 1 || stats.Metric.Max == nil || *stats.Metric.Max != 1.5 {
+			t.Errorf("unexpected metric stats %+v", stats.Metric)
+		}
+		// Three one-byte keys at 8 bytes a value, plus a tag and a metric
+		if stats.ApproxBytes != 3+3*8+8+8 {
+			t.Errorf("expected 43 approximate bytes, got %d", stats.ApproxBytes)
+		}
+
+		if _, err := store.TrackBucketStats(space, 30); !errors.Is(err, store_interface.ErrTrackBucketNotFound) {
+			t.Errorf("expected ErrTrackBucketNotFound for an expired bucket, got %v", err)
+		}
+		if _, err := store.TrackBucketStats(space, 99); !errors.Is(err, store_interface.ErrTrackBucketNotFound) {
+			t.Errorf("expected ErrTrackBucketNotFound for a missing bucket, got %v", err)
+		}
+	})
+}
+
+func TestTrackScanBucket(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackScanBucket(store, name, t)
+	}
+}
+
+func testTrackScanBucket(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 119, TenancyId: 1}
+		bucketID := int32(1)
+
+		tag := int64(6)
+		metric := 0.25
+		past := time.Now().Add(-time.Minute)
+		future := time.Now().Add(time.Hour)
+		var items []model.TrackKeyValueItem
+		for i := 0; i < 50; i++ {
+			items = append(items, model.TrackKeyValueItem{Key: fmt.Sprintf("k%03d", 49-i), Value: model.TrackValue{Value: int64(i)}})
+		}
+		items = append(items,
+			model.TrackKeyValueItem{Key: "a", Value: model.TrackValue{Value: -1, Tag: &tag, Metric: &metric, ExpiresAt: &future}},
+			model.TrackKeyValueItem{Key: "b-expired", Value: model.TrackValue{Value: 1, ExpiresAt: &past}},
+		)
+		if err := store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{bucketID: items, 2: {{Key: "other"}}}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+
+		var scanned []model.TrackKeyValueItem
+		err := store.TrackScanBucket(space, bucketID, func(item model.TrackKeyValueItem) error {
+			scanned = append(scanned, item)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("TrackScanBucket failed: %v", err)
+		}
+		if len(scanned) != 51 {
+			t.Fatalf("expected 51 live keys, got %d", len(scanned))
+		}
+		if !sort.SliceIsSorted(scanned, func(i, j int) bool { return scanned[i].Key < scanned[j].Key }) {
+			t.Errorf("expected keys in ascending order")
+		}
+		first := scanned[0]
+		if first.Key != "a" || first.Value.Value != -1 || first.Value.Tag == nil || *first.Value.Tag != tag ||
+			first.Value.Metric == nil || *first.Value.Metric != metric || first.Value.ExpiresAt == nil {
+			t.Errorf("expected a with its tag, metric and expiry, got %+v", first)
+		}
+
+		stop := errors.New("stop")
+		calls := 0
+		err = store.TrackScanBucket(space, bucketID, func(model.TrackKeyValueItem) error {
+			calls++
+			if calls == 3 {
+				return stop
+			}
+			return nil
+		})
+		if !errors.Is(err, stop) || calls != 3 {
+			t.Errorf("expected the scan to stop at the third key with fn's error, got %d calls and %v", calls, err)
+		}
+
+		err = store.TrackScanBucket(space, 99, func(model.TrackKeyValueItem) error {
+			t.Errorf("unexpected key in a missing bucket")
+			return nil
+		})
+		if err != nil {
+			t.Errorf("TrackScanBucket of a missing bucket failed: %v", err)
+		}
+	})
+}
+
+func TestTrackPutManyIf(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackPutManyIf(store, name, t)
+	}
+}
+
+func testTrackPutManyIf(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 120, TenancyId: 1}
+		bucketID := int32(1)
+
+		owner, other := int64(7), int64(8)
+		past := time.Now().Add(-time.Minute)
+		if err := store.TrackPut(space, bucketID, "claimed", 1, &owner, nil, nil); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if err := store.TrackPut(space, bucketID, "expired", 1, &owner, nil, &past); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		keysOf := func(pairs []model.TrackBucketKeyPair) string {
+			keys := make([]string, len(pairs))
+			for i, p := range pairs {
+				keys[i] = fmt.Sprintf("%d/%s", p.BucketID, p.Key)
+			}
+			return strings.Join(keys, ",")
+		}
+		put := func(items map[int32][]model.TrackKeyValueItem, cond store_interface.TrackPutCondition) model.TrackPutManyResult {
+			t.Helper()
+			result, err := store.TrackPutManyIf(space, items, cond)
+			if err != nil {
+				t.Fatalf("TrackPutManyIf failed: %v", err)
+			}
+			return result
+		}
+
+		// if_absent: expired keys count as absent, and a repeated key sees the earlier write
+		result := put(map[int32][]model.TrackKeyValueItem{
+			bucketID: {
+				{Key: "claimed", Value: model.TrackValue{Value: 2, Tag: &other}},
+				{Key: "expired", Value: model.TrackValue{Value: 2, Tag: &other}},
+				{Key: "new", Value: model.TrackValue{Value: 2, Tag: &other}},
+				{Key: "new", Value: model.TrackValue{Value: 3}},
+			},
+			2: {{Key: "new", Value: model.TrackValue{Value: 2}}},
+		}, store_interface.TrackPutCondition{Mode: store_interface.TrackPutIfAbsent})
+		if keysOf(result.Written) != "1/expired,1/new,2/new" || keysOf(result.Rejected) != "1/claimed,1/new" {
+			t.Errorf("unexpected if_absent result %+v", result)
+		}
+		if value, _ := store.TrackGetValue(space, bucketID, "claimed"); value.Value != 1 || value.Tag == nil || *value.Tag != owner {
+			t.Errorf("if_absent overwrote a live key: %+v", value)
+		}
+		if value, _ := store.TrackGetValue(space, bucketID, "expired"); value.Value != 2 || value.ExpiresAt != nil {
+			t.Errorf("expected the expired key to be replaced without expiry, got %+v", value)
+		}
+
+		// if_present
+		result = put(map[int32][]model.TrackKeyValueItem{bucketID: {
+			{Key: "claimed", Value: model.TrackValue{Value: 4, Tag: &owner}},
+			{Key: "missing", Value: model.TrackValue{Value: 4}},
+		}}, store_interface.TrackPutCondition{Mode: store_interface.TrackPutIfPresent})
+		if keysOf(result.Written) != "1/claimed" || keysOf(result.Rejected) != "1/missing" {
+			t.Errorf("unexpected if_present result %+v", result)
+		}
+		if _, err := store.TrackGetValue(space, bucketID, "missing"); !errors.Is(err, store_interface.ErrTrackKeyNotFound) {
+			t.Errorf("if_present created a key: %v", err)
+		}
+
+		// if_tag compares the current tag
+		result = put(map[int32][]model.TrackKeyValueItem{bucketID: {
+			{Key: "claimed", Value: model.TrackValue{Value: 5, Tag: &other}},
+			{Key: "expired", Value: model.TrackValue{Value: 5}},
+			{Key: "missing", Value: model.TrackValue{Value: 5}},
+		}}, store_interface.TrackPutCondition{Mode: store_interface.TrackPutIfTag, Tag: &owner})
+		if keysOf(result.Written) != "1/claimed" || keysOf(result.Rejected) != "1/expired,1/missing" {
+			t.Errorf("unexpected if_tag result %+v", result)
+		}
+		if value, _ := store.TrackGetValue(space, bucketID, "claimed"); value.Value != 5 || value.Tag == nil || *value.Tag != other {
+			t.Errorf("expected claimed to move to the other tag, got %+v", value)
+		}
+
+		for _, cond := range []store_interface.TrackPutCondition{
+			{Mode: "sometimes"},
+			{Mode: store_interface.TrackPutIfTag},
+			{Mode: store_interface.TrackPutIfAbsent, Tag: &owner},
+		} {
+			if _, err := store.TrackPutManyIf(space, nil, cond); !errors.Is(err, store_interface.ErrInvalidPutCondition) {
+				t.Errorf("expected ErrInvalidPutCondition for %+v, got %v", cond, err)
+			}
+		}
+	})
+}
+
+func TestTrackHistory(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackHistory(store, name, t)
+	}
+}
+
+func testTrackHistory(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 121, TenancyId: 1}
+		bucketID := int32(1)
+
+		valuesOf := func(key string, limit int) string {
+			t.Helper()
+			entries, err := store.TrackGetHistory(space, bucketID, key, limit)
+			if err != nil {
+				t.Fatalf("TrackGetHistory failed: %v", err)
+			}
+			values := make([]string, len(entries))
+			for i, e := range entries {
+				values[i] = fmt.Sprint(e.Value)
+			}
+			return strings.Join(values, ",")
+		}
+
+		// Off by default
+		if policy, err := store.TrackGetHistoryPolicy(space, bucketID); err != nil || policy.Enabled() {
+			t.Fatalf("expected history off by default, got %+v, %v", policy, err)
+		}
+		if err := store.TrackPut(space, bucketID, "k", 0, nil, nil, nil); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if err := store.TrackPut(space, bucketID, "k", 1, nil, nil, nil); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if got := valuesOf("k", 0); got != "" {
+			t.Errorf("expected no history before a policy is set, got %q", got)
+		}
+
+		policy := store_interface.TrackHistoryPolicy{MaxVersions: 3}
+		if err := store.TrackSetHistoryPolicy(space, bucketID, policy); err != nil {
+			t.Fatalf("TrackSetHistoryPolicy failed: %v", err)
+		}
+		if got, _ := store.TrackGetHistoryPolicy(space, bucketID); got != policy {
+			t.Errorf("expected policy %+v, got %+v", policy, got)
+		}
+
+		// Every kind of write records the live value it replaces
+		tag := int64(9)
+		if err := store.TrackPut(space, bucketID, "k", 2, &tag, nil, nil); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if _, err := store.TrackIncrement(space, bucketID, "k", 1); err != nil {
+			t.Fatalf("TrackIncrement failed: %v", err)
+		}
+		expected := int64(3)
+		if err := store.TrackCompareAndSwap(space, bucketID, "k", &expected, 4, nil, nil); err != nil {
+			t.Fatalf("TrackCompareAndSwap failed: %v", err)
+		}
+		if err := store.TrackDeleteMany(space, []model.TrackBucketKeyPair{{BucketID: bucketID, Key: "k"}}); err != nil {
+			t.Fatalf("TrackDeleteMany failed: %v", err)
+		}
+		if got := valuesOf("k", 0); got != "4,3,2" {
+			t.Errorf("expected the newest 3 prior values, got %q", got)
+		}
+		if got := valuesOf("k", 2); got != "4,3" {
+			t.Errorf("expected the newest 2 prior values, got %q", got)
+		}
+		entries, _ := store.TrackGetHistory(space, bucketID, "k", 0)
+		if len(entries) == 3 && (entries[1].Tag == nil || *entries[1].Tag != tag || entries[1].ReplacedAt.IsZero()) {
+			t.Errorf("expected the tagged value with its replace time, got %+v", entries[1])
+		}
+
+		// Expired values are not history, and other buckets keep none
+		past := time.Now().Add(-time.Minute)
+		if err := store.TrackPut(space, bucketID, "expired", 1, nil, nil, &past); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if err := store.TrackPut(space, bucketID, "expired", 2, nil, nil, nil); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if got := valuesOf("expired", 0); got != "" {
+			t.Errorf("expected no history for an expired value, got %q", got)
+		}
+		for _, v := range []int64{1, 2} {
+			if err := store.TrackPut(space, 2, "k", v, nil, nil, nil); err != nil {
+				t.Fatalf("TrackPut failed: %v", err)
+			}
+		}
+		if entries, _ := store.TrackGetHistory(space, 2, "k", 0); len(entries) != 0 {
+			t.Errorf("expected no history in a bucket without a policy, got %+v", entries)
+		}
+
+		// Prefix deletes record history too
+		if err := store.TrackPut(space, bucketID, "p:1", 7, nil, nil, nil); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if _, err := store.TrackDeleteByPrefix(space, bucketID, []string{"p:"}, nil, nil); err != nil {
+			t.Fatalf("TrackDeleteByPrefix failed: %v", err)
+		}
+		if got := valuesOf("p:1", 0); got != "7" {
+			t.Errorf("expected the deleted value in history, got %q", got)
+		}
+
+		// Pruning removes what the policy no longer retains, which reads already hide
+		pruned, err := store.TrackPruneHistory(time.Now(), 100)
+		if err != nil {
+			t.Fatalf("TrackPruneHistory failed: %v", err)
+		}
+		if pruned < 1 {
+			t.Errorf("expected the oldest value of k to be pruned, pruned %d", pruned)
+		}
+		if got := valuesOf("k", 0); got != "4,3,2" {
+			t.Errorf("expected pruning to keep the newest 3 prior values, got %q", got)
+		}
+
+		// Age limits
+		if err := store.TrackSetHistoryPolicy(space, bucketID, store_interface.TrackHistoryPolicy{MaxAge: time.Hour}); err != nil {
+			t.Fatalf("TrackSetHistoryPolicy failed: %v", err)
+		}
+		if _, err := store.TrackPruneHistory(time.Now().Add(2*time.Hour), 100); err != nil {
+			t.Fatalf("TrackPruneHistory failed: %v", err)
+		}
+		if got := valuesOf("k", 0); got != "" {
+			t.Errorf("expected entries older than the max age to be pruned, got %q", got)
+		}
+
+		// Turning history off drops it
+		if err := store.TrackPut(space, bucketID, "expired", 3, nil, nil, nil); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if err := store.TrackSetHistoryPolicy(space, bucketID, store_interface.TrackHistoryPolicy{}); err != nil {
+			t.Fatalf("TrackSetHistoryPolicy failed: %v", err)
+		}
+		if got := valuesOf("expired", 0); got != "" {
+			t.Errorf("expected history dropped with the policy, got %q", got)
+		}
+		if got, _ := store.TrackGetHistoryPolicy(space, bucketID); got.Enabled() {
+			t.Errorf("expected history off, got %+v", got)
+		}
+
+		if err := store.TrackSetHistoryPolicy(space, bucketID, store_interface.TrackHistoryPolicy{MaxVersions: -1}); !errors.Is(err, store_interface.ErrInvalidHistoryPolicy) {
+			t.Errorf("expected ErrInvalidHistoryPolicy, got %v", err)
+		}
+	})
+}
+
+func TestTrackGetKeysByValue(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackGetKeysByValue(store, name, t)
+	}
+}
+
+func testTrackGetKeysByValue(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 122, TenancyId: 1}
+		bucketID := int32(1)
+
+		past := time.Now().Add(-time.Minute)
+		puts := []struct {
+			key       string
+			value     int64
+			expiresAt *time.Time
+		}{
+			{"order:1", 4242, nil},
+			{"order:2", 7, nil},
+			{"order:3", 4242, nil},
+			{"refund:1", 4242, nil},
+			{"order:4", -5, nil},
+			{"order:5", 4242, &past},
+		}
+		for _, p := range puts {
+			if err := store.TrackPut(space, bucketID, p.key, p.value, nil, nil, p.expiresAt); err != nil {
+				t.Fatalf("TrackPut failed: %v", err)
+			}
+		}
+		if err := store.TrackPut(space, 2, "order:9", 4242, nil, nil, nil); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+
+		lookup := func(values []int64, prefix string, opts store_interface.TrackQueryOptions) (string, string) {
+			t.Helper()
+			items, next, err := store.GetKeysByValue(space, bucketID, values, prefix, opts)
+			if err != nil {
+				t.Fatalf("GetKeysByValue failed: %v", err)
+			}
+			keys := make([]string, len(items))
+			for i, item := range items {
+				keys[i] = fmt.Sprintf("%s=%d", item.Key, item.Value.Value)
+			}
+			return strings.Join(keys, ","), next
+		}
+
+		if keys, next := lookup([]int64{4242}, "", store_interface.TrackQueryOptions{}); keys != "order:1=4242,order:3=4242,refund:1=4242" || next != "" {
+			t.Errorf("unexpected keys for 4242: %q, next %q", keys, next)
+		}
+		if keys, _ := lookup([]int64{4242}, "order:", store_interface.TrackQueryOptions{}); keys != "order:1=4242,order:3=4242" {
+			t.Errorf("unexpected keys under the prefix: %q", keys)
+		}
+		if keys, _ := lookup([]int64{-5, 7, 99}, "", store_interface.TrackQueryOptions{}); keys != "order:2=7,order:4=-5" {
+			t.Errorf("unexpected keys for several values: %q", keys)
+		}
+
+		// Paging in both directions
+		keys, next := lookup([]int64{4242, 7}, "", store_interface.TrackQueryOptions{Limit: 2})
+		if keys != "order:1=4242,order:2=7" || next != "order:2" {
+			t.Errorf("unexpected first page: %q, next %q", keys, next)
+		}
+		keys, next = lookup([]int64{4242, 7}, "", store_interface.TrackQueryOptions{Limit: 2, StartAfter: next})
+		if keys != "order:3=4242,refund:1=4242" || next != "" {
+			t.Errorf("unexpected second page: %q, next %q", keys, next)
+		}
+		keys, next = lookup([]int64{4242}, "", store_interface.TrackQueryOptions{Limit: 2, Descending: true})
+		if keys != "refund:1=4242,order:3=4242" || next != "order:3" {
+			t.Errorf("unexpected descending page: %q, next %q", keys, next)
+		}
+
+		// The lookup follows every kind of write
+		if err := store.TrackPut(space, bucketID, "order:1", 8, nil, nil, nil); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if _, err := store.TrackIncrement(space, bucketID, "order:2", 4235); err != nil {
+			t.Fatalf("TrackIncrement failed: %v", err)
+		}
+		expected := int64(4242)
+		if err := store.TrackCompareAndSwap(space, bucketID, "order:3", &expected, 8, nil, nil); err != nil {
+			t.Fatalf("TrackCompareAndSwap failed: %v", err)
+		}
+		if err := store.TrackDeleteMany(space, []model.TrackBucketKeyPair{{BucketID: bucketID, Key: "refund:1"}}); err != nil {
+			t.Fatalf("TrackDeleteMany failed: %v", err)
+		}
+		if err := store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{bucketID: {{Key: "order:6", Value: model.TrackValue{Value: 4242}}}}); err != nil {
+			t.Fatalf("TrackPutMany failed: %v", err)
+		}
+		if keys, _ := lookup([]int64{4242}, "", store_interface.TrackQueryOptions{}); keys != "order:2=4242,order:6=4242" {
+			t.Errorf("unexpected keys for 4242 after writes: %q", keys)
+		}
+		if keys, _ := lookup([]int64{8}, "", store_interface.TrackQueryOptions{}); keys != "order:1=8,order:3=8" {
+			t.Errorf("unexpected keys for 8 after writes: %q", keys)
+		}
+		if _, err := store.TrackDeleteByPrefix(space, bucketID, []string{"order:"}, nil, nil); err != nil {
+			t.Fatalf("TrackDeleteByPrefix failed: %v", err)
+		}
+		if keys, _ := lookup([]int64{4242, 8, -5}, "", store_interface.TrackQueryOptions{}); keys != "" {
+			t.Errorf("expected no keys after deleting the prefix, got %q", keys)
+		}
+
+		if _, _, err := store.GetKeysByValue(space, bucketID, nil, "", store_interface.TrackQueryOptions{}); !errors.Is(err, store_interface.ErrInvalidQueryOptions) {
+			t.Errorf("expected ErrInvalidQueryOptions without values, got %v", err)
+		}
+		byValue := store_interface.TrackQueryOptions{OrderBy: store_interface.TrackOrderByValue}
+		if _, _, err := store.GetKeysByValue(space, bucketID, []int64{1}, "", byValue); !errors.Is(err, store_interface.ErrInvalidQueryOptions) {
+			t.Errorf("expected ErrInvalidQueryOptions ordering by value, got %v", err)
+		}
+	})
+}
+
+func TestTrackTags(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackTags(store, name, t)
+	}
+}
+
+func testTrackTags(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 123, TenancyId: 1}
+		bucketID := int32(1)
+
+		one, two := int64(1), int64(2)
+		items := []model.TrackKeyValueItem{
+			{Key: "t:a", Value: model.TrackValue{Value: 1, Tag: &one, Tags: []int64{2, 3}}},
+			{Key: "t:b", Value: model.TrackValue{Value: 2, Tags: []int64{3}}},
+			{Key: "t:c", Value: model.TrackValue{Value: 3, Tag: &two}},
+			{Key: "t:d", Value: model.TrackValue{Value: 4}},
+		}
+		if err := store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{bucketID: items}); err != nil {
+			t.Fatalf("TrackPutMany failed: %v", err)
+		}
+
+		value, err := store.TrackGetValue(space, bucketID, "t:a")
+		if err != nil {
+			t.Fatalf("TrackGetValue failed: %v", err)
+		}
+		if value.Tag == nil || *value.Tag != 1 || !slices.Equal(value.Tags, []int64{2, 3}) {
+			t.Errorf("unexpected tags for t:a: %v %v", value.Tag, value.Tags)
+		}
+		found, _, err := store.TrackGetMany(space, map[int32][]string{bucketID: {"t:b"}})
+		if err != nil {
+			t.Fatalf("TrackGetMany failed: %v", err)
+		}
+		if tags := found[bucketID]["t:b"].Tags; !slices.Equal(tags, []int64{3}) {
+			t.Errorf("unexpected tags for t:b from TrackGetMany: %v", tags)
+		}
+
+		query := func(tags []int64, match store_interface.TrackTagMatch) string {
+			t.Helper()
+			opts := store_interface.TrackQueryOptions{TagMatch: match}
+			items, _, err := store.GetItemsByKeyPrefixesPage(space, bucketID, []string{"t:"}, tags, nil, opts)
+			if err != nil {
+				t.Fatalf("GetItemsByKeyPrefixesPage failed: %v", err)
+			}
+			keys := make([]string, len(items))
+			for i, item := range items {
+				keys[i] = item.Key
+			}
+			return strings.Join(keys, ",")
+		}
+
+		// Filters see the whole tag set, the primary tag and the further tags alike
+		cases := []struct {
+			tags  []int64
+			match store_interface.TrackTagMatch
+			want  string
+		}{
+			{[]int64{3}, "", "t:a,t:b"},
+			{[]int64{2}, store_interface.TrackTagMatchAny, "t:a,t:c"},
+			{[]int64{1, 3}, store_interface.TrackTagMatchAny, "t:a,t:b"},
+			{[]int64{2, 3}, store_interface.TrackTagMatchAll, "t:a"},
+			{[]int64{1, 2, 3}, store_interface.TrackTagMatchAll, "t:a"},
+			{[]int64{3, 3}, store_interface.TrackTagMatchAll, "t:a,t:b"},
+			{[]int64{3, 4}, store_interface.TrackTagMatchAll, ""},
+			{nil, store_interface.TrackTagMatchAll, "t:a,t:b,t:c,t:d"},
+		}
+		for _, c := range cases {
+			if keys := query(c.tags, c.match); keys != c.want {
+				t.Errorf("tags %v matching %q: got %q, want %q", c.tags, c.match, keys, c.want)
+			}
+		}
+
+		keyRange := store_interface.TrackKeyRange{Start: "t:", StartInclusive: true}
+		ranged, _, err := store.GetItemsByKeyRange(space, bucketID, keyRange, []int64{2, 3}, nil, store_interface.TrackQueryOptions{TagMatch: store_interface.TrackTagMatchAll})
+		if err != nil {
+			t.Fatalf("GetItemsByKeyRange failed: %v", err)
+		}
+		if len(ranged) != 1 || ranged[0].Key != "t:a" {
+			t.Errorf("expected only t:a in the range with tags 2 and 3, got %v", ranged)
+		}
+
+		groups, err := store.TrackAggregate(space, bucketID, []string{"t:"}, []int64{3}, nil, false)
+		if err != nil {
+			t.Fatalf("TrackAggregate failed: %v", err)
+		}
+		if len(groups) != 1 || groups[0].Count != 2 || groups[0].Value.Sum != 3 {
+			t.Errorf("unexpected aggregate over tag 3: %+v", groups)
+		}
+
+		// An increment keeps the tags, while a put with a single tag replaces them
+		if _, err := store.TrackIncrement(space, bucketID, "t:b", 5); err != nil {
+			t.Fatalf("TrackIncrement failed: %v", err)
+		}
+		if value, err := store.TrackGetValue(space, bucketID, "t:b"); err != nil || value.Value != 7 || !slices.Equal(value.Tags, []int64{3}) {
+			t.Errorf("expected t:b to keep its tags after an increment, got %+v, %v", value, err)
+		}
+		if err := store.TrackPut(space, bucketID, "t:a", 1, &one, nil, nil); err != nil {
+			t.Fatalf("TrackPut failed: %v", err)
+		}
+		if keys := query([]int64{3}, ""); keys != "t:b" {
+			t.Errorf("expected only t:b with tag 3 after replacing t:a, got %q", keys)
+		}
+
+		deleted, err := store.TrackDeleteByPrefix(space, bucketID, []string{"t:"}, []int64{3}, nil)
+		if err != nil {
+			t.Fatalf("TrackDeleteByPrefix failed: %v", err)
+		}
+		if deleted != 1 {
+			t.Errorf("expected to delete 1 item with tag 3, deleted %d", deleted)
+		}
+		if keys := query(nil, ""); keys != "t:a,t:c,t:d" {
+			t.Errorf("unexpected keys after deleting tag 3: %q", keys)
+		}
+
+		bad := store_interface.TrackQueryOptions{TagMatch: "some"}
+		if _, _, err := store.GetItemsByKeyPrefixesPage(space, bucketID, []string{"t:"}, []int64{1}, nil, bad); !errors.Is(err, store_interface.ErrInvalidQueryOptions) {
+			t.Errorf("expected ErrInvalidQueryOptions for an unknown tag match, got %v", err)
+		}
+	})
+}
+
+func TestTrackWatch(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackWatch(store, name, t)
+	}
+}
+
+func testTrackWatch(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 124, TenancyId: 1}
+		bucketID := int32(1)
+		watched := store_interface.NewWatchedTrackStore(store, 100)
+		sub, missed, err := watched.TrackWatch(store_interface.TrackWatchFilter{Space: space, BucketID: bucketID, Prefix: "flag:"}, nil)
+		if err != nil {
+			t.Fatalf("Failed to watch: %v", err)
+		}
+		defer sub.Close()
+		if len(missed) != 0 {
+			t.Errorf("Expected no missed events without a resume sequence, got %v", missed)
+		}
+		next := func() model.TrackWatchEvent {
+			t.Helper()
+			select {
+			case e := <-sub.Events():
+				return e
+			default:
+				t.Fatalf("Expected an event")
+				return model.TrackWatchEvent{}
+			}
+		}
+
+		tag := int64(3)
+		if err := watched.TrackPut(space, bucketID, "flag:a", 1, &tag, nil, nil); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		// Outside the prefix, the bucket or the space, so not delivered
+		if err := watched.TrackPut(space, bucketID, "other", 1, nil, nil, nil); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		if err := watched.TrackPut(space, bucketID+1, "flag:a", 1, nil, nil, nil); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		if err := watched.TrackPut(store_interface.TenancySpace{AppId: 124, TenancyId: 2}, bucketID, "flag:a", 1, nil, nil, nil); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		e := next()
+		if e.Sequence != 1 || e.Type != store_interface.TrackWatchEventPut || e.Key != "flag:a" || *e.Value != 1 || *e.Tag != 3 {
+			t.Errorf("Unexpected put event %+v", e)
+		}
+
+		if _, err := watched.TrackIncrement(space, bucketID, "flag:a", 4); err != nil {
+			t.Fatalf("Failed to increment: %v", err)
+		}
+		e = next()
+		if e.Key != "flag:a" || *e.Value != 5 || e.Tag == nil || *e.Tag != 3 {
+			t.Errorf("Expected the increment to publish the whole entry, got %+v", e)
+		}
+
+		// Only the written keys of a conditional put are published
+		result, err := watched.TrackPutManyIf(space, map[int32][]model.TrackKeyValueItem{bucketID: {
+			{Key: "flag:a", Value: model.TrackValue{Value: 10}},
+			{Key: "flag:b", Value: model.TrackValue{Value: 20, Tags: []int64{1, 2}}},
+		}}, store_interface.TrackPutCondition{Mode: store_interface.TrackPutIfAbsent})
+		if err != nil || len(result.Written) != 1 {
+			t.Fatalf("Failed conditional put: %v %+v", err, result)
+		}
+		e = next()
+		if e.Key != "flag:b" || *e.Value != 20 || !slices.Equal(e.Tags, []int64{1, 2}) {
+			t.Errorf("Unexpected conditional put event %+v", e)
+		}
+
+		if err := watched.TrackDeleteMany(space, []model.TrackBucketKeyPair{{BucketID: bucketID, Key: "flag:a"}}); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+		e = next()
+		if e.Type != store_interface.TrackWatchEventDelete || e.Key != "flag:a" || e.Value != nil {
+			t.Errorf("Unexpected delete event %+v", e)
+		}
+		if _, err := watched.TrackDeleteByPrefix(space, bucketID, []string{"fl"}, nil, nil); err != nil {
+			t.Fatalf("Failed to delete by prefix: %v", err)
+		}
+		e = next()
+		if e.Type != store_interface.TrackWatchEventDelete || e.Prefix == nil || *e.Prefix != "fl" {
+			t.Errorf("Unexpected delete by prefix event %+v", e)
+		}
+
+		// Resuming replays the matching changes after the sequence
+		resumed, missed, err := watched.TrackWatch(store_interface.TrackWatchFilter{Space: space, BucketID: bucketID, Prefix: "flag:"}, &e.Sequence)
+		if err != nil {
+			t.Fatalf("Failed to resume: %v", err)
+		}
+		resumed.Close()
+		if len(missed) != 0 {
+			t.Errorf("Expected nothing after the latest sequence, got %v", missed)
+		}
+		since := uint64(1)
+		resumed, missed, err = watched.TrackWatch(store_interface.TrackWatchFilter{Space: space, BucketID: bucketID, Prefix: "flag:"}, &since)
+		if err != nil {
+			t.Fatalf("Failed to resume: %v", err)
+		}
+		resumed.Close()
+		if len(missed) != 4 || missed[0].Sequence != 5 {
+			t.Errorf("Expected the 4 matching changes after sequence 1, got %v", missed)
+		}
+		future := e.Sequence + 1
+		if _, _, err := watched.TrackWatch(store_interface.TrackWatchFilter{Space: space, BucketID: bucketID}, &future); !errors.Is(err, store_interface.ErrTrackWatchResumeGap) {
+			t.Errorf("Expected ErrTrackWatchResumeGap for an unissued sequence, got %v", err)
+		}
+	})
+}
+
+func TestTrackWatchConsistency(t *testing.T) {
+	for name, store := range trackStores {
+		testTrackWatchConsistency(store, name, t)
+	}
+}
+
+// testTrackWatchConsistency checks that events sequence concurrent writes in the order
+// they committed, that a filtered delete by prefix publishes only the keys it removed,
+// and that the expiry sweep publishes the keys it removed.
+func testTrackWatchConsistency(store store_interface.TrackStore, name string, t *testing.T) {
+	t.Run(name, func(t *testing.T) {
+		space := store_interface.TenancySpace{AppId: 126, TenancyId: 1}
+		bucketID := int32(1)
+		watched := store_interface.NewWatchedTrackStore(store, 1000)
+		sub, _, err := watched.TrackWatch(store_interface.TrackWatchFilter{Space: space, BucketID: bucketID}, nil)
+		if err != nil {
+			t.Fatalf("Failed to watch: %v", err)
+		}
+		defer sub.Close()
+
+		// Replaying the events of concurrent writes to one key ends on the stored value
+		var wg sync.WaitGroup
+		for g := 0; g < 10; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 10; i++ {
+					tag := int64(g)
+					if i%2 == 0 {
+						watched.TrackPut(space, bucketID, "hot", int64(g*100+i), &tag, nil, nil)
+					} else {
+						watched.TrackIncrement(space, bucketID, "hot", 1)
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		var last model.TrackWatchEvent
+		for n := 0; n < 100; n++ {
+			select {
+			case last = <-sub.Events():
+			default:
+				t.Fatalf("Expected 100 events, got %d", n)
+			}
+		}
+		stored, err := watched.TrackGetValue(space, bucketID, "hot")
+		if err != nil {
+			t.Fatalf("Failed to get: %v", err)
+		}
+		if *last.Value != stored.Value || last.Tag == nil || stored.Tag == nil || *last.Tag != *stored.Tag {
+			t.Errorf("Expected the last event to match the stored entry %+v, got %+v", stored, last)
+		}
+
+		one, two := int64(1), int64(2)
+		watched.TrackPut(space, bucketID, "flag:a", 1, &one, nil, nil)
+		watched.TrackPut(space, bucketID, "flag:b", 2, &two, nil, nil)
+		<-sub.Events()
+		<-sub.Events()
+		if n, err := watched.TrackDeleteByPrefix(space, bucketID, []string{"flag:"}, []int64{1}, nil); err != nil || n != 1 {
+			t.Fatalf("Expected to delete one key, got %d %v", n, err)
+		}
+		select {
+		case e := <-sub.Events():
+			if e.Type != store_interface.TrackWatchEventDelete || e.Prefix != nil || e.Key != "flag:a" {
+				t.Errorf("Expected a delete of flag:a alone, got %+v", e)
+			}
+		default:
+			t.Fatalf("Expected a delete event")
+		}
+
+		// The sweep publishes a delete for each expired key it removes
+		past := time.Now().Add(-time.Minute)
+		if err := watched.TrackPut(space, bucketID, "session", 1, nil, nil, &past); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		<-sub.Events()
+		for {
+			deleted, err := watched.TrackDeleteExpired(time.Now(), 100)
+			if err != nil {
+				t.Fatalf("Failed to delete expired keys: %v", err)
+			}
+			if len(deleted) < 100 {
+				break
+			}
+		}
+		select {
+		case e := <-sub.Events():
+			if e.Type != store_interface.TrackWatchEventDelete || e.Key != "session" {
+				t.Errorf("Expected a delete of the expired session, got %+v", e)
+			}
+		default:
+			t.Fatalf("Expected a delete event for the expired key")
+		}
+		select {
+		case e := <-sub.Events():
+			t.Errorf("Expected no more events, got %+v", e)
+		default:
+		}
+	})
+}
+
+// TestBoltTrackFilterIndexes builds the indexes over a file written without them, and
+// checks filtered reads through them agree with the scans they replace.
+func TestBoltTrackFilterIndexes(t *testing.T) {
+	path := t.TempDir() + "/track.db"
+	space := store_interface.TenancySpace{AppId: 125, TenancyId: 1}
+	bucketID := int32(1)
+	query := func(store *boltdb.BoltStore) [][]model.TrackKeyValueItem {
+		t.Helper()
+		gt := store_interface.MetricFilter{Operator: store_interface.MetricGt, Value: 0}
+		between := store_interface.MetricFilter{Operator: store_interface.MetricBetween, Value: -1.5, Upper: 2}
+		var out [][]model.TrackKeyValueItem
+		for _, q := range []struct {
+			tags   []int64
+			metric *store_interface.MetricFilter
+			opts   store_interface.TrackQueryOptions
+		}{
+			{tags: []int64{1}},
+			{tags: []int64{1, 2}},
+			{tags: []int64{1, 2}, opts: store_interface.TrackQueryOptions{TagMatch: store_interface.TrackTagMatchAll}},
+			{tags: []int64{2}, metric: &gt},
+			{metric: &gt, opts: store_interface.TrackQueryOptions{Limit: 2, Descending: true}},
+			{metric: &between},
+		} {
+			items, _, err := store.GetItemsByKeyPrefixesPage(space, bucketID, []string{"a:", "b:"}, q.tags, q.metric, q.opts)
+			if err != nil {
+				t.Fatalf("Failed to query: %v", err)
+			}
+			out = append(out, items)
+		}
+		return out
+	}
+
+	store, err := boltdb.NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	metric := func(m float64) *float64 { return &m }
+	tag := func(tag int64) *int64 { return &tag }
+	err = store.TrackPutMany(space, map[int32][]model.TrackKeyValueItem{bucketID: {
+		{Key: "a:1", Value: model.TrackValue{Value: 1, Tag: tag(1), Metric: metric(-2)}},
+		{Key: "a:2", Value: model.TrackValue{Value: 2, Tag: tag(1), Tags: []int64{2}, Metric: metric(1)}},
+		{Key: "a:3", Value: model.TrackValue{Value: 3, Tags: []int64{2}, Metric: metric(0)}},
+		{Key: "b:1", Value: model.TrackValue{Value: 4, Tags: []int64{1, 2}, Metric: metric(5)}},
+		{Key: "c:1", Value: model.TrackValue{Value: 5, Tag: tag(1), Metric: metric(1)}},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	scanned := query(store)
+	store.TrackClose()
+
+	store, err = boltdb.NewBoltStoreWithOptions(path, boltdb.BoltOptions{TrackFilterIndexes: true})
+	if err != nil {
+		t.Fatalf("Failed to open with indexes: %v", err)
+	}
+	if indexed := query(store); !reflect.DeepEqual(indexed, scanned) {
+		t.Errorf("Indexed reads %v differ from scans %v", indexed, scanned)
+	}
+
+	// Writes keep the indexes, even once the file is opened without asking for them
+	store.TrackClose()
+	store, err = boltdb.NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer store.TrackClose()
+	if err := store.TrackPut(space, bucketID, "a:2", 2, nil, metric(-1), nil); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.TrackDeleteMany(space, []model.TrackBucketKeyPair{{BucketID: bucketID, Key: "b:1"}}); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	items, err := store.GetItemsByKeyPrefix(space, bucketID, "", []int64{2}, nil)
+	if err != nil || len(items) != 1 || items[0].Key != "a:3" {
+		t.Errorf("Expected only a:3 to keep tag 2, got %v %v", items, err)
+	}
+	indexed := query(store)
+	if err := store.DropTrackFilterIndexes(); err != nil {
+		t.Fatalf("Failed to drop the indexes: %v", err)
+	}
+	if scanned := query(store); !reflect.DeepEqual(indexed, scanned) {
+		t.Errorf("Indexed reads %v differ from scans %v after writes", indexed, scanned)
+	}
+}